@@ -0,0 +1,158 @@
+// keyshares 生成主密钥的 Shamir 分片，并可向本机服务提交分片完成解封（KMS_UNSEAL_MODE=shamir）。
+//
+// 切换步骤：
+//  1. 拆分现有主密钥：go run ./cmd/keyshares split -key ./master.key -n 5 -m 3，
+//     每位保管人各取一行分片离线保存，随后销毁服务器上的 master.key；
+//     新部署可省略 -key 由本工具随机生成主密钥（仅在库中尚无设备时使用，否则需先用 cmd/rekey 重加密）；
+//  2. 服务端配置 KMS_UNSEAL_MODE=shamir 启动，/api/health 显示 sealed；
+//  3. 保管人在服务器本机执行 go run ./cmd/keyshares unseal（或 POST /api/sys/unseal），凑齐 m 份后自动解封。
+//
+// 用法：
+//
+//	keyshares split [-key ./master.key] -n 5 -m 3
+//	keyshares unseal [-addr http://127.0.0.1:8080]   # 从标准输入读一份分片
+//	keyshares status [-addr http://127.0.0.1:8080]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"promthus/internal/kms"
+	"promthus/internal/shamir"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "split":
+		split(os.Args[2:])
+	case "unseal":
+		unseal(os.Args[2:])
+	case "status":
+		status(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keyshares split [-key path] -n N -m M | unseal [-addr url] | status [-addr url]")
+	os.Exit(2)
+}
+
+func split(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	keyPath := fs.String("key", "", "要拆分的主密钥文件；为空则随机生成新主密钥")
+	n := fs.Int("n", 5, "分片总数")
+	m := fs.Int("m", 3, "解封所需分片数")
+	fs.Parse(args)
+
+	var key []byte
+	if *keyPath != "" {
+		k, err := kms.ReadMasterKey(*keyPath)
+		if err != nil {
+			fail("read master key: %v", err)
+		}
+		key = k
+	} else {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			fail("generate master key: %v", err)
+		}
+	}
+
+	shares, err := shamir.Split(key, *n, *m)
+	if err != nil {
+		fail("split: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "master key fingerprint: %s\n", kms.MasterKeyFingerprint(key)[:16])
+	fmt.Fprintf(os.Stderr, "%d shares, any %d unseal; give one line to each custodian:\n", *n, *m)
+	for _, s := range shares {
+		fmt.Println(s.String())
+	}
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+func unseal(args []string) {
+	fs := flag.NewFlagSet("unseal", flag.ExitOnError)
+	addr := fs.String("addr", "http://127.0.0.1:8080", "服务地址，须为本机")
+	fs.Parse(args)
+
+	fmt.Fprint(os.Stderr, "share: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fail("read share: %v", err)
+	}
+	body, _ := json.Marshal(map[string]string{"share": strings.TrimSpace(line)})
+	printStatus(call(http.MethodPost, *addr+"/api/sys/unseal", body))
+}
+
+func status(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	addr := fs.String("addr", "http://127.0.0.1:8080", "服务地址，须为本机")
+	fs.Parse(args)
+	printStatus(call(http.MethodGet, *addr+"/api/sys/seal-status", nil))
+}
+
+type apiResponse struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *kms.UnsealStatus `json:"data"`
+}
+
+func call(method, url string, body []byte) *apiResponse {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		fail("%v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer resp.Body.Close()
+
+	var out apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		fail("decode response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if out.Code != 0 {
+		fail("server rejected: %s", out.Message)
+	}
+	return &out
+}
+
+func printStatus(resp *apiResponse) {
+	st := resp.Data
+	if st == nil {
+		fail("empty response")
+	}
+	if !st.Sealed {
+		fmt.Println("unsealed")
+		return
+	}
+	if st.Threshold == 0 {
+		fmt.Println("sealed, no shares submitted")
+		return
+	}
+	fmt.Printf("sealed, %d/%d shares submitted\n", st.Progress, st.Threshold)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/ecdh"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,6 +11,7 @@ import (
 
 	"promthus/internal/config"
 	"promthus/internal/handler"
+	"promthus/internal/job"
 	"promthus/internal/kms"
 	"promthus/internal/logger"
 	"promthus/internal/metrics"
@@ -34,9 +36,20 @@ func main() {
 	repository.InitDB(&cfg.Database)
 	defer repository.CloseDB()
 
-	kms.Init(cfg.KMS.MasterKeyPath)
+	// release 模式下主密钥缺失/不合规直接退出;指纹与库中记录不一致同样拒绝启动;
+	// KMS_UNSEAL_MODE=shamir 时以密封状态启动,指纹在解封时校验;
+	kms.Init(&cfg.KMS, cfg.Server.Mode)
+	settingStore := repository.NewPostgresSettingStore()
+	if err := kms.VerifyFingerprint(settingStore); err != nil {
+		if cfg.Server.Mode == "release" {
+			logger.Fatal("master key check failed, refusing to start", zap.Error(err))
+		}
+		logger.Warn("master key check failed", zap.Error(err))
+	}
 
-	middleware.SetTokenSecret(cfg.Auth.TokenSecret)
+	if err := middleware.SetTokenKeys(cfg.Auth.SigningKeys(), cfg.Auth.TokenActiveID); err != nil {
+		logger.Fatal("invalid token signing keys", zap.Error(err))
+	}
 
 	metrics.Init()
 
@@ -64,17 +77,51 @@ func main() {
 	sessionStore := repository.NewPostgresSessionStore()
 	// 初始化设备失败计数存储,这里做通配处理,后续可以拓展成readis之类的其他存储类型;
 	failStore := repository.NewPostgresDeviceFailStore()
-
-	authSvc := service.NewAuthService(sessionStore, &cfg.Auth)
-	lockSvc := service.NewLockService(failStore, publisher)
-	adminSvc := service.NewAdminService(sessionStore)
+	// 挑战随机数存储，用于拒绝时间窗内的重放请求;
+	nonceStore := repository.NewPostgresChallengeNonceStore()
+	// 开锁会话存储,Challenge 签发、Report 核销;
+	unlockStore := repository.NewPostgresUnlockSessionStore()
+	// 限流计数与设备临时封禁,用于 challenge_flood 检测;
+	rateStore := repository.NewPostgresRateLimitStore()
+	blockStore := repository.NewPostgresDeviceBlockStore()
+
+	// TOTP 密钥加密密钥：release 模式必须显式配置 AUTH_MFA_ENCRYPTION_KEY
+	mfaKey, err := cfg.Auth.MFAKey(cfg.Server.Mode)
+	if err != nil {
+		logger.Fatal("invalid mfa encryption key", zap.Error(err))
+	}
+	// 登录后端：AUTH_BACKENDS 未配置时只启用本地手机号 + 密码
+	authenticators, err := service.NewAuthenticators(&cfg.Auth)
+	if err != nil {
+		logger.Fatal("invalid auth backend config", zap.Error(err))
+	}
+	// 管理后台 OIDC 单点登录：OIDC_ISSUER 为空时关闭
+	oidcClient, err := service.NewOIDCClient(&cfg.Auth.OIDC)
+	if err != nil {
+		logger.Fatal("invalid oidc config", zap.Error(err))
+	}
+	authSvc := service.NewAuthService(sessionStore, &cfg.Auth, mfaKey, publisher, authenticators, oidcClient)
+	lockSvc := service.NewLockService(failStore, nonceStore, unlockStore, rateStore, blockStore, publisher, &cfg.Alert)
+	// 产线传输公钥可选：未配置时新建设备必须由管理员提供 K_d
+	var provisionKey *ecdh.PublicKey
+	if cfg.KMS.ProvisionPublicKeyPath != "" {
+		key, err := kms.LoadTransportKey(cfg.KMS.ProvisionPublicKeyPath)
+		if err != nil {
+			logger.Fatal("failed to load provisioning transport key",
+				zap.String("path", cfg.KMS.ProvisionPublicKeyPath), zap.Error(err))
+		}
+		provisionKey = key
+		logger.Info("provisioning transport key loaded", zap.String("recipient", kms.TransportKeyID(key)))
+	}
+	adminSvc := service.NewAdminService(sessionStore, provisionKey)
 
 	authHandler := handler.NewAuthHandler(authSvc)
 	lockHandler := handler.NewLockHandler(lockSvc)
 	adminHandler := handler.NewAdminHandler(adminSvc)
+	sysHandler := handler.NewSysHandler(settingStore)
 
 	// 初始化路由,注册handler,用于gin路由控制;
-	r := router.Setup(authHandler, lockHandler, adminHandler)
+	r := router.Setup(authHandler, lockHandler, adminHandler, sysHandler)
 
 	r.Use(metrics.PrometheusMiddleware())
 	r.GET("/metrics", metrics.MetricsHandler())
@@ -96,6 +143,14 @@ func main() {
 
 	// 启动一个goroutine来处理会话清理;
 	go startSessionCleaner(sessionStore)
+	// 启动一个goroutine来清理过期的挑战随机数和开锁会话;
+	go startChallengeStateCleaner(nonceStore, unlockStore)
+	// 后台定时任务,多副本时通过 advisory lock 保证同一任务只在一个实例上执行;
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	scheduler := job.NewScheduler()
+	scheduler.Register("device_offline", cfg.Alert.OfflineCheckInterval, lockSvc.DetectOfflineDevices)
+	scheduler.Start(jobCtx)
 	// 监听信号,SIGINT,SIGTERM
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -126,3 +181,24 @@ func startSessionCleaner(store repository.SessionStore) {
 		}
 	}
 }
+
+func startChallengeStateCleaner(nonceStore repository.ChallengeNonceStore, unlockStore repository.UnlockSessionStore) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := nonceStore.CleanExpired()
+		if err != nil {
+			logger.Error("challenge nonce cleanup failed", zap.Error(err))
+		} else if count > 0 {
+			logger.Info("cleaned expired challenge nonces", zap.Int64("count", count))
+		}
+
+		count, err = unlockStore.CleanExpired()
+		if err != nil {
+			logger.Error("unlock session cleanup failed", zap.Error(err))
+		} else if count > 0 {
+			logger.Info("cleaned expired unlock sessions", zap.Int64("count", count))
+		}
+	}
+}
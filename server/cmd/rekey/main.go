@@ -0,0 +1,184 @@
+// rekey 把 app.devices_lock 中所有设备密钥（key_encrypted 与轮换中的 pending_key_encrypted）
+// 重新加密到新的主密钥下。
+//
+// 主密钥轮换步骤：
+//  1. 服务端把 KMS_MASTER_KEY_PATH 指向新主密钥，旧主密钥写入 KMS_OLD_MASTER_KEY_PATHS，重启（新旧均可解密）；
+//  2. 运行本工具（可先 -dry-run），按批次重加密，每批单独提交；中断后重跑即可，已是新主密钥的行会被跳过，
+//     也可用 -after 指定上次输出的 last_id 继续；
+//  3. 运行 -verify 确认全部行均为新主密钥 ID 且可解密后，从 KMS_OLD_MASTER_KEY_PATHS 移除旧主密钥。
+//
+// 用法：go run ./cmd/rekey -new-key ./master.key.new -old-keys ./master.key [-batch 100] [-dry-run] [-verify] [-after 0]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"promthus/internal/config"
+	"promthus/internal/kms"
+	"promthus/internal/logger"
+	"promthus/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+type deviceKeyRow struct {
+	ID                  int64
+	DeviceID            string
+	KeyEncrypted        []byte
+	PendingKeyEncrypted []byte
+}
+
+func main() {
+	newKeyPath := flag.String("new-key", "", "新主密钥文件路径（必填）")
+	oldKeyPaths := flag.String("old-keys", "", "旧主密钥文件路径，逗号分隔")
+	batchSize := flag.Int("batch", 100, "每批处理的行数")
+	dryRun := flag.Bool("dry-run", false, "只统计与校验，不写库")
+	verifyOnly := flag.Bool("verify", false, "只校验：全部行可解密且已使用新主密钥")
+	after := flag.Int64("after", 0, "从 id 大于该值的行开始（断点续跑）")
+	flag.Parse()
+
+	if *newKeyPath == "" || *batchSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	newKey, err := kms.ReadMasterKey(*newKeyPath)
+	if err != nil {
+		fail("read new master key: %v", err)
+	}
+	var oldKeys [][]byte
+	for _, p := range strings.Split(*oldKeyPaths, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		k, err := kms.ReadLegacyMasterKey(p)
+		if err != nil {
+			fail("read old master key %s: %v", p, err)
+		}
+		oldKeys = append(oldKeys, k)
+	}
+	km := kms.NewLocalKMS(newKey, oldKeys...)
+	newID := km.ActiveKeyID()
+
+	cfg := config.Load()
+	logger.Init("release")
+	defer logger.Sync()
+	repository.InitDB(&cfg.Database)
+	defer repository.CloseDB()
+
+	fmt.Printf("new master key id: %s, decrypt-only keys: %d, dry-run: %v, verify-only: %v\n",
+		newID, len(oldKeys), *dryRun, *verifyOnly)
+
+	var scanned, rewritten, pending int
+	byKeyID := map[string]int{}
+	lastID := *after
+	for {
+		var rows []deviceKeyRow
+		err := repository.DB.Table("app.devices_lock").
+			Select("id, device_id, key_encrypted, pending_key_encrypted").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(*batchSize).
+			Scan(&rows).Error
+		if err != nil {
+			fail("query batch after id %d: %v", lastID, err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		batchRewritten := 0
+		err = repository.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				scanned++
+				for _, col := range []string{"key_encrypted", "pending_key_encrypted"} {
+					blob := row.KeyEncrypted
+					if col == "pending_key_encrypted" {
+						blob = row.PendingKeyEncrypted
+					}
+					if blob == nil {
+						continue
+					}
+					keyID := kms.KeyIDOf(blob)
+					if keyID == "" {
+						keyID = "(legacy)"
+					}
+					byKeyID[keyID]++
+
+					if kms.KeyIDOf(blob) == newID {
+						if _, err := km.DecryptDeviceKey(blob); err != nil {
+							return fmt.Errorf("device %s %s: decrypt failed: %w", row.DeviceID, col, err)
+						}
+						continue
+					}
+					pending++
+					if *verifyOnly {
+						continue
+					}
+
+					reencrypted, err := rewrap(km, blob)
+					if err != nil {
+						return fmt.Errorf("device %s %s: %w", row.DeviceID, col, err)
+					}
+					if *dryRun {
+						continue
+					}
+					// 以旧密文为条件更新，避免覆盖期间被服务端改写的值
+					result := tx.Exec("UPDATE app.devices_lock SET "+col+" = ? WHERE id = ? AND "+col+" = ?",
+						reencrypted, row.ID, blob)
+					if result.Error != nil {
+						return fmt.Errorf("device %s %s: update failed: %w", row.DeviceID, col, result.Error)
+					}
+					if result.RowsAffected == 1 {
+						batchRewritten++
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fail("batch after id %d rolled back: %v (re-run with -after %d)", lastID, err, lastID)
+		}
+
+		rewritten += batchRewritten
+		lastID = rows[len(rows)-1].ID
+		fmt.Printf("batch done: rows=%d rewritten=%d last_id=%d\n", len(rows), batchRewritten, lastID)
+	}
+
+	fmt.Printf("scanned=%d keys_not_on_new_master=%d rewritten=%d\n", scanned, pending, rewritten)
+	for id, n := range byKeyID {
+		fmt.Printf("  key_id %s: %d\n", id, n)
+	}
+	if *verifyOnly && pending > 0 {
+		fail("verify failed: %d keys still encrypted under an old master key", pending)
+	}
+}
+
+// rewrap 解密后用新主密钥加密，并回读校验明文一致。
+func rewrap(km *kms.LocalKMS, blob []byte) ([]byte, error) {
+	plain, err := km.DecryptDeviceKey(blob)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed: %w", err)
+	}
+	defer clear(plain)
+
+	reencrypted, err := km.EncryptDeviceKey(plain)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt failed: %w", err)
+	}
+	check, err := km.DecryptDeviceKey(reencrypted)
+	if err != nil || !bytes.Equal(check, plain) {
+		return nil, fmt.Errorf("verification after re-encryption failed")
+	}
+	clear(check)
+	return reencrypted, nil
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"promthus/internal/kms"
+)
+
+func TestRewrap(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x5a, 0x01}, 16)
+	newKey := bytes.Repeat([]byte{0xa5, 0x02}, 16)
+	plain := []byte("0123456789abcdef")
+
+	blob, err := kms.NewLocalKMS(oldKey).EncryptDeviceKey(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	km := kms.NewLocalKMS(newKey, oldKey)
+	rewrapped, err := rewrap(km, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kms.KeyIDOf(rewrapped) != km.ActiveKeyID() {
+		t.Fatalf("rewrapped key id = %q, want %q", kms.KeyIDOf(rewrapped), km.ActiveKeyID())
+	}
+	// 只加载新主密钥也能解开，说明不再依赖旧主密钥
+	got, err := kms.NewLocalKMS(newKey).DecryptDeviceKey(rewrapped)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("decrypt rewrapped = %x, %v", got, err)
+	}
+
+	if _, err := rewrap(kms.NewLocalKMS(newKey), blob); err == nil {
+		t.Fatal("rewrap without the old master key should fail")
+	}
+}
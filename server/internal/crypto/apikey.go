@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// API keys look like psk_<12 hex prefix>_<43 char base64url secret>. The prefix is stored in
+// clear for lookup and display; only the SHA-256 of the whole key is persisted.
+
+const APIKeyPrefix = "psk_"
+
+// GenerateAPIKey returns the full key (shown once) and its lookup prefix.
+func GenerateAPIKey() (key, prefix string, err error) {
+	p := make([]byte, 6)
+	if _, err = rand.Read(p); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(p)
+	key = APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, nil
+}
+
+// ParseAPIKeyPrefix extracts the lookup prefix; ok is false if key is not shaped like an API key.
+func ParseAPIKeyPrefix(key string) (prefix string, ok bool) {
+	rest, found := strings.CutPrefix(key, APIKeyPrefix)
+	if !found {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || len(prefix) != 12 || len(secret) != 43 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashAPIKey is a plain SHA-256: the key carries 256 bits of entropy, so a slow hash adds nothing.
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
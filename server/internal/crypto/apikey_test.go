@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix+prefix+"_") {
+		t.Fatalf("key %q does not start with psk_%s_", key, prefix)
+	}
+	got, ok := ParseAPIKeyPrefix(key)
+	if !ok || got != prefix {
+		t.Fatalf("ParseAPIKeyPrefix = %q, %v; want %q, true", got, ok, prefix)
+	}
+
+	other, otherPrefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || otherPrefix == prefix {
+		t.Fatal("two generated keys collide")
+	}
+	if bytes.Equal(HashAPIKey(key), HashAPIKey(other)) {
+		t.Fatal("different keys hash equal")
+	}
+	if !bytes.Equal(HashAPIKey(key), HashAPIKey(key)) {
+		t.Fatal("HashAPIKey is not deterministic")
+	}
+	if len(HashAPIKey(key)) != 32 {
+		t.Fatalf("hash length = %d, want 32", len(HashAPIKey(key)))
+	}
+}
+
+func TestParseAPIKeyPrefixRejectsBadShapes(t *testing.T) {
+	secret := strings.Repeat("A", 43)
+	tests := []struct {
+		name, key string
+	}{
+		{"empty", ""},
+		{"no psk prefix", "abcdef012345_" + secret},
+		{"wrong scheme", "pat_abcdef012345_" + secret},
+		{"no separator", "psk_abcdef012345" + secret},
+		{"short prefix", "psk_abcdef01234_" + secret},
+		{"long prefix", "psk_abcdef0123456_" + secret},
+		{"non-hex prefix", "psk_abcdef01234z_" + secret},
+		{"short secret", "psk_abcdef012345_" + secret[:42]},
+		{"long secret", "psk_abcdef012345_" + secret + "A"},
+		{"jwt", "eyJhbGciOiJIUzI1NiJ9.e30.sig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if prefix, ok := ParseAPIKeyPrefix(tt.key); ok {
+				t.Fatalf("ParseAPIKeyPrefix(%q) = %q, true; want rejection", tt.key, prefix)
+			}
+		})
+	}
+	if prefix, ok := ParseAPIKeyPrefix("psk_abcdef012345_" + secret); !ok || prefix != "abcdef012345" {
+		t.Fatalf("well-formed key: %q, %v", prefix, ok)
+	}
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，取 8 位结果的末 6 位
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("T=%d: code = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		got, ok := VerifyTOTP(secret, " "+TOTPCode(secret, step+offset)+" ", now, 1)
+		if !ok || got != step+offset {
+			t.Errorf("offset %d: step = %d, %v", offset, got, ok)
+		}
+	}
+	if _, ok := VerifyTOTP(secret, TOTPCode(secret, step+2), now, 1); ok {
+		t.Error("code two steps ahead accepted with skew 1")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := VerifyTOTP(secret, bad, now, 1); ok {
+			t.Errorf("VerifyTOTP(%q) accepted", bad)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Promthus", "138****0001", []byte("12345678901234567890"))
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" ||
+		q.Get("issuer") != "Promthus" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("uri = %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || seen[c] {
+			t.Fatalf("bad or duplicate code %q", c)
+		}
+		seen[c] = true
+	}
+	// 大小写、连字符与首尾空白不影响匹配
+	c := codes[0]
+	if HashRecoveryCode(c) != HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(c, "-", ""))+"\n") {
+		t.Fatal("recovery code hash not normalized")
+	}
+	if HashRecoveryCode(codes[0]) == HashRecoveryCode(codes[1]) {
+		t.Fatal("distinct codes share a hash")
+	}
+}
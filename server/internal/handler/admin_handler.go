@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
 	"promthus/internal/logger"
+	"promthus/internal/middleware"
 	"promthus/internal/model"
 	"promthus/internal/service"
 
@@ -57,7 +59,12 @@ func (h *AdminHandler) ListUsers(c *gin.Context) {
 	status := c.Query("status")
 	search := c.Query("search")
 
-	users, total := h.svc.ListUsers(page, pageSize, role, status, search)
+	operatorID := c.GetInt64("user_id")
+	users, total, err := h.svc.ListUsers(operatorID, page, pageSize, role, status, search)
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list users")
+		return
+	}
 	model.OK(c, gin.H{"items": users, "total": total})
 }
 
@@ -71,7 +78,7 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 	operatorID := c.GetInt64("user_id")
 	user, _, code, msg := h.svc.CreateUser(&req, operatorID)
 	if code != 0 {
-		failWithLogStatus(c, http.StatusInternalServerError, code, msg)
+		failWithLog(c, code, msg)
 		return
 	}
 
@@ -109,6 +116,221 @@ func (h *AdminHandler) ResetPassword(c *gin.Context) {
 	model.OK(c, gin.H{"new_password": newPassword})
 }
 
+func (h *AdminHandler) ListUserSessions(c *gin.Context) {
+	items, code, msg := h.svc.ListUserSessions(c.Param("uuid"), c.GetInt64("user_id"))
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, gin.H{"items": items})
+}
+
+func (h *AdminHandler) RevokeUserSession(c *gin.Context) {
+	operatorID := c.GetInt64("user_id")
+
+	code, msg := h.svc.RevokeUserSession(c.Param("uuid"), c.Param("jti"), operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userUUID := c.Param("uuid")
+	operatorID := c.GetInt64("user_id")
+
+	code, msg := h.svc.UnlockUser(userUUID, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+// SetAdminScope 整体替换管辖范围，两个列表都为空即不受限
+func (h *AdminHandler) SetAdminScope(c *gin.Context) {
+	userUUID := c.Param("uuid")
+	var req service.SetAdminScopeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	code, msg := h.svc.SetAdminScope(userUUID, &req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AdminHandler) ResetMFA(c *gin.Context) {
+	userUUID := c.Param("uuid")
+	operatorID := c.GetInt64("user_id")
+
+	code, msg := h.svc.ResetMFA(userUUID, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+// ==================== Roles ====================
+
+// ListRoles 同时返回可分配的全部动作，供角色编辑页使用
+func (h *AdminHandler) ListRoles(c *gin.Context) {
+	roles, err := h.svc.ListRoles()
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list roles")
+		return
+	}
+
+	model.OK(c, gin.H{"items": roles, "permissions": middleware.Permissions})
+}
+
+func (h *AdminHandler) CreateRole(c *gin.Context) {
+	var req service.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	role, code, msg := h.svc.CreateRole(&req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, role)
+}
+
+func (h *AdminHandler) UpdateRole(c *gin.Context) {
+	var req service.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	code, msg := h.svc.UpdateRole(c.Param("name"), &req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AdminHandler) DeleteRole(c *gin.Context) {
+	operatorID := c.GetInt64("user_id")
+	code, msg := h.svc.DeleteRole(c.Param("name"), operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+// ==================== Service Accounts ====================
+
+func (h *AdminHandler) ListServiceAccounts(c *gin.Context) {
+	accounts, err := h.svc.ListServiceAccounts()
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list service accounts")
+		return
+	}
+
+	model.OK(c, gin.H{"items": accounts})
+}
+
+func (h *AdminHandler) CreateServiceAccount(c *gin.Context) {
+	var req service.CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	account, code, msg := h.svc.CreateServiceAccount(&req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, account)
+}
+
+func (h *AdminHandler) UpdateServiceAccount(c *gin.Context) {
+	var req service.UpdateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	code, msg := h.svc.UpdateServiceAccount(c.Param("uuid"), &req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	keys, code, msg := h.svc.ListAPIKeys(c.Param("uuid"))
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, gin.H{"items": keys})
+}
+
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req service.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	resp, code, msg := h.svc.CreateAPIKey(c.Param("uuid"), &req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid api key id")
+		return
+	}
+
+	operatorID := c.GetInt64("user_id")
+	code, msg := h.svc.RevokeAPIKey(c.Param("uuid"), id, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
 // ==================== Devices ====================
 
 func (h *AdminHandler) ListDevices(c *gin.Context) {
@@ -122,8 +344,8 @@ func (h *AdminHandler) ListDevices(c *gin.Context) {
 	pipelineTag := c.Query("pipeline_tag")
 	search := c.Query("search")
 
-	lockSvc := service.NewLockService(nil, nil)
-	devices, total, err := lockSvc.GetDeviceList(page, pageSize, status, pipelineTag, search)
+	operatorID := c.GetInt64("user_id")
+	devices, total, err := h.svc.ListDevices(operatorID, page, pageSize, status, pipelineTag, search)
 	if err != nil {
 		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list devices")
 		return
@@ -149,6 +371,25 @@ func (h *AdminHandler) CreateDevice(c *gin.Context) {
 	model.OK(c, device)
 }
 
+func (h *AdminHandler) RotateDeviceKey(c *gin.Context) {
+	var req service.RotateDeviceKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+			return
+		}
+	}
+
+	operatorID := c.GetInt64("user_id")
+	resp, code, msg := h.svc.RotateDeviceKey(c.Param("device_id"), &req, operatorID)
+	if code != 0 {
+		failWithLog(c, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
 // ==================== Permissions ====================
 
 func (h *AdminHandler) GrantPermission(c *gin.Context) {
@@ -224,7 +465,12 @@ func (h *AdminHandler) ListPermissions(c *gin.Context) {
 		status = &sv
 	}
 
-	perms, total := h.svc.ListPermissions(userID, deviceIDPtr, status, page, pageSize)
+	operatorID := c.GetInt64("user_id")
+	perms, total, err := h.svc.ListPermissions(operatorID, userID, deviceIDPtr, status, page, pageSize)
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list permissions")
+		return
+	}
 	model.OK(c, gin.H{"items": perms, "total": total})
 }
 
@@ -247,7 +493,12 @@ func (h *AdminHandler) ListAlerts(c *gin.Context) {
 		severity = &sv
 	}
 
-	alerts, total := h.svc.ListAlerts(status, deviceID, severity, page, pageSize)
+	operatorID := c.GetInt64("user_id")
+	alerts, total, err := h.svc.ListAlerts(operatorID, status, deviceID, severity, page, pageSize)
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to list alerts")
+		return
+	}
 	model.OK(c, gin.H{"items": alerts, "total": total})
 }
 
@@ -304,7 +555,8 @@ func (h *AdminHandler) ListAuditLogs(c *gin.Context) {
 		endTime = &t
 	}
 
-	data, err := h.svc.ListAuditLogs(userID, deviceID, action, startTime, endTime, cursor, limit)
+	operatorID := c.GetInt64("user_id")
+	data, err := h.svc.ListAuditLogs(operatorID, userID, deviceID, action, startTime, endTime, cursor, limit)
 	if err != nil {
 		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to query audit logs")
 		return
@@ -313,10 +565,51 @@ func (h *AdminHandler) ListAuditLogs(c *gin.Context) {
 	model.OK(c, data)
 }
 
+func (h *AdminHandler) ListLoginLogs(c *gin.Context) {
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
+			limit = v
+		}
+	}
+	cursor := c.Query("cursor")
+
+	var userID *int64
+	if v := c.Query("user_id"); v != "" {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		userID = &id
+	}
+	result := c.Query("result")
+	clientIP := c.Query("client_ip")
+	if clientIP != "" && net.ParseIP(clientIP) == nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid client_ip")
+		return
+	}
+
+	var startTime, endTime *time.Time
+	if v := c.Query("start_time"); v != "" {
+		t, _ := time.Parse(time.RFC3339, v)
+		startTime = &t
+	}
+	if v := c.Query("end_time"); v != "" {
+		t, _ := time.Parse(time.RFC3339, v)
+		endTime = &t
+	}
+
+	operatorID := c.GetInt64("user_id")
+	data, err := h.svc.ListLoginLogs(operatorID, userID, result, clientIP, startTime, endTime, cursor, limit)
+	if err != nil {
+		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to query login logs")
+		return
+	}
+
+	model.OK(c, data)
+}
+
 // ==================== Dashboard ====================
 
 func (h *AdminHandler) Dashboard(c *gin.Context) {
-	data, err := h.svc.GetDashboard()
+	data, err := h.svc.GetDashboard(c.GetInt64("user_id"))
 	if err != nil {
 		failWithLogStatus(c, http.StatusInternalServerError, model.CodeInternalError, "failed to get dashboard data")
 		return
@@ -9,6 +9,7 @@ import (
 	"promthus/internal/service"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -33,6 +34,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		status := http.StatusUnauthorized
 		if code == model.CodeAccountDisabled {
 			status = http.StatusForbidden
+		} else if code == model.CodeAccountLocked {
+			status = http.StatusLocked
 		} else if code == model.CodeInternalError {
 			status = http.StatusInternalServerError
 		}
@@ -46,6 +49,181 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	model.OK(c, resp)
 }
 
+func (h *AuthHandler) OIDCConfig(c *gin.Context) {
+	model.OK(c, h.svc.OIDCConfig())
+}
+
+func (h *AuthHandler) OIDCAuthorize(c *gin.Context) {
+	resp, code, msg := h.svc.OIDCAuthorize(c.ClientIP())
+	if code != 0 {
+		status := http.StatusBadRequest
+		if code == model.CodeInternalError {
+			status = http.StatusInternalServerError
+			logger.Error("auth oidc authorize error", zap.String("request_id", model.GetRequestID(c)), zap.String("msg", msg))
+		}
+		model.Fail(c, status, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	var req service.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	resp, code, msg := h.svc.OIDCCallback(&req, c.Request.UserAgent(), c.ClientIP())
+	if code != 0 {
+		status := http.StatusUnauthorized
+		if code == model.CodeParamError {
+			status = http.StatusBadRequest
+		} else if code == model.CodeAccountDisabled {
+			status = http.StatusForbidden
+		} else if code == model.CodeAccountLocked {
+			status = http.StatusLocked
+		} else if code == model.CodeInternalError {
+			status = http.StatusInternalServerError
+			logger.Error("auth oidc callback error", zap.String("request_id", model.GetRequestID(c)), zap.String("msg", msg))
+		}
+		model.Fail(c, status, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req service.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	resp, code, msg := h.svc.Refresh(&req, c.Request.UserAgent(), c.ClientIP())
+	if code != 0 {
+		status := http.StatusUnauthorized
+		if code == model.CodeAccountDisabled {
+			status = http.StatusForbidden
+		} else if code == model.CodeInternalError {
+			status = http.StatusInternalServerError
+			logger.Error("auth refresh error", zap.String("request_id", model.GetRequestID(c)), zap.Int("code", code), zap.String("msg", msg))
+		}
+		model.Fail(c, status, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req service.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	family, _ := c.Get("session_family")
+	familyID, _ := family.(uuid.UUID)
+	code, msg := h.svc.ChangePassword(c.GetInt64("user_id"), familyID, &req)
+	if code != 0 {
+		status := http.StatusBadRequest
+		switch code {
+		case model.CodeAuthFailed:
+			// 当前密码错误不能返回 401，否则前端会当作会话失效处理
+			status = http.StatusForbidden
+		case model.CodeAccountDisabled:
+			status = http.StatusForbidden
+		case model.CodeInternalError:
+			status = http.StatusInternalServerError
+			logger.Error("auth change password error", zap.String("request_id", model.GetRequestID(c)), zap.Int("code", code), zap.String("msg", msg))
+		}
+		model.Fail(c, status, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	family, _ := c.Get("session_family")
+	familyID, _ := family.(uuid.UUID)
+
+	items, code, msg := h.svc.ListSessions(c.GetInt64("user_id"), familyID)
+	if code != 0 {
+		logger.Error("auth list sessions error", zap.String("request_id", model.GetRequestID(c)), zap.Int("code", code), zap.String("msg", msg))
+		model.Fail(c, http.StatusInternalServerError, code, msg)
+		return
+	}
+
+	model.OK(c, gin.H{"items": items})
+}
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	code, msg := h.svc.RevokeSession(c.GetInt64("user_id"), c.Param("jti"))
+	if code != 0 {
+		status := http.StatusBadRequest
+		if code == model.CodeInternalError {
+			status = http.StatusInternalServerError
+			logger.Error("auth revoke session error", zap.String("request_id", model.GetRequestID(c)), zap.Int("code", code), zap.String("msg", msg))
+		}
+		model.Fail(c, status, code, msg)
+		return
+	}
+
+	model.OK(c, nil)
+}
+
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	var req service.MFAEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	resp, code, msg := h.svc.EnrollMFA(&req)
+	if code != 0 {
+		h.failMFA(c, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req service.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	resp, code, msg := h.svc.VerifyMFA(&req, c.Request.UserAgent(), c.ClientIP())
+	if code != 0 {
+		h.failMFA(c, code, msg)
+		return
+	}
+
+	model.OK(c, resp)
+}
+
+func (h *AuthHandler) failMFA(c *gin.Context, code int, msg string) {
+	status := http.StatusUnauthorized
+	switch code {
+	case model.CodeParamError:
+		status = http.StatusBadRequest
+	case model.CodeAccountDisabled:
+		status = http.StatusForbidden
+	case model.CodeAccountLocked:
+		status = http.StatusLocked
+	case model.CodeInternalError:
+		status = http.StatusInternalServerError
+		logger.Error("auth mfa error", zap.String("request_id", model.GetRequestID(c)), zap.Int("code", code), zap.String("msg", msg))
+	}
+	model.Fail(c, status, code, msg)
+}
+
 func (h *AuthHandler) Logout(c *gin.Context) {
 	header := c.GetHeader("Authorization")
 	tokenStr := header[7:]
@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 
+	"promthus/internal/kms"
 	"promthus/internal/model"
 	"promthus/internal/repository"
 
@@ -21,8 +22,13 @@ func Health(c *gin.Context) {
 		return
 	}
 
+	// 密封状态仍返回 200：进程健康，只是等待解封，避免编排系统重启实例导致已提交的分片丢失
+	status := "healthy"
+	if kms.Sealed() {
+		status = "sealed"
+	}
 	model.OK(c, gin.H{
-		"status":  "healthy",
+		"status":  status,
 		"service": "lock-service",
 	})
 }
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+
+	"promthus/internal/kms"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SysHandler 本机运维接口：Shamir 解封
+type SysHandler struct {
+	settingStore repository.SettingStore
+}
+
+func NewSysHandler(ss repository.SettingStore) *SysHandler {
+	return &SysHandler{settingStore: ss}
+}
+
+type UnsealRequest struct {
+	Share string `json:"share" binding:"required"`
+}
+
+func (h *SysHandler) SealStatus(c *gin.Context) {
+	model.OK(c, kms.SealStatus())
+}
+
+func (h *SysHandler) Unseal(c *gin.Context) {
+	var req UnsealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, "invalid request: "+err.Error())
+		return
+	}
+
+	status, err := kms.SubmitUnsealShare(req.Share, h.settingStore)
+	if err != nil {
+		logger.Warn("unseal: share rejected", zap.Error(err), zap.Int("progress", status.Progress))
+		model.Fail(c, http.StatusBadRequest, model.CodeParamError, err.Error())
+		return
+	}
+	logger.Info("unseal: share accepted",
+		zap.Bool("sealed", status.Sealed), zap.Int("progress", status.Progress), zap.Int("threshold", status.Threshold))
+	model.OK(c, status)
+}
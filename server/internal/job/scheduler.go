@@ -0,0 +1,80 @@
+// Package job 提供后台定时任务调度：每个任务按固定间隔运行，
+// 运行前通过 PostgreSQL advisory lock 抢占，多副本部署时同一任务同一时刻只在一个实例上执行。
+package job
+
+import (
+	"context"
+	"time"
+
+	"promthus/internal/logger"
+	"promthus/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// Func 任务函数，返回错误只记日志，不影响下一次调度。
+type Func func() error
+
+type entry struct {
+	name     string
+	interval time.Duration
+	fn       Func
+}
+
+type Scheduler struct {
+	entries []entry
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Register 注册任务；interval <= 0 的任务不会启动。
+func (s *Scheduler) Register(name string, interval time.Duration, fn Func) {
+	s.entries = append(s.entries, entry{name: name, interval: interval, fn: fn})
+}
+
+// Start 为每个任务启动一个 goroutine，ctx 取消后全部退出。
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, e := range s.entries {
+		if e.interval <= 0 {
+			logger.Info("job disabled", zap.String("job", e.name))
+			continue
+		}
+		go s.loop(ctx, e)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, e entry) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.run(e)
+		}
+	}
+}
+
+func (s *Scheduler) run(e entry) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("job panic", zap.String("job", e.name), zap.Any("panic", r))
+		}
+	}()
+
+	start := time.Now()
+	acquired, err := repository.WithAdvisoryLock("job:"+e.name, e.fn)
+	if !acquired && err == nil {
+		logger.Debug("job skipped, running on another instance", zap.String("job", e.name))
+		return
+	}
+	if err != nil {
+		logger.Error("job failed", zap.String("job", e.name), zap.Error(err), zap.Duration("elapsed", time.Since(start)))
+		return
+	}
+	logger.Debug("job finished", zap.String("job", e.name), zap.Duration("elapsed", time.Since(start)))
+}
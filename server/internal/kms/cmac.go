@@ -0,0 +1,144 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+
+	"promthus/internal/model"
+)
+
+/*
+AES-CMAC（RFC 4493）：
+1. 用 K 加密全零块得到 L，左移一位（最高位溢出则异或 Rb=0x87）得子密钥 K1，再移一位得 K2；
+2. 消息按 16 字节分块，最后一块完整则异或 K1，不完整则补 0x80 0x00... 后异或 K2；
+3. CBC-MAC 链式加密，最后一块的密文即为 16 字节 MAC。
+锁具 MCU 自带 AES 硬件，按同样步骤即可算出相同结果。
+*/
+
+const cmacBlockSize = aes.BlockSize
+
+// rb is the constant used in subkey generation for 128-bit block ciphers.
+const rb = 0x87
+
+var ErrUnknownMACAlgorithm = errors.New("unknown mac algorithm")
+
+// aesCMAC computes AES-CMAC as specified in RFC 4493.
+func aesCMAC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cmacWithBlock(block, data), nil
+}
+
+func cmacWithBlock(block cipher.Block, data []byte) []byte {
+	k1, k2 := cmacSubkeys(block)
+
+	n := (len(data) + cmacBlockSize - 1) / cmacBlockSize
+	complete := n > 0 && len(data)%cmacBlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, cmacBlockSize)
+	lastStart := (n - 1) * cmacBlockSize
+	if complete {
+		xorBlock(last, data[lastStart:], k1)
+	} else {
+		copy(last, data[lastStart:])
+		last[len(data)-lastStart] = 0x80
+		xorBlock(last, last, k2)
+	}
+
+	x := make([]byte, cmacBlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBlock(x, x, data[i*cmacBlockSize:(i+1)*cmacBlockSize])
+		block.Encrypt(x, x)
+	}
+	xorBlock(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+func cmacSubkeys(block cipher.Block) ([]byte, []byte) {
+	l := make([]byte, cmacBlockSize)
+	block.Encrypt(l, l)
+	k1 := shiftLeftXorRb(l)
+	k2 := shiftLeftXorRb(k1)
+	return k1, k2
+}
+
+// shiftLeftXorRb 整块左移 1 位，若原最高位为 1 则末字节异或 Rb。
+func shiftLeftXorRb(in []byte) []byte {
+	out := make([]byte, len(in))
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = in[i]<<1 | carry
+		carry = in[i] >> 7
+	}
+	out[len(out)-1] ^= byte(subtle.ConstantTimeSelect(int(carry), rb, 0))
+	return out
+}
+
+func xorBlock(dst, a, b []byte) {
+	for i := 0; i < cmacBlockSize; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// legacyHMAC 老版本锁具固件使用的 HMAC-SHA256 截断 16 字节，迁移期间继续支持。
+func legacyHMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// ComputeMAC 按设备登记的 MAC 算法分派；空值视为老设备的 HMAC。
+func ComputeMAC(k KMS, algorithm string, key, data []byte) ([]byte, error) {
+	switch algorithm {
+	case model.MACAlgorithmCMAC:
+		return k.ComputeCMAC(key, data)
+	case model.MACAlgorithmHMAC, "":
+		return k.ComputeHMAC(key, data)
+	default:
+		return nil, ErrUnknownMACAlgorithm
+	}
+}
+
+// VerifyMAC 重新计算 MAC 并做常量时间比较，用于校验锁具签名的开锁回执。
+func VerifyMAC(k KMS, algorithm string, key, data, mac []byte) (bool, error) {
+	expected, err := ComputeMAC(k, algorithm, key, data)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(expected, mac), nil
+}
+
+// VerifyDeviceMAC 同 VerifyMAC，但设备密钥以密文传入，由 KMS 实现决定在何处计算。
+func VerifyDeviceMAC(k KMS, algorithm string, encryptedKey, data, mac []byte) (bool, error) {
+	expected, err := k.ComputeDeviceMAC(algorithm, encryptedKey, data)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(expected, mac), nil
+}
+
+// softwareDeviceMAC 软件实现：解密设备密钥、计算 MAC 后立即清零明文。
+func softwareDeviceMAC(k KMS, algorithm string, encryptedKey, data []byte) ([]byte, error) {
+	key, err := k.DecryptDeviceKey(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	return ComputeMAC(k, algorithm, key, data)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
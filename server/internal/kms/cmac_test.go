@@ -0,0 +1,122 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"promthus/internal/model"
+)
+
+// RFC 4493 §4 测试向量
+const rfc4493Key = "2b7e151628aed2a6abf7158809cf4f3c"
+
+const rfc4493Message = "6bc1bee22e409f96e93d7e117393172a" +
+	"ae2d8a571e03ac9c9eb76fac45af8e51" +
+	"30c81c46a35ce411e5fbc1191a0a52ef" +
+	"f69f2445df4f9b17ad2b417be66c3710"
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestCMACSubkeys(t *testing.T) {
+	block, err := aes.NewCipher(mustHex(t, rfc4493Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1, k2 := cmacSubkeys(block)
+	if got, want := hex.EncodeToString(k1), "fbeed618357133667c85e08f7236a8de"; got != want {
+		t.Errorf("K1 = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(k2), "f7ddac306ae266ccf90bc11ee46d513b"; got != want {
+		t.Errorf("K2 = %s, want %s", got, want)
+	}
+}
+
+func TestAESCMACRFC4493(t *testing.T) {
+	msg := mustHex(t, rfc4493Message)
+	tests := []struct {
+		name string
+		len  int
+		want string
+	}{
+		{"empty", 0, "bb1d6929e95937287fa37d129b756746"},
+		{"16 bytes", 16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"40 bytes", 40, "dfa66747de9ae63030ca32611497c827"},
+		{"64 bytes", 64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac, err := aesCMAC(mustHex(t, rfc4493Key), msg[:tt.len])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(mac); got != tt.want {
+				t.Errorf("AES-CMAC = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAESCMACRejectsBadKey(t *testing.T) {
+	if _, err := aesCMAC(make([]byte, 7), nil); err == nil {
+		t.Fatal("expected error for 7-byte key")
+	}
+}
+
+func TestLocalKMSComputeDeviceMACDispatch(t *testing.T) {
+	k := NewLocalKMS(bytes.Repeat([]byte{1, 2}, 16))
+	deviceKey := mustHex(t, rfc4493Key)
+	encrypted, err := k.EncryptDeviceKey(deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := mustHex(t, rfc4493Message)[:16]
+
+	h := hmac.New(sha256.New, deviceKey)
+	h.Write(data)
+	wantHMAC := h.Sum(nil)[:16]
+	wantCMAC := mustHex(t, "070a16b46b4d4144f79bdd9dd04a287c")
+
+	tests := []struct {
+		algorithm string
+		want      []byte
+	}{
+		{model.MACAlgorithmCMAC, wantCMAC},
+		{model.MACAlgorithmHMAC, wantHMAC},
+		{"", wantHMAC}, // 未登记算法的老设备
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			got, err := k.ComputeDeviceMAC(tt.algorithm, encrypted, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ComputeDeviceMAC(%q) = %x, want %x", tt.algorithm, got, tt.want)
+			}
+			ok, err := VerifyDeviceMAC(k, tt.algorithm, encrypted, data, tt.want)
+			if err != nil || !ok {
+				t.Errorf("VerifyDeviceMAC(%q) = %v, %v", tt.algorithm, ok, err)
+			}
+		})
+	}
+
+	if _, err := k.ComputeDeviceMAC("des-mac", encrypted, data); !errors.Is(err, ErrUnknownMACAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v, want ErrUnknownMACAlgorithm", err)
+	}
+	ok, err := VerifyDeviceMAC(k, model.MACAlgorithmCMAC, encrypted, data, wantHMAC)
+	if err != nil || ok {
+		t.Errorf("VerifyDeviceMAC with HMAC value under aes-cmac = %v, %v; want false", ok, err)
+	}
+}
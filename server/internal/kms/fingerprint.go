@@ -0,0 +1,89 @@
+package kms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"promthus/internal/logger"
+	"promthus/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+/*
+主密钥指纹：SHA-256("promthus-master-key-fingerprint" || key) 的 hex，存于 app.system_settings。
+启动时比对，主密钥配错（换了机器、挂错文件）时直接拒绝启动，而不是在每次挑战时才解密失败。
+主密钥轮换期间，库中指纹匹配任一已加载的旧主密钥即可通过，随后更新为当前主密钥的指纹。
+*/
+
+const fingerprintSettingKey = "kms.master_key_fingerprint"
+
+var (
+	ErrMasterKeyMalformed  = errors.New("master key malformed")
+	ErrFingerprintMismatch = errors.New("master key fingerprint does not match the one recorded in database")
+)
+
+// MasterKeyFingerprint 返回主密钥指纹（不可逆，可安全落库与打印）。
+func MasterKeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(append([]byte("promthus-master-key-fingerprint"), key...))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprinter 由持有主密钥明文的实现提供（如 LocalKMS）；托管 KMS 不实现则跳过校验。
+type fingerprinter interface {
+	masterKeyFingerprints() (active string, all []string, ephemeral bool)
+}
+
+func (k *LocalKMS) masterKeyFingerprints() (string, []string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	all := make([]string, 0, len(k.order))
+	for _, id := range k.order {
+		all = append(all, MasterKeyFingerprint(k.keys[id]))
+	}
+	return MasterKeyFingerprint(k.keys[k.activeID]), all, k.ephemeral
+}
+
+// VerifyFingerprint 比对库中记录的主密钥指纹；首次启动时写入当前指纹。密封状态下跳过，解封时再校验。
+func VerifyFingerprint(store repository.SettingStore) error {
+	if Sealed() {
+		return nil
+	}
+	return verifyFingerprint(Get(), store)
+}
+
+func verifyFingerprint(k KMS, store repository.SettingStore) error {
+	fp, ok := k.(fingerprinter)
+	if !ok {
+		return nil
+	}
+	active, all, ephemeral := fp.masterKeyFingerprints()
+
+	stored, found, err := store.Get(fingerprintSettingKey)
+	if err != nil {
+		return fmt.Errorf("read master key fingerprint: %w", err)
+	}
+	if !found {
+		if ephemeral {
+			logger.Warn("ephemeral master key in use, fingerprint not recorded")
+			return nil
+		}
+		logger.Info("recording master key fingerprint", zap.String("fingerprint", active[:16]))
+		return store.Set(fingerprintSettingKey, active)
+	}
+
+	for _, candidate := range all {
+		if candidate != stored {
+			continue
+		}
+		if stored != active && !ephemeral {
+			logger.Info("master key rotated, updating recorded fingerprint",
+				zap.String("old", stored[:16]), zap.String("new", active[:16]))
+			return store.Set(fingerprintSettingKey, active)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w (recorded %s..., active %s...)", ErrFingerprintMismatch, stored[:16], active[:16])
+}
@@ -0,0 +1,125 @@
+package kms
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"promthus/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+type memSettingStore map[string]string
+
+func (m memSettingStore) Get(key string) (string, bool, error) {
+	v, ok := m[key]
+	return v, ok, nil
+}
+
+func (m memSettingStore) Set(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	logger.L = zap.NewNop()
+	oldKey, newKey, otherKey := testMasterKey(1), testMasterKey(2), testMasterKey(3)
+	store := memSettingStore{}
+
+	// 首次启动记录指纹
+	if err := verifyFingerprint(NewLocalKMS(oldKey), store); err != nil {
+		t.Fatal(err)
+	}
+	if store[fingerprintSettingKey] != MasterKeyFingerprint(oldKey) {
+		t.Fatal("fingerprint not recorded on first start")
+	}
+	if err := verifyFingerprint(NewLocalKMS(oldKey), store); err != nil {
+		t.Fatalf("same key: %v", err)
+	}
+
+	// 挂错主密钥拒绝启动，且不改写记录
+	if err := verifyFingerprint(NewLocalKMS(otherKey), store); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("wrong key: err = %v, want ErrFingerprintMismatch", err)
+	}
+	if store[fingerprintSettingKey] != MasterKeyFingerprint(oldKey) {
+		t.Fatal("mismatch overwrote recorded fingerprint")
+	}
+
+	// 轮换：旧主密钥仍在解密列表中即通过，并更新为新主密钥指纹
+	if err := verifyFingerprint(NewLocalKMS(newKey, oldKey), store); err != nil {
+		t.Fatalf("rotation: %v", err)
+	}
+	if store[fingerprintSettingKey] != MasterKeyFingerprint(newKey) {
+		t.Fatal("fingerprint not updated after rotation")
+	}
+	if err := verifyFingerprint(NewLocalKMS(oldKey), store); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("rollback to old key: err = %v, want ErrFingerprintMismatch", err)
+	}
+}
+
+func TestVerifyFingerprintEphemeralKey(t *testing.T) {
+	logger.L = zap.NewNop()
+	store := memSettingStore{}
+	k := NewLocalKMS(testMasterKey(9))
+	k.ephemeral = true
+	if err := verifyFingerprint(k, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store[fingerprintSettingKey]; ok {
+		t.Fatal("ephemeral key fingerprint recorded")
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := testMasterKey(1)
+	tests := []struct {
+		name    string
+		raw     []byte
+		wantErr bool
+	}{
+		{"32 bytes", key, false},
+		{"trailing newline", append(append([]byte(nil), key...), '\n'), false},
+		{"trailing crlf", append(append([]byte(nil), key...), '\r', '\n'), false},
+		{"short", key[:31], true},
+		{"empty", nil, true},
+		{"long", append(append([]byte(nil), key...), 'x'), true},
+		{"all identical", make([]byte, 32), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMasterKey(tt.raw)
+			if tt.wantErr {
+				if !errors.Is(err, ErrMasterKeyMalformed) {
+					t.Fatalf("err = %v, want ErrMasterKeyMalformed", err)
+				}
+				return
+			}
+			if err != nil || string(got) != string(key) {
+				t.Fatalf("ParseMasterKey = %x, %v", got, err)
+			}
+		})
+	}
+}
+
+// release 模式下短密钥与缺失文件都报错；debug 模式短密钥按旧规则补零
+func TestLoadMasterKeyStrict(t *testing.T) {
+	logger.L = zap.NewNop()
+	dir := t.TempDir()
+	short := filepath.Join(dir, "short.key")
+	if err := os.WriteFile(short, []byte("too-short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadMasterKey(short, true); !errors.Is(err, ErrMasterKeyMalformed) {
+		t.Errorf("strict short key: err = %v, want ErrMasterKeyMalformed", err)
+	}
+	if _, err := loadMasterKey(filepath.Join(dir, "missing.key"), true); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("strict missing key: err = %v, want os.ErrNotExist", err)
+	}
+	key, err := loadMasterKey(short, false)
+	if err != nil || len(key) != 32 || string(key[:9]) != "too-short" {
+		t.Errorf("lenient short key = %q, %v", key, err)
+	}
+}
@@ -0,0 +1,52 @@
+package kms
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+/*
+换钥报文（设备密钥轮换时经手机下发给锁具）：
+  version(2B 大端) || AES-128_{K_old}(K_new)(16B) || MAC_{K_old}(version || 密文)(16B)
+锁具用当前 K_old 校验 MAC、解密得到 K_new，写入后以新版本号应答；
+MAC 算法与该设备挑战应答一致（aes-cmac / hmac-sha256）。
+*/
+
+var ErrInvalidDeviceKeyLength = errors.New("device key must be 16 bytes")
+
+// WrapKeyUpdate builds the key-update payload for a lock, protected by its current key.
+func WrapKeyUpdate(k KMS, algorithm string, oldKey, newKey []byte, version int16) ([]byte, error) {
+	if len(oldKey) != 16 || len(newKey) != 16 {
+		return nil, ErrInvalidDeviceKeyLength
+	}
+	block, err := aes.NewCipher(oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 2+aes.BlockSize)
+	binary.BigEndian.PutUint16(payload[:2], uint16(version))
+	block.Encrypt(payload[2:], newKey)
+
+	mac, err := ComputeMAC(k, algorithm, oldKey, payload)
+	if err != nil {
+		return nil, err
+	}
+	return append(payload, mac...), nil
+}
+
+// softwareKeyUpdate 软件实现：解密新旧设备密钥后生成换钥报文，明文用完即清零。
+func softwareKeyUpdate(k KMS, algorithm string, encryptedOldKey, encryptedNewKey []byte, version int16) ([]byte, error) {
+	oldKey, err := k.DecryptDeviceKey(encryptedOldKey)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(oldKey)
+	newKey, err := k.DecryptDeviceKey(encryptedNewKey)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(newKey)
+	return WrapKeyUpdate(k, algorithm, oldKey, newKey, version)
+}
@@ -0,0 +1,74 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"promthus/internal/model"
+)
+
+// 模拟锁具固件解析换钥报文：校验 MAC 后用当前密钥解出新密钥
+func unwrapKeyUpdate(t *testing.T, k KMS, algorithm string, oldKey, payload []byte) (int16, []byte) {
+	t.Helper()
+	if len(payload) != 2+16+16 {
+		t.Fatalf("payload length = %d, want 34", len(payload))
+	}
+	ok, err := VerifyMAC(k, algorithm, oldKey, payload[:18], payload[18:])
+	if err != nil || !ok {
+		t.Fatalf("payload MAC invalid: %v, %v", ok, err)
+	}
+	block, err := aes.NewCipher(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := make([]byte, 16)
+	block.Decrypt(newKey, payload[2:18])
+	return int16(binary.BigEndian.Uint16(payload[:2])), newKey
+}
+
+func TestWrapKeyUpdateRoundTrip(t *testing.T) {
+	k := NewLocalKMS(bytes.Repeat([]byte{7}, 32))
+	oldKey := bytes.Repeat([]byte{0x11}, 16)
+	newKey := bytes.Repeat([]byte{0x22}, 16)
+
+	for _, algorithm := range []string{model.MACAlgorithmCMAC, model.MACAlgorithmHMAC} {
+		t.Run(algorithm, func(t *testing.T) {
+			payload, err := WrapKeyUpdate(k, algorithm, oldKey, newKey, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, got := unwrapKeyUpdate(t, k, algorithm, oldKey, payload)
+			if version != 3 || !bytes.Equal(got, newKey) {
+				t.Fatalf("unwrapped version %d key %x, want 3 %x", version, got, newKey)
+			}
+
+			// 经 KMS 以密文调用应得到同一报文
+			encOld, _ := k.EncryptDeviceKey(oldKey)
+			encNew, _ := k.EncryptDeviceKey(newKey)
+			viaKMS, err := k.WrapDeviceKeyUpdate(algorithm, encOld, encNew, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(viaKMS, payload) {
+				t.Fatalf("WrapDeviceKeyUpdate = %x, want %x", viaKMS, payload)
+			}
+		})
+	}
+}
+
+func TestWrapKeyUpdateRejectsBadKeys(t *testing.T) {
+	k := NewLocalKMS(bytes.Repeat([]byte{7}, 32))
+	key := make([]byte, 16)
+	if _, err := WrapKeyUpdate(k, model.MACAlgorithmCMAC, key[:15], key, 2); !errors.Is(err, ErrInvalidDeviceKeyLength) {
+		t.Errorf("short old key: err = %v", err)
+	}
+	if _, err := WrapKeyUpdate(k, model.MACAlgorithmCMAC, key, append(key, 0), 2); !errors.Is(err, ErrInvalidDeviceKeyLength) {
+		t.Errorf("long new key: err = %v", err)
+	}
+	if _, err := WrapKeyUpdate(k, "des-mac", key, key, 2); !errors.Is(err, ErrUnknownMACAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package kms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+/*
+主密钥版本头：每个 KeyEncrypted 密文前缀
+  magic "PMK"(3B) || 版本 0x01(1B) || keyID 长度(1B) || keyID || nonce || GCM 密文
+keyID 由主密钥内容派生（见 MasterKeyID），无需额外配置，解密时据此直接选中对应主密钥。
+不带头部的密文是版本化之前写入的，解密时依次尝试所有已加载的主密钥。
+*/
+
+var keyHeaderMagic = []byte{'P', 'M', 'K', 0x01}
+
+var ErrNoMatchingMasterKey = errors.New("no loaded master key can decrypt this device key")
+
+// MasterKeyID 主密钥 ID：SHA-256("promthus-master-key-id" || key) 前 4 字节的 hex。
+func MasterKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("promthus-master-key-id"), key...))
+	return hex.EncodeToString(sum[:4])
+}
+
+func encodeKeyHeader(keyID string) []byte {
+	h := make([]byte, 0, len(keyHeaderMagic)+1+len(keyID))
+	h = append(h, keyHeaderMagic...)
+	h = append(h, byte(len(keyID)))
+	return append(h, keyID...)
+}
+
+// parseKeyHeader 拆出 keyID 与其后的 nonce||密文；无合法头部返回 ok=false。
+func parseKeyHeader(blob []byte) (keyID string, body []byte, ok bool) {
+	n := len(keyHeaderMagic)
+	if len(blob) < n+1 || string(blob[:n]) != string(keyHeaderMagic) {
+		return "", nil, false
+	}
+	idLen := int(blob[n])
+	if len(blob) < n+1+idLen {
+		return "", nil, false
+	}
+	return string(blob[n+1 : n+1+idLen]), blob[n+1+idLen:], true
+}
+
+// KeyIDOf 返回密文所用主密钥的 ID；无版本头（旧格式）返回空串。
+func KeyIDOf(blob []byte) string {
+	keyID, _, ok := parseKeyHeader(blob)
+	if !ok {
+		return ""
+	}
+	return keyID
+}
@@ -0,0 +1,92 @@
+package kms
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testMasterKey(b byte) []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b + byte(i)
+	}
+	return key
+}
+
+func TestMasterKeyID(t *testing.T) {
+	a, b := testMasterKey(1), testMasterKey(2)
+	if MasterKeyID(a) != MasterKeyID(append([]byte(nil), a...)) {
+		t.Fatal("MasterKeyID not deterministic")
+	}
+	if MasterKeyID(a) == MasterKeyID(b) {
+		t.Fatal("different keys share an ID")
+	}
+	if len(MasterKeyID(a)) != 8 {
+		t.Fatalf("MasterKeyID length = %d, want 8 hex chars", len(MasterKeyID(a)))
+	}
+}
+
+func TestLocalKMSKeyHeader(t *testing.T) {
+	k := NewLocalKMS(testMasterKey(1))
+	blob, err := k.EncryptDeviceKey([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := KeyIDOf(blob); got != k.ActiveKeyID() {
+		t.Fatalf("KeyIDOf = %q, want active %q", got, k.ActiveKeyID())
+	}
+	if KeyIDOf([]byte("PMK")) != "" || KeyIDOf([]byte{'P', 'M', 'K', 1, 200, 'x'}) != "" {
+		t.Fatal("truncated header parsed as valid")
+	}
+}
+
+// 轮换窗口期：新 KMS 同时加载旧主密钥，新旧密文都能解；移除旧主密钥后旧密文不可解
+func TestLocalKMSRotationWindow(t *testing.T) {
+	oldKey, newKey := testMasterKey(1), testMasterKey(2)
+	plain := []byte("0123456789abcdef")
+
+	before := NewLocalKMS(oldKey)
+	oldBlob, err := before.EncryptDeviceKey(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 版本化之前写入的无头部密文
+	legacyBlob, err := sealGCM(oldKey, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during := NewLocalKMS(newKey, oldKey)
+	if during.ActiveKeyID() != MasterKeyID(newKey) {
+		t.Fatal("active key is not the new master key")
+	}
+	newBlob, err := during.EncryptDeviceKey(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyIDOf(newBlob) != MasterKeyID(newKey) {
+		t.Fatal("new ciphertext not tagged with new key ID")
+	}
+	for name, blob := range map[string][]byte{"old": oldBlob, "legacy": legacyBlob, "new": newBlob} {
+		got, err := during.DecryptDeviceKey(blob)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("decrypt %s during rotation = %x, %v", name, got, err)
+		}
+	}
+
+	after := NewLocalKMS(newKey)
+	if _, err := after.DecryptDeviceKey(oldBlob); !errors.Is(err, ErrNoMatchingMasterKey) {
+		t.Errorf("decrypt old after rotation: err = %v, want ErrNoMatchingMasterKey", err)
+	}
+	if _, err := after.DecryptDeviceKey(newBlob); err != nil {
+		t.Errorf("decrypt new after rotation: %v", err)
+	}
+
+	// 篡改密文时 GCM 认证失败，不会被其他主密钥“误解”
+	tampered := append([]byte(nil), newBlob...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := during.DecryptDeviceKey(tampered); !errors.Is(err, ErrNoMatchingMasterKey) {
+		t.Errorf("decrypt tampered: err = %v, want ErrNoMatchingMasterKey", err)
+	}
+}
@@ -1,16 +1,18 @@
 package kms
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 
+	"promthus/internal/config"
 	"promthus/internal/logger"
 
 	"go.uber.org/zap"
@@ -20,60 +22,258 @@ import (
 KMS 接口：约定「设备密钥」相关的三件事：
 EncryptDeviceKey：用主密钥加密设备密钥（明文 → 密文，存库）。
 DecryptDeviceKey：用主密钥解密设备密钥（密文 → 明文，仅在内存里用）。
-ComputeCMAC：用设备密钥对一段数据算 MAC（挑战-应答里算 Response）。
+ComputeCMAC：用设备密钥对一段数据算 AES-128-CMAC（挑战-应答里算 Response）。
+ComputeHMAC：老固件使用的 HMAC-SHA256 截断 16 字节，迁移期间按设备 mac_algorithm 选用。
+ComputeDeviceMAC / WrapDeviceKeyUpdate：直接以密文形式的设备密钥做运算。业务代码只用这两个，
+HSM 实现（PKCS11KMS）在令牌内完成，明文 K_d 不进入进程内存；软件实现内部解密后计算并立即清零。
 */
 // KMS provides key management operations for device keys.
 type KMS interface {
 	EncryptDeviceKey(plainKey []byte) ([]byte, error)
 	DecryptDeviceKey(encryptedKey []byte) ([]byte, error)
 	ComputeCMAC(key, data []byte) ([]byte, error)
+	ComputeHMAC(key, data []byte) ([]byte, error)
+	ComputeDeviceMAC(algorithm string, encryptedKey, data []byte) ([]byte, error)
+	WrapDeviceKeyUpdate(algorithm string, encryptedOldKey, encryptedNewKey []byte, version int16) ([]byte, error)
 }
 
-// LocalKMS：当前实现，把主密钥放在内存里（masterKey），用 mu 保证并发读主密钥时安全（RLock/RUnlock）。
+// LocalKMS：当前实现，把主密钥放在内存里，用 mu 保证并发读主密钥时安全（RLock/RUnlock）。
+// 支持多个主密钥版本：activeID 用于加密，keys 中的全部版本都可用于解密（轮换窗口期新旧并存）。
 type LocalKMS struct {
-	masterKey []byte
+	keys      map[string][]byte
+	order     []string // 解密无头部的旧密文时按此顺序尝试，active 在前
+	activeID  string
+	ephemeral bool // debug 模式下临时生成的主密钥，不写入指纹
 	mu        sync.RWMutex
 }
 
-var instance KMS
+// instance 用 atomic 保存：Shamir 解封时由 HTTP 请求 goroutine 替换，业务请求并发读取
+var instance atomic.Pointer[KMS]
 var once sync.Once
 
-func Init(masterKeyPath string) {
+func setInstance(k KMS) {
+	instance.Store(&k)
+}
+
+// Init 按 Provider 选择实现：local 加载当前主密钥（MasterKeyPath）以及轮换期间仍需用于解密的旧主密钥
+// （OldMasterKeyPaths）；vault 使用 Vault Transit 包装设备密钥。
+// release 模式下主密钥缺失、过短或格式不对直接终止启动；debug 模式保留宽松行为便于本地开发。
+func Init(cfg *config.KMSConfig, mode string) {
+	strict := mode == "release"
 	// sync.Once 保证所有线程只执行一次;
 	once.Do(func() {
-		key, err := os.ReadFile(masterKeyPath)
-		if err != nil {
-			logger.Warn("master key file not found, generating ephemeral key for development",
-				zap.String("path", masterKeyPath))
-			// 生成一个临时密钥切片,初始化全0;
-			key = make([]byte, 32)
-			if _, err := rand.Read(key); err != nil {
-				logger.Fatal("failed to generate ephemeral master key", zap.Error(err))
+		switch cfg.Provider {
+		case "", "local":
+			if cfg.UnsealMode == UnsealModeShamir {
+				setInstance(initSealed(cfg))
+				return
 			}
+			setInstance(initLocal(cfg, strict))
+		case "vault":
+			setInstance(initVault(cfg))
+		case "pkcs11":
+			setInstance(initPKCS11(cfg))
+		default:
+			logger.Fatal("unsupported kms provider", zap.String("provider", cfg.Provider))
 		}
-		if len(key) < 32 {
-			padded := make([]byte, 32)
-			copy(padded, key)
-			key = padded
-		}
-		// 把主密钥放在内存里;
-		instance = &LocalKMS{masterKey: key[:32]}
 	})
 }
 
+// initLocal 读取本地主密钥文件；strict 为 true 时（release）不合规直接退出。
+func initLocal(cfg *config.KMSConfig, strict bool) KMS {
+	ephemeral := false
+	key, err := loadMasterKey(cfg.MasterKeyPath, strict)
+	if errors.Is(err, os.ErrNotExist) && !strict {
+		logger.Warn("master key file not found, generating ephemeral key for development",
+			zap.String("path", cfg.MasterKeyPath))
+		// 生成一个临时密钥切片,初始化全0;
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logger.Fatal("failed to generate ephemeral master key", zap.Error(err))
+		}
+		ephemeral = true
+	} else if err != nil {
+		logger.Fatal("failed to load master key, refusing to start",
+			zap.String("path", cfg.MasterKeyPath), zap.Error(err))
+	}
+
+	var oldKeys [][]byte
+	for _, path := range cfg.OldMasterKeyPaths {
+		// 旧主密钥仅用于解密，允许历史上不合规的格式，便于 rekey 迁出
+		old, err := ReadLegacyMasterKey(path)
+		if err != nil {
+			logger.Fatal("failed to read old master key", zap.String("path", path), zap.Error(err))
+		}
+		oldKeys = append(oldKeys, old)
+	}
+
+	// 把主密钥放在内存里;
+	local := NewLocalKMS(key, oldKeys...)
+	local.ephemeral = ephemeral
+	logger.Info("kms initialized",
+		zap.String("active_key_id", local.ActiveKeyID()), zap.Int("decrypt_only_keys", len(oldKeys)))
+	return local
+}
+
+// legacyLocalKMS 迁移到外部 KMS 期间，用旧主密钥解密迁移前写入的本地密文；未配置返回 nil。
+func legacyLocalKMS(cfg *config.KMSConfig) *LocalKMS {
+	if len(cfg.OldMasterKeyPaths) == 0 {
+		return nil
+	}
+	var keys [][]byte
+	for _, path := range cfg.OldMasterKeyPaths {
+		key, err := ReadLegacyMasterKey(path)
+		if err != nil {
+			logger.Fatal("failed to read old master key", zap.String("path", path), zap.Error(err))
+		}
+		keys = append(keys, key)
+	}
+	return NewLocalKMS(keys[0], keys[1:]...)
+}
+
+// initVault 旧主密钥（OldMasterKeyPaths）仅用于解密迁移前写入的本地密文。
+func initVault(cfg *config.KMSConfig) KMS {
+	legacy := legacyLocalKMS(cfg)
+	v, err := NewVaultKMS(cfg.Vault, legacy)
+	if err != nil {
+		logger.Fatal("invalid vault kms config", zap.Error(err))
+	}
+	if err := v.Ping(); err != nil {
+		logger.Fatal("vault transit unreachable", zap.String("addr", cfg.Vault.Addr), zap.Error(err))
+	}
+	logger.Info("kms initialized",
+		zap.String("provider", "vault"),
+		zap.String("transit_key", cfg.Vault.TransitKey),
+		zap.Bool("legacy_decrypt", legacy != nil))
+	return v
+}
+
+// NewLocalKMS 以 active 为加密主密钥构造 LocalKMS，old 仅用于解密（主密钥轮换、重加密工具使用）。
+func NewLocalKMS(active []byte, old ...[]byte) *LocalKMS {
+	k := &LocalKMS{keys: make(map[string][]byte)}
+	k.activeID = k.addKey(active)
+	for _, o := range old {
+		k.addKey(o)
+	}
+	return k
+}
+
+func (k *LocalKMS) addKey(key []byte) string {
+	id := MasterKeyID(key)
+	if _, ok := k.keys[id]; !ok {
+		k.keys[id] = key
+		k.order = append(k.order, id)
+	}
+	return id
+}
+
+// ActiveKeyID 返回当前用于加密的主密钥 ID。
+func (k *LocalKMS) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeID
+}
+
+// ReadMasterKey 读取主密钥文件并做严格校验（见 ParseMasterKey）。
+func ReadMasterKey(path string) ([]byte, error) {
+	return loadMasterKey(path, true)
+}
+
+// ReadLegacyMasterKey 按旧版规则读取（不足 32 字节补零、超出截断），仅用于解密存量密文。
+func ReadLegacyMasterKey(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeMasterKey(raw), nil
+}
+
+// loadMasterKey strict 为 false 时不合规的密钥只告警并按旧逻辑补零/截断，兼容开发环境。
+func loadMasterKey(path string, strict bool) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseMasterKey(raw)
+	if err == nil {
+		return key, nil
+	}
+	if strict {
+		return nil, err
+	}
+	logger.Warn("master key malformed, using zero-padded/truncated key (debug only)",
+		zap.String("path", path), zap.Error(err))
+	return normalizeMasterKey(raw), nil
+}
+
+// ParseMasterKey 主密钥文件必须是 32 字节原始随机数（允许末尾一个换行），
+// 且不能是全相同字节这类明显无效的值。
+func ParseMasterKey(raw []byte) ([]byte, error) {
+	key := raw
+	if len(key) > 32 {
+		key = bytes.TrimSuffix(key, []byte("\n"))
+		key = bytes.TrimSuffix(key, []byte("\r"))
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: expected 32 raw bytes, got %d", ErrMasterKeyMalformed, len(raw))
+	}
+	if bytes.Count(key, key[:1]) == len(key) {
+		return nil, fmt.Errorf("%w: key bytes are all identical", ErrMasterKeyMalformed)
+	}
+	return key, nil
+}
+
+func normalizeMasterKey(key []byte) []byte {
+	if len(key) < 32 {
+		padded := make([]byte, 32)
+		copy(padded, key)
+		key = padded
+	}
+	return key[:32]
+}
+
 // instance如果为空,则进行KMS密钥初始化;
 func Get() KMS {
-	if instance == nil {
-		Init("./master.key")
+	if instance.Load() == nil {
+		Init(&config.KMSConfig{MasterKeyPath: "./master.key"}, "debug")
 	}
-	return instance
+	return *instance.Load()
 }
 
 func (k *LocalKMS) EncryptDeviceKey(plainKey []byte) ([]byte, error) {
 	k.mu.RLock()
 	defer k.mu.RUnlock()
 
-	block, err := aes.NewCipher(k.masterKey)
+	sealed, err := sealGCM(k.keys[k.activeID], plainKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(encodeKeyHeader(k.activeID), sealed...), nil
+}
+
+func (k *LocalKMS) DecryptDeviceKey(encryptedKey []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if keyID, body, ok := parseKeyHeader(encryptedKey); ok {
+		if key, known := k.keys[keyID]; known {
+			if plain, err := openGCM(key, body); err == nil {
+				return plain, nil
+			}
+		}
+	}
+
+	// 无头部（轮换前写入的旧密文）或头部不可用：依次尝试全部主密钥，GCM 认证保证不会误解
+	for _, id := range k.order {
+		if plain, err := openGCM(k.keys[id], encryptedKey); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrNoMatchingMasterKey
+}
+
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -88,14 +288,11 @@ func (k *LocalKMS) EncryptDeviceKey(plainKey []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return aesGCM.Seal(nonce, nonce, plainKey, nil), nil
+	return aesGCM.Seal(nonce, nonce, plaintext, nil), nil
 }
 
-func (k *LocalKMS) DecryptDeviceKey(encryptedKey []byte) ([]byte, error) {
-	k.mu.RLock()
-	defer k.mu.RUnlock()
-
-	block, err := aes.NewCipher(k.masterKey)
+func openGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -106,20 +303,43 @@ func (k *LocalKMS) DecryptDeviceKey(encryptedKey []byte) ([]byte, error) {
 	}
 
 	nonceSize := aesGCM.NonceSize()
-	if len(encryptedKey) < nonceSize {
+	if len(sealed) < nonceSize {
 		return nil, errors.New("encrypted key too short")
 	}
 
-	nonce, ciphertext := encryptedKey[:nonceSize], encryptedKey[nonceSize:]
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
 	return aesGCM.Open(nil, nonce, ciphertext, nil)
 }
 
-// ComputeCMAC computes a MAC over the given data using the device key.
-// Uses HMAC-SHA256 truncated to 16 bytes as a portable alternative to AES-CMAC.
-// In production with real MCU integration, replace with a proper AES-128-CMAC implementation.
+// ComputeCMAC computes AES-CMAC (RFC 4493) over the given data using the device key.
 func (k *LocalKMS) ComputeCMAC(key, data []byte) ([]byte, error) {
-	mac := hmac.New(sha256.New, key)
-	mac.Write(data)
-	full := mac.Sum(nil)
-	return full[:16], nil
+	return aesCMAC(key, data)
+}
+
+// ComputeHMAC computes the legacy HMAC-SHA256 MAC truncated to 16 bytes.
+func (k *LocalKMS) ComputeHMAC(key, data []byte) ([]byte, error) {
+	return legacyHMAC(key, data), nil
+}
+
+func (k *LocalKMS) ComputeDeviceMAC(algorithm string, encryptedKey, data []byte) ([]byte, error) {
+	return softwareDeviceMAC(k, algorithm, encryptedKey, data)
+}
+
+func (k *LocalKMS) WrapDeviceKeyUpdate(algorithm string, encryptedOldKey, encryptedNewKey []byte, version int16) ([]byte, error) {
+	return softwareKeyUpdate(k, algorithm, encryptedOldKey, encryptedNewKey, version)
+}
+
+// initPKCS11 同 initVault，旧主密钥仅用于迁移期解密本地密文。
+func initPKCS11(cfg *config.KMSConfig) KMS {
+	legacy := legacyLocalKMS(cfg)
+	p, err := newPKCS11KMS(cfg.PKCS11, legacy)
+	if err != nil {
+		logger.Fatal("pkcs11 kms init failed", zap.String("library", cfg.PKCS11.LibraryPath), zap.Error(err))
+	}
+	logger.Info("kms initialized",
+		zap.String("provider", "pkcs11"),
+		zap.String("token", cfg.PKCS11.TokenLabel),
+		zap.String("key_label", cfg.PKCS11.KeyLabel),
+		zap.Bool("legacy_decrypt", legacy != nil))
+	return p
 }
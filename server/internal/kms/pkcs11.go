@@ -0,0 +1,290 @@
+//go:build pkcs11
+
+package kms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+
+	"github.com/miekg/pkcs11"
+)
+
+/*
+PKCS11KMS：设备密钥以「被令牌内主密钥包装」的形式存库，运算全部在 HSM 内完成：
+  - EncryptDeviceKey：明文 K_d 导入为会话对象，用主密钥 CKM_AES_KEY_WRAP（RFC 3394）包装后销毁；
+  - ComputeDeviceMAC：把密文 unwrap 成不可导出的会话对象，在令牌内做 CKM_AES_CMAC / CKM_SHA256_HMAC；
+  - WrapDeviceKeyUpdate：新旧密钥均 unwrap 到令牌内，以旧密钥 CKM_AES_ECB 包装新密钥，再用旧密钥算 MAC；
+  - DecryptDeviceKey：HSM 密文不允许导出明文，返回 ErrKeyNotExportable。
+密文格式：magic "PHK\x01" || 主密钥标签长度(1B) || 主密钥标签 || 包装结果。
+本地开发可用 SoftHSM：softhsm2-util --init-token 后生成 CKA_TOKEN、不可导出的 AES-256 主密钥并设置 KeyLabel。
+*/
+
+var hsmMagic = []byte("PHK\x01")
+
+var ErrKeyNotExportable = errors.New("device key is held by the hsm and cannot be exported")
+
+type PKCS11KMS struct {
+	ctx       *pkcs11.Ctx
+	slot      uint
+	keyLabel  string
+	masterKey pkcs11.ObjectHandle
+	legacy    *LocalKMS // 迁移期解密旧的本地主密钥密文，可为 nil
+
+	// loginSession 保持登录态；PKCS#11 登录对同一应用的全部会话生效
+	loginSession pkcs11.SessionHandle
+}
+
+func newPKCS11KMS(cfg config.PKCS11Config, legacy *LocalKMS) (KMS, error) {
+	if cfg.LibraryPath == "" || cfg.TokenLabel == "" || cfg.KeyLabel == "" {
+		return nil, errors.New("pkcs11: library path, token label and key label are required")
+	}
+	ctx := pkcs11.New(cfg.LibraryPath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: cannot load module %s", cfg.LibraryPath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: initialize: %w", err)
+	}
+
+	p := &PKCS11KMS{ctx: ctx, keyLabel: cfg.KeyLabel, legacy: legacy}
+	if err := p.open(cfg); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PKCS11KMS) open(cfg config.PKCS11Config) error {
+	slots, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("pkcs11: list slots: %w", err)
+	}
+	found := false
+	for _, slot := range slots {
+		info, err := p.ctx.GetTokenInfo(slot)
+		if err == nil && strings.TrimSpace(info.Label) == cfg.TokenLabel {
+			p.slot, found = slot, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("pkcs11: token %q not found", cfg.TokenLabel)
+	}
+
+	sh, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("pkcs11: open session: %w", err)
+	}
+	if err := p.ctx.Login(sh, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		p.ctx.CloseSession(sh)
+		return fmt.Errorf("pkcs11: login: %w", err)
+	}
+	p.loginSession = sh
+
+	if err := p.ctx.FindObjectsInit(sh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel),
+	}); err != nil {
+		return fmt.Errorf("pkcs11: find master key: %w", err)
+	}
+	handles, _, err := p.ctx.FindObjects(sh, 2)
+	p.ctx.FindObjectsFinal(sh)
+	if err != nil {
+		return fmt.Errorf("pkcs11: find master key: %w", err)
+	}
+	if len(handles) != 1 {
+		return fmt.Errorf("pkcs11: expected exactly one AES key labelled %q, found %d", cfg.KeyLabel, len(handles))
+	}
+	p.masterKey = handles[0]
+	return nil
+}
+
+// withSession 每次运算使用独立会话（会话句柄不可并发使用），关闭会话时令牌自动销毁其中的会话对象。
+func (p *PKCS11KMS) withSession(fn func(sh pkcs11.SessionHandle) error) error {
+	sh, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("pkcs11: open session: %w", err)
+	}
+	defer p.ctx.CloseSession(sh)
+	return fn(sh)
+}
+
+func (p *PKCS11KMS) EncryptDeviceKey(plainKey []byte) ([]byte, error) {
+	if len(plainKey) != 16 {
+		return nil, ErrInvalidDeviceKeyLength
+	}
+	var wrapped []byte
+	err := p.withSession(func(sh pkcs11.SessionHandle) error {
+		obj, err := p.ctx.CreateObject(sh, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, plainKey),
+		})
+		if err != nil {
+			return fmt.Errorf("pkcs11: import device key: %w", err)
+		}
+		defer p.ctx.DestroyObject(sh, obj)
+
+		wrapped, err = p.ctx.WrapKey(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil)}, p.masterKey, obj)
+		if err != nil {
+			return fmt.Errorf("pkcs11: wrap device key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, hsmMagic...)
+	out = append(out, byte(len(p.keyLabel)))
+	out = append(out, p.keyLabel...)
+	return append(out, wrapped...), nil
+}
+
+func (p *PKCS11KMS) DecryptDeviceKey(encryptedKey []byte) ([]byte, error) {
+	if _, ok := p.parseWrapped(encryptedKey); ok {
+		return nil, ErrKeyNotExportable
+	}
+	if p.legacy == nil {
+		return nil, ErrNoMatchingMasterKey
+	}
+	return p.legacy.DecryptDeviceKey(encryptedKey)
+}
+
+// ComputeCMAC / ComputeHMAC 对明文密钥的软件计算，仅供迁移期旧密文与 WrapKeyUpdate 使用。
+func (p *PKCS11KMS) ComputeCMAC(key, data []byte) ([]byte, error) {
+	return aesCMAC(key, data)
+}
+
+func (p *PKCS11KMS) ComputeHMAC(key, data []byte) ([]byte, error) {
+	return legacyHMAC(key, data), nil
+}
+
+func (p *PKCS11KMS) ComputeDeviceMAC(algorithm string, encryptedKey, data []byte) ([]byte, error) {
+	wrapped, ok := p.parseWrapped(encryptedKey)
+	if !ok {
+		return softwareDeviceMAC(p, algorithm, encryptedKey, data)
+	}
+	var mac []byte
+	err := p.withSession(func(sh pkcs11.SessionHandle) error {
+		var err error
+		mac, err = p.macInToken(sh, algorithm, wrapped, data)
+		return err
+	})
+	return mac, err
+}
+
+func (p *PKCS11KMS) WrapDeviceKeyUpdate(algorithm string, encryptedOldKey, encryptedNewKey []byte, version int16) ([]byte, error) {
+	oldWrapped, oldOK := p.parseWrapped(encryptedOldKey)
+	newWrapped, newOK := p.parseWrapped(encryptedNewKey)
+	if !oldOK && !newOK {
+		return softwareKeyUpdate(p, algorithm, encryptedOldKey, encryptedNewKey, version)
+	}
+	if !oldOK || !newOK {
+		// 旧密钥仍是迁移前的本地密文而新密钥已在 HSM 内：无法在任一侧完成包装
+		return nil, errors.New("pkcs11: key update requires both device keys to be held by the hsm")
+	}
+
+	var payload []byte
+	err := p.withSession(func(sh pkcs11.SessionHandle) error {
+		oldKey, err := p.unwrap(sh, oldWrapped, pkcs11.CKK_AES, pkcs11.CKA_WRAP, false)
+		if err != nil {
+			return err
+		}
+		defer p.ctx.DestroyObject(sh, oldKey)
+		newKey, err := p.unwrap(sh, newWrapped, pkcs11.CKK_AES, pkcs11.CKA_ENCRYPT, true)
+		if err != nil {
+			return err
+		}
+		defer p.ctx.DestroyObject(sh, newKey)
+
+		encrypted, err := p.ctx.WrapKey(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_ECB, nil)}, oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("pkcs11: wrap new device key: %w", err)
+		}
+		payload = make([]byte, 2, 2+len(encrypted))
+		binary.BigEndian.PutUint16(payload, uint16(version))
+		payload = append(payload, encrypted...)
+
+		mac, err := p.macInToken(sh, algorithm, oldWrapped, payload)
+		if err != nil {
+			return err
+		}
+		payload = append(payload, mac...)
+		return nil
+	})
+	return payload, err
+}
+
+// macInToken 按算法以对应密钥类型 unwrap 后在令牌内计算 MAC（HMAC 需要 generic secret 类型）。
+func (p *PKCS11KMS) macInToken(sh pkcs11.SessionHandle, algorithm string, wrapped, data []byte) ([]byte, error) {
+	keyType, mech := uint(pkcs11.CKK_AES), uint(pkcs11.CKM_AES_CMAC)
+	switch algorithm {
+	case model.MACAlgorithmCMAC:
+	case model.MACAlgorithmHMAC, "":
+		keyType, mech = pkcs11.CKK_GENERIC_SECRET, pkcs11.CKM_SHA256_HMAC
+	default:
+		return nil, ErrUnknownMACAlgorithm
+	}
+
+	key, err := p.unwrap(sh, wrapped, keyType, pkcs11.CKA_SIGN, false)
+	if err != nil {
+		return nil, err
+	}
+	defer p.ctx.DestroyObject(sh, key)
+
+	if err := p.ctx.SignInit(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)}, key); err != nil {
+		return nil, fmt.Errorf("pkcs11: sign init: %w", err)
+	}
+	mac, err := p.ctx.Sign(sh, data)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: sign: %w", err)
+	}
+	// CMAC 为 16 字节、HMAC-SHA256 为 32 字节取前 16；令牌返回更短的结果说明机制实现不符，不能截断了事
+	if len(mac) < 16 {
+		return nil, fmt.Errorf("pkcs11: sign returned %d bytes, want at least 16", len(mac))
+	}
+	return mac[:16], nil
+}
+
+// unwrap 把设备密钥解包为仅本会话可见的对象；usage 为允许的用途属性，extractable 仅换钥时的新密钥需要。
+func (p *PKCS11KMS) unwrap(sh pkcs11.SessionHandle, wrapped []byte, keyType, usage uint, extractable bool) (pkcs11.ObjectHandle, error) {
+	obj, err := p.ctx.UnwrapKey(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil)}, p.masterKey, wrapped,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, extractable),
+			pkcs11.NewAttribute(usage, true),
+		})
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: unwrap device key: %w", err)
+	}
+	return obj, nil
+}
+
+// parseWrapped 识别本实现写入的密文并校验主密钥标签。
+func (p *PKCS11KMS) parseWrapped(blob []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(blob, hsmMagic) || len(blob) < len(hsmMagic)+1 {
+		return nil, false
+	}
+	n := int(blob[len(hsmMagic)])
+	rest := blob[len(hsmMagic)+1:]
+	if len(rest) < n || string(rest[:n]) != p.keyLabel {
+		return nil, false
+	}
+	return rest[n:], true
+}
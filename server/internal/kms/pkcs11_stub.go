@@ -0,0 +1,14 @@
+//go:build !pkcs11
+
+package kms
+
+import (
+	"errors"
+
+	"promthus/internal/config"
+)
+
+// newPKCS11KMS 默认构建不链接 PKCS#11（Dockerfile 使用 CGO_ENABLED=0）。
+func newPKCS11KMS(cfg config.PKCS11Config, legacy *LocalKMS) (KMS, error) {
+	return nil, errors.New("binary built without pkcs11 support, rebuild with CGO_ENABLED=1 -tags pkcs11")
+}
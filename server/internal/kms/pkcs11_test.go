@@ -0,0 +1,235 @@
+//go:build pkcs11
+
+package kms
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+
+	"github.com/miekg/pkcs11"
+)
+
+/*
+需要已初始化的 SoftHSM 令牌，未设置 SOFTHSM2_CONF 时跳过：
+  softhsm2-util --init-token --free --label promthus-test --pin 1234 --so-pin 1234
+  SOFTHSM2_CONF=/etc/softhsm/softhsm2.conf go test -tags pkcs11 ./internal/kms
+模块路径、令牌标签与 PIN 可用 PKCS11_TEST_MODULE / PKCS11_TEST_TOKEN / PKCS11_TEST_PIN 覆盖。
+测试在令牌内临时生成两把 AES 主密钥，结束时销毁。
+*/
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// openTestHSM 返回以 labels[0] 为主密钥的 PKCS11KMS，以及 labels 对应的主密钥句柄
+func openTestHSM(t *testing.T, legacy *LocalKMS, labels ...string) (*PKCS11KMS, []pkcs11.ObjectHandle) {
+	t.Helper()
+	if os.Getenv("SOFTHSM2_CONF") == "" {
+		t.Skip("SOFTHSM2_CONF not set, skipping pkcs11 test")
+	}
+	cfg := config.PKCS11Config{
+		LibraryPath: envOr("PKCS11_TEST_MODULE", "/usr/lib/softhsm/libsofthsm2.so"),
+		TokenLabel:  envOr("PKCS11_TEST_TOKEN", "promthus-test"),
+		PIN:         envOr("PKCS11_TEST_PIN", "1234"),
+		KeyLabel:    labels[0],
+	}
+	ctx := pkcs11.New(cfg.LibraryPath)
+	if ctx == nil {
+		t.Fatalf("cannot load %s", cfg.LibraryPath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+
+	// 先在单独的会话里生成主密钥，登出后再走正常的 open 流程
+	p := &PKCS11KMS{ctx: ctx, keyLabel: cfg.KeyLabel, legacy: legacy}
+	slot := findTestSlot(t, ctx, cfg.TokenLabel)
+	sh, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Login(sh, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	var handles []pkcs11.ObjectHandle
+	for _, label := range labels {
+		h, err := ctx.GenerateKey(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)},
+			[]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+				pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+				pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+				pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+				pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+				pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, true),
+			})
+		if err != nil {
+			t.Fatalf("generate master key %s: %v", label, err)
+		}
+		handles = append(handles, h)
+	}
+	_ = ctx.Logout(sh)
+	_ = ctx.CloseSession(sh)
+
+	if err := p.open(cfg); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, h := range handles {
+			_ = ctx.DestroyObject(p.loginSession, h)
+		}
+		_ = ctx.Logout(p.loginSession)
+		_ = ctx.CloseSession(p.loginSession)
+		_ = ctx.Finalize()
+		ctx.Destroy()
+	})
+	return p, handles
+}
+
+func findTestSlot(t *testing.T, ctx *pkcs11.Ctx, tokenLabel string) uint {
+	t.Helper()
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range slots {
+		if info, err := ctx.GetTokenInfo(slot); err == nil && strings.TrimSpace(info.Label) == tokenLabel {
+			return slot
+		}
+	}
+	t.Fatalf("token %q not found", tokenLabel)
+	return 0
+}
+
+func randomLabel(t *testing.T) string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "promthus-test-" + hex.EncodeToString(b)
+}
+
+func TestPKCS11ComputeDeviceMACMatchesSoftware(t *testing.T) {
+	p, _ := openTestHSM(t, nil, randomLabel(t))
+	deviceKey := mustHex(t, rfc4493Key)
+	blob, err := p.EncryptDeviceKey(deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(blob, hsmMagic) || bytes.Contains(blob, deviceKey) {
+		t.Fatalf("unexpected hsm ciphertext %x", blob)
+	}
+
+	for _, n := range []int{0, 16, 40, 64} {
+		data := mustHex(t, rfc4493Message)[:n]
+		cmac, err := p.ComputeDeviceMAC(model.MACAlgorithmCMAC, blob, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := aesCMAC(deviceKey, data)
+		if !bytes.Equal(cmac, want) {
+			t.Errorf("cmac len %d = %x, want %x", n, cmac, want)
+		}
+		hmacValue, err := p.ComputeDeviceMAC(model.MACAlgorithmHMAC, blob, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := legacyHMAC(deviceKey, data); !bytes.Equal(hmacValue, want) {
+			t.Errorf("hmac len %d = %x, want %x", n, hmacValue, want)
+		}
+	}
+	if _, err := p.ComputeDeviceMAC("des-mac", blob, nil); !errors.Is(err, ErrUnknownMACAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v", err)
+	}
+}
+
+func TestPKCS11DecryptNotExportable(t *testing.T) {
+	p, _ := openTestHSM(t, nil, randomLabel(t))
+	blob, err := p.EncryptDeviceKey(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.DecryptDeviceKey(blob); !errors.Is(err, ErrKeyNotExportable) {
+		t.Fatalf("err = %v, want ErrKeyNotExportable", err)
+	}
+	if _, err := p.EncryptDeviceKey(make([]byte, 15)); !errors.Is(err, ErrInvalidDeviceKeyLength) {
+		t.Fatalf("short key: err = %v, want ErrInvalidDeviceKeyLength", err)
+	}
+}
+
+func TestPKCS11WrapDeviceKeyUpdateRoundTrip(t *testing.T) {
+	p, _ := openTestHSM(t, nil, randomLabel(t))
+	oldKey := bytes.Repeat([]byte{0x11, 0x22}, 8)
+	newKey := bytes.Repeat([]byte{0x33, 0x44}, 8)
+	encOld, err := p.EncryptDeviceKey(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encNew, err := p.EncryptDeviceKey(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []string{model.MACAlgorithmCMAC, model.MACAlgorithmHMAC} {
+		t.Run(algorithm, func(t *testing.T) {
+			payload, err := p.WrapDeviceKeyUpdate(algorithm, encOld, encNew, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			software, _ := WrapKeyUpdate(p, algorithm, oldKey, newKey, 5)
+			if !bytes.Equal(payload, software) {
+				t.Fatalf("hsm payload %x != software %x", payload, software)
+			}
+			version, got := unwrapKeyUpdate(t, p, algorithm, oldKey, payload)
+			if version != 5 || !bytes.Equal(got, newKey) {
+				t.Fatalf("unwrapped version %d key %x", version, got)
+			}
+		})
+	}
+}
+
+// 别的主密钥标签写入的密文不当作本令牌密文处理，交给迁移期的本地主密钥
+func TestPKCS11ForeignLabelFallsBackToLegacy(t *testing.T) {
+	legacy := NewLocalKMS(testMasterKey(1))
+	ours, other := randomLabel(t), randomLabel(t)
+	p, handles := openTestHSM(t, legacy, ours, other)
+	foreign := &PKCS11KMS{ctx: p.ctx, slot: p.slot, keyLabel: other, masterKey: handles[1]}
+
+	deviceKey := mustHex(t, rfc4493Key)
+	foreignBlob, err := foreign.EncryptDeviceKey(deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.parseWrapped(foreignBlob); ok {
+		t.Fatal("foreign label parsed as our ciphertext")
+	}
+	if _, err := p.DecryptDeviceKey(foreignBlob); !errors.Is(err, ErrNoMatchingMasterKey) {
+		t.Fatalf("foreign blob: err = %v, want legacy ErrNoMatchingMasterKey", err)
+	}
+
+	legacyBlob, err := legacy.EncryptDeviceKey(deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := p.DecryptDeviceKey(legacyBlob); err != nil || !bytes.Equal(plain, deviceKey) {
+		t.Fatalf("legacy decrypt = %x, %v", plain, err)
+	}
+	data := mustHex(t, rfc4493Message)[:16]
+	mac, err := p.ComputeDeviceMAC(model.MACAlgorithmCMAC, legacyBlob, data)
+	if err != nil || !bytes.Equal(mac, mustHex(t, "070a16b46b4d4144f79bdd9dd04a287c")) {
+		t.Fatalf("legacy MAC = %x, %v", mac, err)
+	}
+}
@@ -0,0 +1,156 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+产线灌装包（provisioning bundle）：服务端生成的 K_d 只以密文形式离开服务端，管理后台不显示明文。
+  - 接收方为产线灌装工位的 X25519 传输公钥（PEM "PUBLIC KEY"）；
+  - 每个包生成临时 X25519 密钥对，ECDH 共享秘密经 HKDF-SHA256（salt = epk || 接收方公钥）派生 AES-256-GCM 密钥；
+  - 明文为 ProvisioningPayload 的 JSON，AAD 为 device_id，防止包被挪用到其他设备；
+  - checksum 为 K_d 的密钥校验值（KCV：AES-128_{K_d}(0^16) 前 3 字节），灌装后工位据此核对写入是否正确。
+*/
+
+const (
+	provisionBundleVersion = 1
+	provisionAlgorithm     = "X25519-HKDF-SHA256-A256GCM"
+	provisionHKDFInfo      = "promthus-provisioning-v1"
+)
+
+var ErrInvalidTransportKey = errors.New("transport public key must be an X25519 PKIX PEM")
+
+// ProvisioningPayload 灌装包明文
+type ProvisioningPayload struct {
+	DeviceID     string `json:"device_id"`
+	DeviceKey    string `json:"device_key"` // hex 编码的 K_d
+	KeyVersion   int16  `json:"key_version"`
+	MACAlgorithm string `json:"mac_algorithm"`
+	Checksum     string `json:"checksum"`
+}
+
+// ProvisioningBundle 加密后的灌装包，可直接下载为 JSON 交给灌装工位
+type ProvisioningBundle struct {
+	Version      int    `json:"version"`
+	Algorithm    string `json:"alg"`
+	Recipient    string `json:"recipient"` // 传输公钥指纹，工位据此选择私钥
+	DeviceID     string `json:"device_id"`
+	Checksum     string `json:"checksum"` // 与明文中相同，非机密，供后台展示核对
+	EphemeralKey string `json:"epk"`
+	Nonce        string `json:"nonce"`
+	Ciphertext   string `json:"ciphertext"`
+}
+
+// LoadTransportKey 读取产线传输公钥。
+func LoadTransportKey(path string) (*ecdh.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, ErrInvalidTransportKey
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransportKey, err)
+	}
+	key, ok := pub.(*ecdh.PublicKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, ErrInvalidTransportKey
+	}
+	return key, nil
+}
+
+// TransportKeyID 传输公钥指纹：SHA-256 前 8 字节 hex。
+func TransportKeyID(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// KeyCheckValue 计算 16 字节设备密钥的 KCV。
+func KeyCheckValue(key []byte) (string, error) {
+	if len(key) != 16 {
+		return "", ErrInvalidDeviceKeyLength
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, aes.BlockSize)
+	block.Encrypt(out, out)
+	return hex.EncodeToString(out[:3]), nil
+}
+
+// SealProvisioningBundle 用传输公钥加密灌装包明文；payload.Checksum 为空时自动计算。
+func SealProvisioningBundle(recipient *ecdh.PublicKey, deviceKey []byte, payload ProvisioningPayload) (*ProvisioningBundle, error) {
+	checksum, err := KeyCheckValue(deviceKey)
+	if err != nil {
+		return nil, err
+	}
+	payload.DeviceKey = hex.EncodeToString(deviceKey)
+	payload.Checksum = checksum
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plaintext)
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(shared)
+
+	epk := ephemeral.PublicKey().Bytes()
+	salt := append(append([]byte{}, epk...), recipient.Bytes()...)
+	aeadKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(provisionHKDFInfo)), aeadKey); err != nil {
+		return nil, err
+	}
+	defer wipe(aeadKey)
+
+	block, err := aes.NewCipher(aeadKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, []byte(payload.DeviceID))
+
+	return &ProvisioningBundle{
+		Version:      provisionBundleVersion,
+		Algorithm:    provisionAlgorithm,
+		Recipient:    TransportKeyID(recipient),
+		DeviceID:     payload.DeviceID,
+		Checksum:     checksum,
+		EphemeralKey: base64.StdEncoding.EncodeToString(epk),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext:   base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
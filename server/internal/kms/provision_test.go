@@ -0,0 +1,137 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"promthus/internal/model"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// openBundle 模拟灌装工位：用传输私钥解开灌装包
+func openBundle(priv *ecdh.PrivateKey, b *ProvisioningBundle) (*ProvisioningPayload, error) {
+	epkBytes, _ := base64.StdEncoding.DecodeString(b.EphemeralKey)
+	nonce, _ := base64.StdEncoding.DecodeString(b.Nonce)
+	ciphertext, _ := base64.StdEncoding.DecodeString(b.Ciphertext)
+	epk, err := ecdh.X25519().NewPublicKey(epkBytes)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(epk)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte{}, epkBytes...), priv.PublicKey().Bytes()...)
+	aeadKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(provisionHKDFInfo)), aeadKey); err != nil {
+		return nil, err
+	}
+	block, _ := aes.NewCipher(aeadKey)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(b.DeviceID))
+	if err != nil {
+		return nil, err
+	}
+	var payload ProvisioningPayload
+	return &payload, json.Unmarshal(plaintext, &payload)
+}
+
+func TestKeyCheckValue(t *testing.T) {
+	// AES-128_K(0^16) 即 RFC 4493 子密钥生成中的 L = 7df76b0c...
+	kcv, err := KeyCheckValue(mustHex(t, rfc4493Key))
+	if err != nil || kcv != "7df76b" {
+		t.Fatalf("KCV = %q, %v; want 7df76b", kcv, err)
+	}
+	if _, err := KeyCheckValue(make([]byte, 32)); !errors.Is(err, ErrInvalidDeviceKeyLength) {
+		t.Fatalf("32-byte key: err = %v", err)
+	}
+}
+
+func TestSealProvisioningBundle(t *testing.T) {
+	station, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceKey := mustHex(t, rfc4493Key)
+	bundle, err := SealProvisioningBundle(station.PublicKey(), deviceKey, ProvisioningPayload{
+		DeviceID: "LOCK-0001", KeyVersion: 1, MACAlgorithm: model.MACAlgorithmCMAC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.Version != 1 || bundle.Algorithm != provisionAlgorithm || bundle.DeviceID != "LOCK-0001" ||
+		bundle.Checksum != "7df76b" || bundle.Recipient != TransportKeyID(station.PublicKey()) {
+		t.Fatalf("bundle header = %+v", bundle)
+	}
+	raw, _ := json.Marshal(bundle)
+	if bytes.Contains(bytes.ToLower(raw), []byte(rfc4493Key)) {
+		t.Fatal("bundle JSON contains the plaintext device key")
+	}
+
+	payload, err := openBundle(station, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProvisioningPayload{DeviceID: "LOCK-0001", DeviceKey: rfc4493Key, KeyVersion: 1,
+		MACAlgorithm: model.MACAlgorithmCMAC, Checksum: "7df76b"}
+	if *payload != want {
+		t.Fatalf("payload = %+v, want %+v", *payload, want)
+	}
+
+	// device_id 是 AAD：包被改挂到其他设备时解不开
+	moved := *bundle
+	moved.DeviceID = "LOCK-0002"
+	if _, err := openBundle(station, &moved); err == nil {
+		t.Fatal("bundle opened under a different device_id")
+	}
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err := openBundle(other, bundle); err == nil {
+		t.Fatal("bundle opened with another station key")
+	}
+}
+
+func TestLoadTransportKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, der []byte, typ string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	x, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	xDER, _ := x509.MarshalPKIXPublicKey(x.PublicKey())
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pDER, _ := x509.MarshalPKIXPublicKey(&p256.PublicKey)
+
+	key, err := LoadTransportKey(write("x25519.pem", xDER, "PUBLIC KEY"))
+	if err != nil || !key.Equal(x.PublicKey()) {
+		t.Fatalf("x25519: %v", err)
+	}
+	for name, path := range map[string]string{
+		"p256":       write("p256.pem", pDER, "PUBLIC KEY"),
+		"wrong type": write("priv.pem", xDER, "PRIVATE KEY"),
+		"garbage":    write("garbage.pem", []byte("not der"), "PUBLIC KEY"),
+	} {
+		if _, err := LoadTransportKey(path); !errors.Is(err, ErrInvalidTransportKey) {
+			t.Errorf("%s: err = %v, want ErrInvalidTransportKey", name, err)
+		}
+	}
+}
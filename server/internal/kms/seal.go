@@ -0,0 +1,146 @@
+package kms
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"promthus/internal/config"
+	"promthus/internal/logger"
+	"promthus/internal/repository"
+	"promthus/internal/shamir"
+
+	"go.uber.org/zap"
+)
+
+/*
+Shamir 解封（KMS_UNSEAL_MODE=shamir）：
+启动时不读主密钥文件，KMS 处于密封状态，所有设备密钥运算返回 ErrSealed；
+保管人经本机解封接口（POST /api/sys/unseal）或 cmd/keyshares unseal 逐份提交分片，
+凑齐 threshold 份后还原主密钥，校验格式与库中指纹（见 fingerprint.go）通过才切换为 LocalKMS。
+还原失败会清空已提交的分片，需全部重新提交。
+*/
+
+const (
+	UnsealModeFile   = "file"
+	UnsealModeShamir = "shamir"
+)
+
+var ErrSealed = errors.New("kms is sealed")
+
+// UnsealStatus 解封进度；Threshold 在提交第一份分片前为 0
+type UnsealStatus struct {
+	Sealed    bool `json:"sealed"`
+	Threshold int  `json:"threshold"`
+	Progress  int  `json:"progress"`
+}
+
+type unsealState struct {
+	mu      sync.Mutex
+	shares  []shamir.Share
+	oldKeys [][]byte
+}
+
+var unsealer unsealState
+
+// sealedKMS 密封期间的占位实现
+type sealedKMS struct{}
+
+func (sealedKMS) EncryptDeviceKey([]byte) ([]byte, error)                 { return nil, ErrSealed }
+func (sealedKMS) DecryptDeviceKey([]byte) ([]byte, error)                 { return nil, ErrSealed }
+func (sealedKMS) ComputeCMAC(_, _ []byte) ([]byte, error)                 { return nil, ErrSealed }
+func (sealedKMS) ComputeHMAC(_, _ []byte) ([]byte, error)                 { return nil, ErrSealed }
+func (sealedKMS) ComputeDeviceMAC(string, []byte, []byte) ([]byte, error) { return nil, ErrSealed }
+func (sealedKMS) WrapDeviceKeyUpdate(string, []byte, []byte, int16) ([]byte, error) {
+	return nil, ErrSealed
+}
+
+func initSealed(cfg *config.KMSConfig) KMS {
+	for _, path := range cfg.OldMasterKeyPaths {
+		old, err := ReadLegacyMasterKey(path)
+		if err != nil {
+			logger.Fatal("failed to read old master key", zap.String("path", path), zap.Error(err))
+		}
+		unsealer.oldKeys = append(unsealer.oldKeys, old)
+	}
+	logger.Warn("kms started sealed, waiting for master key shares")
+	return sealedKMS{}
+}
+
+// Sealed 报告 KMS 是否仍处于密封状态。
+func Sealed() bool {
+	k := instance.Load()
+	if k == nil {
+		return false
+	}
+	_, sealed := (*k).(sealedKMS)
+	return sealed
+}
+
+// SealStatus 返回当前解封进度。
+func SealStatus() UnsealStatus {
+	unsealer.mu.Lock()
+	defer unsealer.mu.Unlock()
+	return unsealer.statusLocked()
+}
+
+func (u *unsealState) statusLocked() UnsealStatus {
+	st := UnsealStatus{Sealed: Sealed(), Progress: len(u.shares)}
+	if len(u.shares) > 0 {
+		st.Threshold = u.shares[0].Threshold
+	}
+	return st
+}
+
+// SubmitUnsealShare 提交一份分片；凑齐后还原主密钥并用 store 中的指纹校验，成功即解封。
+func SubmitUnsealShare(text string, store repository.SettingStore) (UnsealStatus, error) {
+	unsealer.mu.Lock()
+	defer unsealer.mu.Unlock()
+
+	if !Sealed() {
+		return unsealer.statusLocked(), nil
+	}
+	share, err := shamir.ParseShare(text)
+	if err != nil {
+		return unsealer.statusLocked(), err
+	}
+	for _, s := range unsealer.shares {
+		if s.X == share.X {
+			return unsealer.statusLocked(), shamir.ErrDuplicateShare
+		}
+		if s.Threshold != share.Threshold || len(s.Y) != len(share.Y) {
+			return unsealer.statusLocked(), shamir.ErrThresholdMismatch
+		}
+	}
+	unsealer.shares = append(unsealer.shares, share)
+	if len(unsealer.shares) < share.Threshold {
+		return unsealer.statusLocked(), nil
+	}
+
+	err = unsealer.unsealLocked(store)
+	unsealer.shares = nil
+	if err != nil {
+		logger.Warn("kms unseal failed, submitted shares discarded", zap.Error(err))
+		return unsealer.statusLocked(), err
+	}
+	return unsealer.statusLocked(), nil
+}
+
+func (u *unsealState) unsealLocked(store repository.SettingStore) error {
+	secret, err := shamir.Combine(u.shares)
+	if err != nil {
+		return err
+	}
+	key, err := ParseMasterKey(secret)
+	if err != nil {
+		return fmt.Errorf("reconstructed master key rejected: %w", err)
+	}
+
+	local := NewLocalKMS(key, u.oldKeys...)
+	if err := verifyFingerprint(local, store); err != nil {
+		return err
+	}
+	setInstance(local)
+	logger.Info("kms unsealed", zap.String("active_key_id", local.ActiveKeyID()))
+	return nil
+}
@@ -0,0 +1,136 @@
+package kms
+
+import (
+	"errors"
+	"testing"
+
+	"promthus/internal/config"
+	"promthus/internal/logger"
+	"promthus/internal/shamir"
+
+	"go.uber.org/zap"
+)
+
+// sealForTest 把全局 KMS 切到密封状态，测试结束后恢复
+func sealForTest(t *testing.T) {
+	t.Helper()
+	logger.L = zap.NewNop()
+	prev := instance.Load()
+	setInstance(initSealed(&config.KMSConfig{}))
+	unsealer.shares = nil
+	t.Cleanup(func() {
+		instance.Store(prev)
+		unsealer.shares, unsealer.oldKeys = nil, nil
+	})
+}
+
+func splitMasterKey(t *testing.T, key []byte) []string {
+	t.Helper()
+	shares, err := shamir.Split(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, len(shares))
+	for i, s := range shares {
+		out[i] = s.String()
+	}
+	return out
+}
+
+func TestSealedKMSRefusesOperations(t *testing.T) {
+	sealForTest(t)
+	if !Sealed() {
+		t.Fatal("Sealed() = false")
+	}
+	if _, err := Get().EncryptDeviceKey(make([]byte, 16)); !errors.Is(err, ErrSealed) {
+		t.Fatalf("encrypt: err = %v", err)
+	}
+	if _, err := Get().ComputeDeviceMAC("aes-cmac", nil, nil); !errors.Is(err, ErrSealed) {
+		t.Fatalf("mac: err = %v", err)
+	}
+	// 密封期间跳过指纹校验，解封时再校验
+	if err := VerifyFingerprint(memSettingStore{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitUnsealShare(t *testing.T) {
+	sealForTest(t)
+	key := testMasterKey(7)
+	shares := splitMasterKey(t, key)
+	store := memSettingStore{fingerprintSettingKey: MasterKeyFingerprint(key)}
+
+	if st := SealStatus(); !st.Sealed || st.Threshold != 0 || st.Progress != 0 {
+		t.Fatalf("initial status = %+v", st)
+	}
+	st, err := SubmitUnsealShare(shares[0], store)
+	if err != nil || st.Progress != 1 || st.Threshold != 3 {
+		t.Fatalf("first share: %+v, %v", st, err)
+	}
+	if _, err := SubmitUnsealShare(shares[0], store); !errors.Is(err, shamir.ErrDuplicateShare) {
+		t.Fatalf("duplicate: err = %v", err)
+	}
+	if st, err := SubmitUnsealShare("pms1-garbage", store); !errors.Is(err, shamir.ErrInvalidShare) || st.Progress != 1 {
+		t.Fatalf("garbage: %+v, %v", st, err)
+	}
+	other, _ := shamir.Split(key, 3, 2)
+	if _, err := SubmitUnsealShare(other[0].String(), store); !errors.Is(err, shamir.ErrThresholdMismatch) {
+		t.Fatalf("foreign threshold: err = %v", err)
+	}
+
+	if _, err := SubmitUnsealShare(shares[3], store); err != nil {
+		t.Fatal(err)
+	}
+	st, err = SubmitUnsealShare(shares[4], store)
+	if err != nil || st.Sealed || st.Progress != 0 {
+		t.Fatalf("final share: %+v, %v", st, err)
+	}
+	if Sealed() {
+		t.Fatal("still sealed")
+	}
+	local, ok := Get().(*LocalKMS)
+	if !ok || local.ActiveKeyID() != MasterKeyID(key) {
+		t.Fatalf("unsealed KMS = %T", Get())
+	}
+
+	// 解封后再提交不报错也不改变状态
+	if st, err := SubmitUnsealShare(shares[1], store); err != nil || st.Sealed {
+		t.Fatalf("after unseal: %+v, %v", st, err)
+	}
+}
+
+func TestSubmitUnsealShareFingerprintMismatch(t *testing.T) {
+	sealForTest(t)
+	shares := splitMasterKey(t, testMasterKey(7))
+	store := memSettingStore{fingerprintSettingKey: MasterKeyFingerprint(testMasterKey(8))}
+
+	for _, s := range shares[:2] {
+		if _, err := SubmitUnsealShare(s, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := SubmitUnsealShare(shares[2], store)
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("err = %v, want ErrFingerprintMismatch", err)
+	}
+	// 还原失败清空已提交分片，保持密封
+	if !st.Sealed || st.Progress != 0 || !Sealed() {
+		t.Fatalf("status after mismatch = %+v", st)
+	}
+}
+
+func TestSubmitUnsealShareRejectsWeakKey(t *testing.T) {
+	sealForTest(t)
+	shares := splitMasterKey(t, make([]byte, 32))
+	for _, s := range shares[:2] {
+		if _, err := SubmitUnsealShare(s, memSettingStore{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := SubmitUnsealShare(shares[2], memSettingStore{}); !errors.Is(err, ErrMasterKeyMalformed) {
+		t.Fatalf("err = %v, want ErrMasterKeyMalformed", err)
+	}
+	if !Sealed() {
+		t.Fatal("unsealed with an all-zero master key")
+	}
+}
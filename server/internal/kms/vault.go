@@ -0,0 +1,251 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"promthus/internal/config"
+)
+
+/*
+VaultKMS：设备密钥由 Vault Transit 引擎包装/解包，主密钥不落本机磁盘。
+  - EncryptDeviceKey → POST /v1/{transit}/encrypt/{key}，库中保存 "vault:vN:..." 密文；
+  - DecryptDeviceKey → POST /v1/{transit}/decrypt/{key}；
+  - 认证支持静态 Token 与 AppRole，AppRole 的 client_token 过期前自动重新登录，收到 403 时也会重登一次；
+  - 从 LocalKMS 迁移期间，可配置旧主密钥（legacy）解密非 "vault:" 前缀的存量密文，写入一律走 Vault。
+CMAC/HMAC 仍在进程内计算。
+*/
+
+const vaultCiphertextPrefix = "vault:"
+
+// tokenRenewSkew 在 Token 到期前提前重新登录，避免请求途中过期
+const tokenRenewSkew = 30 * time.Second
+
+var ErrVaultAuth = errors.New("vault authentication failed")
+
+type VaultKMS struct {
+	cfg    config.VaultConfig
+	client *http.Client
+	legacy *LocalKMS // 迁移期解密旧的本地主密钥密文，可为 nil
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time // 零值表示不过期（静态 Token）
+}
+
+// vaultError Vault 返回的非 2xx 响应
+type vaultError struct {
+	Status int
+	Errors []string
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("vault: status %d: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// NewVaultKMS 校验配置并构造 VaultKMS；legacy 可为 nil。
+func NewVaultKMS(cfg config.VaultConfig, legacy *LocalKMS) (*VaultKMS, error) {
+	if cfg.Addr == "" || cfg.TransitKey == "" {
+		return nil, errors.New("vault: addr and transit key are required")
+	}
+	if cfg.Token == "" && (cfg.RoleID == "" || cfg.SecretID == "") {
+		return nil, errors.New("vault: either token or approle role_id/secret_id is required")
+	}
+	if cfg.TransitMount == "" {
+		cfg.TransitMount = "transit"
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = "approle"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	cfg.Addr = strings.TrimRight(cfg.Addr, "/")
+
+	v := &VaultKMS{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		legacy: legacy,
+	}
+	if cfg.RoleID == "" {
+		v.token = cfg.Token
+	}
+	return v, nil
+}
+
+func (v *VaultKMS) EncryptDeviceKey(plainKey []byte) ([]byte, error) {
+	var out struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plainKey)}
+	if err := v.call(http.MethodPost, v.transitPath("encrypt"), body, &out); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(out.Data.Ciphertext, vaultCiphertextPrefix) {
+		return nil, errors.New("vault: unexpected ciphertext format")
+	}
+	return []byte(out.Data.Ciphertext), nil
+}
+
+func (v *VaultKMS) DecryptDeviceKey(encryptedKey []byte) ([]byte, error) {
+	if !bytes.HasPrefix(encryptedKey, []byte(vaultCiphertextPrefix)) {
+		if v.legacy == nil {
+			return nil, ErrNoMatchingMasterKey
+		}
+		return v.legacy.DecryptDeviceKey(encryptedKey)
+	}
+
+	var out struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"ciphertext": string(encryptedKey)}
+	if err := v.call(http.MethodPost, v.transitPath("decrypt"), body, &out); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Data.Plaintext)
+}
+
+func (v *VaultKMS) ComputeCMAC(key, data []byte) ([]byte, error) {
+	return aesCMAC(key, data)
+}
+
+func (v *VaultKMS) ComputeHMAC(key, data []byte) ([]byte, error) {
+	return legacyHMAC(key, data), nil
+}
+
+func (v *VaultKMS) ComputeDeviceMAC(algorithm string, encryptedKey, data []byte) ([]byte, error) {
+	return softwareDeviceMAC(v, algorithm, encryptedKey, data)
+}
+
+func (v *VaultKMS) WrapDeviceKeyUpdate(algorithm string, encryptedOldKey, encryptedNewKey []byte, version int16) ([]byte, error) {
+	return softwareKeyUpdate(v, algorithm, encryptedOldKey, encryptedNewKey, version)
+}
+
+// Ping 启动时做一次认证并读取 Transit 密钥元数据，配置错误尽早暴露。
+func (v *VaultKMS) Ping() error {
+	return v.call(http.MethodGet, fmt.Sprintf("/v1/%s/keys/%s", v.cfg.TransitMount, v.cfg.TransitKey), nil, nil)
+}
+
+func (v *VaultKMS) transitPath(op string) string {
+	return fmt.Sprintf("/v1/%s/%s/%s", v.cfg.TransitMount, op, v.cfg.TransitKey)
+}
+
+// call 发送带 Token 的请求；AppRole 模式下遇到 403 重新登录后重试一次。
+func (v *VaultKMS) call(method, path string, body, out interface{}) error {
+	token, err := v.currentToken()
+	if err != nil {
+		return err
+	}
+	err = v.do(method, path, token, body, out)
+	var ve *vaultError
+	if errors.As(err, &ve) && ve.Status == http.StatusForbidden && v.cfg.RoleID != "" {
+		v.invalidateToken(token)
+		if token, err = v.currentToken(); err != nil {
+			return err
+		}
+		err = v.do(method, path, token, body, out)
+	}
+	return err
+}
+
+func (v *VaultKMS) currentToken() (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.token != "" && (v.tokenExpiry.IsZero() || time.Now().Add(tokenRenewSkew).Before(v.tokenExpiry)) {
+		return v.token, nil
+	}
+	if v.cfg.RoleID == "" {
+		return v.token, nil
+	}
+	return v.loginAppRole()
+}
+
+func (v *VaultKMS) invalidateToken(token string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token == token {
+		v.token = ""
+	}
+}
+
+// loginAppRole 调用方需持有 mu
+func (v *VaultKMS) loginAppRole() (string, error) {
+	var out struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": v.cfg.RoleID, "secret_id": v.cfg.SecretID}
+	if err := v.do(http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", v.cfg.AppRoleMount), "", body, &out); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrVaultAuth, err)
+	}
+	if out.Auth.ClientToken == "" {
+		return "", fmt.Errorf("%w: empty client token", ErrVaultAuth)
+	}
+
+	v.token = out.Auth.ClientToken
+	v.tokenExpiry = time.Time{}
+	if out.Auth.LeaseDuration > 0 {
+		v.tokenExpiry = time.Now().Add(time.Duration(out.Auth.LeaseDuration) * time.Second)
+	}
+	return v.token, nil
+}
+
+func (v *VaultKMS) do(method, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, v.cfg.Addr+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("vault: read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		ve := &vaultError{Status: resp.StatusCode}
+		_ = json.Unmarshal(raw, &struct {
+			Errors *[]string `json:"errors"`
+		}{&ve.Errors})
+		return ve
+	}
+	if out == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,368 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+)
+
+type transitRequest struct {
+	Method    string
+	Path      string
+	Token     string
+	Namespace string
+	Body      map[string]string
+}
+
+// fakeTransit 模拟 Vault 的 AppRole 登录与 Transit encrypt/decrypt，记录收到的每个请求
+type fakeTransit struct {
+	t             *testing.T
+	mu            sync.Mutex
+	requests      []transitRequest
+	valid         map[string]bool
+	logins        int
+	leaseDuration int
+	keyVersion    int
+	// 非 0 时所有 transit 请求直接返回该状态码
+	failStatus int
+}
+
+func newFakeTransit(t *testing.T) (*fakeTransit, *httptest.Server) {
+	f := &fakeTransit{t: t, valid: map[string]bool{"static-token": true}, leaseDuration: 3600, keyVersion: 1}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeTransit) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req := transitRequest{Method: r.Method, Path: r.URL.Path,
+		Token: r.Header.Get("X-Vault-Token"), Namespace: r.Header.Get("X-Vault-Namespace")}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&req.Body)
+	}
+	f.requests = append(f.requests, req)
+
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	denied := map[string]interface{}{"errors": []string{"permission denied"}}
+
+	if req.Path == "/v1/auth/approle/login" {
+		if req.Body["role_id"] != "role" || req.Body["secret_id"] != "secret" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		f.logins++
+		token := "approle-token-" + string(rune('0'+f.logins))
+		f.valid[token] = true
+		reply(http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": f.leaseDuration}})
+		return
+	}
+	if !f.valid[req.Token] {
+		reply(http.StatusForbidden, denied)
+		return
+	}
+	if f.failStatus != 0 {
+		reply(f.failStatus, map[string]interface{}{"errors": []string{"transit unavailable"}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && req.Path == "/v1/transit/keys/devkey":
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"name": "devkey"}})
+	case r.Method == http.MethodPost && req.Path == "/v1/transit/encrypt/devkey":
+		plain, err := base64.StdEncoding.DecodeString(req.Body["plaintext"])
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"plaintext must be base64"}})
+			return
+		}
+		ciphertext := "vault:v" + string(rune('0'+f.keyVersion)) + ":" + base64.StdEncoding.EncodeToString(xorBytes(plain))
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+	case r.Method == http.MethodPost && req.Path == "/v1/transit/decrypt/devkey":
+		parts := strings.SplitN(req.Body["ciphertext"], ":", 3)
+		if len(parts) != 3 || parts[0] != "vault" {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid ciphertext"}})
+			return
+		}
+		sealed, _ := base64.StdEncoding.DecodeString(parts[2])
+		reply(http.StatusOK, map[string]interface{}{
+			"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(xorBytes(sealed))}})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (f *fakeTransit) revokeAll() {
+	f.set(func() { f.valid = map[string]bool{} })
+}
+
+func (f *fakeTransit) paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, r := range f.requests {
+		out = append(out, r.Path)
+	}
+	return out
+}
+
+func (f *fakeTransit) loginCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins
+}
+
+func (f *fakeTransit) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func (f *fakeTransit) first() transitRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[0]
+}
+
+func (f *fakeTransit) last() transitRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func xorBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x5a
+	}
+	return out
+}
+
+func TestNewVaultKMSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.VaultConfig
+		wantErr bool
+	}{
+		{"static token", config.VaultConfig{Addr: "http://v", TransitKey: "k", Token: "t"}, false},
+		{"approle", config.VaultConfig{Addr: "http://v", TransitKey: "k", RoleID: "r", SecretID: "s"}, false},
+		{"missing addr", config.VaultConfig{TransitKey: "k", Token: "t"}, true},
+		{"missing transit key", config.VaultConfig{Addr: "http://v", Token: "t"}, true},
+		{"no credentials", config.VaultConfig{Addr: "http://v", TransitKey: "k"}, true},
+		{"approle without secret", config.VaultConfig{Addr: "http://v", TransitKey: "k", RoleID: "r"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewVaultKMS(tt.cfg, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (v.cfg.TransitMount != "transit" || v.cfg.AppRoleMount != "approle" || v.cfg.Timeout <= 0) {
+				t.Fatalf("defaults not applied: %+v", v.cfg)
+			}
+		})
+	}
+}
+
+func TestVaultKMSRequestShapes(t *testing.T) {
+	fake, srv := newFakeTransit(t)
+	v, err := NewVaultKMS(config.VaultConfig{Addr: srv.URL + "/", Namespace: "ops", Token: "static-token", TransitKey: "devkey"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceKey := mustHex(t, rfc4493Key)
+
+	if err := v.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if got := fake.last(); got.Method != http.MethodGet || got.Path != "/v1/transit/keys/devkey" {
+		t.Fatalf("Ping request = %+v", got)
+	}
+
+	blob, err := v.EncryptDeviceKey(deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := fake.last()
+	if enc.Method != http.MethodPost || enc.Path != "/v1/transit/encrypt/devkey" ||
+		enc.Token != "static-token" || enc.Namespace != "ops" {
+		t.Fatalf("encrypt request = %+v", enc)
+	}
+	if enc.Body["plaintext"] != base64.StdEncoding.EncodeToString(deviceKey) || len(enc.Body) != 1 {
+		t.Fatalf("encrypt body = %v", enc.Body)
+	}
+	if !strings.HasPrefix(string(blob), "vault:v1:") {
+		t.Fatalf("ciphertext %q lacks vault:v1: prefix", blob)
+	}
+
+	plain, err := v.DecryptDeviceKey(blob)
+	if err != nil || !bytes.Equal(plain, deviceKey) {
+		t.Fatalf("decrypt = %x, %v", plain, err)
+	}
+	dec := fake.last()
+	if dec.Path != "/v1/transit/decrypt/devkey" || dec.Body["ciphertext"] != string(blob) || len(dec.Body) != 1 {
+		t.Fatalf("decrypt request = %+v", dec)
+	}
+
+	// 设备 MAC 在进程内计算：只调用一次 decrypt，不走 Transit 的 hmac 接口
+	before := len(fake.paths())
+	data := mustHex(t, rfc4493Message)[:16]
+	mac, err := v.ComputeDeviceMAC(model.MACAlgorithmCMAC, blob, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustHex(t, "070a16b46b4d4144f79bdd9dd04a287c"); !bytes.Equal(mac, want) {
+		t.Fatalf("ComputeDeviceMAC = %x, want %x", mac, want)
+	}
+	if calls := fake.paths()[before:]; len(calls) != 1 || calls[0] != "/v1/transit/decrypt/devkey" {
+		t.Fatalf("ComputeDeviceMAC vault calls = %v, want one decrypt", calls)
+	}
+
+	// Transit 密钥轮换后的新版本密文同样可解
+	fake.set(func() { fake.keyVersion = 2 })
+	blob2, err := v.EncryptDeviceKey(deviceKey)
+	if err != nil || !strings.HasPrefix(string(blob2), "vault:v2:") {
+		t.Fatalf("encrypt after transit rotation = %q, %v", blob2, err)
+	}
+	if plain, err := v.DecryptDeviceKey(blob2); err != nil || !bytes.Equal(plain, deviceKey) {
+		t.Fatalf("decrypt v2 = %x, %v", plain, err)
+	}
+}
+
+func TestVaultKMSErrorMapping(t *testing.T) {
+	fake, srv := newFakeTransit(t)
+	v, _ := NewVaultKMS(config.VaultConfig{Addr: srv.URL, Token: "static-token", TransitKey: "devkey"}, nil)
+
+	tests := []struct {
+		name       string
+		failStatus int
+		wantStatus int
+		wantMsg    string
+	}{
+		{"bad request", http.StatusBadRequest, 400, "transit unavailable"},
+		{"server error", http.StatusInternalServerError, 500, "transit unavailable"},
+		{"sealed", http.StatusServiceUnavailable, 503, "transit unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.set(func() { fake.failStatus = tt.failStatus })
+			_, err := v.EncryptDeviceKey([]byte("0123456789abcdef"))
+			var ve *vaultError
+			if !errors.As(err, &ve) || ve.Status != tt.wantStatus || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Fatalf("err = %v, want vault status %d", err, tt.wantStatus)
+			}
+		})
+	}
+	fake.set(func() { fake.failStatus = 0 })
+
+	// 静态 Token 被拒不重试
+	fake.revokeAll()
+	before := len(fake.paths())
+	_, err := v.DecryptDeviceKey([]byte("vault:v1:AAAA"))
+	var ve *vaultError
+	if !errors.As(err, &ve) || ve.Status != http.StatusForbidden {
+		t.Fatalf("revoked static token: err = %v, want 403", err)
+	}
+	if n := len(fake.paths()) - before; n != 1 {
+		t.Fatalf("revoked static token made %d requests, want 1", n)
+	}
+
+	// 非 vault: 前缀的响应视为异常
+	weird := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"ciphertext":"plain-text"}}`))
+	}))
+	defer weird.Close()
+	v2, _ := NewVaultKMS(config.VaultConfig{Addr: weird.URL, Token: "t", TransitKey: "devkey"}, nil)
+	if _, err := v2.EncryptDeviceKey([]byte("0123456789abcdef")); err == nil {
+		t.Fatal("ciphertext without vault: prefix accepted")
+	}
+
+	// Vault 不可达
+	srv.Close()
+	if _, err := v.EncryptDeviceKey([]byte("0123456789abcdef")); err == nil || errors.As(err, &ve) {
+		t.Fatalf("unreachable vault: err = %v, want transport error", err)
+	}
+}
+
+func TestVaultKMSAppRoleTokenRenewal(t *testing.T) {
+	fake, srv := newFakeTransit(t)
+	v, _ := NewVaultKMS(config.VaultConfig{Addr: srv.URL, RoleID: "role", SecretID: "secret", TransitKey: "devkey"}, nil)
+	key := []byte("0123456789abcdef")
+
+	// 有效期内复用同一 Token
+	for i := 0; i < 3; i++ {
+		if _, err := v.EncryptDeviceKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.loginCount() != 1 {
+		t.Fatalf("logins = %d, want 1", fake.loginCount())
+	}
+	login := fake.first()
+	if login.Method != http.MethodPost || login.Token != "" ||
+		login.Body["role_id"] != "role" || login.Body["secret_id"] != "secret" {
+		t.Fatalf("login request = %+v", login)
+	}
+	if got := fake.last().Token; got != "approle-token-1" {
+		t.Fatalf("transit token = %q", got)
+	}
+
+	// Token 被吊销：收到 403 后重新登录并重试一次
+	fake.revokeAll()
+	if _, err := v.EncryptDeviceKey(key); err != nil {
+		t.Fatalf("after revoke: %v", err)
+	}
+	if fake.loginCount() != 2 || fake.last().Token != "approle-token-2" {
+		t.Fatalf("after revoke: logins = %d, token = %q", fake.loginCount(), fake.last().Token)
+	}
+
+	// 租期短于提前量：每次调用前都重新登录
+	fake.set(func() { fake.leaseDuration = 10 })
+	v.invalidateToken(v.token)
+	for i := 0; i < 2; i++ {
+		if _, err := v.EncryptDeviceKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.loginCount() != 4 {
+		t.Fatalf("short lease: logins = %d, want 4", fake.loginCount())
+	}
+
+	bad, _ := NewVaultKMS(config.VaultConfig{Addr: srv.URL, RoleID: "role", SecretID: "wrong", TransitKey: "devkey"}, nil)
+	if _, err := bad.EncryptDeviceKey(key); !errors.Is(err, ErrVaultAuth) {
+		t.Fatalf("bad secret: err = %v, want ErrVaultAuth", err)
+	}
+}
+
+func TestVaultKMSLegacyCiphertext(t *testing.T) {
+	_, srv := newFakeTransit(t)
+	legacy := NewLocalKMS(testMasterKey(1))
+	local, err := legacy.EncryptDeviceKey([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := NewVaultKMS(config.VaultConfig{Addr: srv.URL, Token: "static-token", TransitKey: "devkey"}, legacy)
+	if plain, err := v.DecryptDeviceKey(local); err != nil || string(plain) != "0123456789abcdef" {
+		t.Fatalf("legacy decrypt = %q, %v", plain, err)
+	}
+	noLegacy, _ := NewVaultKMS(config.VaultConfig{Addr: srv.URL, Token: "static-token", TransitKey: "devkey"}, nil)
+	if _, err := noLegacy.DecryptDeviceKey(local); !errors.Is(err, ErrNoMatchingMasterKey) {
+		t.Fatalf("legacy without old key: err = %v, want ErrNoMatchingMasterKey", err)
+	}
+}
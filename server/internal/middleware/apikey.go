@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+/*
+服务账号 API Key 鉴权：Authorization: Bearer psk_... 与会话 Token 共用 Auth()。
+API Key 只能访问 apiKeyRouteScopes 中列出的路由，且 Key 必须带有该路由要求的 scope；
+未列出的路由（开锁、用户管理、服务账号管理等）一律 403，新增接口默认不对 API Key 开放。
+每次 API Key 请求结束后写一条 log.operation_logs（operator 为服务账号背后的用户）。
+*/
+
+// APIKeyScopes 可授予 API Key 的全部 scope
+var APIKeyScopes = []string{
+	"devices:read",
+	"permissions:read",
+	"permissions:write",
+	"audit:read",
+	"alerts:read",
+	"alerts:write",
+}
+
+// 方法 + 路由模板 → 所需 scope
+var apiKeyRouteScopes = map[string]string{
+	"GET /api/admin/devices":            "devices:read",
+	"GET /api/admin/permissions":        "permissions:read",
+	"POST /api/admin/permissions":       "permissions:write",
+	"POST /api/admin/permissions/batch": "permissions:write",
+	"DELETE /api/admin/permissions/:id": "permissions:write",
+	"GET /api/admin/audit-logs":         "audit:read",
+	"GET /api/admin/login-logs":         "audit:read",
+	"GET /api/admin/alerts":             "alerts:read",
+	"PUT /api/admin/alerts/:id":         "alerts:write",
+}
+
+// API Key 的 last_used 最多每分钟写一次
+const apiKeyTouchInterval = time.Minute
+
+type apiKeyPrincipal struct {
+	KeyID            int64
+	Scopes           string
+	ExpiresAt        *time.Time
+	RevokedAt        *time.Time
+	LastUsedAt       *time.Time
+	KeyHash          []byte
+	ServiceAccountID int64
+	UserID           int64
+	AccountStatus    int16
+}
+
+func authAPIKey(c *gin.Context, key string) {
+	prefix, ok := crypto.ParseAPIKeyPrefix(key)
+	if !ok {
+		model.Fail(c, http.StatusUnauthorized, model.CodeSessionExpired, "invalid api key")
+		c.Abort()
+		return
+	}
+
+	var p apiKeyPrincipal
+	result := repository.DB.Raw(`SELECT k.id AS key_id, k.scopes, k.expires_at, k.revoked_at, k.last_used_at, k.key_hash,
+			sa.id AS service_account_id, sa.user_id, sa.status AS account_status
+		FROM app.api_keys k JOIN app.service_accounts sa ON sa.id = k.service_account_id
+		WHERE k.key_prefix = ?`, prefix).Scan(&p)
+	if result.Error != nil || result.RowsAffected == 0 ||
+		subtle.ConstantTimeCompare(p.KeyHash, crypto.HashAPIKey(key)) != 1 {
+		model.Fail(c, http.StatusUnauthorized, model.CodeSessionExpired, "invalid api key")
+		c.Abort()
+		return
+	}
+	now := time.Now()
+	if p.RevokedAt != nil || (p.ExpiresAt != nil && !p.ExpiresAt.After(now)) {
+		model.Fail(c, http.StatusUnauthorized, model.CodeSessionExpired, "api key expired or revoked")
+		c.Abort()
+		return
+	}
+	if p.AccountStatus != 1 {
+		model.Fail(c, http.StatusUnauthorized, model.CodeAccountDisabled, "service account has been disabled")
+		c.Abort()
+		return
+	}
+
+	scope, allowed := apiKeyRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !allowed {
+		model.Fail(c, http.StatusForbidden, model.CodeForbidden, "route not available to api keys")
+		c.Abort()
+		return
+	}
+	if !hasScope(p.Scopes, scope) {
+		model.Fail(c, http.StatusForbidden, model.CodeForbidden, "api key lacks scope "+scope)
+		c.Abort()
+		return
+	}
+
+	if p.LastUsedAt == nil || now.Sub(*p.LastUsedAt) > apiKeyTouchInterval {
+		repository.DB.Exec("UPDATE app.api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ?",
+			now, c.ClientIP(), p.KeyID)
+	}
+
+	c.Set("user_id", p.UserID)
+	c.Set("role", model.RoleService)
+	c.Set("auth_type", "api_key")
+	c.Set("service_account_id", p.ServiceAccountID)
+	c.Set("api_key_id", p.KeyID)
+	c.Next()
+
+	recordAPIKeyRequest(c, &p)
+}
+
+func hasScope(scopes, want string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAPIKeyRequest 记录 API Key 的每一次调用；业务写操作另有各自的操作日志
+func recordAPIKeyRequest(c *gin.Context, p *apiKeyPrincipal) {
+	log := &model.OperationLog{
+		OperatorID: p.UserID,
+		Action:     "api_key_request",
+		TargetType: "api_key",
+		TargetID:   p.KeyID,
+		AfterSnapshot: model.JSON{
+			"service_account_id": p.ServiceAccountID,
+			"method":             c.Request.Method,
+			"path":               c.Request.URL.Path,
+			"query":              c.Request.URL.RawQuery,
+			"status":             c.Writer.Status(),
+			"client_ip":          c.ClientIP(),
+			"request_id":         model.GetRequestID(c),
+		},
+	}
+	if err := repository.DB.Create(log).Error; err != nil {
+		logger.Error("api key request log failed", zap.Error(err), zap.Int64("api_key_id", p.KeyID))
+	}
+}
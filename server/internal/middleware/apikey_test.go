@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 路由表中的 scope 必须都可授予，否则该路由永远无法用 API Key 访问
+func TestAPIKeyRouteScopesGrantable(t *testing.T) {
+	grantable := map[string]bool{}
+	for _, s := range APIKeyScopes {
+		grantable[s] = true
+	}
+	for route, scope := range apiKeyRouteScopes {
+		if !grantable[scope] {
+			t.Errorf("route %q requires scope %q which is not in APIKeyScopes", route, scope)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes, want string
+		ok           bool
+	}{
+		{"audit:read alerts:read", "alerts:read", true},
+		{"audit:read", "audit:read", true},
+		{"audit:read", "audit:write", false},
+		{"permissions:read", "permissions", false},
+		{"", "audit:read", false},
+	}
+	for _, tt := range tests {
+		if got := hasScope(tt.scopes, tt.want); got != tt.ok {
+			t.Errorf("hasScope(%q, %q) = %v, want %v", tt.scopes, tt.want, got, tt.ok)
+		}
+	}
+}
+
+// 形状不对的 Key 在查库前就被拒绝
+func TestAuthRejectsMalformedAPIKeyWithoutDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/admin/audit-logs", Auth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/audit-logs", nil)
+	req.Header.Set("Authorization", "Bearer psk_not-a-key")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", w.Code)
+	}
+}
+
+// newTestAPIKey 直接写库创建服务账号及一把 Key，返回明文
+func newTestAPIKey(t *testing.T, account *model.ServiceAccount, scopes string, expiresAt *time.Time) (*model.APIKey, string) {
+	t.Helper()
+	key, prefix, err := crypto.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := &model.APIKey{ServiceAccountID: account.ID, Name: "test", KeyPrefix: prefix,
+		KeyHash: crypto.HashAPIKey(key), Scopes: scopes, ExpiresAt: expiresAt, CreatedBy: account.UserID}
+	if err := repository.DB.Create(apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return apiKey, key
+}
+
+func newTestServiceAccount(t *testing.T, name string) *model.ServiceAccount {
+	t.Helper()
+	user := &model.User{UUID: uuid.New(), Phone: "svc-" + name, PasswordHash: "!", Name: name,
+		Role: model.RoleService, Status: 1, AuthSource: model.AuthSourceLocal}
+	if err := repository.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	account := &model.ServiceAccount{UUID: uuid.New(), UserID: user.ID, Name: name, Status: 1, CreatedBy: user.ID}
+	if err := repository.DB.Create(account).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	testdb.Open(t)
+	gin.SetMode(gin.TestMode)
+
+	account := newTestServiceAccount(t, "siem")
+	auditKey, audit := newTestAPIKey(t, account, "audit:read alerts:read", nil)
+	_, revoked := newTestAPIKey(t, account, "audit:read", nil)
+	past := time.Now().Add(-time.Minute)
+	_, expired := newTestAPIKey(t, account, "audit:read", &past)
+	if err := repository.DB.Model(&model.APIKey{}).Where("key_prefix = ?", revoked[4:16]).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	disabledAccount := newTestServiceAccount(t, "disabled")
+	_, disabled := newTestAPIKey(t, disabledAccount, "audit:read", nil)
+	if err := repository.DB.Model(disabledAccount).Update("status", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	ok := func(c *gin.Context) {
+		if c.GetString("auth_type") != "api_key" || c.GetInt64("user_id") != account.UserID {
+			c.Status(http.StatusTeapot)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.GET("/api/admin/audit-logs", Auth(), ok)
+	r.PUT("/api/admin/alerts/:id", Auth(), ok)
+	r.POST("/api/admin/users", Auth(), ok)
+
+	tests := []struct {
+		name, method, path, key string
+		wantStatus, wantCode    int
+	}{
+		{"scoped route", http.MethodGet, "/api/admin/audit-logs", audit, http.StatusOK, 0},
+		{"missing scope", http.MethodPut, "/api/admin/alerts/1", audit, http.StatusForbidden, model.CodeForbidden},
+		{"unlisted route", http.MethodPost, "/api/admin/users", audit, http.StatusForbidden, model.CodeForbidden},
+		{"wrong secret", http.MethodGet, "/api/admin/audit-logs", audit[:len(audit)-1] + flipLast(audit),
+			http.StatusUnauthorized, model.CodeSessionExpired},
+		{"revoked", http.MethodGet, "/api/admin/audit-logs", revoked, http.StatusUnauthorized, model.CodeSessionExpired},
+		{"expired", http.MethodGet, "/api/admin/audit-logs", expired, http.StatusUnauthorized, model.CodeSessionExpired},
+		{"disabled account", http.MethodGet, "/api/admin/audit-logs", disabled, http.StatusUnauthorized, model.CodeAccountDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != 0 {
+				var resp model.Response
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if resp.Code != tt.wantCode {
+					t.Fatalf("code %d, want %d", resp.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	// 放行的请求写一条 api_key_request，并更新 last_used
+	var logs []model.OperationLog
+	if err := repository.DB.Where("action = ? AND target_id = ?", "api_key_request", auditKey.ID).Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("api_key_request logs = %d, want 1", len(logs))
+	}
+	if logs[0].OperatorID != account.UserID || logs[0].AfterSnapshot["path"] != "/api/admin/audit-logs" {
+		t.Fatalf("log = %+v", logs[0])
+	}
+	var stored model.APIKey
+	if err := repository.DB.First(&stored, auditKey.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastUsedAt == nil {
+		t.Fatal("last_used_at not recorded")
+	}
+}
+
+// flipLast 返回与 key 末位不同的字符，保持 Key 形状不变
+func flipLast(key string) string {
+	if key[len(key)-1] == 'A' {
+		return "B"
+	}
+	return "A"
+}
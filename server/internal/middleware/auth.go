@@ -1,20 +1,28 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"promthus/internal/crypto"
 	"promthus/internal/model"
 	"promthus/internal/repository"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
+// 须先改密的用户只能访问这些路由
+var passwordChangeExempt = map[string]bool{
+	"/api/auth/password": true,
+	"/api/auth/logout":   true,
+}
+
 /*
-检查 Header 里有没有合法 Token → 用 Token 里的 JTI 查 session 是否有效 → 再查用户是否存在且未禁用；
-任何一步不通过就 401 并 Abort；
+Bearer 后为 psk_ 开头的服务账号 API Key 时交给 authAPIKey；
+否则检查 Header 里有没有合法 Token → 用 Token 里的 JTI 查 session 是否有效 → 再查用户是否存在且未禁用；
+任何一步不通过就 401 并 Abort；管理员重置过密码的用户在改密前只能访问 passwordChangeExempt（403）；
 全部通过就把当前用户信息写入 Context 并 Next，让后续逻辑按「已登录用户」继续跑。
 */
 func Auth() gin.HandlerFunc {
@@ -27,7 +35,17 @@ func Auth() gin.HandlerFunc {
 		}
 
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
+		if strings.HasPrefix(tokenStr, crypto.APIKeyPrefix) {
+			authAPIKey(c, tokenStr)
+			return
+		}
+		// 签名与有效期在本地校验，过期 Token 不会触发数据库查询
 		claims, err := ParseToken(tokenStr)
+		if errors.Is(err, ErrTokenExpired) {
+			model.Fail(c, http.StatusUnauthorized, model.CodeSessionExpired, "token expired, please login again")
+			c.Abort()
+			return
+		}
 		if err != nil {
 			model.Fail(c, http.StatusUnauthorized, model.CodeSessionExpired, "invalid token")
 			c.Abort()
@@ -53,76 +71,16 @@ func Auth() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("user_id", session.UserID)
-		c.Set("user_uuid", claims.UserUUID)
-		c.Set("role", session.Role)
-		c.Next()
-	}
-}
-
-func RBAC(allowedRoles ...string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists {
-			model.Fail(c, http.StatusForbidden, model.CodeForbidden, "access denied")
+		if user.MustChangePassword && !passwordChangeExempt[c.FullPath()] {
+			model.Fail(c, http.StatusForbidden, model.CodePasswordChangeRequired, "password change required")
 			c.Abort()
 			return
 		}
 
-		roleStr := role.(string)
-		for _, allowed := range allowedRoles {
-			if roleStr == allowed {
-				c.Next()
-				return
-			}
-		}
-
-		model.Fail(c, http.StatusForbidden, model.CodeForbidden, "insufficient permissions")
-		c.Abort()
-	}
-}
-
-// Token claims and helpers
-
-type TokenClaims struct {
-	UserUUID uuid.UUID
-	JTI      uuid.UUID
-}
-
-var tokenSecret []byte
-
-func SetTokenSecret(secret string) {
-	tokenSecret = []byte(secret)
-}
-
-func GenerateToken(userUUID, jti uuid.UUID) (string, error) {
-	payload := userUUID.String() + ":" + jti.String()
-	return signHMAC(payload, tokenSecret)
-}
-
-func ParseToken(tokenStr string) (*TokenClaims, error) {
-	payload, err := verifyHMAC(tokenStr, tokenSecret)
-	if err != nil {
-		return nil, err
-	}
-
-	parts := strings.SplitN(payload, ":", 2)
-	if len(parts) != 2 {
-		return nil, ErrInvalidToken
-	}
-
-	userUUID, err := uuid.Parse(parts[0])
-	if err != nil {
-		return nil, ErrInvalidToken
-	}
-
-	jti, err := uuid.Parse(parts[1])
-	if err != nil {
-		return nil, ErrInvalidToken
+		c.Set("user_id", session.UserID)
+		c.Set("session_family", session.FamilyID)
+		c.Set("user_uuid", claims.UserUUID)
+		c.Set("role", user.Role)
+		c.Next()
 	}
-
-	return &TokenClaims{
-		UserUUID: userUUID,
-		JTI:      jti,
-	}, nil
 }
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 管理员重置过密码的用户在改密前只能访问改密与登出接口
+func TestAuthMustChangePassword(t *testing.T) {
+	testdb.Open(t)
+	gin.SetMode(gin.TestMode)
+	setTestTokenKeys(t, "", keyK1)
+
+	user := &model.User{UUID: uuid.New(), Phone: "13800009999", PasswordHash: "x", Name: "reset", Role: "user",
+		Status: 1, AuthSource: model.AuthSourceLocal, MustChangePassword: true}
+	if err := repository.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	session := &model.Session{JTI: uuid.New(), FamilyID: uuid.New(), UserID: user.ID, Role: user.Role,
+		ExpiresAt: time.Now().Add(time.Hour), LastUsedAt: time.Now()}
+	if err := repository.DB.Create(session).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, err := GenerateToken(user.UUID, session.JTI, user.Role, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/api/auth/password", Auth(), ok)
+	r.GET("/api/lock/devices", Auth(), ok)
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantCode     int
+	}{
+		{http.MethodPost, "/api/auth/password", http.StatusOK, 0},
+		{http.MethodGet, "/api/lock/devices", http.StatusForbidden, model.CodePasswordChangeRequired},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: status %d, want %d", tt.path, w.Code, tt.wantStatus)
+		}
+		if tt.wantCode != 0 {
+			var resp model.Response
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Code != tt.wantCode {
+				t.Fatalf("%s: code %d, want %d", tt.path, resp.Code, tt.wantCode)
+			}
+		}
+	}
+}
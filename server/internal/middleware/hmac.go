@@ -1,46 +0,0 @@
-package middleware
-
-import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"errors"
-	"strings"
-)
-
-var ErrInvalidToken = errors.New("invalid token")
-
-func signHMAC(payload string, secret []byte) (string, error) {
-	mac := hmac.New(sha256.New, secret)
-	mac.Write([]byte(payload))
-	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(payload))
-	return encodedPayload + "." + sig, nil
-}
-
-func verifyHMAC(token string, secret []byte) (string, error) {
-	parts := strings.SplitN(token, ".", 2)
-	if len(parts) != 2 {
-		return "", ErrInvalidToken
-	}
-
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return "", ErrInvalidToken
-	}
-
-	sigBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return "", ErrInvalidToken
-	}
-
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(payloadBytes)
-	expectedSig := mac.Sum(nil)
-
-	if !hmac.Equal(sigBytes, expectedSig) {
-		return "", ErrInvalidToken
-	}
-
-	return string(payloadBytes), nil
-}
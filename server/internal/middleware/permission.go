@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+/*
+管理后台按动作鉴权：角色 → 动作集合存 app.roles / app.role_permissions，/api/admin 下每个路由用 RequirePermission
+声明所需动作。admin 持有 PermissionAll，新增动作无需迁移即对其生效；user、service 没有任何后台动作。
+角色取自当前用户行（而非会话创建时的快照），改角色后下一次请求即生效。
+API Key 不经角色，已在 Auth 中按 apiKeyRouteScopes 校验 scope。
+*/
+
+// Permissions 可分配给角色的全部动作；API Key 的 scope 是其子集
+var Permissions = []string{
+	"dashboard:read",
+	"users:read",
+	"users:write",
+	"devices:read",
+	"devices:write",
+	"permissions:read",
+	"permissions:write",
+	"audit:read",
+	"alerts:read",
+	"alerts:write",
+	"service_accounts:read",
+	"service_accounts:write",
+	"roles:read",
+	"roles:write",
+}
+
+// PermissionAll 持有者拥有全部动作
+const PermissionAll = "*"
+
+func IsPermission(p string) bool {
+	for _, known := range Permissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+func RequirePermission(perm string) gin.HandlerFunc {
+	if !IsPermission(perm) {
+		panic("middleware: unknown permission " + perm)
+	}
+	return func(c *gin.Context) {
+		if c.GetString("auth_type") == "api_key" {
+			c.Next()
+			return
+		}
+
+		ok, err := RoleHasPermission(c.GetString("role"), perm)
+		if err != nil {
+			logger.Error("permission check failed", zap.Error(err), zap.String("request_id", model.GetRequestID(c)))
+			model.Fail(c, http.StatusInternalServerError, model.CodeInternalError, "permission check failed")
+			c.Abort()
+			return
+		}
+		if !ok {
+			model.Fail(c, http.StatusForbidden, model.CodeForbidden, "insufficient permissions: "+perm)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func RoleHasPermission(role, perm string) (bool, error) {
+	var cnt int64
+	err := repository.DB.Model(&model.RolePermission{}).
+		Where("role = ? AND permission IN ?", role, []string{perm, PermissionAll}).
+		Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// RoleCanWrite 角色是否持有任一写动作（含 PermissionAll），用于判定是否强制两步验证
+func RoleCanWrite(role string) (bool, error) {
+	var cnt int64
+	err := repository.DB.Model(&model.RolePermission{}).
+		Where("role = ? AND (permission = ? OR permission LIKE ?)", role, PermissionAll, "%:write").
+		Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// RolePermissions 返回角色的动作集合，PermissionAll 展开为全部动作
+func RolePermissions(role string) ([]string, error) {
+	var perms []string
+	if err := repository.DB.Model(&model.RolePermission{}).Where("role = ?", role).
+		Order("permission").Pluck("permission", &perms).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range perms {
+		if p == PermissionAll {
+			return append([]string(nil), Permissions...), nil
+		}
+	}
+	return perms, nil
+}
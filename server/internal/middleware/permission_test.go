@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsPermission(t *testing.T) {
+	for _, p := range Permissions {
+		if !IsPermission(p) {
+			t.Errorf("IsPermission(%q) = false", p)
+		}
+	}
+	for _, p := range []string{"", PermissionAll, "users", "users:delete", "AUDIT:READ"} {
+		if IsPermission(p) {
+			t.Errorf("IsPermission(%q) = true", p)
+		}
+	}
+	// API Key 的 scope 必须是动作的子集
+	for _, s := range APIKeyScopes {
+		if !IsPermission(s) {
+			t.Errorf("api key scope %q is not a permission", s)
+		}
+	}
+}
+
+// 路由声明了不存在的动作属于编程错误，注册时即 panic
+func TestRequirePermissionPanicsOnUnknown(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RequirePermission(unknown) did not panic")
+		}
+	}()
+	RequirePermission("users:delete")
+}
+
+// API Key 已在 Auth 中按 scope 校验，不再查角色
+func TestRequirePermissionSkipsAPIKeyWithoutDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/x", func(c *gin.Context) {
+		c.Set("auth_type", "api_key")
+		c.Set("role", model.RoleService)
+	}, RequirePermission("audit:read"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	testdb.Open(t)
+	gin.SetMode(gin.TestMode)
+	if err := repository.DB.Create(&model.Role{Name: "viewer", DisplayName: "viewer"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.DB.Create(&model.RolePermission{Role: "viewer", Permission: "audit:read"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role, perm string
+		want       int
+	}{
+		{"admin", "roles:write", http.StatusOK}, // "*" 覆盖全部动作
+		{"auditor", "audit:read", http.StatusOK},
+		{"auditor", "alerts:write", http.StatusForbidden},
+		{"alert_handler", "alerts:write", http.StatusOK},
+		{"permission_manager", "permissions:write", http.StatusOK},
+		{"permission_manager", "users:write", http.StatusForbidden},
+		{"user", "dashboard:read", http.StatusForbidden},
+		{model.RoleService, "audit:read", http.StatusForbidden}, // 背后用户持会话 Token 也没有后台动作
+		{"viewer", "audit:read", http.StatusOK},
+		{"viewer", "alerts:read", http.StatusForbidden},
+		{"no_such_role", "audit:read", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := gin.New()
+		r.GET("/x", func(c *gin.Context) { c.Set("role", tt.role) },
+			RequirePermission(tt.perm), func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s → %s: status %d, want %d", tt.role, tt.perm, w.Code, tt.want)
+		}
+	}
+
+	// 修改角色的动作集合，下一次请求即生效
+	if err := repository.DB.Create(&model.RolePermission{Role: "viewer", Permission: "alerts:read"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := RoleHasPermission("viewer", "alerts:read"); err != nil || !ok {
+		t.Fatalf("after grant: %v, %v", ok, err)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	testdb.Open(t)
+
+	admin, err := RolePermissions("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(admin) != len(Permissions) {
+		t.Fatalf("admin permissions = %q, want all %d", admin, len(Permissions))
+	}
+	handler, err := RolePermissions("alert_handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"alerts:read", "alerts:write", "audit:read", "dashboard:read", "devices:read"}
+	if len(handler) != len(want) {
+		t.Fatalf("alert_handler permissions = %q, want %q", handler, want)
+	}
+	for i := range want {
+		if handler[i] != want[i] {
+			t.Fatalf("alert_handler permissions = %q, want %q", handler, want)
+		}
+	}
+	for _, role := range []string{"user", model.RoleService} {
+		perms, err := RolePermissions(role)
+		if err != nil || len(perms) != 0 {
+			t.Fatalf("%s permissions = %q, %v; want none", role, perms, err)
+		}
+	}
+}
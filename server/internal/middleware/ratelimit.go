@@ -54,30 +54,10 @@ func checkIPBlock(c *gin.Context) error {
 	return nil
 }
 
-func incrementRateLimit(key string, windowSecs int) (int, error) {
-	var rl model.RateLimit
-	sql := `INSERT INTO app.rate_limits (key, count, window_start, updated_at)
-		VALUES (?, 1, NOW(), NOW())
-		ON CONFLICT (key) DO UPDATE SET
-			count = CASE
-				WHEN app.rate_limits.window_start < NOW() - INTERVAL '%d seconds'
-				THEN 1
-				ELSE app.rate_limits.count + 1
-			END,
-			window_start = CASE
-				WHEN app.rate_limits.window_start < NOW() - INTERVAL '%d seconds'
-				THEN NOW()
-				ELSE app.rate_limits.window_start
-			END,
-			updated_at = NOW()
-		RETURNING count, window_start`
+var rateLimitStore = repository.NewPostgresRateLimitStore()
 
-	formattedSQL := fmt.Sprintf(sql, windowSecs, windowSecs)
-	result := repository.DB.Raw(formattedSQL, key).Scan(&rl)
-	if result.Error != nil {
-		return 0, result.Error
-	}
-	return rl.Count, nil
+func incrementRateLimit(key string, windowSecs int) (int, error) {
+	return rateLimitStore.Increment(key, windowSecs)
 }
 
 // LoginRateLimit is a specialized rate limiter for login endpoint with IP blocking.
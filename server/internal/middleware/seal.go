@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"promthus/internal/kms"
+	"promthus/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireUnsealed KMS 未解封时拒绝依赖设备密钥的接口（锁具与设备管理），返回 503。
+func RequireUnsealed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if kms.Sealed() {
+			model.Fail(c, http.StatusServiceUnavailable, model.CodeServiceSealed, "service is sealed, waiting for master key shares")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// LocalOnly 只允许本机回环地址访问；直接看 TCP 对端地址，不信任 X-Forwarded-For。
+func LocalOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil || !ip.IsLoopback() || c.GetHeader("X-Forwarded-For") != "" {
+			model.Fail(c, http.StatusForbidden, model.CodeForbidden, "only available from localhost")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"promthus/internal/config"
+
+	"github.com/google/uuid"
+)
+
+/*
+访问 Token：JWT（HS256），header 带 kid，claims 含 sub(user_uuid)、jti、role、iat、exp、ver。
+  - 可同时配置多把签名密钥（AUTH_TOKEN_KEYS），新 Token 用 active kid 签发，其余仅用于校验；
+    轮换时先加入新密钥并切换 active，旧密钥保留到其签发的 Token 全部过期后再移除，用户无感知；
+  - ParseToken 只做签名与时间校验，不查库：过期、未来签发、未知 kid 的 Token 在 Auth() 查 session 之前即被拒绝。
+*/
+
+const (
+	tokenVersion = 1
+	tokenAlg     = "HS256"
+	// iat 允许的时钟偏差（多实例部署）
+	tokenClockSkew = 60 * time.Second
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+type TokenClaims struct {
+	UserUUID  uuid.UUID
+	JTI       uuid.UUID
+	Role      string
+	KeyID     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type tokenPayload struct {
+	Sub  string `json:"sub"`
+	JTI  string `json:"jti"`
+	Role string `json:"role"`
+	Iat  int64  `json:"iat"`
+	Exp  int64  `json:"exp"`
+	Ver  int    `json:"ver"`
+}
+
+type tokenKeyring struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+var tokenKeys tokenKeyring
+
+// SetTokenKeys 配置签名密钥；activeKID 为空时使用第一把。
+func SetTokenKeys(keys []config.TokenKey, activeKID string) error {
+	if len(keys) == 0 {
+		return errors.New("no token signing keys configured")
+	}
+	m := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if k.ID == "" || k.Secret == "" {
+			return errors.New("token key id and secret must not be empty")
+		}
+		if _, dup := m[k.ID]; dup {
+			return fmt.Errorf("duplicate token key id %q", k.ID)
+		}
+		m[k.ID] = []byte(k.Secret)
+	}
+	if activeKID == "" {
+		activeKID = keys[0].ID
+	}
+	if _, ok := m[activeKID]; !ok {
+		return fmt.Errorf("active token key id %q not configured", activeKID)
+	}
+
+	tokenKeys.mu.Lock()
+	defer tokenKeys.mu.Unlock()
+	tokenKeys.keys, tokenKeys.active = m, activeKID
+	return nil
+}
+
+func GenerateToken(userUUID, jti uuid.UUID, role string, expiresAt time.Time) (string, error) {
+	tokenKeys.mu.RLock()
+	kid, secret := tokenKeys.active, tokenKeys.keys[tokenKeys.active]
+	tokenKeys.mu.RUnlock()
+	if secret == nil {
+		return "", errors.New("token signing key not configured")
+	}
+
+	header, err := json.Marshal(tokenHeader{Alg: tokenAlg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(tokenPayload{
+		Sub:  userUUID.String(),
+		JTI:  jti.String(),
+		Role: role,
+		Iat:  time.Now().Unix(),
+		Exp:  expiresAt.Unix(),
+		Ver:  tokenVersion,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signHS256(signingInput, secret)), nil
+}
+
+func ParseToken(tokenStr string) (*TokenClaims, error) {
+	parts := strings.Split(tokenStr, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != tokenAlg {
+		return nil, ErrInvalidToken
+	}
+	tokenKeys.mu.RLock()
+	secret := tokenKeys.keys[header.Kid]
+	tokenKeys.mu.RUnlock()
+	if secret == nil {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signHS256(parts[0]+"."+parts[1], secret)) {
+		return nil, ErrInvalidToken
+	}
+
+	var payload tokenPayload
+	if err := decodeSegment(parts[1], &payload); err != nil || payload.Ver != tokenVersion {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	issuedAt, expiresAt := time.Unix(payload.Iat, 0), time.Unix(payload.Exp, 0)
+	if payload.Exp == 0 || !now.Before(expiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if issuedAt.After(now.Add(tokenClockSkew)) {
+		return nil, ErrInvalidToken
+	}
+
+	userUUID, err := uuid.Parse(payload.Sub)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	jti, err := uuid.Parse(payload.JTI)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &TokenClaims{
+		UserUUID:  userUUID,
+		JTI:       jti,
+		Role:      payload.Role,
+		KeyID:     header.Kid,
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func signHS256(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func setTestTokenKeys(t *testing.T, active string, keys ...config.TokenKey) {
+	t.Helper()
+	if err := SetTokenKeys(keys, active); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	keyK1 = config.TokenKey{ID: "k1", Secret: "secret-one"}
+	keyK2 = config.TokenKey{ID: "k2", Secret: "secret-two"}
+)
+
+// resign 改写 payload 后用 secret 重新签名，模拟持有密钥的签发方
+func resign(t *testing.T, token string, mutate func(p *tokenPayload), secret string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	var p tokenPayload
+	if err := decodeSegment(parts[1], &p); err != nil {
+		t.Fatal(err)
+	}
+	mutate(&p)
+	raw, _ := json.Marshal(p)
+	input := parts[0] + "." + base64.RawURLEncoding.EncodeToString(raw)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signHS256(input, []byte(secret)))
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	setTestTokenKeys(t, "", keyK1)
+	userUUID, jti := uuid.New(), uuid.New()
+	exp := time.Now().Add(15 * time.Minute).Truncate(time.Second)
+
+	token, err := GenerateToken(userUUID, jti, "admin", exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserUUID != userUUID || claims.JTI != jti || claims.Role != "admin" ||
+		claims.KeyID != "k1" || !claims.ExpiresAt.Equal(exp) || time.Since(claims.IssuedAt) > time.Minute {
+		t.Fatalf("claims = %+v", claims)
+	}
+}
+
+func TestParseTokenRejects(t *testing.T) {
+	setTestTokenKeys(t, "", keyK1)
+	good, _ := GenerateToken(uuid.New(), uuid.New(), "user", time.Now().Add(time.Hour))
+	parts := strings.Split(good, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"k1"}`))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", resign(t, good, func(p *tokenPayload) { p.Exp = time.Now().Add(-time.Second).Unix() }, keyK1.Secret), ErrTokenExpired},
+		{"no exp", resign(t, good, func(p *tokenPayload) { p.Exp = 0 }, keyK1.Secret), ErrTokenExpired},
+		{"issued in the future", resign(t, good, func(p *tokenPayload) { p.Iat = time.Now().Add(time.Hour).Unix() }, keyK1.Secret), ErrInvalidToken},
+		{"old version", resign(t, good, func(p *tokenPayload) { p.Ver = 0 }, keyK1.Secret), ErrInvalidToken},
+		{"bad subject", resign(t, good, func(p *tokenPayload) { p.Sub = "alice" }, keyK1.Secret), ErrInvalidToken},
+		{"wrong secret", resign(t, good, func(p *tokenPayload) {}, "guess"), ErrInvalidToken},
+		{"role escalated", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"role":"admin"}`)) + "." + parts[2], ErrInvalidToken},
+		{"alg none", noneHeader + "." + parts[1] + ".", ErrInvalidToken},
+		{"legacy uuid:jti", uuid.NewString() + ":" + uuid.NewString() + ":sig", ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseToken(tt.token); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// 轮换：新 kid 上线后旧 Token 仍有效，旧 kid 移除后旧 Token 失效
+func TestTokenKeyRotation(t *testing.T) {
+	setTestTokenKeys(t, "k1", keyK1)
+	old, _ := GenerateToken(uuid.New(), uuid.New(), "user", time.Now().Add(time.Hour))
+
+	setTestTokenKeys(t, "k2", keyK1, keyK2)
+	fresh, _ := GenerateToken(uuid.New(), uuid.New(), "user", time.Now().Add(time.Hour))
+	if c, err := ParseToken(fresh); err != nil || c.KeyID != "k2" {
+		t.Fatalf("new token: %+v, %v", c, err)
+	}
+	if c, err := ParseToken(old); err != nil || c.KeyID != "k1" {
+		t.Fatalf("old token during rotation: %+v, %v", c, err)
+	}
+
+	setTestTokenKeys(t, "k2", keyK2)
+	if _, err := ParseToken(old); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("old token after k1 removed: err = %v", err)
+	}
+	if _, err := ParseToken(fresh); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetTokenKeysValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []config.TokenKey
+		active string
+	}{
+		{"none", nil, ""},
+		{"empty secret", []config.TokenKey{{ID: "k1"}}, ""},
+		{"empty id", []config.TokenKey{{Secret: "s"}}, ""},
+		{"duplicate", []config.TokenKey{keyK1, keyK1}, ""},
+		{"unknown active", []config.TokenKey{keyK1}, "k9"},
+	}
+	for _, tt := range tests {
+		if err := SetTokenKeys(tt.keys, tt.active); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+// 过期 Token 在查库之前被拒绝：repository.DB 未初始化，一旦查库会 panic
+func TestAuthRejectsExpiredTokenWithoutDB(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setTestTokenKeys(t, "", keyK1)
+	good, _ := GenerateToken(uuid.New(), uuid.New(), "user", time.Now().Add(time.Hour))
+	expired := resign(t, good, func(p *tokenPayload) { p.Exp = time.Now().Add(-time.Minute).Unix() }, keyK1.Secret)
+
+	r := gin.New()
+	r.GET("/api/me", Auth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	forged := resign(t, good, func(p *tokenPayload) {}, "guess")
+	for _, token := range []string{expired, forged} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+
+		var resp model.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnauthorized || resp.Code != model.CodeSessionExpired {
+			t.Fatalf("status %d code %d, want 401/%d", w.Code, resp.Code, model.CodeSessionExpired)
+		}
+	}
+}
@@ -11,6 +11,24 @@ import (
 // DeviceTypeLock 当前业务仅锁具，后续扩展传感器等时在 device_types 注册
 const DeviceTypeLock = "lock"
 
+// 锁具 MAC 算法：新固件用 AES-128-CMAC（MCU 有 AES 硬件），老固件仍为 HMAC-SHA256 截断
+const (
+	MACAlgorithmCMAC = "aes-cmac"
+	MACAlgorithmHMAC = "hmac-sha256"
+)
+
+// User.AuthSource
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+)
+
+// RoleService 服务账号背后的用户角色，只能经 API Key 访问，不能登录
+const RoleService = "service"
+
+// AlertSubjectAccount 账户类告警（login_anomaly、account_locked）不关联设备，device_id 为空、user_id 指向账户
+const AlertSubjectAccount = "account"
+
 // ==================== 用户表 app.users ====================
 
 type User struct {
@@ -23,46 +41,164 @@ type User struct {
 	Department   sql.NullString `gorm:"type:varchar(100)" json:"department"`
 	Role         string         `gorm:"type:varchar(20);not null" json:"role"`
 	Status       int16          `gorm:"type:smallint;not null;default:1" json:"status"`
-	CreatedAt    time.Time      `gorm:"not null;default:now()" json:"created_at"`
-	UpdatedAt    time.Time      `gorm:"not null;default:now()" json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	// 登录后端：local 本地密码；ldap 由目录管理密码，姓名/部门/角色在每次登录时同步
+	AuthSource string  `gorm:"type:varchar(20);not null;default:local" json:"auth_source"`
+	ExternalID *string `gorm:"type:varchar(255)" json:"-"`
+	// 管理员创建/重置的随机密码须在下次登录后先修改
+	MustChangePassword bool       `gorm:"not null;default:false" json:"must_change_password"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	// 连续登录失败计数与渐进式锁定
+	FailedLoginCount int            `gorm:"not null;default:0" json:"-"`
+	LockoutCount     int            `gorm:"not null;default:0" json:"-"`
+	LockedUntil      *time.Time     `json:"locked_until,omitempty"`
+	CreatedAt        time.Time      `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"not null;default:now()" json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+	// 后台管辖范围，仅用户列表填充
+	AdminScope *AdminScope `gorm:"-" json:"admin_scope,omitempty"`
 }
 
 func (User) TableName() string { return "app.users" }
 
+// PasswordHistory 最近设置过的密码哈希，用于禁止复用
+type PasswordHistory struct {
+	ID           int64     `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID       int64     `gorm:"not null" json:"-"`
+	PasswordHash string    `gorm:"type:varchar(100);not null" json:"-"`
+	CreatedAt    time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (PasswordHistory) TableName() string { return "app.password_history" }
+
+// ==================== 角色 app.roles / app.role_permissions ====================
+
+// Role 后台角色；Permissions 由 app.role_permissions 填充，admin 为 ["*"]
+type Role struct {
+	Name        string    `gorm:"type:varchar(20);primaryKey" json:"name"`
+	DisplayName string    `gorm:"type:varchar(50);not null" json:"display_name"`
+	Description string    `gorm:"type:varchar(200)" json:"description"`
+	Builtin     bool      `gorm:"not null;default:false" json:"builtin"`
+	Permissions []string  `gorm:"-" json:"permissions"`
+	CreatedAt   time.Time `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+func (Role) TableName() string { return "app.roles" }
+
+type RolePermission struct {
+	Role       string `gorm:"type:varchar(20);primaryKey"`
+	Permission string `gorm:"type:varchar(50);primaryKey"`
+}
+
+func (RolePermission) TableName() string { return "app.role_permissions" }
+
+// ==================== 管辖范围 app.admin_scopes ====================
+
+// AdminScopeEntry.Kind
+const (
+	ScopeKindPipelineTag = "pipeline_tag"
+	ScopeKindDepartment  = "department"
+)
+
+type AdminScopeEntry struct {
+	UserID    int64     `gorm:"primaryKey" json:"-"`
+	Kind      string    `gorm:"type:varchar(20);primaryKey" json:"kind"`
+	Value     string    `gorm:"type:varchar(100);primaryKey" json:"value"`
+	CreatedBy int64     `gorm:"not null" json:"-"`
+	CreatedAt time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (AdminScopeEntry) TableName() string { return "app.admin_scopes" }
+
+// AdminScope 按 Kind 聚合的管辖范围；某一维度为空表示该维度不受限
+type AdminScope struct {
+	PipelineTags []string `json:"pipeline_tags"`
+	Departments  []string `json:"departments"`
+}
+
+// Unrestricted 两个维度都不受限（含 nil）
+func (s *AdminScope) Unrestricted() bool {
+	return s == nil || (len(s.PipelineTags) == 0 && len(s.Departments) == 0)
+}
+
+// ==================== 服务账号 app.service_accounts / app.api_keys ====================
+
+type ServiceAccount struct {
+	ID          int64     `gorm:"primaryKey;autoIncrement" json:"-"`
+	UUID        uuid.UUID `gorm:"type:uuid;not null;default:gen_random_uuid()" json:"uuid"`
+	UserID      int64     `gorm:"not null" json:"user_id"` // 背后的 role=service 用户，作为操作日志的 operator
+	Name        string    `gorm:"type:varchar(50);not null" json:"name"`
+	Description string    `gorm:"type:varchar(200)" json:"description"`
+	Status      int16     `gorm:"type:smallint;not null;default:1" json:"status"`
+	CreatedBy   int64     `gorm:"not null" json:"created_by"`
+	CreatedAt   time.Time `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+func (ServiceAccount) TableName() string { return "app.service_accounts" }
+
+type APIKey struct {
+	ID               int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	ServiceAccountID int64      `gorm:"not null" json:"-"`
+	Name             string     `gorm:"type:varchar(50);not null" json:"name"`
+	KeyPrefix        string     `gorm:"type:varchar(16);not null" json:"key_prefix"`
+	KeyHash          []byte     `gorm:"type:bytea;not null" json:"-"`
+	Scopes           string     `gorm:"type:varchar(500);not null" json:"scopes"` // 空格分隔
+	ExpiresAt        *time.Time `json:"expires_at"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+	LastUsedIP       *string    `gorm:"type:inet" json:"last_used_ip"`
+	CreatedBy        int64      `gorm:"not null" json:"created_by"`
+	CreatedAt        time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+	RevokedBy        *int64     `json:"revoked_by,omitempty"`
+}
+
+func (APIKey) TableName() string { return "app.api_keys" }
+
 // ==================== 会话表 app.sessions ====================
 
+// JTI 为当前访问 Token 的 ID，每次刷新更换；FamilyID 在整个登录周期内不变。
 type Session struct {
-	ID        int64     `gorm:"primaryKey;autoIncrement" json:"-"`
-	JTI       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_sessions_jti" json:"jti"`
-	UserID    int64     `gorm:"not null;index:idx_sessions_user_id" json:"user_id"`
-	Role      string    `gorm:"type:varchar(20);not null" json:"role"`
-	ExpiresAt time.Time `gorm:"not null;index:idx_sessions_expires" json:"expires_at"`
-	CreatedAt time.Time `gorm:"not null;default:now()" json:"created_at"`
-	UserAgent string    `gorm:"type:varchar(200)" json:"user_agent"`
-	IPAddress string    `gorm:"type:varchar(45)" json:"ip_address"`
+	ID                int64     `gorm:"primaryKey;autoIncrement" json:"-"`
+	JTI               uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_sessions_jti" json:"jti"`
+	FamilyID          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_sessions_family_id" json:"family_id"`
+	UserID            int64     `gorm:"not null;index:idx_sessions_user_id" json:"user_id"`
+	Role              string    `gorm:"type:varchar(20);not null" json:"role"`
+	RefreshTokenHash  []byte    `gorm:"type:bytea" json:"-"`
+	RefreshGeneration int       `gorm:"not null;default:0" json:"-"`
+	ExpiresAt         time.Time `gorm:"not null;index:idx_sessions_expires" json:"expires_at"`
+	LastUsedAt        time.Time `gorm:"not null;default:now()" json:"last_used_at"`
+	CreatedAt         time.Time `gorm:"not null;default:now()" json:"created_at"`
+	UserAgent         string    `gorm:"type:varchar(200)" json:"user_agent"`
+	IPAddress         string    `gorm:"type:varchar(45)" json:"ip_address"`
 }
 
 func (Session) TableName() string { return "app.sessions" }
 
 // ==================== 锁具设备表 app.devices_lock ====================
 
+// PendingKey* 为轮换中的新密钥：锁具通过回执确认后替换 KeyEncrypted/KeyVersion，旧密钥随之作废
 type Device struct {
-	ID            int64          `gorm:"primaryKey;autoIncrement" json:"-"`
-	DeviceID      string         `gorm:"type:varchar(32);not null" json:"device_id"`
-	Name          string         `gorm:"type:varchar(100);not null" json:"name"`
-	LocationText  string         `gorm:"type:text;not null" json:"location_text"`
-	Longitude     *float64       `gorm:"type:numeric(10,7)" json:"longitude,omitempty"`
-	Latitude      *float64       `gorm:"type:numeric(10,7)" json:"latitude,omitempty"`
-	PipelineTag   sql.NullString `gorm:"type:varchar(50)" json:"pipeline_tag"`
-	RiskLevel     int16          `gorm:"type:smallint;not null;default:1" json:"risk_level"`
-	KeyEncrypted  []byte         `gorm:"type:bytea;not null" json:"-"`
-	KeyVersion    int16          `gorm:"type:smallint;not null;default:1" json:"key_version"`
-	Status        int16          `gorm:"type:smallint;not null;default:1" json:"status"`
-	LastActiveAt  *time.Time     `gorm:"" json:"last_active_at,omitempty"`
-	CreatedAt     time.Time      `gorm:"not null;default:now()" json:"created_at"`
-	UpdatedAt     time.Time      `gorm:"not null;default:now()" json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                  int64          `gorm:"primaryKey;autoIncrement" json:"-"`
+	DeviceID            string         `gorm:"type:varchar(32);not null" json:"device_id"`
+	Name                string         `gorm:"type:varchar(100);not null" json:"name"`
+	LocationText        string         `gorm:"type:text;not null" json:"location_text"`
+	Longitude           *float64       `gorm:"type:numeric(10,7)" json:"longitude,omitempty"`
+	Latitude            *float64       `gorm:"type:numeric(10,7)" json:"latitude,omitempty"`
+	PipelineTag         sql.NullString `gorm:"type:varchar(50)" json:"pipeline_tag"`
+	RiskLevel           int16          `gorm:"type:smallint;not null;default:1" json:"risk_level"`
+	KeyEncrypted        []byte         `gorm:"type:bytea;not null" json:"-"`
+	KeyVersion          int16          `gorm:"type:smallint;not null;default:1" json:"key_version"`
+	PendingKeyEncrypted []byte         `gorm:"type:bytea" json:"-"`
+	PendingKeyVersion   *int16         `gorm:"type:smallint" json:"pending_key_version,omitempty"`
+	KeyRotatedAt        *time.Time     `gorm:"" json:"key_rotated_at,omitempty"`
+	MACAlgorithm        string         `gorm:"column:mac_algorithm;type:varchar(16);not null;default:aes-cmac" json:"mac_algorithm"`
+	ReceiptCounter      int64          `gorm:"not null;default:0" json:"-"`
+	Status              int16          `gorm:"type:smallint;not null;default:1" json:"status"`
+	LastActiveAt        *time.Time     `gorm:"" json:"last_active_at,omitempty"`
+	CreatedAt           time.Time      `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt           time.Time      `gorm:"not null;default:now()" json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (Device) TableName() string { return "app.devices_lock" }
@@ -70,17 +206,18 @@ func (Device) TableName() string { return "app.devices_lock" }
 // ==================== 权限授权表 app.permissions (device_type + device_id) ====================
 
 type Permission struct {
-	ID         int64      `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID     int64      `gorm:"not null;index:idx_permissions_user_id" json:"user_id"`
-	DeviceType string     `gorm:"type:varchar(32);not null;index:idx_permissions_device" json:"device_type"`
-	DeviceID   string     `gorm:"type:varchar(32);not null;index:idx_permissions_device" json:"device_id"`
-	GrantedBy  int64      `gorm:"not null" json:"granted_by"`
-	ValidFrom  time.Time  `gorm:"not null" json:"valid_from"`
-	ValidUntil *time.Time `gorm:"" json:"valid_until,omitempty"`
-	Status     int16      `gorm:"type:smallint;not null;default:1" json:"status"`
-	RevokedBy  *int64     `gorm:"" json:"revoked_by,omitempty"`
-	RevokedAt  *time.Time `gorm:"" json:"revoked_at,omitempty"`
-	CreatedAt  time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	ID         int64               `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int64               `gorm:"not null;index:idx_permissions_user_id" json:"user_id"`
+	DeviceType string              `gorm:"type:varchar(32);not null;index:idx_permissions_device" json:"device_type"`
+	DeviceID   string              `gorm:"type:varchar(32);not null;index:idx_permissions_device" json:"device_id"`
+	GrantedBy  int64               `gorm:"not null" json:"granted_by"`
+	ValidFrom  time.Time           `gorm:"not null" json:"valid_from"`
+	ValidUntil *time.Time          `gorm:"" json:"valid_until,omitempty"`
+	Schedule   *PermissionSchedule `gorm:"type:jsonb" json:"schedule,omitempty"`
+	Status     int16               `gorm:"type:smallint;not null;default:1" json:"status"`
+	RevokedBy  *int64              `gorm:"" json:"revoked_by,omitempty"`
+	RevokedAt  *time.Time          `gorm:"" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time           `gorm:"not null;default:now()" json:"created_at"`
 
 	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
@@ -149,6 +286,22 @@ type OperationLog struct {
 
 func (OperationLog) TableName() string { return "log.operation_logs" }
 
+// ==================== 登录审计表 log.login_logs ====================
+
+type LoginLog struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     *int64    `gorm:"" json:"user_id,omitempty"`
+	Phone      string    `gorm:"type:varchar(20);not null" json:"phone"`
+	Action     string    `gorm:"type:varchar(20);not null" json:"action"`
+	Result     string    `gorm:"type:varchar(30);not null" json:"result"`
+	ClientIP   string    `gorm:"type:varchar(45);not null" json:"client_ip"`
+	UserAgent  string    `gorm:"type:varchar(200)" json:"user_agent"`
+	Extra      JSON      `gorm:"type:jsonb" json:"extra,omitempty"`
+	OccurredAt time.Time `gorm:"not null" json:"occurred_at"`
+}
+
+func (LoginLog) TableName() string { return "log.login_logs" }
+
 // ==================== 连续失败计数表 app.device_fail_counts (device_type, device_id) ====================
 
 type DeviceFailCount struct {
@@ -171,3 +324,110 @@ type IPBlock struct {
 }
 
 func (IPBlock) TableName() string { return "app.ip_blocks" }
+
+// ==================== 挑战随机数表 app.challenge_nonces (device_type, device_id, challenge_c) ====================
+
+type ChallengeNonce struct {
+	DeviceType string    `gorm:"type:varchar(32);primaryKey" json:"device_type"`
+	DeviceID   string    `gorm:"type:varchar(32);primaryKey" json:"device_id"`
+	ChallengeC string    `gorm:"type:varchar(16);primaryKey" json:"challenge_c"`
+	ExpiresAt  time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (ChallengeNonce) TableName() string { return "app.challenge_nonces" }
+
+// ==================== 开锁会话表 app.unlock_sessions ====================
+
+// UnlockSession 由 Challenge 签发，Report 时凭 ID 一次性核销，绑定用户与设备。
+type UnlockSession struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     int64     `gorm:"not null" json:"user_id"`
+	DeviceType string    `gorm:"type:varchar(32);not null" json:"device_type"`
+	DeviceID   string    `gorm:"type:varchar(32);not null" json:"device_id"`
+	ChallengeC string    `gorm:"type:varchar(16);not null" json:"challenge_c"`
+	ExpiresAt  time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (UnlockSession) TableName() string { return "app.unlock_sessions" }
+
+// ==================== 设备临时封禁表 app.device_blocks (device_type, device_id) ====================
+
+type DeviceBlock struct {
+	DeviceType string    `gorm:"type:varchar(32);primaryKey" json:"device_type"`
+	DeviceID   string    `gorm:"type:varchar(32);primaryKey" json:"device_id"`
+	BlockedAt  time.Time `gorm:"not null;default:now()" json:"blocked_at"`
+	ExpiresAt  time.Time `gorm:"not null" json:"expires_at"`
+	Reason     string    `gorm:"type:varchar(100)" json:"reason"`
+}
+
+func (DeviceBlock) TableName() string { return "app.device_blocks" }
+
+// ==================== 系统设置表 app.system_settings ====================
+
+type SystemSetting struct {
+	Key       string    `gorm:"type:varchar(100);primaryKey" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"value"`
+	UpdatedAt time.Time `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+func (SystemSetting) TableName() string { return "app.system_settings" }
+
+// ==================== 双因素认证 app.user_mfa / app.mfa_recovery_codes / app.mfa_challenges ====================
+
+type UserMFA struct {
+	UserID          int64      `gorm:"primaryKey" json:"-"`
+	SecretEncrypted []byte     `gorm:"type:bytea;not null" json:"-"`
+	Enabled         bool       `gorm:"not null;default:false" json:"enabled"`
+	LastUsedStep    int64      `gorm:"not null;default:0" json:"-"`
+	CreatedAt       time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	ConfirmedAt     *time.Time `json:"confirmed_at"`
+}
+
+func (UserMFA) TableName() string { return "app.user_mfa" }
+
+type MFARecoveryCode struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID    int64      `gorm:"not null" json:"-"`
+	CodeHash  string     `gorm:"type:varchar(64);not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (MFARecoveryCode) TableName() string { return "app.mfa_recovery_codes" }
+
+type MFAChallenge struct {
+	Token     uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	UserID    int64     `gorm:"not null" json:"-"`
+	Attempts  int16     `gorm:"not null;default:0" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time `gorm:"not null;default:now()" json:"created_at"`
+	IPAddress string    `gorm:"type:varchar(45)" json:"-"`
+}
+
+func (MFAChallenge) TableName() string { return "app.mfa_challenges" }
+
+// ==================== OIDC 单点登录 app.user_identities / app.oidc_states ====================
+
+type UserIdentity struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID      int64      `gorm:"not null" json:"-"`
+	Issuer      string     `gorm:"type:varchar(255);not null" json:"issuer"`
+	Subject     string     `gorm:"type:varchar(255);not null" json:"subject"`
+	CreatedAt   time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+func (UserIdentity) TableName() string { return "app.user_identities" }
+
+type OIDCState struct {
+	State        string    `gorm:"type:varchar(64);primaryKey" json:"-"` // SHA-256(state) hex
+	CodeVerifier string    `gorm:"type:varchar(128);not null" json:"-"`
+	Nonce        string    `gorm:"type:varchar(64);not null" json:"-"`
+	IPAddress    string    `gorm:"type:varchar(45)" json:"-"`
+	ExpiresAt    time.Time `gorm:"not null" json:"-"`
+	CreatedAt    time.Time `gorm:"not null;default:now()" json:"-"`
+}
+
+func (OIDCState) TableName() string { return "app.oidc_states" }
@@ -57,20 +57,30 @@ const (
 	CodeAuthFailed      = 1001
 	CodeAccountDisabled = 1002
 	CodeSessionExpired  = 1003
+	// 管理员重置过密码，须先调用 POST /api/auth/password
+	CodePasswordChangeRequired = 1004
+	// 连续登录失败被临时锁定
+	CodeAccountLocked = 1005
 
 	// 2xxx - Authorization
 	CodeNoPermission = 2001
 	CodeForbidden    = 2002
+	// 授权有效但当前不在 schedule 时段内
+	CodeOutsideSchedule = 2003
 
 	// 3xxx - Lock operations
-	CodeDeviceNotFound    = 3001
-	CodeDeviceUnavailable = 3002
-	CodeTooManyRequests   = 3003
+	CodeDeviceNotFound       = 3001
+	CodeDeviceUnavailable    = 3002
+	CodeTooManyRequests      = 3003
+	CodeUnlockSessionInvalid = 3004
 
 	// 4xxx - Validation
 	CodeParamError     = 4001
 	CodeRequestExpired = 4002
+	CodeReplayDetected = 4003
 
 	// 5xxx - Internal
 	CodeInternalError = 5001
+	// KMS 处于 Shamir 密封状态，等待解封
+	CodeServiceSealed = 5002
 )
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+PermissionSchedule 权限的周期性时段规则（存 app.permissions.schedule JSONB）：
+  - Timezone：IANA 时区名，如 "Asia/Shanghai"；为空按 Asia/Shanghai；
+  - Rules：任一条命中即允许；为空表示不限时段（仅受 valid_from/valid_until 约束）。
+
+ScheduleRule 中 Weekdays 采用 ISO 编号（1=周一 … 7=周日），Start/End 为 "HH:MM"。
+End 早于 Start 表示跨零点（如 22:00~06:00），此时 Weekdays 指开始那一天。
+*/
+type PermissionSchedule struct {
+	Timezone string         `json:"timezone"`
+	Rules    []ScheduleRule `json:"rules"`
+}
+
+type ScheduleRule struct {
+	Weekdays []int  `json:"weekdays"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+const defaultScheduleTimezone = "Asia/Shanghai"
+
+func (s PermissionSchedule) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *PermissionSchedule) Scan(value interface{}) error {
+	if value == nil {
+		*s = PermissionSchedule{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed for PermissionSchedule")
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+// Validate 校验时区、星期与时间格式，授权写库前调用。
+func (s *PermissionSchedule) Validate() error {
+	if _, err := s.location(); err != nil {
+		return fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+	for i, r := range s.Rules {
+		if len(r.Weekdays) == 0 {
+			return fmt.Errorf("rule %d: weekdays is empty", i)
+		}
+		for _, d := range r.Weekdays {
+			if d < 1 || d > 7 {
+				return fmt.Errorf("rule %d: weekday must be 1-7 (Mon-Sun)", i)
+			}
+		}
+		start, err := parseClock(r.Start)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid start %q", i, r.Start)
+		}
+		end, err := parseClock(r.End)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid end %q", i, r.End)
+		}
+		if start == end {
+			return fmt.Errorf("rule %d: start equals end", i)
+		}
+	}
+	return nil
+}
+
+// Allows 判断时刻 t 是否落在任一规则时段内；schedule 为 nil 或无规则时恒为 true。
+func (s *PermissionSchedule) Allows(t time.Time) bool {
+	if s == nil || len(s.Rules) == 0 {
+		return true
+	}
+	loc, err := s.location()
+	if err != nil {
+		return false
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := isoWeekday(local.Weekday())
+	yesterday := isoWeekday(local.AddDate(0, 0, -1).Weekday())
+
+	for _, r := range s.Rules {
+		start, err1 := parseClock(r.Start)
+		end, err2 := parseClock(r.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < end {
+			if r.hasWeekday(today) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		// 跨零点：开始那天的 start 之后，或次日 end 之前
+		if r.hasWeekday(today) && minute >= start {
+			return true
+		}
+		if r.hasWeekday(yesterday) && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PermissionSchedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.LoadLocation(defaultScheduleTimezone)
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+func (r ScheduleRule) hasWeekday(d int) bool {
+	for _, w := range r.Weekdays {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock 把 "HH:MM" 转为当天分钟数，"24:00" 视为一天结束。
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil || len(v) != 5 {
+		return 0, errors.New("invalid clock")
+	}
+	if h == 24 && m == 0 {
+		return 24 * 60, nil
+	}
+	if h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, errors.New("invalid clock")
+	}
+	return h*60 + m, nil
+}
+
+func isoWeekday(d time.Weekday) int {
+	if d == time.Sunday {
+		return 7
+	}
+	return int(d)
+}
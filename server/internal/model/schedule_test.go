@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermissionScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule PermissionSchedule
+		wantErr  bool
+	}{
+		{"weekday shift", PermissionSchedule{Timezone: "Asia/Shanghai",
+			Rules: []ScheduleRule{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "08:00", End: "18:00"}}}, false},
+		{"default timezone", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{7}, Start: "22:00", End: "06:00"}}}, false},
+		{"until midnight", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{6}, Start: "12:00", End: "24:00"}}}, false},
+		{"unknown timezone", PermissionSchedule{Timezone: "Mars/Olympus"}, true},
+		{"empty weekdays", PermissionSchedule{Rules: []ScheduleRule{{Start: "08:00", End: "18:00"}}}, true},
+		{"weekday zero", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{0}, Start: "08:00", End: "18:00"}}}, true},
+		{"weekday eight", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{8}, Start: "08:00", End: "18:00"}}}, true},
+		{"bad start", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{1}, Start: "8:00", End: "18:00"}}}, true},
+		{"bad end", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{1}, Start: "08:00", End: "18:60"}}}, true},
+		{"empty window", PermissionSchedule{Rules: []ScheduleRule{{Weekdays: []int{1}, Start: "08:00", End: "08:00"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schedule.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPermissionScheduleAllows(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata unavailable:", err)
+	}
+	// day 为 ISO 星期，2026-10-12 是周一
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, 11+day, hour, minute, 0, 0, shanghai)
+	}
+	shift := &PermissionSchedule{Timezone: "Asia/Shanghai",
+		Rules: []ScheduleRule{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "08:00", End: "18:00"}}}
+	night := &PermissionSchedule{Timezone: "Asia/Shanghai",
+		Rules: []ScheduleRule{{Weekdays: []int{5}, Start: "22:00", End: "06:00"}}}
+
+	tests := []struct {
+		name     string
+		schedule *PermissionSchedule
+		t        time.Time
+		want     bool
+	}{
+		{"nil schedule", nil, at(1, 3, 0), true},
+		{"no rules", &PermissionSchedule{}, at(1, 3, 0), true},
+		{"monday in shift", shift, at(1, 8, 0), true},
+		{"friday before end", shift, at(5, 17, 59), true},
+		{"end is exclusive", shift, at(5, 18, 0), false},
+		{"before start", shift, at(3, 7, 59), false},
+		{"saturday", shift, at(6, 10, 0), false},
+		{"utc instant converted", shift, time.Date(2026, 10, 12, 1, 0, 0, 0, time.UTC), true}, // 北京时间 09:00
+		{"overnight start day", night, at(5, 23, 30), true},
+		{"overnight next morning", night, at(6, 5, 59), true},
+		{"overnight ends", night, at(6, 6, 0), false},
+		{"overnight wrong day", night, at(4, 23, 30), false},
+		{"overnight before start", night, at(5, 21, 59), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Allows(tt.t); got != tt.want {
+				t.Fatalf("Allows(%s) = %v, want %v", tt.t.In(shanghai).Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
@@ -14,11 +14,12 @@ import (
 )
 
 type AuditConsumer struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	buffer  []model.AuditLog
-	mu      sync.Mutex
-	done    chan struct{}
+	conn        *amqp.Connection
+	channel     *amqp.Channel
+	buffer      []model.AuditLog
+	loginBuffer []model.LoginLog
+	mu          sync.Mutex
+	done        chan struct{}
 }
 
 func NewAuditConsumer(url string, workerCount int) (*AuditConsumer, error) {
@@ -38,10 +39,11 @@ func NewAuditConsumer(url string, workerCount int) (*AuditConsumer, error) {
 	}
 
 	consumer := &AuditConsumer{
-		conn:    conn,
-		channel: ch,
-		buffer:  make([]model.AuditLog, 0, 100),
-		done:    make(chan struct{}),
+		conn:        conn,
+		channel:     ch,
+		buffer:      make([]model.AuditLog, 0, 100),
+		loginBuffer: make([]model.LoginLog, 0, 100),
+		done:        make(chan struct{}),
 	}
 
 	msgs, err := ch.Consume("audit.queue", "", false, false, false, false, nil)
@@ -49,9 +51,19 @@ func NewAuditConsumer(url string, workerCount int) (*AuditConsumer, error) {
 		return nil, err
 	}
 
+	// 登录审计量小，单 worker 即可；队列可能先于 Publisher 声明
+	if _, err := ch.QueueDeclare("login_audit.queue", true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	loginMsgs, err := ch.Consume("login_audit.queue", "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	for i := 0; i < workerCount; i++ {
 		go consumer.worker(msgs)
 	}
+	go consumer.loginWorker(loginMsgs)
 
 	go consumer.flushLoop()
 
@@ -98,6 +110,48 @@ func (c *AuditConsumer) worker(msgs <-chan amqp.Delivery) {
 	}
 }
 
+func (c *AuditConsumer) loginWorker(msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		var audit LoginAuditMessage
+		if err := json.Unmarshal(msg.Body, &audit); err != nil {
+			logger.Error("failed to unmarshal login audit message", zap.Error(err))
+			_ = msg.Nack(false, false)
+			continue
+		}
+
+		c.mu.Lock()
+		c.loginBuffer = append(c.loginBuffer, LoginLogFromMessage(&audit))
+		shouldFlush := len(c.loginBuffer) >= 100
+		c.mu.Unlock()
+
+		if shouldFlush {
+			c.flush()
+		}
+
+		_ = msg.Ack(false)
+	}
+}
+
+// LoginLogFromMessage 供 MQ 不可用时服务端直接落库复用
+func LoginLogFromMessage(msg *LoginAuditMessage) model.LoginLog {
+	log := model.LoginLog{
+		Phone:      msg.Phone,
+		Action:     msg.Action,
+		Result:     msg.Result,
+		ClientIP:   msg.ClientIP,
+		UserAgent:  msg.UserAgent,
+		OccurredAt: time.UnixMilli(msg.OccurredAt),
+	}
+	if msg.UserID != 0 {
+		userID := msg.UserID
+		log.UserID = &userID
+	}
+	if msg.Extra != nil {
+		log.Extra = model.JSON(msg.Extra)
+	}
+	return log
+}
+
 func (c *AuditConsumer) flushLoop() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -115,20 +169,34 @@ func (c *AuditConsumer) flushLoop() {
 
 func (c *AuditConsumer) flush() {
 	c.mu.Lock()
-	if len(c.buffer) == 0 {
-		c.mu.Unlock()
-		return
-	}
 	batch := c.buffer
-	c.buffer = make([]model.AuditLog, 0, 100)
+	loginBatch := c.loginBuffer
+	if len(batch) > 0 {
+		c.buffer = make([]model.AuditLog, 0, 100)
+	}
+	if len(loginBatch) > 0 {
+		c.loginBuffer = make([]model.LoginLog, 0, 100)
+	}
 	c.mu.Unlock()
 
-	if err := repository.DB.CreateInBatches(batch, len(batch)).Error; err != nil {
-		logger.Error("failed to batch insert audit logs",
-			zap.Error(err),
-			zap.Int("count", len(batch)))
-	} else {
-		logger.Debug("flushed audit logs", zap.Int("count", len(batch)))
+	if len(batch) > 0 {
+		if err := repository.DB.CreateInBatches(batch, len(batch)).Error; err != nil {
+			logger.Error("failed to batch insert audit logs",
+				zap.Error(err),
+				zap.Int("count", len(batch)))
+		} else {
+			logger.Debug("flushed audit logs", zap.Int("count", len(batch)))
+		}
+	}
+
+	if len(loginBatch) > 0 {
+		if err := repository.DB.CreateInBatches(loginBatch, len(loginBatch)).Error; err != nil {
+			logger.Error("failed to batch insert login logs",
+				zap.Error(err),
+				zap.Int("count", len(loginBatch)))
+		} else {
+			logger.Debug("flushed login logs", zap.Int("count", len(loginBatch)))
+		}
 	}
 }
 
@@ -32,6 +32,21 @@ type AuditMessage struct {
 	Extra       map[string]interface{} `json:"extra,omitempty"`
 }
 
+// LoginAuditMessage 登录审计，UserID 为 0 表示账户不存在
+type LoginAuditMessage struct {
+	MessageID  string                 `json:"message_id"`
+	Version    string                 `json:"version"`
+	Source     string                 `json:"source"`
+	OccurredAt int64                  `json:"occurred_at"`
+	UserID     int64                  `json:"user_id"`
+	Phone      string                 `json:"phone"` // 已脱敏
+	Action     string                 `json:"action"`
+	Result     string                 `json:"result"`
+	ClientIP   string                 `json:"client_ip"`
+	UserAgent  string                 `json:"user_agent"`
+	Extra      map[string]interface{} `json:"extra,omitempty"`
+}
+
 type NotifyMessage struct {
 	MessageID string                 `json:"message_id"`
 	Version   string                 `json:"version"`
@@ -55,7 +70,7 @@ func NewPublisher(url string) (*Publisher, error) {
 		return nil, err
 	}
 
-	queues := []string{"audit.queue", "notify.queue", "audit.dlq", "notify.dlq"}
+	queues := []string{"audit.queue", "notify.queue", "login_audit.queue", "audit.dlq", "notify.dlq"}
 	for _, q := range queues {
 		_, err := ch.QueueDeclare(q, true, false, false, false, nil)
 		if err != nil {
@@ -75,6 +90,15 @@ func (p *Publisher) PublishAudit(msg *AuditMessage) error {
 	return p.publish("audit.queue", msg)
 }
 
+func (p *Publisher) PublishLoginAudit(msg *LoginAuditMessage) error {
+	msg.MessageID = uuid.New().String()
+	msg.Version = "1.0"
+	msg.Source = "auth-service"
+	msg.OccurredAt = time.Now().UnixMilli()
+
+	return p.publish("login_audit.queue", msg)
+}
+
 func (p *Publisher) PublishNotify(msg *NotifyMessage) error {
 	msg.MessageID = uuid.New().String()
 	msg.Version = "1.0"
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"hash/fnv"
+
+	"gorm.io/gorm"
+)
+
+// WithAdvisoryLock 用 PostgreSQL 会话级 advisory lock 保证多副本部署时同名任务同一时刻只有一个实例执行。
+// 抢锁失败（其他副本正在执行）返回 (false, nil)，不执行 fn。
+func WithAdvisoryLock(name string, fn func() error) (bool, error) {
+	key := advisoryLockKey(name)
+	acquired := false
+	var fnErr error
+
+	// advisory lock 绑定在连接上，加锁/解锁必须使用同一条连接
+	err := DB.Connection(func(conn *gorm.DB) error {
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+
+		fnErr = fn()
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return acquired, fnErr
+}
+
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("promthus:" + name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"errors"
+	"testing"
+
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+// 持锁期间其他连接抢同名锁失败（相当于另一副本跳过本轮），不同名的锁互不影响
+func TestWithAdvisoryLockExclusive(t *testing.T) {
+	testdb.Open(t)
+
+	var nested, other bool
+	acquired, err := repository.WithAdvisoryLock("job:test", func() error {
+		var err error
+		if nested, err = repository.WithAdvisoryLock("job:test", func() error { return nil }); err != nil {
+			return err
+		}
+		other, err = repository.WithAdvisoryLock("job:other", func() error { return nil })
+		return err
+	})
+	if err != nil || !acquired {
+		t.Fatalf("outer lock = %v, %v; want acquired", acquired, err)
+	}
+	if nested {
+		t.Error("same-name lock acquired while held")
+	}
+	if !other {
+		t.Error("different-name lock not acquired")
+	}
+
+	// 释放后可再次获取，任务错误原样返回
+	errJob := errors.New("job failed")
+	acquired, err = repository.WithAdvisoryLock("job:test", func() error { return errJob })
+	if !acquired || !errors.Is(err, errJob) {
+		t.Fatalf("relock = %v, %v; want true, errJob", acquired, err)
+	}
+}
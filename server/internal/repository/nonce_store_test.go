@@ -0,0 +1,51 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestChallengeNonceClaim(t *testing.T) {
+	testdb.Open(t)
+	store := repository.NewPostgresChallengeNonceStore()
+	expires := time.Now().Add(time.Minute)
+
+	claim := func(deviceID, nonce string, expiresAt time.Time) bool {
+		t.Helper()
+		ok, err := store.Claim(model.DeviceTypeLock, deviceID, nonce, expiresAt)
+		if err != nil {
+			t.Fatalf("Claim(%s, %s): %v", deviceID, nonce, err)
+		}
+		return ok
+	}
+
+	if !claim("LOCK-1", "0011223344556677", expires) {
+		t.Fatal("first claim rejected")
+	}
+	if claim("LOCK-1", "0011223344556677", expires) {
+		t.Fatal("replayed nonce accepted within window")
+	}
+	if !claim("LOCK-2", "0011223344556677", expires) {
+		t.Fatal("same nonce on another device should be independent")
+	}
+
+	// 过期的记录可被覆盖：nonce 只在漂移窗口内不可复用
+	if !claim("LOCK-3", "8899aabbccddeeff", time.Now().Add(-time.Second)) {
+		t.Fatal("first claim rejected")
+	}
+	if !claim("LOCK-3", "8899aabbccddeeff", expires) {
+		t.Fatal("expired nonce should be claimable again")
+	}
+	if claim("LOCK-3", "8899aabbccddeeff", expires) {
+		t.Fatal("re-claimed nonce accepted twice")
+	}
+
+	n, err := store.CleanExpired()
+	if err != nil || n != 0 {
+		t.Fatalf("CleanExpired = %d, %v; want 0 (all live)", n, err)
+	}
+}
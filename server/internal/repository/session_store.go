@@ -10,6 +10,7 @@
 package repository
 
 import (
+	"fmt"
 	"time"
 
 	"promthus/internal/model"
@@ -26,6 +27,16 @@ type SessionStore interface {
 	DeleteByUserID(userID int64) error
 	CleanExpired() (int64, error)
 	CountActive() (int64, error)
+	FindByFamily(familyID uuid.UUID) (*model.Session, error)
+	// Rotate 仅当 refresh_generation 仍为 generation 时轮换，返回 false 表示已被并发使用（按重用处理）
+	Rotate(id int64, generation int, jti uuid.UUID, refreshHash []byte, role string, now time.Time) (bool, error)
+	DeleteByFamily(familyID uuid.UUID) error
+	// DeleteOthers 删除该用户除 keepFamily 外的全部会话（改密后踢掉其他设备）
+	DeleteOthers(userID int64, keepFamily uuid.UUID) error
+	// ListByUser 该用户未过期的会话，最近使用的在前
+	ListByUser(userID int64, now time.Time) ([]model.Session, error)
+	// DeleteForUser 按 jti 或 family_id 删除该用户的一个会话（刷新后 jti 会变，family_id 不变），false 表示不存在
+	DeleteForUser(userID int64, id uuid.UUID) (bool, error)
 }
 
 // 类似于定义一个类，实现 SessionStore 接口;
@@ -68,11 +79,123 @@ func (s *PostgresSessionStore) CountActive() (int64, error) {
 	return count, err
 }
 
+// FindByFamily 不过滤过期，由调用方区分「已过期」与「不存在」。
+func (s *PostgresSessionStore) FindByFamily(familyID uuid.UUID) (*model.Session, error) {
+	var session model.Session
+	if err := DB.Where("family_id = ?", familyID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) Rotate(id int64, generation int, jti uuid.UUID, refreshHash []byte, role string, now time.Time) (bool, error) {
+	result := DB.Model(&model.Session{}).
+		Where("id = ? AND refresh_generation = ?", id, generation).
+		Updates(map[string]interface{}{
+			"jti":                jti,
+			"refresh_token_hash": refreshHash,
+			"refresh_generation": generation + 1,
+			"role":               role,
+			"last_used_at":       now,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *PostgresSessionStore) DeleteByFamily(familyID uuid.UUID) error {
+	return DB.Where("family_id = ?", familyID).Delete(&model.Session{}).Error
+}
+
+func (s *PostgresSessionStore) DeleteOthers(userID int64, keepFamily uuid.UUID) error {
+	return DB.Where("user_id = ? AND family_id <> ?", userID, keepFamily).Delete(&model.Session{}).Error
+}
+
+func (s *PostgresSessionStore) ListByUser(userID int64, now time.Time) ([]model.Session, error) {
+	var sessions []model.Session
+	err := DB.Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *PostgresSessionStore) DeleteForUser(userID int64, id uuid.UUID) (bool, error) {
+	result := DB.Where("user_id = ? AND (jti = ? OR family_id = ?)", userID, id, id).Delete(&model.Session{})
+	return result.RowsAffected > 0, result.Error
+}
+
 // RateLimitStore abstracts rate limiting persistence.
 type RateLimitStore interface {
 	Increment(key string, windowSecs int) (int, error)
 }
 
+type PostgresRateLimitStore struct{}
+
+func NewPostgresRateLimitStore() RateLimitStore {
+	return &PostgresRateLimitStore{}
+}
+
+// Increment 固定窗口计数：窗口过期则从 1 重新计数，返回当前窗口内的次数。
+func (s *PostgresRateLimitStore) Increment(key string, windowSecs int) (int, error) {
+	var rl model.RateLimit
+	sql := `INSERT INTO app.rate_limits (key, count, window_start, updated_at)
+		VALUES (?, 1, NOW(), NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE
+				WHEN app.rate_limits.window_start < NOW() - INTERVAL '%d seconds'
+				THEN 1
+				ELSE app.rate_limits.count + 1
+			END,
+			window_start = CASE
+				WHEN app.rate_limits.window_start < NOW() - INTERVAL '%d seconds'
+				THEN NOW()
+				ELSE app.rate_limits.window_start
+			END,
+			updated_at = NOW()
+		RETURNING count, window_start`
+
+	formattedSQL := fmt.Sprintf(sql, windowSecs, windowSecs)
+	result := DB.Raw(formattedSQL, key).Scan(&rl)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return rl.Count, nil
+}
+
+// DeviceBlockStore 设备级临时封禁（如 challenge_flood 后暂停受理挑战）。Key is (device_type, device_id).
+type DeviceBlockStore interface {
+	Block(deviceType, deviceID string, expiresAt time.Time, reason string) error
+	// BlockedUntil 返回封禁截止时间；未封禁返回 nil。
+	BlockedUntil(deviceType, deviceID string) (*time.Time, error)
+}
+
+type PostgresDeviceBlockStore struct{}
+
+func NewPostgresDeviceBlockStore() DeviceBlockStore {
+	return &PostgresDeviceBlockStore{}
+}
+
+func (s *PostgresDeviceBlockStore) Block(deviceType, deviceID string, expiresAt time.Time, reason string) error {
+	return DB.Exec(`INSERT INTO app.device_blocks (device_type, device_id, blocked_at, expires_at, reason)
+		VALUES (?, ?, NOW(), ?, ?)
+		ON CONFLICT (device_type, device_id) DO UPDATE SET
+			blocked_at = NOW(),
+			expires_at = GREATEST(app.device_blocks.expires_at, EXCLUDED.expires_at),
+			reason = EXCLUDED.reason`,
+		deviceType, deviceID, expiresAt, reason).Error
+}
+
+func (s *PostgresDeviceBlockStore) BlockedUntil(deviceType, deviceID string) (*time.Time, error) {
+	var block model.DeviceBlock
+	err := DB.Where("device_type = ? AND device_id = ? AND expires_at > ?", deviceType, deviceID, time.Now()).
+		First(&block).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &block.ExpiresAt, nil
+}
+
 // DeviceFailStore abstracts device failure counting. Key is (device_type, device_id).
 type DeviceFailStore interface {
 	Increment(deviceType, deviceID string) (int, error)
@@ -114,3 +237,113 @@ func (s *PostgresDeviceFailStore) Get(deviceType, deviceID string) (int, error)
 	}
 	return fc.Count, err
 }
+
+// ChallengeNonceStore 记录已使用过的挑战随机数 (device_type, device_id, challenge_c)，在时间窗内拒绝重放。
+type ChallengeNonceStore interface {
+	// Claim 占用一个 nonce；返回 false 表示窗口内已被使用过（重放）。
+	Claim(deviceType, deviceID, challengeC string, expiresAt time.Time) (bool, error)
+	CleanExpired() (int64, error)
+}
+
+type PostgresChallengeNonceStore struct{}
+
+func NewPostgresChallengeNonceStore() ChallengeNonceStore {
+	return &PostgresChallengeNonceStore{}
+}
+
+func (s *PostgresChallengeNonceStore) Claim(deviceType, deviceID, challengeC string, expiresAt time.Time) (bool, error) {
+	// 已过期的旧记录允许被覆盖，未过期的冲突即为重放（RowsAffected = 0）
+	sql := `INSERT INTO app.challenge_nonces (device_type, device_id, challenge_c, expires_at, created_at)
+		VALUES (?, ?, ?, ?, NOW())
+		ON CONFLICT (device_type, device_id, challenge_c) DO UPDATE SET
+			expires_at = EXCLUDED.expires_at,
+			created_at = NOW()
+		WHERE app.challenge_nonces.expires_at < NOW()`
+	result := DB.Exec(sql, deviceType, deviceID, challengeC, expiresAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *PostgresChallengeNonceStore) CleanExpired() (int64, error) {
+	result := DB.Where("expires_at < ?", time.Now()).Delete(&model.ChallengeNonce{})
+	return result.RowsAffected, result.Error
+}
+
+// UnlockSessionStore 管理 Challenge 签发、Report 核销的一次性开锁会话。
+type UnlockSessionStore interface {
+	Create(session *model.UnlockSession) error
+	// Consume 原子地删除并返回与 userID、设备匹配且未过期的会话；不匹配返回 gorm.ErrRecordNotFound。
+	Consume(id uuid.UUID, userID int64, deviceType, deviceID string) (*model.UnlockSession, error)
+	FindByID(id uuid.UUID) (*model.UnlockSession, error)
+	CleanExpired() (int64, error)
+}
+
+type PostgresUnlockSessionStore struct{}
+
+func NewPostgresUnlockSessionStore() UnlockSessionStore {
+	return &PostgresUnlockSessionStore{}
+}
+
+func (s *PostgresUnlockSessionStore) Create(session *model.UnlockSession) error {
+	return DB.Create(session).Error
+}
+
+func (s *PostgresUnlockSessionStore) Consume(id uuid.UUID, userID int64, deviceType, deviceID string) (*model.UnlockSession, error) {
+	var sessions []model.UnlockSession
+	err := DB.Raw(`DELETE FROM app.unlock_sessions
+		WHERE id = ? AND user_id = ? AND device_type = ? AND device_id = ? AND expires_at > NOW()
+		RETURNING *`, id, userID, deviceType, deviceID).Scan(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &sessions[0], nil
+}
+
+func (s *PostgresUnlockSessionStore) FindByID(id uuid.UUID) (*model.UnlockSession, error) {
+	var session model.UnlockSession
+	if err := DB.Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresUnlockSessionStore) CleanExpired() (int64, error) {
+	result := DB.Where("expires_at < ?", time.Now()).Delete(&model.UnlockSession{})
+	return result.RowsAffected, result.Error
+}
+
+// SettingStore 系统级键值配置（如主密钥指纹），存于 app.system_settings。
+type SettingStore interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string) error
+}
+
+type PostgresSettingStore struct{}
+
+func NewPostgresSettingStore() SettingStore {
+	return &PostgresSettingStore{}
+}
+
+func (s *PostgresSettingStore) Get(key string) (string, bool, error) {
+	var setting model.SystemSetting
+	err := DB.Where("key = ?", key).First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return setting.Value, true, nil
+}
+
+func (s *PostgresSettingStore) Set(key, value string) error {
+	return DB.Exec(`INSERT INTO app.system_settings (key, value, updated_at)
+		VALUES (?, ?, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`,
+		key, value).Error
+}
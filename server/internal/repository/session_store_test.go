@@ -0,0 +1,101 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/google/uuid"
+)
+
+func createSessionUser(t *testing.T, phone string) int64 {
+	t.Helper()
+	user := &model.User{UUID: uuid.New(), Phone: phone, PasswordHash: "x", Name: phone, Role: "user",
+		Status: 1, AuthSource: model.AuthSourceLocal}
+	if err := repository.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func createSession(t *testing.T, store repository.SessionStore, userID int64, lastUsed, expires time.Time) *model.Session {
+	t.Helper()
+	session := &model.Session{JTI: uuid.New(), FamilyID: uuid.New(), UserID: userID, Role: "user",
+		ExpiresAt: expires, LastUsedAt: lastUsed, UserAgent: "ua", IPAddress: "10.0.0.1"}
+	if err := store.Create(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestSessionStoreListAndRevoke(t *testing.T) {
+	testdb.Open(t)
+	store := repository.NewPostgresSessionStore()
+	alice, bob := createSessionUser(t, "13800000001"), createSessionUser(t, "13800000002")
+	now := time.Now()
+
+	older := createSession(t, store, alice, now.Add(-time.Hour), now.Add(time.Hour))
+	newer := createSession(t, store, alice, now, now.Add(time.Hour))
+	createSession(t, store, alice, now, now.Add(-time.Minute)) // 已过期
+	bobs := createSession(t, store, bob, now, now.Add(time.Hour))
+
+	list, err := store.ListByUser(alice, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].FamilyID != newer.FamilyID || list[1].FamilyID != older.FamilyID {
+		t.Fatalf("ListByUser = %+v", list)
+	}
+
+	// 只能删除自己的会话；jti 与 family_id 均可定位
+	if found, err := store.DeleteForUser(alice, bobs.FamilyID); err != nil || found {
+		t.Fatalf("delete other user's session: found %v, err %v", found, err)
+	}
+	if found, err := store.DeleteForUser(alice, older.JTI); err != nil || !found {
+		t.Fatalf("delete by jti: found %v, err %v", found, err)
+	}
+	if found, _ := store.DeleteForUser(alice, newer.FamilyID); !found {
+		t.Fatal("delete by family_id: not found")
+	}
+	if list, _ := store.ListByUser(alice, now); len(list) != 0 {
+		t.Fatalf("sessions left = %d", len(list))
+	}
+	if _, err := store.FindByJTI(bobs.JTI); err != nil {
+		t.Fatal("other user's session was deleted")
+	}
+}
+
+func TestSessionStoreRotateAndDeleteOthers(t *testing.T) {
+	testdb.Open(t)
+	store := repository.NewPostgresSessionStore()
+	user := createSessionUser(t, "13800000003")
+	now := time.Now()
+	keep := createSession(t, store, user, now, now.Add(time.Hour))
+	other := createSession(t, store, user, now, now.Add(time.Hour))
+
+	newJTI := uuid.New()
+	if ok, err := store.Rotate(keep.ID, 0, newJTI, []byte("h1"), "admin", now); err != nil || !ok {
+		t.Fatalf("rotate: %v, %v", ok, err)
+	}
+	// 同一代只能轮换一次，并发的另一方失败
+	if ok, _ := store.Rotate(keep.ID, 0, uuid.New(), []byte("h2"), "admin", now); ok {
+		t.Fatal("second rotation of generation 0 succeeded")
+	}
+	got, err := store.FindByFamily(keep.FamilyID)
+	if err != nil || got.JTI != newJTI || got.RefreshGeneration != 1 || got.Role != "admin" {
+		t.Fatalf("after rotate = %+v, %v", got, err)
+	}
+
+	if err := store.DeleteOthers(user, keep.FamilyID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindByFamily(other.FamilyID); err == nil {
+		t.Fatal("other session survived DeleteOthers")
+	}
+	if _, err := store.FindByFamily(keep.FamilyID); err != nil {
+		t.Fatal("kept session deleted")
+	}
+}
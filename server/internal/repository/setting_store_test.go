@@ -0,0 +1,26 @@
+package repository_test
+
+import (
+	"testing"
+
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestSettingStore(t *testing.T) {
+	testdb.Open(t)
+	store := repository.NewPostgresSettingStore()
+
+	if _, found, err := store.Get("kms.master_key_fingerprint"); err != nil || found {
+		t.Fatalf("Get on empty table = found %v, err %v", found, err)
+	}
+	for _, v := range []string{"first", "second"} {
+		if err := store.Set("kms.master_key_fingerprint", v); err != nil {
+			t.Fatal(err)
+		}
+		got, found, err := store.Get("kms.master_key_fingerprint")
+		if err != nil || !found || got != v {
+			t.Fatalf("Get after Set(%q) = %q, %v, %v", v, got, found, err)
+		}
+	}
+}
@@ -11,6 +11,7 @@ func Setup(
 	authHandler *handler.AuthHandler,
 	lockHandler *handler.LockHandler,
 	adminHandler *handler.AdminHandler,
+	sysHandler *handler.SysHandler,
 ) *gin.Engine {
 	// 根据注册函数进行gin引擎注册,随后返回注册好的gin引擎;
 	r := gin.New()
@@ -28,43 +29,79 @@ func Setup(
 	// GET会检测当前库是否正常;
 	r.GET("/api/health", handler.Health)
 
-	// 认证组,POST登录,POST登出;
+	// 本机运维组：Shamir 解封，只接受回环地址;
+	sys := r.Group("/api/sys").Use(middleware.LocalOnly())
+	{
+		sys.GET("/seal-status", sysHandler.SealStatus)
+		sys.POST("/unseal", sysHandler.Unseal)
+	}
+
+	// 认证组,POST登录,OIDC 单点登录,POST刷新,POST登出,MFA 绑定/校验为登录第二步,POST改密,会话(登录设备)查看与吊销;
 	auth := r.Group("/api/auth")
 	{
 		// 登录可以多handle,次序执行;
 		auth.POST("/login", middleware.LoginRateLimit(), authHandler.Login)
+		auth.POST("/refresh", middleware.LoginRateLimit(), authHandler.Refresh)
+		auth.GET("/oidc/config", authHandler.OIDCConfig)
+		auth.POST("/oidc/authorize", middleware.LoginRateLimit(), authHandler.OIDCAuthorize)
+		auth.POST("/oidc/callback", middleware.LoginRateLimit(), authHandler.OIDCCallback)
+		auth.POST("/mfa/enroll", middleware.LoginRateLimit(), authHandler.EnrollMFA)
+		auth.POST("/mfa/verify", middleware.LoginRateLimit(), authHandler.VerifyMFA)
 		auth.POST("/logout", middleware.Auth(), authHandler.Logout)
+		auth.POST("/password", middleware.LoginRateLimit(), middleware.Auth(), authHandler.ChangePassword)
+		auth.GET("/sessions", middleware.Auth(), authHandler.ListSessions)
+		auth.DELETE("/sessions/:jti", middleware.Auth(), authHandler.RevokeSession)
 	}
 
 	// 锁具组,所有接口都需要认证认证;
-	lock := r.Group("/api/lock").Use(middleware.Auth())
+	lock := r.Group("/api/lock").Use(middleware.RequireUnsealed(), middleware.Auth())
 	{
 		lock.GET("/devices", lockHandler.GetDevices)
 		lock.POST("/challenge", lockHandler.Challenge)
 		lock.POST("/report", lockHandler.Report)
 	}
 
-	admin := r.Group("/api/admin").Use(middleware.Auth(), middleware.RBAC("admin"))
+	// 管理后台组：每个路由声明所需动作，由角色的动作集合（app.role_permissions）决定能否访问;
+	admin := r.Group("/api/admin").Use(middleware.Auth())
 	{
-		admin.GET("/dashboard", adminHandler.Dashboard)
+		admin.GET("/dashboard", middleware.RequirePermission("dashboard:read"), adminHandler.Dashboard)
+
+		admin.GET("/users", middleware.RequirePermission("users:read"), adminHandler.ListUsers)
+		admin.POST("/users", middleware.RequirePermission("users:write"), adminHandler.CreateUser)
+		admin.PUT("/users/:uuid", middleware.RequirePermission("users:write"), adminHandler.UpdateUser)
+		admin.POST("/users/:uuid/reset-pwd", middleware.RequirePermission("users:write"), adminHandler.ResetPassword)
+		admin.DELETE("/users/:uuid/mfa", middleware.RequirePermission("users:write"), adminHandler.ResetMFA)
+		admin.POST("/users/:uuid/unlock", middleware.RequirePermission("users:write"), adminHandler.UnlockUser)
+		admin.PUT("/users/:uuid/scope", middleware.RequirePermission("users:write"), adminHandler.SetAdminScope)
+		admin.GET("/users/:uuid/sessions", middleware.RequirePermission("users:read"), adminHandler.ListUserSessions)
+		admin.DELETE("/users/:uuid/sessions/:jti", middleware.RequirePermission("users:write"), adminHandler.RevokeUserSession)
+
+		admin.GET("/roles", middleware.RequirePermission("roles:read"), adminHandler.ListRoles)
+		admin.POST("/roles", middleware.RequirePermission("roles:write"), adminHandler.CreateRole)
+		admin.PUT("/roles/:name", middleware.RequirePermission("roles:write"), adminHandler.UpdateRole)
+		admin.DELETE("/roles/:name", middleware.RequirePermission("roles:write"), adminHandler.DeleteRole)
 
-		admin.GET("/users", adminHandler.ListUsers)
-		admin.POST("/users", adminHandler.CreateUser)
-		admin.PUT("/users/:uuid", adminHandler.UpdateUser)
-		admin.POST("/users/:uuid/reset-pwd", adminHandler.ResetPassword)
+		admin.GET("/devices", middleware.RequirePermission("devices:read"), middleware.RequireUnsealed(), adminHandler.ListDevices)
+		admin.POST("/devices", middleware.RequirePermission("devices:write"), middleware.RequireUnsealed(), adminHandler.CreateDevice)
+		admin.POST("/devices/:device_id/rotate-key", middleware.RequirePermission("devices:write"), middleware.RequireUnsealed(), adminHandler.RotateDeviceKey)
 
-		admin.GET("/devices", adminHandler.ListDevices)
-		admin.POST("/devices", adminHandler.CreateDevice)
+		admin.GET("/permissions", middleware.RequirePermission("permissions:read"), adminHandler.ListPermissions)
+		admin.POST("/permissions", middleware.RequirePermission("permissions:write"), adminHandler.GrantPermission)
+		admin.POST("/permissions/batch", middleware.RequirePermission("permissions:write"), adminHandler.BatchGrantPermissions)
+		admin.DELETE("/permissions/:id", middleware.RequirePermission("permissions:write"), adminHandler.RevokePermission)
 
-		admin.GET("/permissions", adminHandler.ListPermissions)
-		admin.POST("/permissions", adminHandler.GrantPermission)
-		admin.POST("/permissions/batch", adminHandler.BatchGrantPermissions)
-		admin.DELETE("/permissions/:id", adminHandler.RevokePermission)
+		admin.GET("/audit-logs", middleware.RequirePermission("audit:read"), adminHandler.ListAuditLogs)
+		admin.GET("/login-logs", middleware.RequirePermission("audit:read"), adminHandler.ListLoginLogs)
 
-		admin.GET("/audit-logs", adminHandler.ListAuditLogs)
+		admin.GET("/alerts", middleware.RequirePermission("alerts:read"), adminHandler.ListAlerts)
+		admin.PUT("/alerts/:id", middleware.RequirePermission("alerts:write"), adminHandler.HandleAlert)
 
-		admin.GET("/alerts", adminHandler.ListAlerts)
-		admin.PUT("/alerts/:id", adminHandler.HandleAlert)
+		admin.GET("/service-accounts", middleware.RequirePermission("service_accounts:read"), adminHandler.ListServiceAccounts)
+		admin.POST("/service-accounts", middleware.RequirePermission("service_accounts:write"), adminHandler.CreateServiceAccount)
+		admin.PUT("/service-accounts/:uuid", middleware.RequirePermission("service_accounts:write"), adminHandler.UpdateServiceAccount)
+		admin.GET("/service-accounts/:uuid/keys", middleware.RequirePermission("service_accounts:read"), adminHandler.ListAPIKeys)
+		admin.POST("/service-accounts/:uuid/keys", middleware.RequirePermission("service_accounts:write"), adminHandler.CreateAPIKey)
+		admin.DELETE("/service-accounts/:uuid/keys/:id", middleware.RequirePermission("service_accounts:write"), adminHandler.RevokeAPIKey)
 	}
 
 	return r
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+管辖范围：把后台用户限定到若干管线（devices_lock.pipeline_tag）和/或部门（users.department）。
+角色决定能做哪些动作，范围决定能对哪些锁具、用户做；没有任何范围行的用户不受限。
+某一维度受限时，带该维度属性的记录必须命中；缺少该属性的记录（如账户类告警没有设备、
+设备告警没有关联用户）一律视为范围外，宁可看不到也不越界。
+*/
+
+type SetAdminScopeRequest struct {
+	PipelineTags []string `json:"pipeline_tags" binding:"max=50,dive,required,max=50"`
+	Departments  []string `json:"departments" binding:"max=50,dive,required,max=100"`
+}
+
+func loadAdminScope(userID int64) (*model.AdminScope, error) {
+	var rows []model.AdminScopeEntry
+	if err := repository.DB.Where("user_id = ?", userID).Order("kind, value").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	scope := &model.AdminScope{PipelineTags: []string{}, Departments: []string{}}
+	for _, r := range rows {
+		switch r.Kind {
+		case model.ScopeKindPipelineTag:
+			scope.PipelineTags = append(scope.PipelineTags, r.Value)
+		case model.ScopeKindDepartment:
+			scope.Departments = append(scope.Departments, r.Value)
+		}
+	}
+	return scope, nil
+}
+
+// operatorScope 加载操作者的管辖范围，失败按 500 处理
+func (s *AdminService) operatorScope(operatorID int64) (*model.AdminScope, int, string) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		logger.Error("admin_scope: load failed", zap.Error(err), zap.Int64("operator_id", operatorID))
+		return nil, model.CodeInternalError, "failed to load admin scope"
+	}
+	return scope, 0, ""
+}
+
+// scopeDevices 把 column（设备业务编号）限定到范围内管线的锁具
+func scopeDevices(query *gorm.DB, scope *model.AdminScope, column string) *gorm.DB {
+	if scope == nil || len(scope.PipelineTags) == 0 {
+		return query
+	}
+	return query.Where(column+" IN (SELECT device_id FROM app.devices_lock WHERE pipeline_tag IN ? AND deleted_at IS NULL)",
+		scope.PipelineTags)
+}
+
+// scopeUsers 把 column（用户 ID）限定到范围内部门的用户
+func scopeUsers(query *gorm.DB, scope *model.AdminScope, column string) *gorm.DB {
+	if scope == nil || len(scope.Departments) == 0 {
+		return query
+	}
+	return query.Where(column+" IN (SELECT id FROM app.users WHERE department IN ?)", scope.Departments)
+}
+
+func scopeAllowsDevice(scope *model.AdminScope, deviceID string) (bool, error) {
+	if scope == nil || len(scope.PipelineTags) == 0 {
+		return true, nil
+	}
+	if deviceID == "" {
+		return false, nil
+	}
+	var cnt int64
+	err := scopeDevices(repository.DB.Model(&model.Device{}), scope, "device_id").
+		Where("device_id = ?", deviceID).Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// scopeAllowsUser userID 为 nil 表示记录未关联用户
+func scopeAllowsUser(scope *model.AdminScope, userID *int64) (bool, error) {
+	if scope == nil || len(scope.Departments) == 0 {
+		return true, nil
+	}
+	if userID == nil {
+		return false, nil
+	}
+	var cnt int64
+	err := scopeUsers(repository.DB.Model(&model.User{}), scope, "id").
+		Where("id = ?", *userID).Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// checkScope 校验一条记录的设备与用户都在操作者范围内，范围外返回 CodeForbidden
+func (s *AdminService) checkScope(operatorID int64, deviceID string, userID *int64) (int, string) {
+	scope, code, msg := s.operatorScope(operatorID)
+	if code != 0 {
+		return code, msg
+	}
+	if scope.Unrestricted() {
+		return 0, ""
+	}
+	ok, err := scopeAllowsDevice(scope, deviceID)
+	if err == nil && ok {
+		ok, err = scopeAllowsUser(scope, userID)
+	}
+	if err != nil {
+		logger.Error("admin_scope: check failed", zap.Error(err), zap.Int64("operator_id", operatorID))
+		return model.CodeInternalError, "failed to check admin scope"
+	}
+	if !ok {
+		logger.Info("admin_scope: out of scope",
+			zap.Int64("operator_id", operatorID), zap.String("device_id", deviceID))
+		return model.CodeForbidden, "target is outside your admin scope"
+	}
+	return 0, ""
+}
+
+// checkDepartmentScope 用户管理按部门校验：departments 为目标用户的当前部门及要改成的部门，任一在范围外即拒绝。
+// 用户没有管线属性，只按管线受限的操作者不因此受限；部门为空视为范围外。
+func (s *AdminService) checkDepartmentScope(operatorID int64, departments ...string) (int, string) {
+	scope, code, msg := s.operatorScope(operatorID)
+	if code != 0 {
+		return code, msg
+	}
+	if len(scope.Departments) == 0 {
+		return 0, ""
+	}
+	allowed := map[string]bool{}
+	for _, d := range scope.Departments {
+		allowed[d] = true
+	}
+	for _, d := range departments {
+		if !allowed[strings.TrimSpace(d)] {
+			logger.Info("admin_scope: department out of scope",
+				zap.Int64("operator_id", operatorID), zap.String("department", d))
+			return model.CodeForbidden, "target is outside your admin scope"
+		}
+	}
+	return 0, ""
+}
+
+// checkUserScopeWithin 目标用户的范围不宽于操作者，否则返回 CodeForbidden；用于管理服务账号等不按部门区分的对象
+func (s *AdminService) checkUserScopeWithin(operatorID, userID int64) (int, string) {
+	operatorScope, code, msg := s.operatorScope(operatorID)
+	if code != 0 {
+		return code, msg
+	}
+	if operatorScope.Unrestricted() {
+		return 0, ""
+	}
+	target, err := loadAdminScope(userID)
+	if err != nil {
+		logger.Error("admin_scope: load target scope failed", zap.Error(err), zap.Int64("user_id", userID))
+		return model.CodeInternalError, "failed to load admin scope"
+	}
+	if !scopeWithin(target, operatorScope) {
+		logger.Info("admin_scope: target scope wider than operator",
+			zap.Int64("operator_id", operatorID), zap.Int64("user_id", userID))
+		return model.CodeForbidden, "target is outside your admin scope"
+	}
+	return 0, ""
+}
+
+// copyAdminScope 在 tx 内把 from 的范围复制给 to：服务账号继承创建者的范围，API Key 不会比创建者看得更多
+func copyAdminScope(tx *gorm.DB, from, to, createdBy int64) error {
+	var rows []model.AdminScopeEntry
+	if err := tx.Where("user_id = ?", from).Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	for i := range rows {
+		rows[i].UserID, rows[i].CreatedBy, rows[i].CreatedAt = to, createdBy, time.Time{}
+	}
+	return tx.Create(&rows).Error
+}
+
+// scopeWithin inner 的每个受限维度都不宽于 outer：outer 受限的维度 inner 也必须受限且取值为其子集
+func scopeWithin(inner, outer *model.AdminScope) bool {
+	return valuesWithin(inner.PipelineTags, outer.PipelineTags) && valuesWithin(inner.Departments, outer.Departments)
+}
+
+func valuesWithin(inner, outer []string) bool {
+	if len(outer) == 0 {
+		return true
+	}
+	if len(inner) == 0 {
+		return false
+	}
+	allowed := map[string]bool{}
+	for _, v := range outer {
+		allowed[v] = true
+	}
+	for _, v := range inner {
+		if !allowed[v] {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeScopeValues(values []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+SetAdminScope 整体替换用户的管辖范围，两个列表都为空即取消限制。
+受限的操作者只能管理范围不宽于自己的用户，也只能设置不宽于自己的范围，
+否则区域主管可以给自己放开限制，或把总部管理员收窄到自己的管线。
+*/
+func (s *AdminService) SetAdminScope(userUUID string, req *SetAdminScopeRequest, operatorID int64) (int, string) {
+	var user model.User
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
+		logger.Info("set_admin_scope: user not found", zap.String("user_uuid", userUUID))
+		return model.CodeParamError, "user not found"
+	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return code, msg
+	}
+
+	next := &model.AdminScope{
+		PipelineTags: normalizeScopeValues(req.PipelineTags),
+		Departments:  normalizeScopeValues(req.Departments),
+	}
+	operatorScope, code, msg := s.operatorScope(operatorID)
+	if code != 0 {
+		return code, msg
+	}
+	before, err := loadAdminScope(user.ID)
+	if err != nil {
+		logger.Error("set_admin_scope: load scope failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return model.CodeInternalError, "failed to load admin scope"
+	}
+	if !scopeWithin(before, operatorScope) || !scopeWithin(next, operatorScope) {
+		return model.CodeForbidden, "cannot manage admin scope wider than your own"
+	}
+
+	rows := make([]model.AdminScopeEntry, 0, len(next.PipelineTags)+len(next.Departments))
+	for _, v := range next.PipelineTags {
+		rows = append(rows, model.AdminScopeEntry{UserID: user.ID, Kind: model.ScopeKindPipelineTag, Value: v, CreatedBy: operatorID})
+	}
+	for _, v := range next.Departments {
+		rows = append(rows, model.AdminScopeEntry{UserID: user.ID, Kind: model.ScopeKindDepartment, Value: v, CreatedBy: operatorID})
+	}
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.AdminScopeEntry{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		logger.Error("set_admin_scope: db write failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return model.CodeInternalError, "failed to update admin scope"
+	}
+
+	s.logOperation(operatorID, "set_admin_scope", "user", user.ID, before, next)
+	logger.Info("set_admin_scope success",
+		zap.Int64("user_id", user.ID), zap.Strings("pipeline_tags", next.PipelineTags),
+		zap.Strings("departments", next.Departments), zap.Int64("operator_id", operatorID))
+
+	return 0, ""
+}
+
+// fillAdminScopes 为用户列表批量填充管辖范围，只填有范围的用户
+func fillAdminScopes(users []model.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	var rows []model.AdminScopeEntry
+	if err := repository.DB.Where("user_id IN ?", ids).Order("kind, value").Find(&rows).Error; err != nil {
+		return err
+	}
+	scopes := map[int64]*model.AdminScope{}
+	for _, r := range rows {
+		scope := scopes[r.UserID]
+		if scope == nil {
+			scope = &model.AdminScope{PipelineTags: []string{}, Departments: []string{}}
+			scopes[r.UserID] = scope
+		}
+		switch r.Kind {
+		case model.ScopeKindPipelineTag:
+			scope.PipelineTags = append(scope.PipelineTags, r.Value)
+		case model.ScopeKindDepartment:
+			scope.Departments = append(scope.Departments, r.Value)
+		}
+	}
+	for i := range users {
+		users[i].AdminScope = scopes[users[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestValuesWithin(t *testing.T) {
+	tests := []struct {
+		inner, outer []string
+		want         bool
+	}{
+		{nil, nil, true},
+		{[]string{"west"}, nil, true},  // outer 不受限
+		{nil, []string{"west"}, false}, // inner 不受限即比 outer 宽
+		{[]string{"west"}, []string{"west", "east"}, true},
+		{[]string{"west", "north"}, []string{"west", "east"}, false},
+	}
+	for _, tt := range tests {
+		if got := valuesWithin(tt.inner, tt.outer); got != tt.want {
+			t.Errorf("valuesWithin(%q, %q) = %v, want %v", tt.inner, tt.outer, got, tt.want)
+		}
+	}
+
+	outer := &model.AdminScope{PipelineTags: []string{"west"}}
+	if !scopeWithin(&model.AdminScope{PipelineTags: []string{"west"}, Departments: []string{"运维部"}}, outer) {
+		t.Error("narrower scope rejected")
+	}
+	if scopeWithin(&model.AdminScope{Departments: []string{"运维部"}}, outer) {
+		t.Error("scope without pipeline restriction accepted under pipeline-restricted operator")
+	}
+}
+
+func TestNormalizeScopeValues(t *testing.T) {
+	got := normalizeScopeValues([]string{" west ", "east", "west", "", "  "})
+	if len(got) != 2 || got[0] != "east" || got[1] != "west" {
+		t.Fatalf("normalizeScopeValues = %q", got)
+	}
+	if got := normalizeScopeValues(nil); got == nil || len(got) != 0 {
+		t.Fatalf("normalizeScopeValues(nil) = %#v", got)
+	}
+}
+
+// newScopedAdmin 由不受限的管理员为新建的 admin 设置管辖范围
+func newScopedAdmin(t *testing.T, svc *AdminService, root *model.User, department string, req *SetAdminScopeRequest) *model.User {
+	t.Helper()
+	user := newTestUser(t, "admin", department)
+	if code, msg := svc.SetAdminScope(user.UUID.String(), req, root.ID); code != 0 {
+		t.Fatalf("set scope: %d %s", code, msg)
+	}
+	return user
+}
+
+func TestAdminScopeDevicesPermissionsAlerts(t *testing.T) {
+	testdb.Open(t)
+	svc := NewAdminService(repository.NewPostgresSessionStore(), nil)
+	root := newTestUser(t, "admin", "")
+	west, _ := newTestDevice(t, "LOCK-WEST", "west")
+	east, _ := newTestDevice(t, "LOCK-EAST", "east")
+	ops := newTestUser(t, "user", "运维部")
+	finance := newTestUser(t, "user", "财务部")
+	supervisor := newScopedAdmin(t, svc, root, "运维部",
+		&SetAdminScopeRequest{PipelineTags: []string{"west"}, Departments: []string{"运维部"}})
+
+	devices, total, err := svc.ListDevices(supervisor.ID, 1, 20, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || devices[0].DeviceID != west.DeviceID {
+		t.Fatalf("ListDevices = %d devices", total)
+	}
+
+	grant := func(userID int64, deviceID string) int {
+		code, _ := svc.GrantPermission(&GrantPermissionRequest{UserID: userID, DeviceID: deviceID,
+			ValidFrom: time.Now()}, supervisor.ID)
+		return code
+	}
+	if code := grant(ops.ID, west.DeviceID); code != 0 {
+		t.Fatalf("grant in scope: code %d", code)
+	}
+	if code := grant(ops.ID, east.DeviceID); code != model.CodeForbidden {
+		t.Fatalf("grant on other pipeline: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code := grant(finance.ID, west.DeviceID); code != model.CodeForbidden {
+		t.Fatalf("grant to other department: code %d, want %d", code, model.CodeForbidden)
+	}
+	outside := grantTestPermission(t, finance.ID, east.DeviceID, nil)
+	if code, _ := svc.RevokePermission(outside.ID, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("revoke out of scope: code %d, want %d", code, model.CodeForbidden)
+	}
+
+	perms, total, err := svc.ListPermissions(supervisor.ID, nil, nil, nil, 1, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || perms[0].UserID != ops.ID {
+		t.Fatalf("ListPermissions = %d permissions", total)
+	}
+
+	for _, l := range []model.AuditLog{
+		{UserID: ops.ID, DeviceID: west.DeviceID, Action: "unlock", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+		{UserID: ops.ID, DeviceID: east.DeviceID, Action: "unlock", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+		{UserID: finance.ID, DeviceID: west.DeviceID, Action: "unlock", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+	} {
+		if err := repository.DB.Create(&l).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := svc.ListAuditLogs(supervisor.ID, nil, "", "", nil, nil, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs := page.Items.([]model.AuditLog); len(logs) != 1 || logs[0].DeviceID != west.DeviceID || logs[0].UserID != ops.ID {
+		t.Fatalf("ListAuditLogs = %+v", page.Items)
+	}
+
+	alerts := []*model.Alert{
+		{AlertType: "consecutive_fail", DeviceID: west.DeviceID, UserID: &ops.ID, Severity: 2},
+		{AlertType: "consecutive_fail", DeviceID: east.DeviceID, UserID: &ops.ID, Severity: 2},
+		{AlertType: "consecutive_fail", DeviceID: west.DeviceID, Severity: 2}, // 未关联用户，部门受限时视为范围外
+	}
+	for _, a := range alerts {
+		if err := repository.DB.Create(a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantCodes := []int{0, model.CodeForbidden, model.CodeForbidden}
+	for i, a := range alerts {
+		if code, _ := svc.HandleAlert(a.ID, &HandleAlertRequest{HandleNote: "checked"}, supervisor.ID); code != wantCodes[i] {
+			t.Errorf("HandleAlert(%d) code %d, want %d", i, code, wantCodes[i])
+		}
+	}
+}
+
+// 受限的操作者不能放宽自己的范围，也不能管理范围比自己宽的用户
+func TestSetAdminScopeCannotWiden(t *testing.T) {
+	testdb.Open(t)
+	svc := NewAdminService(repository.NewPostgresSessionStore(), nil)
+	root := newTestUser(t, "admin", "")
+	supervisor := newScopedAdmin(t, svc, root, "", &SetAdminScopeRequest{PipelineTags: []string{"west"}})
+	deputy := newTestUser(t, "admin", "")
+
+	if code, _ := svc.SetAdminScope(supervisor.UUID.String(), &SetAdminScopeRequest{}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("lift own scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code, _ := svc.SetAdminScope(deputy.UUID.String(),
+		&SetAdminScopeRequest{PipelineTags: []string{"west", "east"}}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("grant wider scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	// deputy 当前不受限，比 supervisor 宽
+	if code, _ := svc.SetAdminScope(deputy.UUID.String(),
+		&SetAdminScopeRequest{PipelineTags: []string{"west"}}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("narrow an unrestricted admin: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code, msg := svc.SetAdminScope(deputy.UUID.String(),
+		&SetAdminScopeRequest{PipelineTags: []string{"west"}}, root.ID); code != 0 {
+		t.Fatalf("root sets scope: %d %s", code, msg)
+	}
+	if code, msg := svc.SetAdminScope(deputy.UUID.String(),
+		&SetAdminScopeRequest{PipelineTags: []string{" west ", "west"}, Departments: []string{"运维部"}}, supervisor.ID); code != 0 {
+		t.Fatalf("narrow within scope: %d %s", code, msg)
+	}
+	scope, err := loadAdminScope(deputy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scope.PipelineTags) != 1 || len(scope.Departments) != 1 {
+		t.Fatalf("deputy scope = %+v", scope)
+	}
+}
+
+// 限定部门的操作者只能管理本部门的用户，也不能把用户调出或建到范围外的部门
+func TestDepartmentScopeUserManagement(t *testing.T) {
+	testdb.Open(t)
+	store := repository.NewPostgresSessionStore()
+	svc := NewAdminService(store, nil)
+	root := newTestUser(t, "admin", "")
+	supervisor := newScopedAdmin(t, svc, root, "运维部", &SetAdminScopeRequest{Departments: []string{"运维部"}})
+	ops := newTestUser(t, "user", "运维部")
+	finance := newTestUser(t, "user", "财务部")
+	nobody := newTestUser(t, "user", "")
+
+	create := func(phone, department string) int {
+		_, _, code, _ := svc.CreateUser(&CreateUserRequest{Phone: phone, Name: "x", Department: department, Role: "user"}, supervisor.ID)
+		return code
+	}
+	if code := create("13900001001", "运维部"); code != 0 {
+		t.Fatalf("create in scope: code %d", code)
+	}
+	if code := create("13900001002", "财务部"); code != model.CodeForbidden {
+		t.Fatalf("create in other department: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code := create("13900001003", ""); code != model.CodeForbidden {
+		t.Fatalf("create without department: code %d, want %d", code, model.CodeForbidden)
+	}
+
+	name, other := "renamed", "财务部"
+	if code, msg := svc.UpdateUser(ops.UUID.String(), &UpdateUserRequest{Name: &name}, supervisor.ID); code != 0 {
+		t.Fatalf("update in scope: %d %s", code, msg)
+	}
+	if code, _ := svc.UpdateUser(ops.UUID.String(), &UpdateUserRequest{Department: &other}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("move user out of scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code, _ := svc.UpdateUser(finance.UUID.String(), &UpdateUserRequest{Name: &name}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("update out of scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	mine := "运维部"
+	if code, _ := svc.UpdateUser(finance.UUID.String(), &UpdateUserRequest{Department: &mine}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("pull user into scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	var stored model.User
+	if err := repository.DB.First(&stored, ops.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Department.String != "运维部" {
+		t.Fatalf("department changed to %q", stored.Department.String)
+	}
+
+	if _, code, msg := svc.ListUserSessions(ops.UUID.String(), supervisor.ID); code != 0 {
+		t.Fatalf("list sessions in scope: %d %s", code, msg)
+	}
+	for _, target := range []*model.User{finance, nobody} {
+		uuid := target.UUID.String()
+		if _, code, _ := svc.ListUserSessions(uuid, supervisor.ID); code != model.CodeForbidden {
+			t.Errorf("ListUserSessions(%q): code %d", target.Department.String, code)
+		}
+		if code, _ := svc.RevokeUserSession(uuid, ops.UUID.String(), supervisor.ID); code != model.CodeForbidden {
+			t.Errorf("RevokeUserSession(%q): code %d", target.Department.String, code)
+		}
+		if _, code, _ := svc.ResetPassword(uuid, supervisor.ID); code != model.CodeForbidden {
+			t.Errorf("ResetPassword(%q): code %d", target.Department.String, code)
+		}
+		if code, _ := svc.UnlockUser(uuid, supervisor.ID); code != model.CodeForbidden {
+			t.Errorf("UnlockUser(%q): code %d", target.Department.String, code)
+		}
+		if code, _ := svc.ResetMFA(uuid, supervisor.ID); code != model.CodeForbidden {
+			t.Errorf("ResetMFA(%q): code %d", target.Department.String, code)
+		}
+	}
+
+	// 只限定管线的操作者不受部门限制
+	pipelineOnly := newScopedAdmin(t, svc, root, "", &SetAdminScopeRequest{PipelineTags: []string{"west"}})
+	if code, msg := svc.UpdateUser(finance.UUID.String(), &UpdateUserRequest{Name: &name}, pipelineOnly.ID); code != 0 {
+		t.Fatalf("pipeline-only operator: %d %s", code, msg)
+	}
+	if _, code, msg := svc.ListUserSessions(nobody.UUID.String(), pipelineOnly.ID); code != 0 {
+		t.Fatalf("pipeline-only operator list sessions: %d %s", code, msg)
+	}
+}
+
+// 用户列表、登录审计与首页统计同样按范围过滤
+func TestAdminScopeListsAndDashboard(t *testing.T) {
+	testdb.Open(t)
+	svc := NewAdminService(repository.NewPostgresSessionStore(), nil)
+	root := newTestUser(t, "admin", "")
+	west, _ := newTestDevice(t, "LOCK-WEST", "west")
+	east, _ := newTestDevice(t, "LOCK-EAST", "east")
+	ops := newTestUser(t, "user", "运维部")
+	finance := newTestUser(t, "user", "财务部")
+	supervisor := newScopedAdmin(t, svc, root, "运维部",
+		&SetAdminScopeRequest{PipelineTags: []string{"west"}, Departments: []string{"运维部"}})
+
+	users, total, err := svc.ListUsers(supervisor.ID, 1, 20, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Fatalf("ListUsers total = %d, want 2", total)
+	}
+	for _, u := range users {
+		if u.ID != ops.ID && u.ID != supervisor.ID {
+			t.Fatalf("ListUsers returned user %d outside scope", u.ID)
+		}
+	}
+	if _, total, _ := svc.ListUsers(root.ID, 1, 20, "", "", ""); total != 4 {
+		t.Fatalf("unrestricted ListUsers total = %d, want 4", total)
+	}
+
+	for _, l := range []model.LoginLog{
+		{UserID: &ops.ID, Phone: ops.Phone, Action: "login", Result: "success", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+		{UserID: &finance.ID, Phone: finance.Phone, Action: "login", Result: "success", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+		{Phone: "13999999999", Action: "login", Result: "unknown_user", ClientIP: "10.0.0.1", OccurredAt: time.Now()},
+	} {
+		if err := repository.DB.Create(&l).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := svc.ListLoginLogs(supervisor.ID, nil, "", "", nil, nil, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs := page.Items.([]model.LoginLog); len(logs) != 1 || *logs[0].UserID != ops.ID {
+		t.Fatalf("ListLoginLogs = %+v", page.Items)
+	}
+
+	for _, a := range []*model.Alert{
+		{AlertType: "consecutive_fail", DeviceID: west.DeviceID, UserID: &ops.ID, Severity: 2},
+		{AlertType: "consecutive_fail", DeviceID: east.DeviceID, UserID: &ops.ID, Severity: 2},
+		{AlertType: "consecutive_fail", DeviceID: west.DeviceID, UserID: &finance.ID, Severity: 2},
+	} {
+		if err := repository.DB.Create(a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := svc.GetDashboard(supervisor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.TotalUsers != 2 || data.TotalDevices != 1 || data.PendingAlerts != 1 || len(data.RecentAlerts) != 1 ||
+		data.RecentAlerts[0].DeviceID != west.DeviceID || data.DevicesByStatus["normal"] != 1 {
+		t.Fatalf("dashboard = %+v", data)
+	}
+	if data, err := svc.GetDashboard(root.ID); err != nil || data.TotalDevices != 2 || data.PendingAlerts != 3 {
+		t.Fatalf("unrestricted dashboard = %+v, %v", data, err)
+	}
+}
+
+// 受管线范围限制的操作者只能在范围内登记锁具、轮换密钥
+func TestAdminScopeDeviceManagement(t *testing.T) {
+	testdb.Open(t)
+	svc := NewAdminService(repository.NewPostgresSessionStore(), nil)
+	root := newTestUser(t, "admin", "")
+	east, _ := newTestDevice(t, "LOCK-EAST", "east")
+	supervisor := newScopedAdmin(t, svc, root, "", &SetAdminScopeRequest{PipelineTags: []string{"west"}})
+
+	create := func(deviceID, pipelineTag string) int {
+		_, code, _ := svc.CreateDevice(&CreateDeviceRequest{DeviceID: deviceID, Name: deviceID, LocationText: "x",
+			PipelineTag: pipelineTag, RiskLevel: 1, DeviceKey: "00112233445566778899aabbccddeeff"}, supervisor.ID)
+		return code
+	}
+	if code := create("LOCK-W1", "west"); code != 0 {
+		t.Fatalf("create in scope: code %d", code)
+	}
+	if code := create("LOCK-E1", "east"); code != model.CodeForbidden {
+		t.Fatalf("create on other pipeline: code %d, want %d", code, model.CodeForbidden)
+	}
+	if code := create("LOCK-N1", ""); code != model.CodeForbidden {
+		t.Fatalf("create without pipeline: code %d, want %d", code, model.CodeForbidden)
+	}
+
+	if _, code, _ := svc.RotateDeviceKey(east.DeviceID, &RotateDeviceKeyRequest{}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("rotate out of scope: code %d, want %d", code, model.CodeForbidden)
+	}
+	var stored model.Device
+	if err := repository.DB.First(&stored, east.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.PendingKeyVersion != nil {
+		t.Fatal("out-of-scope rotation stored a pending key")
+	}
+	if _, code, msg := svc.RotateDeviceKey("LOCK-W1", &RotateDeviceKeyRequest{}, supervisor.ID); code != 0 {
+		t.Fatalf("rotate in scope: %d %s", code, msg)
+	}
+}
+
+// 服务账号继承创建者的范围，受限的操作者不能借服务账号或总部建的账号绕过范围
+func TestServiceAccountAdminScope(t *testing.T) {
+	testdb.Open(t)
+	svc := NewAdminService(repository.NewPostgresSessionStore(), nil)
+	root := newTestUser(t, "admin", "")
+	west, _ := newTestDevice(t, "LOCK-WEST", "west")
+	east, _ := newTestDevice(t, "LOCK-EAST", "east")
+	worker := newTestUser(t, "user", "运维部")
+	supervisor := newScopedAdmin(t, svc, root, "", &SetAdminScopeRequest{PipelineTags: []string{"west"}})
+
+	account, code, msg := svc.CreateServiceAccount(&CreateServiceAccountRequest{Name: "west-orders"}, supervisor.ID)
+	if code != 0 {
+		t.Fatalf("create account: %d %s", code, msg)
+	}
+	scope, err := loadAdminScope(account.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scope.PipelineTags) != 1 || scope.PipelineTags[0] != "west" || len(scope.Departments) != 0 {
+		t.Fatalf("service user scope = %+v", scope)
+	}
+	if _, code, msg := svc.CreateAPIKey(account.UUID.String(),
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"permissions:write"}}, supervisor.ID); code != 0 {
+		t.Fatalf("create key: %d %s", code, msg)
+	}
+	grant := func(deviceID string) int {
+		code, _ := svc.GrantPermission(&GrantPermissionRequest{UserID: worker.ID, DeviceID: deviceID,
+			ValidFrom: time.Now()}, account.UserID)
+		return code
+	}
+	if code := grant(west.DeviceID); code != 0 {
+		t.Fatalf("service account grant in scope: code %d", code)
+	}
+	if code := grant(east.DeviceID); code != model.CodeForbidden {
+		t.Fatalf("service account grant out of scope: code %d, want %d", code, model.CodeForbidden)
+	}
+
+	hq, code, msg := svc.CreateServiceAccount(&CreateServiceAccountRequest{Name: "hq-orders"}, root.ID)
+	if code != 0 {
+		t.Fatalf("create hq account: %d %s", code, msg)
+	}
+	if _, code, _ := svc.CreateAPIKey(hq.UUID.String(),
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"permissions:write"}}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("key for unrestricted account: code %d, want %d", code, model.CodeForbidden)
+	}
+	disabled := int16(0)
+	if code, _ := svc.UpdateServiceAccount(hq.UUID.String(), &UpdateServiceAccountRequest{Status: &disabled}, supervisor.ID); code != model.CodeForbidden {
+		t.Fatalf("disable unrestricted account: code %d, want %d", code, model.CodeForbidden)
+	}
+}
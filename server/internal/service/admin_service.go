@@ -1,6 +1,8 @@
 package service
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"strings"
@@ -19,10 +21,11 @@ import (
 
 type AdminService struct {
 	sessionStore repository.SessionStore
+	provisionKey *ecdh.PublicKey // 产线传输公钥，nil 时不支持服务端生成设备密钥
 }
 
-func NewAdminService(ss repository.SessionStore) *AdminService {
-	return &AdminService{sessionStore: ss}
+func NewAdminService(ss repository.SessionStore, provisionKey *ecdh.PublicKey) *AdminService {
+	return &AdminService{sessionStore: ss, provisionKey: provisionKey}
 }
 
 // ==================== User Management ====================
@@ -31,13 +34,13 @@ type CreateUserRequest struct {
 	Phone      string `json:"phone" binding:"required"`
 	Name       string `json:"name" binding:"required,max=50"`
 	Department string `json:"department"`
-	Role       string `json:"role" binding:"required,oneof=user admin"`
+	Role       string `json:"role" binding:"required"` // app.roles 中除 service 外的角色
 }
 
 type UpdateUserRequest struct {
 	Name       *string `json:"name" binding:"omitempty,max=50"`
 	Department *string `json:"department"`
-	Role       *string `json:"role" binding:"omitempty,oneof=user admin"`
+	Role       *string `json:"role"`
 	Status     *int16  `json:"status" binding:"omitempty,oneof=0 1"`
 }
 
@@ -46,6 +49,16 @@ func (s *AdminService) CreateUser(req *CreateUserRequest, operatorID int64) (*mo
 		zap.String("phone", req.Phone), zap.String("name", req.Name),
 		zap.String("role", req.Role), zap.Int64("operator_id", operatorID))
 
+	if !roleAssignable(req.Role) {
+		return nil, "", model.CodeParamError, "unknown role"
+	}
+	if code, msg := s.roleWithin(operatorID, req.Role); code != 0 {
+		return nil, "", code, msg
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, req.Department); code != 0 {
+		return nil, "", code, msg
+	}
+
 	password, err := crypto.GenerateRandomPassword(16)
 	if err != nil {
 		logger.Error("create_user: generate password failed", zap.Error(err))
@@ -65,6 +78,8 @@ func (s *AdminService) CreateUser(req *CreateUserRequest, operatorID int64) (*mo
 		Name:         req.Name,
 		Role:         req.Role,
 		Status:       1,
+
+		MustChangePassword: true,
 	}
 	if req.Department != "" {
 		user.Department.String = req.Department
@@ -89,10 +104,29 @@ func (s *AdminService) UpdateUser(userUUID string, req *UpdateUserRequest, opera
 		zap.String("user_uuid", userUUID), zap.Int64("operator_id", operatorID))
 
 	var user model.User
-	if err := repository.DB.Where("uuid = ? AND deleted_at IS NULL", userUUID).First(&user).Error; err != nil {
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
 		logger.Info("update_user: user not found", zap.String("user_uuid", userUUID))
 		return model.CodeParamError, "user not found"
 	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return code, msg
+	}
+	// 不能管理范围外的用户，也不能把用户调到范围外的部门
+	departments := []string{user.Department.String}
+	if req.Department != nil {
+		departments = append(departments, *req.Department)
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, departments...); code != 0 {
+		return code, msg
+	}
+	if req.Role != nil && *req.Role != user.Role {
+		if !roleAssignable(*req.Role) {
+			return model.CodeParamError, "unknown role"
+		}
+		if code, msg := s.roleWithin(operatorID, *req.Role); code != 0 {
+			return code, msg
+		}
+	}
 
 	before := user
 
@@ -132,10 +166,19 @@ func (s *AdminService) UpdateUser(userUUID string, req *UpdateUserRequest, opera
 
 func (s *AdminService) ResetPassword(userUUID string, operatorID int64) (string, int, string) {
 	var user model.User
-	if err := repository.DB.Where("uuid = ? AND deleted_at IS NULL", userUUID).First(&user).Error; err != nil {
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
 		logger.Info("reset_password: user not found", zap.String("user_uuid", userUUID), zap.Int64("operator_id", operatorID))
 		return "", model.CodeParamError, "user not found"
 	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return "", code, msg
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, user.Department.String); code != 0 {
+		return "", code, msg
+	}
+	if user.AuthSource != model.AuthSourceLocal {
+		return "", model.CodeParamError, "password is managed by the company directory"
+	}
 
 	password, err := crypto.GenerateRandomPassword(16)
 	if err != nil {
@@ -148,9 +191,11 @@ func (s *AdminService) ResetPassword(userUUID string, operatorID int64) (string,
 		return "", model.CodeInternalError, "failed to hash password"
 	}
 
+	// 随机密码仅用于下次登录，登录后必须先改密
 	if err := repository.DB.Model(&user).Updates(map[string]interface{}{
-		"password_hash": hash,
-		"updated_at":    time.Now(),
+		"password_hash":        hash,
+		"must_change_password": true,
+		"updated_at":           time.Now(),
 	}).Error; err != nil {
 		logger.Error("reset_password: db update failed", zap.Error(err), zap.String("user_uuid", userUUID))
 		return "", model.CodeInternalError, "failed to update password"
@@ -168,11 +213,152 @@ func (s *AdminService) ResetPassword(userUUID string, operatorID int64) (string,
 	return password, 0, ""
 }
 
-func (s *AdminService) ListUsers(page, pageSize int, role, status, search string) ([]model.User, int64) {
+// ListUserSessions 某用户的在线会话，供管理员远程踢下线
+func (s *AdminService) ListUserSessions(userUUID string, operatorID int64) ([]SessionInfo, int, string) {
+	var user model.User
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
+		return nil, model.CodeParamError, "user not found"
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, user.Department.String); code != 0 {
+		return nil, code, msg
+	}
+	items, err := listSessions(s.sessionStore, user.ID, uuid.Nil)
+	if err != nil {
+		logger.Error("list_user_sessions: query failed", zap.Error(err), zap.String("user_uuid", userUUID))
+		return nil, model.CodeInternalError, "failed to list sessions"
+	}
+	return items, 0, ""
+}
+
+// RevokeUserSession 吊销某用户的单个会话（如丢失的手机），不影响其他设备和账户状态
+func (s *AdminService) RevokeUserSession(userUUID, id string, operatorID int64) (int, string) {
+	sessionID, err := uuid.Parse(id)
+	if err != nil {
+		return model.CodeParamError, "invalid session id"
+	}
+	var user model.User
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
+		logger.Info("revoke_user_session: user not found", zap.String("user_uuid", userUUID), zap.Int64("operator_id", operatorID))
+		return model.CodeParamError, "user not found"
+	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return code, msg
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, user.Department.String); code != 0 {
+		return code, msg
+	}
+
+	found, err := s.sessionStore.DeleteForUser(user.ID, sessionID)
+	if err != nil {
+		logger.Error("revoke_user_session: delete failed", zap.Error(err), zap.String("user_uuid", userUUID))
+		return model.CodeInternalError, "failed to revoke session"
+	}
+	if !found {
+		return model.CodeParamError, "session not found"
+	}
+
+	s.logOperation(operatorID, "revoke_session", "user", user.ID, map[string]interface{}{"session": id}, nil)
+	logger.Info("revoke_user_session success",
+		zap.String("user_uuid", userUUID),
+		zap.String("session", id),
+		zap.Int64("operator_id", operatorID),
+	)
+
+	return 0, ""
+}
+
+// UnlockUser 解除连续登录失败造成的锁定，并清零失败计数与锁定次数
+func (s *AdminService) UnlockUser(userUUID string, operatorID int64) (int, string) {
+	var user model.User
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
+		logger.Info("unlock_user: user not found", zap.String("user_uuid", userUUID), zap.Int64("operator_id", operatorID))
+		return model.CodeParamError, "user not found"
+	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return code, msg
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, user.Department.String); code != 0 {
+		return code, msg
+	}
+
+	before := map[string]interface{}{
+		"failed_login_count": user.FailedLoginCount,
+		"lockout_count":      user.LockoutCount,
+		"locked_until":       user.LockedUntil,
+	}
+	if err := repository.DB.Model(&user).Updates(map[string]interface{}{
+		"failed_login_count": 0,
+		"lockout_count":      0,
+		"locked_until":       nil,
+	}).Error; err != nil {
+		logger.Error("unlock_user: db update failed", zap.Error(err), zap.String("user_uuid", userUUID))
+		return model.CodeInternalError, "failed to unlock user"
+	}
+
+	s.logOperation(operatorID, "unlock_user", "user", user.ID, before, nil)
+	logger.Info("unlock_user success",
+		zap.String("user_uuid", userUUID),
+		zap.Int64("user_id", user.ID),
+		zap.Int64("operator_id", operatorID),
+	)
+
+	return 0, ""
+}
+
+// ResetMFA 清除用户的 TOTP 绑定与恢复码（如手机丢失），下次登录需重新绑定；同时踢下线
+func (s *AdminService) ResetMFA(userUUID string, operatorID int64) (int, string) {
+	var user model.User
+	if err := repository.DB.Where("uuid = ? AND role <> ? AND deleted_at IS NULL", userUUID, model.RoleService).First(&user).Error; err != nil {
+		logger.Info("reset_mfa: user not found", zap.String("user_uuid", userUUID), zap.Int64("operator_id", operatorID))
+		return model.CodeParamError, "user not found"
+	}
+	if code, msg := s.roleWithin(operatorID, user.Role); code != 0 {
+		return code, msg
+	}
+	if code, msg := s.checkDepartmentScope(operatorID, user.Department.String); code != 0 {
+		return code, msg
+	}
+
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.MFAChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&model.UserMFA{}).Error
+	})
+	if err != nil {
+		logger.Error("reset_mfa: db delete failed", zap.Error(err), zap.String("user_uuid", userUUID))
+		return model.CodeInternalError, "failed to reset mfa"
+	}
+
+	_ = s.sessionStore.DeleteByUserID(user.ID)
+	s.logOperation(operatorID, "reset_mfa", "user", user.ID, nil, nil)
+
+	logger.Info("reset_mfa success",
+		zap.String("user_uuid", userUUID),
+		zap.Int64("user_id", user.ID),
+		zap.Int64("operator_id", operatorID),
+	)
+
+	return 0, ""
+}
+
+// ListUsers 用户列表，受部门范围限制的操作者只能看到范围内部门的用户
+func (s *AdminService) ListUsers(operatorID int64, page, pageSize int, role, status, search string) ([]model.User, int64, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, 0, err
+	}
 	query := repository.DB.Model(&model.User{}).Where("deleted_at IS NULL")
+	query = scopeUsers(query, scope, "id")
 
+	// 服务账号在「服务账号」页面单独管理
 	if role != "" {
 		query = query.Where("role = ?", role)
+	} else {
+		query = query.Where("role <> ?", model.RoleService)
 	}
 	if status != "" {
 		query = query.Where("status = ?", status)
@@ -185,20 +371,34 @@ func (s *AdminService) ListUsers(page, pageSize int, role, status, search string
 	query.Count(&total)
 
 	var users []model.User
-	query.Order("created_at DESC").
+	if err := query.Order("created_at DESC").
 		Offset((page - 1) * pageSize).
 		Limit(pageSize).
-		Find(&users)
+		Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
 
 	for i := range users {
 		users[i].PhoneMasked = crypto.MaskPhone(users[i].Phone)
 	}
+	if err := fillAdminScopes(users); err != nil {
+		logger.Error("list_users: load admin scopes failed", zap.Error(err))
+	}
 
-	return users, total
+	return users, total, nil
 }
 
 // ==================== Device Management ====================
 
+// ListDevices 锁具列表，受管线范围限制的操作者只能看到范围内的锁具
+func (s *AdminService) ListDevices(operatorID int64, page, pageSize int, status *int16, pipelineTag, search string) ([]model.Device, int64, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return listDevices(page, pageSize, status, pipelineTag, search, scope)
+}
+
 type CreateDeviceRequest struct {
 	DeviceID     string   `json:"device_id" binding:"required,max=32"`
 	Name         string   `json:"name" binding:"required,max=100"`
@@ -207,20 +407,73 @@ type CreateDeviceRequest struct {
 	Latitude     *float64 `json:"latitude"`
 	PipelineTag  string   `json:"pipeline_tag"`
 	RiskLevel    int16    `json:"risk_level" binding:"required,oneof=1 2 3"`
-	DeviceKey    string   `json:"device_key" binding:"required"` // hex-encoded K_d
+	// hex 编码的 K_d；为空由服务端随机生成，并返回加密给产线传输公钥的灌装包（推荐，明文不经过浏览器）
+	DeviceKey string `json:"device_key"`
+	// 锁具固件的 MAC 算法，默认 aes-cmac；老固件登记为 hmac-sha256
+	MACAlgorithm string `json:"mac_algorithm" binding:"omitempty,oneof=aes-cmac hmac-sha256"`
+}
+
+// CreateDeviceResponse 服务端生成密钥时附带灌装包，其余字段与设备一致
+type CreateDeviceResponse struct {
+	*model.Device
+	ProvisioningBundle *kms.ProvisioningBundle `json:"provisioning_bundle,omitempty"`
 }
 
-func (s *AdminService) CreateDevice(req *CreateDeviceRequest, operatorID int64) (*model.Device, int, string) {
+func (s *AdminService) CreateDevice(req *CreateDeviceRequest, operatorID int64) (*CreateDeviceResponse, int, string) {
 	logger.Debug("create_device start", zap.String("device_id", req.DeviceID), zap.String("name", req.Name), zap.Int64("operator_id", operatorID))
-	keyHex := strings.TrimSpace(strings.ReplaceAll(req.DeviceKey, " ", ""))
-	if len(keyHex) != 32 {
-		return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（AES-128，如 0123456789abcdef0123456789abcdef）"
+
+	// 受管线范围限制的操作者只能在范围内的管线下登记锁具，不带管线的也不行
+	scope, code, msg := s.operatorScope(operatorID)
+	if code != 0 {
+		return nil, code, msg
 	}
-	keyBytes, err := decodeHexKey(keyHex)
-	if err != nil {
-		return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（仅含 0-9、a-f）"
+	if !valuesWithin([]string{req.PipelineTag}, scope.PipelineTags) {
+		logger.Info("create_device: pipeline out of scope",
+			zap.String("pipeline_tag", req.PipelineTag), zap.Int64("operator_id", operatorID))
+		return nil, model.CodeForbidden, "target is outside your admin scope"
+	}
+
+	macAlgorithm := req.MACAlgorithm
+	if macAlgorithm == "" {
+		macAlgorithm = model.MACAlgorithmCMAC
+	}
+
+	var keyBytes []byte
+	var bundle *kms.ProvisioningBundle
+	if req.DeviceKey == "" {
+		if s.provisionKey == nil {
+			return nil, model.CodeParamError, "未配置产线传输公钥，无法由服务端生成设备密钥，请填写 device_key"
+		}
+		keyBytes = make([]byte, 16)
+		if _, err := rand.Read(keyBytes); err != nil {
+			logger.Error("create_device: generate key failed", zap.Error(err))
+			return nil, model.CodeInternalError, "failed to generate device key"
+		}
+		defer clearBytes(keyBytes)
+
+		// 先生成灌装包再入库：包生成失败不会留下一个密钥无处可取的设备
+		b, err := kms.SealProvisioningBundle(s.provisionKey, keyBytes, kms.ProvisioningPayload{
+			DeviceID:     req.DeviceID,
+			KeyVersion:   1,
+			MACAlgorithm: macAlgorithm,
+		})
+		if err != nil {
+			logger.Error("create_device: seal provisioning bundle failed", zap.Error(err), zap.String("device_id", req.DeviceID))
+			return nil, model.CodeInternalError, "failed to build provisioning bundle"
+		}
+		bundle = b
+	} else {
+		keyHex := strings.TrimSpace(strings.ReplaceAll(req.DeviceKey, " ", ""))
+		if len(keyHex) != 32 {
+			return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（AES-128，如 0123456789abcdef0123456789abcdef）"
+		}
+		b, err := decodeHexKey(keyHex)
+		if err != nil {
+			return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（仅含 0-9、a-f）"
+		}
+		keyBytes = b
+		defer clearBytes(keyBytes)
 	}
-	defer clearBytes(keyBytes)
 
 	encrypted, err := kms.Get().EncryptDeviceKey(keyBytes)
 	if err != nil {
@@ -237,6 +490,7 @@ func (s *AdminService) CreateDevice(req *CreateDeviceRequest, operatorID int64)
 		RiskLevel:    req.RiskLevel,
 		KeyEncrypted: encrypted,
 		KeyVersion:   1,
+		MACAlgorithm: macAlgorithm,
 		Status:       1,
 	}
 	if req.PipelineTag != "" {
@@ -249,12 +503,113 @@ func (s *AdminService) CreateDevice(req *CreateDeviceRequest, operatorID int64)
 		return nil, model.CodeInternalError, "failed to create device"
 	}
 
-	logger.Info("create_device success", zap.String("device_id", device.DeviceID), zap.Int64("id", device.ID))
-	s.logOperation(operatorID, "create_device", "device", device.ID, nil, map[string]interface{}{
-		"device_id": device.DeviceID, "name": device.Name,
-	})
+	logger.Info("create_device success", zap.String("device_id", device.DeviceID), zap.Int64("id", device.ID),
+		zap.Bool("server_generated_key", bundle != nil))
+	newValue := map[string]interface{}{
+		"device_id": device.DeviceID, "name": device.Name, "mac_algorithm": device.MACAlgorithm,
+		"key_source": "imported",
+	}
+	if bundle != nil {
+		newValue["key_source"] = "generated"
+		newValue["bundle_recipient"] = bundle.Recipient
+		newValue["key_checksum"] = bundle.Checksum
+	}
+	s.logOperation(operatorID, "create_device", "device", device.ID, nil, newValue)
+
+	return &CreateDeviceResponse{Device: device, ProvisioningBundle: bundle}, 0, ""
+}
 
-	return device, 0, ""
+type RotateDeviceKeyRequest struct {
+	// 可选：hex 编码的新 K_d；为空由服务端随机生成（推荐，明文不经过浏览器）
+	DeviceKey string `json:"device_key"`
+}
+
+type RotateDeviceKeyResponse struct {
+	DeviceID          string `json:"device_id"`
+	KeyVersion        int16  `json:"key_version"`
+	PendingKeyVersion int16  `json:"pending_key_version"`
+}
+
+/*
+RotateDeviceKey 发起设备密钥轮换：
+新密钥加密后存入 pending_key_*，旧密钥继续可用；锁具下次挑战时收到用旧密钥包装的换钥报文，
+换钥后以新版本签名回执，LockService 据此转正新密钥并作废旧密钥。
+*/
+func (s *AdminService) RotateDeviceKey(deviceID string, req *RotateDeviceKeyRequest, operatorID int64) (*RotateDeviceKeyResponse, int, string) {
+	logger.Info("rotate_device_key: start", zap.String("device_id", deviceID), zap.Int64("operator_id", operatorID))
+
+	var device model.Device
+	if err := repository.DB.Where("device_id = ? AND deleted_at IS NULL", deviceID).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.CodeDeviceNotFound, "device not found"
+		}
+		logger.Error("rotate_device_key: device query failed", zap.Error(err), zap.String("device_id", deviceID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if code, msg := s.checkScope(operatorID, device.DeviceID, nil); code != 0 {
+		return nil, code, msg
+	}
+	if device.PendingKeyVersion != nil {
+		logger.Info("rotate_device_key: rotation already pending",
+			zap.String("device_id", deviceID), zap.Int16("pending_key_version", *device.PendingKeyVersion))
+		return nil, model.CodeParamError, "该设备已有待锁具确认的密钥轮换"
+	}
+
+	var keyBytes []byte
+	if req.DeviceKey != "" {
+		keyHex := strings.TrimSpace(strings.ReplaceAll(req.DeviceKey, " ", ""))
+		if len(keyHex) != 32 {
+			return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（AES-128）"
+		}
+		b, err := decodeHexKey(keyHex)
+		if err != nil {
+			return nil, model.CodeParamError, "设备密钥须为 32 位十六进制（仅含 0-9、a-f）"
+		}
+		keyBytes = b
+	} else {
+		keyBytes = make([]byte, 16)
+		if _, err := rand.Read(keyBytes); err != nil {
+			logger.Error("rotate_device_key: generate key failed", zap.Error(err))
+			return nil, model.CodeInternalError, "failed to generate device key"
+		}
+	}
+	defer clearBytes(keyBytes)
+
+	encrypted, err := kms.Get().EncryptDeviceKey(keyBytes)
+	if err != nil {
+		logger.Error("rotate_device_key: KMS encrypt failed", zap.Error(err))
+		return nil, model.CodeInternalError, "failed to encrypt device key"
+	}
+
+	newVersion := device.KeyVersion + 1
+	result := repository.DB.Model(&model.Device{}).
+		Where("id = ? AND pending_key_version IS NULL", device.ID).
+		Updates(map[string]interface{}{
+			"pending_key_encrypted": encrypted,
+			"pending_key_version":   newVersion,
+			"updated_at":            time.Now(),
+		})
+	if result.Error != nil {
+		logger.Error("rotate_device_key: db update failed", zap.Error(result.Error), zap.String("device_id", deviceID))
+		return nil, model.CodeInternalError, "failed to rotate device key"
+	}
+	if result.RowsAffected == 0 {
+		return nil, model.CodeParamError, "该设备已有待锁具确认的密钥轮换"
+	}
+
+	s.logOperation(operatorID, "rotate_device_key", "device", device.ID,
+		map[string]interface{}{"device_id": device.DeviceID, "key_version": device.KeyVersion},
+		map[string]interface{}{"device_id": device.DeviceID, "key_version": device.KeyVersion, "pending_key_version": newVersion,
+			"server_generated": req.DeviceKey == ""})
+	logger.Info("rotate_device_key: pending key stored, waiting for lock confirmation",
+		zap.String("device_id", deviceID), zap.Int16("key_version", device.KeyVersion),
+		zap.Int16("pending_key_version", newVersion), zap.Int64("operator_id", operatorID))
+
+	return &RotateDeviceKeyResponse{
+		DeviceID:          device.DeviceID,
+		KeyVersion:        device.KeyVersion,
+		PendingKeyVersion: newVersion,
+	}, 0, ""
 }
 
 // ==================== Permission Management ====================
@@ -265,6 +620,8 @@ type GrantPermissionRequest struct {
 	DeviceType string     `json:"device_type"`                         // 可选，默认 lock
 	ValidFrom  time.Time  `json:"valid_from" binding:"required"`
 	ValidUntil *time.Time `json:"valid_until"`
+	// 可选：周期性时段规则，如周一至周五 08:00~18:00（Asia/Shanghai）
+	Schedule *model.PermissionSchedule `json:"schedule"`
 }
 
 type BatchGrantRequest struct {
@@ -283,11 +640,27 @@ func (s *AdminService) GrantPermission(req *GrantPermissionRequest, operatorID i
 		zap.Int64("operator_id", operatorID),
 		zap.Time("valid_from", req.ValidFrom),
 	)
+	if req.Schedule != nil {
+		if err := req.Schedule.Validate(); err != nil {
+			logger.Info("grant_permission 400: schedule 不合法", zap.Int64("user_id", req.UserID), zap.Error(err))
+			return model.CodeParamError, "时段规则不合法: " + err.Error()
+		}
+		if len(req.Schedule.Rules) == 0 {
+			req.Schedule = nil
+		}
+	}
+	// 续期已有授权同样校验：设备与被授权用户都须在操作者的管辖范围内
+	if code, msg := s.checkScope(operatorID, req.DeviceID, &req.UserID); code != 0 {
+		return code, msg
+	}
 	var existing model.Permission
 	err := repository.DB.Where("user_id = ? AND device_type = ? AND device_id = ? AND status = 1", req.UserID, deviceType, req.DeviceID).First(&existing).Error
 	if err == nil {
-		logger.Debug("grant_permission found existing, updating valid_until", zap.Int64("perm_id", existing.ID))
-		if err := repository.DB.Model(&existing).Update("valid_until", req.ValidUntil).Error; err != nil {
+		logger.Debug("grant_permission found existing, updating valid_until and schedule", zap.Int64("perm_id", existing.ID))
+		if err := repository.DB.Model(&existing).Updates(map[string]interface{}{
+			"valid_until": req.ValidUntil,
+			"schedule":    req.Schedule,
+		}).Error; err != nil {
 			logger.Error("grant_permission update failed", zap.Error(err), zap.Int64("user_id", req.UserID), zap.String("device_id", req.DeviceID))
 			return model.CodeInternalError, "更新授权失败"
 		}
@@ -298,7 +671,7 @@ func (s *AdminService) GrantPermission(req *GrantPermissionRequest, operatorID i
 			return model.CodeInternalError, "查询授权失败"
 		}
 		var userCnt int64
-		if repository.DB.Model(&model.User{}).Where("id = ? AND deleted_at IS NULL", req.UserID).Count(&userCnt).Error != nil || userCnt == 0 {
+		if repository.DB.Model(&model.User{}).Where("id = ? AND role <> ? AND deleted_at IS NULL", req.UserID, model.RoleService).Count(&userCnt).Error != nil || userCnt == 0 {
 			logger.Info("grant_permission 400: 用户不存在", zap.Int64("user_id", req.UserID), zap.String("device_id", req.DeviceID))
 			return model.CodeParamError, "用户不存在，请填写用户管理中的用户 ID（数字）"
 		}
@@ -317,6 +690,7 @@ func (s *AdminService) GrantPermission(req *GrantPermissionRequest, operatorID i
 			GrantedBy:  operatorID,
 			ValidFrom:  req.ValidFrom,
 			ValidUntil: req.ValidUntil,
+			Schedule:   req.Schedule,
 			Status:     1,
 		}
 		logger.Debug("grant_permission creating new permission")
@@ -343,6 +717,15 @@ func (s *AdminService) RevokePermission(permID int64, operatorID int64) (int, st
 	logger.Info("revoke_permission: start",
 		zap.Int64("perm_id", permID), zap.Int64("operator_id", operatorID))
 
+	var perm model.Permission
+	if err := repository.DB.Select("id, user_id, device_id").Where("id = ? AND status = 1", permID).First(&perm).Error; err != nil {
+		logger.Info("revoke_permission: not found or already revoked", zap.Int64("perm_id", permID))
+		return model.CodeParamError, "permission not found or already revoked"
+	}
+	if code, msg := s.checkScope(operatorID, perm.DeviceID, &perm.UserID); code != 0 {
+		return code, msg
+	}
+
 	result := repository.DB.Model(&model.Permission{}).
 		Where("id = ? AND status = 1", permID).
 		Updates(map[string]interface{}{
@@ -379,6 +762,9 @@ func (s *AdminService) HandleAlert(alertID int64, req *HandleAlertRequest, opera
 		logger.Info("handle_alert: not found or already handled", zap.Int64("alert_id", alertID))
 		return model.CodeParamError, "alert not found or already handled"
 	}
+	if code, msg := s.checkScope(operatorID, alert.DeviceID, alert.UserID); code != 0 {
+		return code, msg
+	}
 
 	err := repository.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Model(&alert).Updates(map[string]interface{}{
@@ -412,8 +798,15 @@ func (s *AdminService) HandleAlert(alertID int64, req *HandleAlertRequest, opera
 	return 0, ""
 }
 
-func (s *AdminService) ListAlerts(status *int16, deviceID string, severity *int16, page, pageSize int) ([]model.Alert, int64) {
+// ListAlerts 按操作者管辖范围过滤：受限维度上缺少设备或用户的告警不展示
+func (s *AdminService) ListAlerts(operatorID int64, status *int16, deviceID string, severity *int16, page, pageSize int) ([]model.Alert, int64, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, 0, err
+	}
 	query := repository.DB.Model(&model.Alert{})
+	query = scopeDevices(query, scope, "device_id")
+	query = scopeUsers(query, scope, "user_id")
 
 	if status != nil {
 		query = query.Where("status = ?", *status)
@@ -429,12 +822,12 @@ func (s *AdminService) ListAlerts(status *int16, deviceID string, severity *int1
 	query.Count(&total)
 
 	var alerts []model.Alert
-	query.Order("created_at DESC").
+	err = query.Order("created_at DESC").
 		Offset((page - 1) * pageSize).
 		Limit(pageSize).
-		Find(&alerts)
+		Find(&alerts).Error
 
-	return alerts, total
+	return alerts, total, err
 }
 
 // ==================== Dashboard ====================
@@ -448,23 +841,38 @@ type DashboardData struct {
 	DevicesByStatus map[string]int64 `json:"devices_by_status"`
 }
 
-func (s *AdminService) GetDashboard() (*DashboardData, error) {
+// GetDashboard 统计与最近告警按操作者管辖范围过滤，口径与 ListUsers、ListDevices、ListAlerts 一致
+func (s *AdminService) GetDashboard(operatorID int64) (*DashboardData, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, err
+	}
 	data := &DashboardData{
 		DevicesByStatus: make(map[string]int64),
 	}
 
-	repository.DB.Model(&model.User{}).Where("deleted_at IS NULL AND status = 1").Count(&data.TotalUsers)
-	repository.DB.Model(&model.Device{}).Where("deleted_at IS NULL").Count(&data.TotalDevices)
+	scopeUsers(repository.DB.Model(&model.User{}), scope, "id").
+		Where("deleted_at IS NULL AND status = 1 AND role <> ?", model.RoleService).Count(&data.TotalUsers)
+	scopeDevices(repository.DB.Model(&model.Device{}), scope, "device_id").Where("deleted_at IS NULL").Count(&data.TotalDevices)
 
-	var err error
-	data.ActiveSessions, err = s.sessionStore.CountActive()
+	if len(scope.Departments) > 0 {
+		err = scopeUsers(repository.DB.Model(&model.Session{}), scope, "user_id").
+			Where("expires_at > ?", time.Now()).Count(&data.ActiveSessions).Error
+	} else {
+		data.ActiveSessions, err = s.sessionStore.CountActive()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	repository.DB.Model(&model.Alert{}).Where("status = 0").Count(&data.PendingAlerts)
+	scopeAlerts := func() *gorm.DB {
+		query := repository.DB.Model(&model.Alert{})
+		query = scopeDevices(query, scope, "device_id")
+		return scopeUsers(query, scope, "user_id")
+	}
+	scopeAlerts().Where("status = 0").Count(&data.PendingAlerts)
 
-	repository.DB.Model(&model.Alert{}).
+	scopeAlerts().
 		Where("status = 0").
 		Order("created_at DESC").
 		Limit(10).
@@ -474,7 +882,7 @@ func (s *AdminService) GetDashboard() (*DashboardData, error) {
 		Status int16
 		Count  int64
 	}
-	repository.DB.Model(&model.Device{}).
+	scopeDevices(repository.DB.Model(&model.Device{}), scope, "device_id").
 		Select("status, count(*) as count").
 		Where("deleted_at IS NULL").
 		Group("status").
@@ -494,8 +902,14 @@ func (s *AdminService) GetDashboard() (*DashboardData, error) {
 
 // ==================== Audit Logs ====================
 
-func (s *AdminService) ListAuditLogs(userID *int64, deviceID, action string, startTime, endTime *time.Time, cursor string, limit int) (*model.PagedData, error) {
+func (s *AdminService) ListAuditLogs(operatorID int64, userID *int64, deviceID, action string, startTime, endTime *time.Time, cursor string, limit int) (*model.PagedData, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, err
+	}
 	query := repository.DB.Model(&model.AuditLog{})
+	query = scopeDevices(query, scope, "device_id")
+	query = scopeUsers(query, scope, "user_id")
 
 	if userID != nil {
 		query = query.Where("user_id = ?", *userID)
@@ -517,7 +931,60 @@ func (s *AdminService) ListAuditLogs(userID *int64, deviceID, action string, sta
 	}
 
 	var logs []model.AuditLog
-	err := query.Order("occurred_at DESC").
+	err = query.Order("occurred_at DESC").
+		Limit(limit + 1).
+		Find(&logs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(logs) > limit
+	if hasMore {
+		logs = logs[:limit]
+	}
+
+	nextCursor := ""
+	if hasMore && len(logs) > 0 {
+		nextCursor = logs[len(logs)-1].OccurredAt.Format(time.RFC3339Nano)
+	}
+
+	return &model.PagedData{
+		Items:      logs,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}, nil
+}
+
+// ListLoginLogs 登录审计，与 ListAuditLogs 一样按 occurred_at 游标翻页；受部门范围限制时只含范围内用户的记录
+func (s *AdminService) ListLoginLogs(operatorID int64, userID *int64, result, clientIP string, startTime, endTime *time.Time, cursor string, limit int) (*model.PagedData, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, err
+	}
+	query := repository.DB.Model(&model.LoginLog{})
+	query = scopeUsers(query, scope, "user_id")
+
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if result != "" {
+		query = query.Where("result = ?", result)
+	}
+	if clientIP != "" {
+		query = query.Where("client_ip = ?::inet", clientIP)
+	}
+	if startTime != nil {
+		query = query.Where("occurred_at >= ?", *startTime)
+	}
+	if endTime != nil {
+		query = query.Where("occurred_at <= ?", *endTime)
+	}
+	if cursor != "" {
+		query = query.Where("occurred_at < ?", cursor)
+	}
+
+	var logs []model.LoginLog
+	err = query.Order("occurred_at DESC").
 		Limit(limit + 1).
 		Find(&logs).Error
 	if err != nil {
@@ -543,8 +1010,14 @@ func (s *AdminService) ListAuditLogs(userID *int64, deviceID, action string, sta
 
 // ==================== Operation Logs ====================
 
-func (s *AdminService) ListPermissions(userID *int64, deviceID *string, status *int16, page, pageSize int) ([]model.Permission, int64) {
+func (s *AdminService) ListPermissions(operatorID int64, userID *int64, deviceID *string, status *int16, page, pageSize int) ([]model.Permission, int64, error) {
+	scope, err := loadAdminScope(operatorID)
+	if err != nil {
+		return nil, 0, err
+	}
 	query := repository.DB.Model(&model.Permission{}).Preload("User")
+	query = scopeDevices(query, scope, "device_id")
+	query = scopeUsers(query, scope, "user_id")
 
 	if userID != nil {
 		query = query.Where("user_id = ?", *userID)
@@ -560,17 +1033,22 @@ func (s *AdminService) ListPermissions(userID *int64, deviceID *string, status *
 	query.Count(&total)
 
 	var perms []model.Permission
-	query.Order("created_at DESC").
+	err = query.Order("created_at DESC").
 		Offset((page - 1) * pageSize).
 		Limit(pageSize).
-		Find(&perms)
+		Find(&perms).Error
 
-	return perms, total
+	return perms, total, err
 }
 
 // ==================== Helpers ====================
 
 func (s *AdminService) logOperation(operatorID int64, action, targetType string, targetID int64, before, after interface{}) {
+	writeOperationLog(operatorID, action, targetType, targetID, before, after)
+}
+
+// writeOperationLog 写 log.operation_logs；AdminService 以外（如锁具确认换钥）也经由此处记录。
+func writeOperationLog(operatorID int64, action, targetType string, targetID int64, before, after interface{}) {
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error("logOperation panic", zap.Any("panic", r), zap.String("action", action))
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"promthus/internal/config"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type ldapAuthenticator struct {
+	cfg  *config.LDAPConfig
+	dial func() (ldapConn, error)
+}
+
+// ldapConn 登录流程用到的 *ldap.Conn 方法，测试中替换为内存目录
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// ldapEntry Resolve 查到的用户条目中登录需要的部分
+type ldapEntry struct {
+	DN         string
+	ExternalID string
+	Name       string
+	Department string
+	Phone      string
+	Groups     []string
+}
+
+func NewLDAPAuthenticator(cfg *config.LDAPConfig) (Authenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required for the ldap auth backend")
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, errors.New("LDAP_USER_FILTER must contain %s for the login name")
+	}
+	for _, m := range cfg.GroupRoles {
+		if !roleAssignable(m.Value) {
+			return nil, fmt.Errorf("LDAP_GROUP_ROLES: unknown role %q for group %q", m.Value, m.Group)
+		}
+	}
+	if cfg.DefaultRole != "" && !roleAssignable(cfg.DefaultRole) {
+		return nil, fmt.Errorf("LDAP_DEFAULT_ROLE: unknown role %q", cfg.DefaultRole)
+	}
+	if len(cfg.GroupRoles) == 0 && cfg.DefaultRole == "" {
+		return nil, errors.New("LDAP_GROUP_ROLES or LDAP_DEFAULT_ROLE must be set, otherwise no directory user can sign in")
+	}
+	a := &ldapAuthenticator{cfg: cfg}
+	a.dial = a.dialDirectory
+	return a, nil
+}
+
+func (a *ldapAuthenticator) Name() string { return model.AuthSourceLDAP }
+
+// Resolve 以查询账号搜索登录名；目录中不存在或不唯一时不认领
+func (a *ldapAuthenticator) Resolve(login string) (*Identity, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	attrs := []string{a.cfg.IDAttr, a.cfg.NameAttr, a.cfg.DepartmentAttr, a.cfg.PhoneAttr, a.cfg.GroupAttr}
+	req := ldap.NewSearchRequest(a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(a.cfg.Timeout/time.Second), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(login)), attrs, nil)
+	result, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		if result != nil && len(result.Entries) > 1 {
+			logger.Warn("ldap: login name matches multiple entries, refusing", zap.String("filter", req.Filter))
+		}
+		return nil, nil
+	}
+
+	e := result.Entries[0]
+	entry := &ldapEntry{
+		DN:         e.DN,
+		ExternalID: a.externalID(e.GetRawAttributeValue(a.cfg.IDAttr)),
+		Name:       e.GetAttributeValue(a.cfg.NameAttr),
+		Department: e.GetAttributeValue(a.cfg.DepartmentAttr),
+		Phone:      e.GetAttributeValue(a.cfg.PhoneAttr),
+		Groups:     e.GetAttributeValues(a.cfg.GroupAttr),
+	}
+	if entry.ExternalID == "" {
+		logger.Warn("ldap: entry has no id attribute, refusing", zap.String("dn", e.DN), zap.String("attr", a.cfg.IDAttr))
+		return nil, nil
+	}
+	if entry.Name == "" {
+		entry.Name = login
+	}
+
+	id := &Identity{Login: login, entry: entry}
+	var user model.User
+	err = repository.DB.Where("auth_source = ? AND external_id = ? AND deleted_at IS NULL",
+		model.AuthSourceLDAP, entry.ExternalID).First(&user).Error
+	if err == nil {
+		id.User = &user
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Verify 以用户 DN + 密码 bind；通过后按组映射角色与部门，首次登录即时开通，之后同步变化
+func (a *ldapAuthenticator) Verify(id *Identity, password string) (*model.User, error) {
+	entry := id.entry.(*ldapEntry)
+	if password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	role := a.cfg.DefaultRole
+	if r, ok := matchGroup(a.cfg.GroupRoles, entry.Groups); ok {
+		role = r
+	}
+	if role == "" {
+		return nil, errNotEntitled
+	}
+	department := entry.Department
+	if d, ok := matchGroup(a.cfg.GroupDepartments, entry.Groups); ok {
+		department = d
+	}
+
+	if id.User == nil {
+		return a.provision(entry, role, department)
+	}
+	return a.sync(id.User, entry, role, department)
+}
+
+func (a *ldapAuthenticator) provision(entry *ldapEntry, role, department string) (*model.User, error) {
+	phone, err := a.provisionPhone(entry.Phone)
+	if err != nil {
+		return nil, err
+	}
+	externalID := entry.ExternalID
+	user := &model.User{
+		UUID:         uuid.New(),
+		Phone:        phone,
+		PasswordHash: "!",
+		Name:         truncateRunes(entry.Name, 50),
+		Role:         role,
+		Status:       1,
+		AuthSource:   model.AuthSourceLDAP,
+		ExternalID:   &externalID,
+	}
+	if department != "" {
+		user.Department.String = truncateRunes(department, 100)
+		user.Department.Valid = true
+	}
+	if err := repository.DB.Create(user).Error; err != nil {
+		return nil, fmt.Errorf("ldap provision: %w", err)
+	}
+
+	writeOperationLog(user.ID, "ldap_provision", "user", user.ID, nil, map[string]interface{}{
+		"dn": entry.DN, "role": role, "department": department,
+	})
+	logger.Info("ldap: user provisioned",
+		zap.Int64("user_id", user.ID), zap.String("dn", entry.DN), zap.String("role", role))
+	return user, nil
+}
+
+// sync 目录是姓名、部门、角色的权威来源，本地修改会在下次登录时被覆盖
+func (a *ldapAuthenticator) sync(user *model.User, entry *ldapEntry, role, department string) (*model.User, error) {
+	name := truncateRunes(entry.Name, 50)
+	department = truncateRunes(department, 100)
+	if user.Name == name && user.Role == role && user.Department.String == department {
+		return user, nil
+	}
+
+	before := map[string]interface{}{"name": user.Name, "role": user.Role, "department": user.Department.String}
+	updates := map[string]interface{}{
+		"name":       name,
+		"role":       role,
+		"department": department,
+		"updated_at": time.Now(),
+	}
+	if err := repository.DB.Model(user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("ldap sync: %w", err)
+	}
+	user.Name, user.Role = name, role
+	user.Department.String, user.Department.Valid = department, department != ""
+
+	writeOperationLog(user.ID, "ldap_sync", "user", user.ID, before, updates)
+	logger.Info("ldap: user attributes synced",
+		zap.Int64("user_id", user.ID), zap.String("role", role))
+	return user, nil
+}
+
+// provisionPhone 优先用目录中的手机号；缺失、过长或已被其他用户占用时生成 "ldap-" 占位值（不能用于本地登录）
+func (a *ldapAuthenticator) provisionPhone(phone string) (string, error) {
+	phone = strings.ReplaceAll(strings.TrimSpace(phone), " ", "")
+	if phone != "" && len(phone) <= 20 {
+		var cnt int64
+		if err := repository.DB.Model(&model.User{}).Where("phone = ? AND deleted_at IS NULL", phone).Count(&cnt).Error; err != nil {
+			return "", err
+		}
+		if cnt == 0 {
+			return phone, nil
+		}
+	}
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "ldap-" + hex.EncodeToString(b), nil
+}
+
+func (a *ldapAuthenticator) dialDirectory() (ldapConn, error) {
+	u, err := url.Parse(a.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_URL: %w", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.cfg.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// matchGroup 按配置顺序返回第一个命中的映射值；配置项可为完整 DN，也可只写组的 CN
+func matchGroup(mappings []config.GroupMapping, groups []string) (string, bool) {
+	for _, m := range mappings {
+		for _, g := range groups {
+			if strings.EqualFold(m.Group, g) || strings.EqualFold(m.Group, groupCN(g)) {
+				return m.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func groupCN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// externalID objectGUID 为 16 字节二进制，按 hex 存；entryUUID 等文本属性原样保存
+func (a *ldapAuthenticator) externalID(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	if strings.EqualFold(a.cfg.IDAttr, "objectGUID") {
+		return hex.EncodeToString(raw)
+	}
+	return truncateRunes(string(raw), 255)
+}
+
+// truncateRunes 按字符截断，避免截断在多字节字符中间
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
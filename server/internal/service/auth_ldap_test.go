@@ -0,0 +1,352 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testLDAPBindDN   = "cn=reader,dc=example,dc=com"
+	testLDAPBindPass = "reader-pass"
+	testLDAPAdmins   = "cn=lock-admins,ou=groups,dc=example,dc=com"
+	testLDAPAuditors = "cn=auditors,ou=groups,dc=example,dc=com"
+)
+
+// fakeDirectory 内存目录：只支持 (uid=...) 形式的过滤器，按 RFC 4515 解析转义与 * 通配
+type fakeDirectory struct {
+	entries   []*ldap.Entry
+	passwords map[string]string // DN → 密码
+	filters   []string
+	binds     []string
+	dials     int
+	closes    int
+}
+
+func (d *fakeDirectory) dial() (ldapConn, error) {
+	d.dials++
+	return d, nil
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	d.binds = append(d.binds, username)
+	// 与多数目录服务器一致：空密码按匿名（unauthenticated）bind 放行
+	if password == "" {
+		return nil
+	}
+	if username == testLDAPBindDN && password == testLDAPBindPass {
+		return nil
+	}
+	if want, ok := d.passwords[username]; ok && want == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.filters = append(d.filters, req.Filter)
+	if _, err := ldap.CompileFilter(req.Filter); err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultFilterError, err)
+	}
+	value, ok := strings.CutPrefix(req.Filter, "(uid=")
+	if !ok || !strings.HasSuffix(value, ")") {
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("unsupported filter "+req.Filter))
+	}
+	value = strings.TrimSuffix(value, ")")
+
+	result := &ldap.SearchResult{}
+	for _, e := range d.entries {
+		if !filterValueMatches(value, e.GetAttributeValue("uid")) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(result.Entries) == req.SizeLimit {
+			return result, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+		result.Entries = append(result.Entries, e)
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	d.closes++
+	return nil
+}
+
+// filterValueMatches 未转义的 * 为通配，其余部分按 \XX 解码后依次匹配
+func filterValueMatches(raw, attr string) bool {
+	parts := strings.Split(raw, "*")
+	for i, p := range parts {
+		parts[i] = unescapeFilterValue(p)
+	}
+	if len(parts) == 1 {
+		return parts[0] == attr
+	}
+	if !strings.HasPrefix(attr, parts[0]) {
+		return false
+	}
+	rest := attr[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(p):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+func unescapeFilterValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+2 < len(s) {
+			if v, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+				b.Write(v)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func testLDAPConfig() *config.LDAPConfig {
+	return &config.LDAPConfig{
+		URL:            "ldaps://dc.example.com",
+		BindDN:         testLDAPBindDN,
+		BindPassword:   testLDAPBindPass,
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		IDAttr:         "entryUUID",
+		NameAttr:       "cn",
+		DepartmentAttr: "department",
+		PhoneAttr:      "mobile",
+		GroupAttr:      "memberOf",
+		GroupRoles: []config.GroupMapping{
+			{Group: testLDAPAdmins, Value: "admin"},
+			{Group: "auditors", Value: "auditor"},
+		},
+		GroupDepartments: []config.GroupMapping{{Group: "lock-admins", Value: "运维部"}},
+		Timeout:          time.Second,
+	}
+}
+
+func newLDAPTestAuthenticator(dir *fakeDirectory) *ldapAuthenticator {
+	return &ldapAuthenticator{cfg: testLDAPConfig(), dial: dir.dial}
+}
+
+func ldapTestEntry(uid, id string, attrs map[string][]string) *ldap.Entry {
+	all := map[string][]string{"uid": {uid}, "entryUUID": {id}, "cn": {uid}}
+	for k, v := range attrs {
+		all[k] = v
+	}
+	return ldap.NewEntry("uid="+uid+",ou=people,dc=example,dc=com", all)
+}
+
+func TestUnescapeFilterValue(t *testing.T) {
+	if got := unescapeFilterValue(ldap.EscapeFilter(`a*b(c)\d`)); got != `a*b(c)\d` {
+		t.Fatalf("round trip = %q", got)
+	}
+}
+
+// 登录名中的过滤器元字符被转义，不能借通配或注入条件命中他人条目
+func TestLDAPResolveEscapesFilter(t *testing.T) {
+	dir := &fakeDirectory{entries: []*ldap.Entry{ldapTestEntry("alice", "id-alice", nil)}}
+	a := newLDAPTestAuthenticator(dir)
+
+	for _, login := range []string{"*", "a*", "alice)(uid=*", `alice\2a`} {
+		id, err := a.Resolve(login)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", login, err)
+		}
+		if id != nil {
+			t.Fatalf("Resolve(%q) claimed %+v", login, id)
+		}
+	}
+	want := []string{`(uid=\2a)`, `(uid=a\2a)`, `(uid=alice\29\28uid=\2a)`, `(uid=alice\5c2a)`}
+	if strings.Join(dir.filters, " ") != strings.Join(want, " ") {
+		t.Fatalf("filters = %q, want %q", dir.filters, want)
+	}
+	// 每次查询都以查询账号 bind 并关闭连接
+	if dir.dials != 4 || dir.closes != 4 || dir.binds[0] != testLDAPBindDN {
+		t.Fatalf("dials=%d closes=%d binds=%q", dir.dials, dir.closes, dir.binds)
+	}
+}
+
+func TestLDAPResolveRefusesMultipleEntries(t *testing.T) {
+	dir := &fakeDirectory{entries: []*ldap.Entry{
+		ldapTestEntry("bob", "id-bob-1", nil),
+		ldap.NewEntry("uid=bob,ou=contractors,dc=example,dc=com",
+			map[string][]string{"uid": {"bob"}, "entryUUID": {"id-bob-2"}}),
+	}}
+	id, err := newLDAPTestAuthenticator(dir).Resolve("bob")
+	if err != nil || id != nil {
+		t.Fatalf("Resolve(duplicate) = %+v, %v; want nil, nil", id, err)
+	}
+}
+
+func TestLDAPResolveRefusesEntryWithoutID(t *testing.T) {
+	dir := &fakeDirectory{entries: []*ldap.Entry{
+		ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{"uid": {"carol"}}),
+	}}
+	id, err := newLDAPTestAuthenticator(dir).Resolve("carol")
+	if err != nil || id != nil {
+		t.Fatalf("Resolve(no id) = %+v, %v; want nil, nil", id, err)
+	}
+}
+
+// 空密码在 bind 前拒绝：目录会把空密码 bind 当作匿名 bind 放行
+func TestLDAPVerifyRefusesEmptyPassword(t *testing.T) {
+	dir := &fakeDirectory{}
+	a := newLDAPTestAuthenticator(dir)
+	id := &Identity{Login: "alice", entry: &ldapEntry{DN: "uid=alice,ou=people,dc=example,dc=com", ExternalID: "id-alice"}}
+	if _, err := a.Verify(id, ""); !errors.Is(err, errInvalidCredentials) {
+		t.Fatalf("Verify(empty) err = %v, want errInvalidCredentials", err)
+	}
+	if dir.dials != 0 || len(dir.binds) != 0 {
+		t.Fatalf("directory contacted: dials=%d binds=%q", dir.dials, dir.binds)
+	}
+}
+
+func TestLDAPVerifyWrongPassword(t *testing.T) {
+	dn := "uid=alice,ou=people,dc=example,dc=com"
+	dir := &fakeDirectory{passwords: map[string]string{dn: "right"}}
+	id := &Identity{Login: "alice", entry: &ldapEntry{DN: dn, ExternalID: "id-alice"}}
+	if _, err := newLDAPTestAuthenticator(dir).Verify(id, "wrong"); !errors.Is(err, errInvalidCredentials) {
+		t.Fatalf("err = %v, want errInvalidCredentials", err)
+	}
+}
+
+// 不在任何映射组内且未配置默认角色时不开通
+func TestLDAPVerifyNotEntitled(t *testing.T) {
+	dn := "uid=dave,ou=people,dc=example,dc=com"
+	dir := &fakeDirectory{passwords: map[string]string{dn: "pw"}}
+	id := &Identity{Login: "dave", entry: &ldapEntry{DN: dn, ExternalID: "id-dave",
+		Groups: []string{"cn=staff,ou=groups,dc=example,dc=com"}}}
+	if _, err := newLDAPTestAuthenticator(dir).Verify(id, "pw"); !errors.Is(err, errNotEntitled) {
+		t.Fatalf("err = %v, want errNotEntitled", err)
+	}
+}
+
+func TestMatchGroup(t *testing.T) {
+	mappings := testLDAPConfig().GroupRoles
+	tests := []struct {
+		groups []string
+		want   string
+		ok     bool
+	}{
+		{[]string{testLDAPAdmins}, "admin", true},
+		{[]string{strings.ToUpper(testLDAPAdmins)}, "admin", true},
+		{[]string{testLDAPAuditors}, "auditor", true},               // 按 CN 配置
+		{[]string{testLDAPAuditors, testLDAPAdmins}, "admin", true}, // 按配置顺序取第一个
+		{[]string{"cn=staff,ou=groups,dc=example,dc=com"}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := matchGroup(mappings, tt.groups)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchGroup(%q) = %q, %v; want %q, %v", tt.groups, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLDAPExternalID(t *testing.T) {
+	a := &ldapAuthenticator{cfg: &config.LDAPConfig{IDAttr: "objectGUID"}}
+	if got := a.externalID([]byte{0xde, 0xad, 0xbe, 0xef}); got != "deadbeef" {
+		t.Fatalf("objectGUID = %q", got)
+	}
+	a.cfg.IDAttr = "entryUUID"
+	if got := a.externalID([]byte("1b2c")); got != "1b2c" {
+		t.Fatalf("entryUUID = %q", got)
+	}
+	if got := a.externalID(nil); got != "" {
+		t.Fatalf("empty = %q", got)
+	}
+}
+
+func TestNewLDAPAuthenticatorRequiresConfig(t *testing.T) {
+	for _, cfg := range []*config.LDAPConfig{
+		{BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"},
+		{URL: "ldaps://dc.example.com", UserFilter: "(uid=%s)"},
+		{URL: "ldaps://dc.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(uid=alice)"},
+	} {
+		if _, err := NewLDAPAuthenticator(cfg); err == nil {
+			t.Errorf("NewLDAPAuthenticator(%+v) accepted", cfg)
+		}
+	}
+}
+
+// 首次目录登录即时开通，之后按目录同步角色与部门
+func TestLDAPJustInTimeProvisioning(t *testing.T) {
+	testdb.Open(t)
+	taken := newTestUser(t, "user", "")
+
+	dn := "uid=erin,ou=people,dc=example,dc=com"
+	entry := ldapTestEntry("erin", "id-erin", map[string][]string{
+		"cn":         {"Erin"},
+		"department": {"财务部"},
+		"mobile":     {taken.Phone}, // 已被本地用户占用
+		"memberOf":   {testLDAPAdmins},
+	})
+	dir := &fakeDirectory{entries: []*ldap.Entry{entry}, passwords: map[string]string{dn: "erin-pass"}}
+	a := newLDAPTestAuthenticator(dir)
+
+	id, err := a.Resolve("erin")
+	if err != nil || id == nil || id.User != nil {
+		t.Fatalf("Resolve = %+v, %v; want new identity", id, err)
+	}
+	user, err := a.Verify(id, "erin-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AuthSource != model.AuthSourceLDAP || user.ExternalID == nil || *user.ExternalID != "id-erin" {
+		t.Fatalf("provisioned source=%q external=%v", user.AuthSource, user.ExternalID)
+	}
+	if user.Role != "admin" || user.Department.String != "运维部" || user.Name != "Erin" {
+		t.Fatalf("provisioned role=%q department=%q name=%q", user.Role, user.Department.String, user.Name)
+	}
+	if !strings.HasPrefix(user.Phone, "ldap-") || user.PasswordHash != "!" {
+		t.Fatalf("provisioned phone=%q hash=%q", user.Phone, user.PasswordHash)
+	}
+	if countOperationLogs(t, "ldap_provision", user.ID) != 1 {
+		t.Fatal("ldap_provision not logged once")
+	}
+
+	// 再次登录认领同一用户；组变化后角色与部门随之同步
+	entry.Attributes = ldapTestEntry("erin", "id-erin", map[string][]string{
+		"cn": {"Erin"}, "department": {"审计部"}, "memberOf": {testLDAPAuditors},
+	}).Attributes
+	id, err = a.Resolve("erin")
+	if err != nil || id == nil || id.User == nil || id.User.ID != user.ID {
+		t.Fatalf("second Resolve = %+v, %v; want existing user %d", id, err, user.ID)
+	}
+	synced, err := a.Verify(id, "erin-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.ID != user.ID || synced.Role != "auditor" || synced.Department.String != "审计部" {
+		t.Fatalf("synced id=%d role=%q department=%q", synced.ID, synced.Role, synced.Department.String)
+	}
+	if countOperationLogs(t, "ldap_sync", user.ID) != 1 {
+		t.Fatal("ldap_sync not logged once")
+	}
+
+	var n int64
+	repository.DB.Model(&model.User{}).Where("auth_source = ?", model.AuthSourceLDAP).Count(&n)
+	if n != 1 {
+		t.Fatalf("ldap users = %d, want 1", n)
+	}
+	// 目录用户不能经本地密码后端登录
+	if id, err := (localAuthenticator{}).Resolve(user.Phone); err != nil || id != nil {
+		t.Fatalf("local Resolve(ldap user) = %+v, %v", id, err)
+	}
+}
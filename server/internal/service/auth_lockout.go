@@ -0,0 +1,224 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/mq"
+	"promthus/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+/*
+账户级防爆破与登录审计：
+  - LoginRateLimit 只按 IP 限流，分布式慢速猜测同一手机号时由这里按账户累计连续失败（密码或两步验证码），
+    达到阈值即锁定，锁定时长随锁定次数翻倍，成功登录或管理员解锁后清零；
+  - 登录成功时与该用户的会话历史（在线会话 + 近 90 天成功登录）比对，新 IP 或新 User-Agent 产生 login_anomaly 告警；
+  - 每次登录尝试都写入登录审计流 log.login_logs。
+*/
+
+const (
+	loginActionLogin     = "login"
+	loginActionMFA       = "mfa_verify"
+	loginAnomalyWindow   = 90 * 24 * time.Hour
+	loginAnomalyCooldown = time.Hour
+)
+
+// 登录审计 result 取值
+const (
+	loginResultSuccess            = "success"
+	loginResultMFAChallenge       = "mfa_challenge"
+	loginResultInvalidCredentials = "invalid_credentials"
+	loginResultInvalidMFA         = "invalid_mfa"
+	loginResultAccountLocked      = "account_locked"
+	loginResultAccountDisabled    = "account_disabled"
+	loginResultNotEntitled        = "not_entitled" // 目录密码正确但未映射到任何角色
+)
+
+// lockedMessage 锁定提示带上解锁时间，便于用户判断等待还是联系管理员
+func lockedMessage(until time.Time) string {
+	return fmt.Sprintf("account locked due to repeated failed logins, retry after %s", until.Format(time.RFC3339))
+}
+
+func accountLockedUntil(user *model.User, now time.Time) (time.Time, bool) {
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		return *user.LockedUntil, true
+	}
+	return time.Time{}, false
+}
+
+// recordLoginFailure 累加连续失败次数，达到阈值时锁定账户并返回解锁时间。
+func (s *AuthService) recordLoginFailure(user *model.User, ipAddress string) (*time.Time, error) {
+	if s.cfg.LockoutThreshold <= 0 {
+		return nil, nil
+	}
+	var row struct {
+		FailedLoginCount int
+		LockoutCount     int
+		LockedUntil      *time.Time
+	}
+	// SET 中引用的都是旧值：第 n 次锁定时 lockout_count 旧值为 n-1，时长 = base * 2^(n-1)
+	err := repository.DB.Raw(`UPDATE app.users SET
+			failed_login_count = CASE WHEN failed_login_count + 1 >= ? THEN 0 ELSE failed_login_count + 1 END,
+			lockout_count = CASE WHEN failed_login_count + 1 >= ? THEN lockout_count + 1 ELSE lockout_count END,
+			locked_until = CASE WHEN failed_login_count + 1 >= ?
+				THEN NOW() + make_interval(secs => LEAST(? * POWER(2, LEAST(lockout_count, 30)), ?))
+				ELSE locked_until END
+		WHERE id = ?
+		RETURNING failed_login_count, lockout_count, locked_until`,
+		s.cfg.LockoutThreshold, s.cfg.LockoutThreshold, s.cfg.LockoutThreshold,
+		s.cfg.LockoutDuration.Seconds(), s.cfg.LockoutMaxDuration.Seconds(), user.ID).
+		Scan(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	if row.FailedLoginCount != 0 || row.LockedUntil == nil {
+		return nil, nil
+	}
+
+	logger.Warn("login: account locked after repeated failures",
+		zap.Int64("user_id", user.ID), zap.Int("lockout_count", row.LockoutCount),
+		zap.Time("locked_until", *row.LockedUntil), zap.String("ip", ipAddress))
+	s.raiseAccountAlert("account_locked", user, 2, 0, map[string]interface{}{
+		"user_uuid":     user.UUID.String(),
+		"lockout_count": row.LockoutCount,
+		"locked_until":  row.LockedUntil.Format(time.RFC3339),
+		"last_ip":       ipAddress,
+	})
+	return row.LockedUntil, nil
+}
+
+// clearLoginFailures 成功登录后清零失败计数与锁定次数
+func (s *AuthService) clearLoginFailures(user *model.User) {
+	if user.FailedLoginCount == 0 && user.LockoutCount == 0 && user.LockedUntil == nil {
+		return
+	}
+	err := repository.DB.Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"failed_login_count": 0,
+		"lockout_count":      0,
+		"locked_until":       nil,
+	}).Error
+	if err != nil {
+		logger.Error("login: clear failed login count failed", zap.Error(err), zap.Int64("user_id", user.ID))
+	}
+}
+
+// detectLoginAnomaly 须在本次会话写入前调用；没有任何历史（首次登录）时不告警。
+func (s *AuthService) detectLoginAnomaly(user *model.User, userAgent, ipAddress string) {
+	since := time.Now().Add(-loginAnomalyWindow)
+	var seen struct {
+		HasHistory bool
+		IPSeen     bool
+		UASeen     bool
+	}
+	err := repository.DB.Raw(`SELECT
+			EXISTS (SELECT 1 FROM app.sessions WHERE user_id = ?)
+				OR EXISTS (SELECT 1 FROM log.login_logs WHERE user_id = ? AND result = ? AND occurred_at > ?) AS has_history,
+			EXISTS (SELECT 1 FROM app.sessions WHERE user_id = ? AND ip_address = ?)
+				OR EXISTS (SELECT 1 FROM log.login_logs WHERE user_id = ? AND result = ? AND occurred_at > ? AND client_ip = ?::inet) AS ip_seen,
+			EXISTS (SELECT 1 FROM app.sessions WHERE user_id = ? AND user_agent = ?)
+				OR EXISTS (SELECT 1 FROM log.login_logs WHERE user_id = ? AND result = ? AND occurred_at > ? AND user_agent = ?) AS ua_seen`,
+		user.ID, user.ID, loginResultSuccess, since,
+		user.ID, ipAddress, user.ID, loginResultSuccess, since, ipAddress,
+		user.ID, userAgent, user.ID, loginResultSuccess, since, userAgent).
+		Scan(&seen).Error
+	if err != nil {
+		logger.Error("login: anomaly check failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return
+	}
+	if !seen.HasHistory || (seen.IPSeen && seen.UASeen) {
+		return
+	}
+
+	logger.Warn("login: anomaly detected",
+		zap.Int64("user_id", user.ID), zap.Bool("new_ip", !seen.IPSeen), zap.Bool("new_user_agent", !seen.UASeen),
+		zap.String("ip", ipAddress), zap.String("user_agent", userAgent))
+	s.raiseAccountAlert("login_anomaly", user, 2, loginAnomalyCooldown, map[string]interface{}{
+		"user_uuid":      user.UUID.String(),
+		"ip":             ipAddress,
+		"user_agent":     userAgent,
+		"new_ip":         !seen.IPSeen,
+		"new_user_agent": !seen.UASeen,
+	})
+}
+
+// raiseAccountAlert 账户类告警；cooldown 内同一用户同类型告警已存在则只记日志。
+func (s *AuthService) raiseAccountAlert(alertType string, user *model.User, severity int16, cooldown time.Duration, extra map[string]interface{}) {
+	if cooldown > 0 {
+		var recent int64
+		err := repository.DB.Model(&model.Alert{}).
+			Where("alert_type = ? AND device_type = ? AND user_id = ? AND created_at > ?",
+				alertType, model.AlertSubjectAccount, user.ID, time.Now().Add(-cooldown)).
+			Count(&recent).Error
+		if err == nil && recent > 0 {
+			logger.Info("raiseAccountAlert: suppressed within cooldown",
+				zap.String("alert_type", alertType), zap.Int64("user_id", user.ID))
+			return
+		}
+	}
+
+	userID := user.ID
+	alert := &model.Alert{
+		AlertType:  alertType,
+		DeviceType: model.AlertSubjectAccount,
+		UserID:     &userID,
+		Severity:   severity,
+		Status:     0,
+		Extra:      model.JSON(extra),
+	}
+	if err := repository.DB.Create(alert).Error; err != nil {
+		logger.Error("raiseAccountAlert: create alert failed",
+			zap.String("alert_type", alertType), zap.Int64("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	if s.publisher != nil {
+		_ = s.publisher.PublishNotify(&mq.NotifyMessage{
+			AlertType: alertType,
+			Severity:  severity,
+			Extra:     extra,
+		})
+	}
+}
+
+// auditLogin 写入登录审计流；MQ 不可用或投递失败时直接落库，登录审计不能丢。
+func (s *AuthService) auditLogin(user *model.User, phone, action, result, userAgent, ipAddress string, extra map[string]interface{}) {
+	msg := &mq.LoginAuditMessage{
+		Phone:     crypto.MaskPhone(phone),
+		Action:    action,
+		Result:    result,
+		ClientIP:  ipAddress,
+		UserAgent: truncate(userAgent, 200),
+		Extra:     extra,
+	}
+	if user != nil {
+		msg.UserID = user.ID
+		msg.Phone = crypto.MaskPhone(user.Phone)
+	}
+
+	if s.publisher != nil {
+		err := s.publisher.PublishLoginAudit(msg)
+		if err == nil {
+			return
+		}
+		logger.Warn("login audit: publish failed, writing directly", zap.Error(err))
+	} else {
+		msg.OccurredAt = time.Now().UnixMilli()
+	}
+
+	log := mq.LoginLogFromMessage(msg)
+	if err := repository.DB.Create(&log).Error; err != nil {
+		logger.Error("login audit: insert failed", zap.Error(err), zap.String("result", result))
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func lockoutTestService() *AuthService {
+	cfg := &config.AuthConfig{
+		SessionTTL: time.Hour, AccessTTL: 15 * time.Minute,
+		LockoutThreshold: 3, LockoutDuration: time.Minute, LockoutMaxDuration: 3 * time.Minute,
+	}
+	return NewAuthService(repository.NewPostgresSessionStore(), cfg, nil, nil, []Authenticator{localAuthenticator{}}, nil)
+}
+
+func newLoginUser(t *testing.T, password string) *model.User {
+	t.Helper()
+	user := newTestUser(t, "user", "")
+	user.PasswordHash = testPasswordHash(t, password)
+	if err := repository.DB.Model(user).Update("password_hash", user.PasswordHash).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func countAccountAlerts(t *testing.T, alertType string, userID int64) int64 {
+	t.Helper()
+	var n int64
+	if err := repository.DB.Model(&model.Alert{}).
+		Where("alert_type = ? AND device_type = ? AND user_id = ?", alertType, model.AlertSubjectAccount, userID).
+		Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// expireLock 把锁定时间拨到过去，模拟锁定期结束
+func expireLock(t *testing.T, userID int64) {
+	t.Helper()
+	if err := repository.DB.Model(&model.User{}).Where("id = ?", userID).
+		Update("locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	testdb.Open(t)
+	user := newLoginUser(t, "Right-Pass1")
+	svc := lockoutTestService()
+	login := func(password string) int {
+		_, code, _ := svc.Login(&LoginRequest{Phone: user.Phone, Password: password}, "ua", "10.0.0.1")
+		return code
+	}
+	failUntilLocked := func() time.Duration {
+		t.Helper()
+		for i := 1; i < 3; i++ {
+			if code := login("Wrong-Pass1"); code != model.CodeAuthFailed {
+				t.Fatalf("failure %d: code %d", i, code)
+			}
+		}
+		start := time.Now()
+		if code := login("Wrong-Pass1"); code != model.CodeAccountLocked {
+			t.Fatalf("threshold reached: code %d, want %d", code, model.CodeAccountLocked)
+		}
+		var u model.User
+		repository.DB.First(&u, user.ID)
+		return u.LockedUntil.Sub(start).Round(10 * time.Second)
+	}
+
+	if d := failUntilLocked(); d != time.Minute {
+		t.Fatalf("first lockout = %v, want 1m", d)
+	}
+	// 锁定期内正确密码也不放行
+	if code := login("Right-Pass1"); code != model.CodeAccountLocked {
+		t.Fatalf("correct password while locked: %d", code)
+	}
+	if n := countAccountAlerts(t, "account_locked", user.ID); n != 1 {
+		t.Fatalf("account_locked alerts = %d", n)
+	}
+
+	// 再次被锁时长翻倍，且不超过上限
+	expireLock(t, user.ID)
+	if d := failUntilLocked(); d != 2*time.Minute {
+		t.Fatalf("second lockout = %v, want 2m", d)
+	}
+	expireLock(t, user.ID)
+	if d := failUntilLocked(); d != 3*time.Minute {
+		t.Fatalf("third lockout = %v, want capped 3m", d)
+	}
+
+	admin := newTestUser(t, "admin", "")
+	if code, msg := NewAdminService(nil, nil).UnlockUser(user.UUID.String(), admin.ID); code != 0 {
+		t.Fatalf("unlock: %d %s", code, msg)
+	}
+	if code := login("Right-Pass1"); code != 0 {
+		t.Fatalf("login after unlock: %d", code)
+	}
+	var u model.User
+	repository.DB.First(&u, user.ID)
+	if u.FailedLoginCount != 0 || u.LockoutCount != 0 || u.LockedUntil != nil {
+		t.Fatalf("counters not cleared: %d %d %v", u.FailedLoginCount, u.LockoutCount, u.LockedUntil)
+	}
+
+	var results []string
+	repository.DB.Model(&model.LoginLog{}).Where("user_id = ?", user.ID).Order("id").Pluck("result", &results)
+	if len(results) != 11 || results[2] != loginResultInvalidCredentials || results[3] != loginResultAccountLocked ||
+		results[10] != loginResultSuccess {
+		t.Fatalf("login_logs results = %v", results)
+	}
+}
+
+func TestLoginAuditUnknownAccount(t *testing.T) {
+	testdb.Open(t)
+	svc := lockoutTestService()
+	if _, code, _ := svc.Login(&LoginRequest{Phone: "13912345678", Password: "Whatever-1"}, "ua", "10.0.0.9"); code != model.CodeAuthFailed {
+		t.Fatalf("unknown account: %d", code)
+	}
+	var log model.LoginLog
+	if err := repository.DB.Where("client_ip = ?", "10.0.0.9").First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	if log.UserID != nil || log.Phone == "13912345678" || log.Result != loginResultInvalidCredentials {
+		t.Fatalf("login log = %+v", log)
+	}
+}
+
+func TestLoginAnomaly(t *testing.T) {
+	testdb.Open(t)
+	user := newLoginUser(t, "Right-Pass1")
+	svc := lockoutTestService()
+	login := func(ip, ua string) {
+		t.Helper()
+		if _, code, msg := svc.Login(&LoginRequest{Phone: user.Phone, Password: "Right-Pass1"}, ua, ip); code != 0 {
+			t.Fatalf("login from %s: %d %s", ip, code, msg)
+		}
+	}
+
+	login("10.0.0.1", "app/1.0") // 首次登录没有历史，不告警
+	login("10.0.0.1", "app/1.0")
+	if n := countAccountAlerts(t, "login_anomaly", user.ID); n != 0 {
+		t.Fatalf("alerts for known ip/ua = %d", n)
+	}
+	login("203.0.113.7", "app/1.0")
+	if n := countAccountAlerts(t, "login_anomaly", user.ID); n != 1 {
+		t.Fatalf("alerts after new ip = %d", n)
+	}
+	var alert model.Alert
+	repository.DB.Where("alert_type = ? AND user_id = ?", "login_anomaly", user.ID).First(&alert)
+	if alert.Extra["new_ip"] != true || alert.Extra["new_user_agent"] != false {
+		t.Fatalf("alert extra = %v", alert.Extra)
+	}
+	// 冷却期内同类告警只记一次
+	login("198.51.100.3", "curl/8.0")
+	if n := countAccountAlerts(t, "login_anomaly", user.ID); n != 1 {
+		t.Fatalf("alerts within cooldown = %d", n)
+	}
+}
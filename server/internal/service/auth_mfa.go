@@ -0,0 +1,287 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/logger"
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+TOTP 双因素两步登录：
+ 1. POST /api/auth/login 密码通过后，若用户已启用 MFA 或其角色须强制两步验证（见 mfaRequired），返回 mfa_token（5 分钟有效）；
+ 2. 未绑定者先 POST /api/auth/mfa/enroll 取得密钥与 otpauth URI，在认证器 App 中添加；
+ 3. POST /api/auth/mfa/verify 提交 6 位验证码（或恢复码）换取会话；首次确认绑定时一次性返回 10 个恢复码。
+每个 mfa_token 最多尝试 5 次；同一时间步的验证码不能重复使用。
+*/
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	mfaTOTPSkew       = 1
+	mfaRecoveryCodesN = 10
+)
+
+var errMFAChallengeInvalid = errors.New("mfa challenge expired or exhausted")
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required,uuid"`
+}
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"` // base32，供手动输入
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required,uuid"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// mfaRequired 角色持有任一写动作（admin 的 "*" 亦然）或在 MFARequiredRoles 中时强制两步验证；
+// 按动作判定，新建的自定义角色无需改配置即受约束。查询失败时按需要处理，宁可多问一次验证码。
+func (s *AuthService) mfaRequired(role string) bool {
+	for _, r := range s.cfg.MFARequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	canWrite, err := middleware.RoleCanWrite(role)
+	if err != nil {
+		logger.Error("login: role permission query failed, requiring mfa", zap.Error(err), zap.String("role", role))
+		return true
+	}
+	return canWrite
+}
+
+func (s *AuthService) mfaEnabled(userID int64) (bool, error) {
+	var count int64
+	err := repository.DB.Model(&model.UserMFA{}).Where("user_id = ? AND enabled", userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *AuthService) startMFAChallenge(user *model.User, enrolled bool, ipAddress string) (*LoginResponse, int, string) {
+	now := time.Now()
+	repository.DB.Where("expires_at < ?", now).Delete(&model.MFAChallenge{})
+
+	challenge := &model.MFAChallenge{
+		Token:     uuid.New(),
+		UserID:    user.ID,
+		ExpiresAt: now.Add(mfaChallengeTTL),
+		IPAddress: ipAddress,
+	}
+	if err := repository.DB.Create(challenge).Error; err != nil {
+		logger.Error("login: create mfa challenge failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	logger.Info("login: password accepted, mfa required",
+		zap.Int64("user_id", user.ID), zap.Bool("enrolled", enrolled), zap.String("ip", ipAddress))
+	return &LoginResponse{
+		UserUUID:          user.UUID.String(),
+		Role:              user.Role,
+		Name:              user.Name,
+		MFARequired:       true,
+		MFAEnrollRequired: !enrolled,
+		MFAToken:          challenge.Token.String(),
+	}, 0, ""
+}
+
+// EnrollMFA 为尚未启用 MFA 的用户生成（或重新生成）TOTP 密钥，需随后 VerifyMFA 确认。
+func (s *AuthService) EnrollMFA(req *MFAEnrollRequest) (*MFAEnrollResponse, int, string) {
+	user, code, msg := s.mfaChallengeUser(req.MFAToken, false)
+	if code != 0 {
+		return nil, code, msg
+	}
+	enabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		logger.Error("mfa_enroll: query failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if enabled {
+		return nil, model.CodeParamError, "mfa already enabled"
+	}
+
+	secret, err := crypto.GenerateTOTPSecret()
+	if err != nil {
+		logger.Error("mfa_enroll: generate secret failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	encrypted, err := crypto.AESEncrypt(secret, s.mfaKey)
+	if err != nil {
+		logger.Error("mfa_enroll: encrypt secret failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	err = repository.DB.Exec(`INSERT INTO app.user_mfa (user_id, secret_encrypted, enabled, last_used_step, created_at)
+		VALUES (?, ?, FALSE, 0, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, created_at = NOW()
+		WHERE NOT app.user_mfa.enabled`, user.ID, encrypted).Error
+	if err != nil {
+		logger.Error("mfa_enroll: save secret failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	logger.Info("mfa_enroll: secret generated", zap.Int64("user_id", user.ID))
+	return &MFAEnrollResponse{
+		Secret:     crypto.TOTPSecretBase32(secret),
+		OTPAuthURI: crypto.TOTPURI(s.cfg.MFAIssuer, crypto.MaskPhone(user.Phone), secret),
+	}, 0, ""
+}
+
+// VerifyMFA 两步登录第二步；未确认绑定时以验证码确认绑定并发放恢复码。
+func (s *AuthService) VerifyMFA(req *MFAVerifyRequest, userAgent, ipAddress string) (*LoginResponse, int, string) {
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		return nil, model.CodeParamError, "provide exactly one of code or recovery_code"
+	}
+	user, code, msg := s.mfaChallengeUser(req.MFAToken, true)
+	if code != 0 {
+		return nil, code, msg
+	}
+
+	var mfa model.UserMFA
+	if err := repository.DB.Where("user_id = ?", user.ID).First(&mfa).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.CodeParamError, "mfa not enrolled"
+		}
+		logger.Error("mfa_verify: query failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	var recoveryCodes []string
+	switch {
+	case req.RecoveryCode != "":
+		if !mfa.Enabled || !s.consumeRecoveryCode(user.ID, req.RecoveryCode) {
+			logger.Info("mfa_verify: invalid recovery code", zap.Int64("user_id", user.ID), zap.String("ip", ipAddress))
+			s.auditLogin(user, user.Phone, loginActionMFA, loginResultInvalidMFA, userAgent, ipAddress, map[string]interface{}{"method": "recovery_code"})
+			return s.loginFailed(user, ipAddress, "invalid mfa code")
+		}
+		logger.Warn("mfa_verify: recovery code used", zap.Int64("user_id", user.ID), zap.String("ip", ipAddress))
+	default:
+		ok, err := s.verifyTOTP(&mfa, req.Code)
+		if err != nil {
+			logger.Error("mfa_verify: totp check failed", zap.Error(err), zap.Int64("user_id", user.ID))
+			return nil, model.CodeInternalError, "internal error"
+		}
+		if !ok {
+			logger.Info("mfa_verify: invalid totp code", zap.Int64("user_id", user.ID), zap.String("ip", ipAddress))
+			s.auditLogin(user, user.Phone, loginActionMFA, loginResultInvalidMFA, userAgent, ipAddress, map[string]interface{}{"method": "totp"})
+			return s.loginFailed(user, ipAddress, "invalid mfa code")
+		}
+		if !mfa.Enabled {
+			codes, err := s.confirmMFAEnrollment(user.ID)
+			if err != nil {
+				logger.Error("mfa_verify: confirm enrollment failed", zap.Error(err), zap.Int64("user_id", user.ID))
+				return nil, model.CodeInternalError, "internal error"
+			}
+			recoveryCodes = codes
+			logger.Info("mfa_verify: enrollment confirmed", zap.Int64("user_id", user.ID))
+		}
+	}
+
+	repository.DB.Where("token = ?", req.MFAToken).Delete(&model.MFAChallenge{})
+
+	resp, code, msg := s.createSession(user, loginActionMFA, userAgent, ipAddress)
+	if code != 0 {
+		return nil, code, msg
+	}
+	resp.RecoveryCodes = recoveryCodes
+	return resp, 0, ""
+}
+
+// mfaChallengeUser 校验 mfa_token；countAttempt 为 true 时计一次尝试，超过上限即失效。
+func (s *AuthService) mfaChallengeUser(token string, countAttempt bool) (*model.User, int, string) {
+	var challenge model.MFAChallenge
+	var err error
+	if countAttempt {
+		result := repository.DB.Raw(`UPDATE app.mfa_challenges SET attempts = attempts + 1
+			WHERE token = ? AND expires_at > NOW() AND attempts < ?
+			RETURNING token, user_id, attempts, expires_at, created_at`, token, mfaMaxAttempts).Scan(&challenge)
+		err = result.Error
+		if err == nil && result.RowsAffected == 0 {
+			err = errMFAChallengeInvalid
+		}
+	} else {
+		err = repository.DB.Where("token = ? AND expires_at > ? AND attempts < ?", token, time.Now(), mfaMaxAttempts).First(&challenge).Error
+	}
+	if errors.Is(err, errMFAChallengeInvalid) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, model.CodeSessionExpired, "mfa challenge expired, please login again"
+	}
+	if err != nil {
+		logger.Error("mfa: challenge query failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	var user model.User
+	if err := repository.DB.Where("id = ? AND deleted_at IS NULL", challenge.UserID).First(&user).Error; err != nil || user.Status == 0 {
+		return nil, model.CodeAccountDisabled, "account has been disabled"
+	}
+	if until, locked := accountLockedUntil(&user, time.Now()); locked {
+		return nil, model.CodeAccountLocked, lockedMessage(until)
+	}
+	return &user, 0, ""
+}
+
+// verifyTOTP 校验验证码并推进 last_used_step，同一时间步（及更早）的验证码不可再用。
+func (s *AuthService) verifyTOTP(mfa *model.UserMFA, code string) (bool, error) {
+	secret, err := crypto.AESDecrypt(mfa.SecretEncrypted, s.mfaKey)
+	if err != nil {
+		return false, err
+	}
+	defer clearBytes(secret)
+
+	step, ok := crypto.VerifyTOTP(secret, code, time.Now(), mfaTOTPSkew)
+	if !ok || step <= mfa.LastUsedStep {
+		return false, nil
+	}
+	result := repository.DB.Model(&model.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", mfa.UserID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *AuthService) confirmMFAEnrollment(userID int64) ([]string, error) {
+	codes, err := crypto.GenerateRecoveryCodes(mfaRecoveryCodesN)
+	if err != nil {
+		return nil, err
+	}
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&model.UserMFA{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"enabled": true, "confirmed_at": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		rows := make([]model.MFARecoveryCode, len(codes))
+		for i, c := range codes {
+			rows[i] = model.MFARecoveryCode{UserID: userID, CodeHash: crypto.HashRecoveryCode(c)}
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *AuthService) consumeRecoveryCode(userID int64, code string) bool {
+	result := repository.DB.Model(&model.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, crypto.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		logger.Error("mfa: consume recovery code failed", zap.Error(result.Error))
+		return false
+	}
+	return result.RowsAffected == 1
+}
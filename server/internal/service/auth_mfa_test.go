@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func mfaTestService(extraRoles ...string) *AuthService {
+	cfg := refreshTestConfig()
+	cfg.MFARequiredRoles = extraRoles
+	cfg.MFAIssuer = "Promthus"
+	return NewAuthService(repository.NewPostgresSessionStore(), cfg, bytes.Repeat([]byte{7}, 32), nil, nil, nil)
+}
+
+func totpNow(t *testing.T, secretB32 string, offset int64) string {
+	t.Helper()
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secretB32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.TOTPCode(secret, crypto.TOTPStep(time.Now())+offset)
+}
+
+// 绑定 → 验证码确认并领取恢复码 → 同一时间步的验证码不能再用 → 恢复码一次有效
+func TestMFAEnrollAndVerify(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	svc := mfaTestService()
+
+	challenge, code, msg := svc.startMFAChallenge(admin, false, "10.0.0.1")
+	if code != 0 || !challenge.MFAEnrollRequired || challenge.Token != "" {
+		t.Fatalf("challenge: %+v %d %s", challenge, code, msg)
+	}
+	enroll, code, msg := svc.EnrollMFA(&MFAEnrollRequest{MFAToken: challenge.MFAToken})
+	if code != 0 {
+		t.Fatalf("enroll: %d %s", code, msg)
+	}
+
+	if _, code, _ := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"}, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+		t.Fatalf("wrong code: %d", code)
+	}
+	totp := totpNow(t, enroll.Secret, 0)
+	resp, code, msg := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: totp}, "ua", "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("verify: %d %s", code, msg)
+	}
+	if resp.Token == "" || len(resp.RecoveryCodes) != mfaRecoveryCodesN {
+		t.Fatalf("verify response: token %q, %d recovery codes", resp.Token, len(resp.RecoveryCodes))
+	}
+	// mfa_token 用过即删
+	if _, code, _ := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: totp}, "ua", "10.0.0.1"); code != model.CodeSessionExpired {
+		t.Fatalf("reused mfa_token: %d", code)
+	}
+
+	second, _, _ := svc.startMFAChallenge(admin, true, "10.0.0.1")
+	if _, code, _ := svc.EnrollMFA(&MFAEnrollRequest{MFAToken: second.MFAToken}); code != model.CodeParamError {
+		t.Fatalf("re-enroll while enabled: %d", code)
+	}
+	if _, code, _ := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: second.MFAToken, Code: totp}, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+		t.Fatalf("replayed totp: code = %d, want %d", code, model.CodeAuthFailed)
+	}
+	recovery := resp.RecoveryCodes[0]
+	if _, code, msg := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: second.MFAToken, RecoveryCode: recovery}, "ua", "10.0.0.1"); code != 0 {
+		t.Fatalf("recovery code: %d %s", code, msg)
+	}
+	third, _, _ := svc.startMFAChallenge(admin, true, "10.0.0.1")
+	if _, code, _ := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: third.MFAToken, RecoveryCode: recovery}, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+		t.Fatalf("reused recovery code: %d", code)
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	svc := mfaTestService()
+	challenge, _, _ := svc.startMFAChallenge(admin, false, "10.0.0.1")
+	enroll, _, _ := svc.EnrollMFA(&MFAEnrollRequest{MFAToken: challenge.MFAToken})
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		svc.VerifyMFA(&MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"}, "ua", "10.0.0.1")
+	}
+	_, code, _ := svc.VerifyMFA(&MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: totpNow(t, enroll.Secret, 0)}, "ua", "10.0.0.1")
+	if code != model.CodeSessionExpired {
+		t.Fatalf("after %d attempts: code = %d, want %d", mfaMaxAttempts, code, model.CodeSessionExpired)
+	}
+}
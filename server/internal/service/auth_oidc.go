@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+/*
+OIDC 单点登录（授权码 + PKCE），供管理后台使用：
+  1. 前端 POST /api/auth/oidc/authorize 取得 auth_url 与 state，state 存 sessionStorage 后跳转 IdP；
+  2. IdP 回跳前端回调页，前端核对 state 后 POST /api/auth/oidc/callback {code, state}；
+  3. 服务端一次性消费 state 取回 code_verifier 与 nonce，换取并校验 ID Token（签名、iss、aud、exp、nonce），
+     按 (issuer, sub) 或已验证的 phone_number 找到本地用户，之后与密码登录一样走 createSession，
+     写普通 app.sessions 行，Auth / RequirePermission 无需区分登录方式。
+须两步验证的用户（见 mfaRequired）只有 IdP 在 ID Token 中声明做过多因素认证（amr 含 mfa / otp，
+或 acr 在 MFAACRValues 中）时才直接登录，否则与密码登录一样返回 mfa_token，继续本地 TOTP。
+*/
+
+const (
+	loginActionOIDC = "oidc_login"
+	oidcStateTTL    = 10 * time.Minute
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+// OIDCClient IdP 端点在首次使用时发现并缓存，IdP 暂时不可用不影响服务启动
+type OIDCClient struct {
+	cfg *config.OIDCConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCClient 未配置 Issuer 时返回 nil（关闭单点登录）
+func NewOIDCClient(cfg *config.OIDCConfig) (*OIDCClient, error) {
+	if cfg.Issuer == "" {
+		return nil, nil
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+	}
+	for _, m := range cfg.RoleMapping {
+		if !roleAssignable(m.Value) {
+			return nil, fmt.Errorf("OIDC_ROLE_MAPPING: unknown role %q for %q", m.Value, m.Group)
+		}
+	}
+	if len(cfg.AllowedRoles) == 0 {
+		return nil, errors.New("OIDC_ALLOWED_ROLES must not be empty when OIDC_ISSUER is set")
+	}
+	return &OIDCClient{cfg: cfg}, nil
+}
+
+// discover 的 context 会被远程 JWKS 沿用，不能带超时；超时由 http.Client 控制
+func (c *OIDCClient) discover() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.oauth != nil {
+		return c.oauth, c.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(c.context(context.Background()), c.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	scopes := c.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "phone"}
+	}
+	c.oauth = &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  c.cfg.RedirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+	}
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID})
+	return c.oauth, c.verifier, nil
+}
+
+func (c *OIDCClient) context(parent context.Context) context.Context {
+	return oidc.ClientContext(parent, &http.Client{Timeout: oidcHTTPTimeout})
+}
+
+type OIDCConfigResponse struct {
+	Enabled     bool   `json:"enabled"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+type OIDCAuthorizeResponse struct {
+	AuthURL string `json:"auth_url"`
+	State   string `json:"state"` // 前端存 sessionStorage，回调时核对，防止登录 CSRF
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// oidcClaims ID Token 中用到的 claim；角色 claim 名可配置，单独从原始 claims 中取
+type oidcClaims struct {
+	PhoneNumber         string `json:"phone_number"`
+	PhoneNumberVerified bool   `json:"phone_number_verified"`
+}
+
+func (s *AuthService) OIDCConfig() *OIDCConfigResponse {
+	if s.oidc == nil {
+		return &OIDCConfigResponse{}
+	}
+	return &OIDCConfigResponse{Enabled: true, DisplayName: s.oidc.cfg.DisplayName}
+}
+
+// OIDCAuthorize 生成 state、nonce 与 PKCE code_verifier，服务端只保存 state 的哈希
+func (s *AuthService) OIDCAuthorize(ipAddress string) (*OIDCAuthorizeResponse, int, string) {
+	if s.oidc == nil {
+		return nil, model.CodeParamError, "single sign-on is not configured"
+	}
+	conf, _, err := s.oidc.discover()
+	if err != nil {
+		logger.Error("oidc authorize: discovery failed", zap.Error(err))
+		return nil, model.CodeInternalError, "identity provider unavailable"
+	}
+
+	state, err := randomURLToken(32)
+	if err != nil {
+		return nil, model.CodeInternalError, "internal error"
+	}
+	nonce, err := randomURLToken(32)
+	if err != nil {
+		return nil, model.CodeInternalError, "internal error"
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	repository.DB.Where("expires_at < ?", now).Delete(&model.OIDCState{})
+	if err := repository.DB.Create(&model.OIDCState{
+		State:        hashOIDCState(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		IPAddress:    ipAddress,
+		ExpiresAt:    now.Add(oidcStateTTL),
+	}).Error; err != nil {
+		logger.Error("oidc authorize: save state failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	return &OIDCAuthorizeResponse{
+		AuthURL: conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)),
+		State:   state,
+	}, 0, ""
+}
+
+// OIDCCallback 用授权码换取 ID Token，校验后登录对应的本地用户
+func (s *AuthService) OIDCCallback(req *OIDCCallbackRequest, userAgent, ipAddress string) (*LoginResponse, int, string) {
+	if s.oidc == nil {
+		return nil, model.CodeParamError, "single sign-on is not configured"
+	}
+	conf, verifier, err := s.oidc.discover()
+	if err != nil {
+		logger.Error("oidc callback: discovery failed", zap.Error(err))
+		return nil, model.CodeInternalError, "identity provider unavailable"
+	}
+
+	// state 一次性消费，重放或过期都拒绝
+	var st model.OIDCState
+	result := repository.DB.Raw(`DELETE FROM app.oidc_states WHERE state = ? AND expires_at > ?
+		RETURNING code_verifier, nonce`, hashOIDCState(req.State), time.Now()).Scan(&st)
+	if result.Error != nil {
+		logger.Error("oidc callback: consume state failed", zap.Error(result.Error))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if result.RowsAffected == 0 {
+		return nil, model.CodeAuthFailed, "sign-in request expired, please try again"
+	}
+
+	ctx, cancel := context.WithTimeout(s.oidc.context(context.Background()), oidcHTTPTimeout)
+	defer cancel()
+	token, err := conf.Exchange(ctx, req.Code, oauth2.VerifierOption(st.CodeVerifier))
+	if err != nil {
+		logger.Info("oidc callback: code exchange failed", zap.Error(err), zap.String("ip", ipAddress))
+		return nil, model.CodeAuthFailed, "single sign-on failed"
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		logger.Warn("oidc callback: token response has no id_token")
+		return nil, model.CodeAuthFailed, "single sign-on failed"
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != st.Nonce {
+		logger.Warn("oidc callback: id token rejected", zap.Error(err), zap.String("ip", ipAddress))
+		return nil, model.CodeAuthFailed, "single sign-on failed"
+	}
+
+	var claims oidcClaims
+	var raw map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, model.CodeAuthFailed, "single sign-on failed"
+	}
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, model.CodeAuthFailed, "single sign-on failed"
+	}
+	audit := map[string]interface{}{"backend": "oidc", "issuer": idToken.Issuer, "subject": idToken.Subject}
+
+	user, err := s.oidcUser(idToken.Issuer, idToken.Subject, &claims)
+	if err != nil {
+		logger.Error("oidc callback: user lookup failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if user == nil {
+		logger.Info("oidc callback: no linked user",
+			zap.String("issuer", idToken.Issuer), zap.String("subject", idToken.Subject))
+		s.auditLogin(nil, claims.PhoneNumber, loginActionOIDC, loginResultNotEntitled, userAgent, ipAddress, audit)
+		return nil, model.CodeAuthFailed, "no account is linked to this identity"
+	}
+
+	if until, locked := accountLockedUntil(user, time.Now()); locked {
+		s.auditLogin(user, user.Phone, loginActionOIDC, loginResultAccountLocked, userAgent, ipAddress, audit)
+		return nil, model.CodeAccountLocked, lockedMessage(until)
+	}
+	if user.Status == 0 {
+		s.auditLogin(user, user.Phone, loginActionOIDC, loginResultAccountDisabled, userAgent, ipAddress, audit)
+		return nil, model.CodeAccountDisabled, "account has been disabled"
+	}
+	if err := s.oidcSyncRole(user, raw); err != nil {
+		if errors.Is(err, errNotEntitled) {
+			s.auditLogin(user, user.Phone, loginActionOIDC, loginResultNotEntitled, userAgent, ipAddress, audit)
+			return nil, model.CodeAuthFailed, "this identity is not permitted to sign in"
+		}
+		logger.Error("oidc callback: role sync failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	repository.DB.Model(&model.UserIdentity{}).
+		Where("issuer = ? AND subject = ?", idToken.Issuer, idToken.Subject).
+		Update("last_login_at", time.Now())
+
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		logger.Error("oidc callback: mfa query failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if (mfaEnabled || s.mfaRequired(user.Role)) && !s.oidc.idpMFA(raw) {
+		s.auditLogin(user, user.Phone, loginActionOIDC, loginResultMFAChallenge, userAgent, ipAddress, audit)
+		return s.startMFAChallenge(user, mfaEnabled, ipAddress)
+	}
+
+	return s.createSession(user, loginActionOIDC, userAgent, ipAddress)
+}
+
+// idpMFA ID Token 是否表明 IdP 本次做过多因素认证：amr（RFC 8176）含 mfa 或 otp，或 acr 为配置的取值之一
+func (c *OIDCClient) idpMFA(raw map[string]interface{}) bool {
+	for _, m := range claimStrings(raw["amr"]) {
+		if m == "mfa" || m == "otp" {
+			return true
+		}
+	}
+	if acr, ok := raw["acr"].(string); ok {
+		for _, v := range c.cfg.MFAACRValues {
+			if acr == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcUser 先按 (issuer, sub) 查已关联的用户；首次登录按已验证的 phone_number 关联。
+// 已与该 issuer 下其他 sub 关联的用户不再接受新的关联，避免 IdP 侧手机号被他人认领后冒用。
+func (s *AuthService) oidcUser(issuer, subject string, claims *oidcClaims) (*model.User, error) {
+	var ident model.UserIdentity
+	err := repository.DB.Where("issuer = ? AND subject = ?", issuer, subject).First(&ident).Error
+	if err == nil {
+		var user model.User
+		err = repository.DB.Where("id = ? AND role <> ? AND deleted_at IS NULL", ident.UserID, model.RoleService).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !claims.PhoneNumberVerified || claims.PhoneNumber == "" {
+		return nil, nil
+	}
+	var user model.User
+	err = repository.DB.Where("phone = ? AND role <> ? AND deleted_at IS NULL",
+		normalizeOIDCPhone(claims.PhoneNumber), model.RoleService).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var linked int64
+	if err := repository.DB.Model(&model.UserIdentity{}).
+		Where("user_id = ? AND issuer = ?", user.ID, issuer).Count(&linked).Error; err != nil {
+		return nil, err
+	}
+	if linked > 0 {
+		logger.Warn("oidc: user already linked to another subject, refusing",
+			zap.Int64("user_id", user.ID), zap.String("issuer", issuer), zap.String("subject", subject))
+		return nil, nil
+	}
+	if err := repository.DB.Create(&model.UserIdentity{UserID: user.ID, Issuer: issuer, Subject: subject}).Error; err != nil {
+		return nil, err
+	}
+
+	writeOperationLog(user.ID, "link_oidc_identity", "user", user.ID, nil, map[string]interface{}{
+		"issuer": issuer, "subject": subject,
+	})
+	logger.Info("oidc: identity linked by verified phone",
+		zap.Int64("user_id", user.ID), zap.String("issuer", issuer), zap.String("subject", subject))
+	return &user, nil
+}
+
+// oidcSyncRole 配置了角色映射时以 IdP 为准并同步到本地；最终角色须在 AllowedRoles 内
+func (s *AuthService) oidcSyncRole(user *model.User, raw map[string]interface{}) error {
+	cfg := s.oidc.cfg
+	if len(cfg.RoleMapping) > 0 {
+		role, ok := matchGroup(cfg.RoleMapping, claimStrings(raw[cfg.RoleClaim]))
+		if !ok {
+			return errNotEntitled
+		}
+		if role != user.Role {
+			if err := repository.DB.Model(user).Updates(map[string]interface{}{
+				"role":       role,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			writeOperationLog(user.ID, "oidc_sync", "user", user.ID,
+				map[string]interface{}{"role": user.Role}, map[string]interface{}{"role": role})
+			user.Role = role
+		}
+	}
+	for _, r := range cfg.AllowedRoles {
+		if r == user.Role {
+			return nil
+		}
+	}
+	return errNotEntitled
+}
+
+// claimStrings 角色 claim 可能是字符串或字符串数组
+func claimStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// normalizeOIDCPhone IdP 给出 E.164（+8613800138000），本地存 11 位手机号
+func normalizeOIDCPhone(phone string) string {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	return strings.TrimPrefix(phone, "+86")
+}
+
+func hashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomURLToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const testOIDCClientID = "promthus-console"
+
+// fakeIdP 最小的 OIDC 提供方：discovery、JWKS 与校验 PKCE 的 token 端点，ID Token 以 RS256 签名
+type fakeIdP struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]fakeIdPGrant // 授权码 → 待签发的 ID Token
+}
+
+type fakeIdPGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, grants: map[string]fakeIdPGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.srv.URL,
+			"authorization_endpoint":                idp.srv.URL + "/authorize",
+			"token_endpoint":                        idp.srv.URL + "/token",
+			"jwks_uri":                              idp.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+// authorize 模拟浏览器在 IdP 完成登录：记下 PKCE challenge 与 nonce，返回授权码与 state。
+// claims 中未给出的 iss、aud、nonce、exp 按授权请求补齐。
+func (idp *fakeIdP) authorize(t *testing.T, authURL string, claims map[string]interface{}) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testOIDCClientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorize request = %s", u.RawQuery)
+	}
+	full := map[string]interface{}{
+		"iss":   idp.srv.URL,
+		"aud":   testOIDCClientID,
+		"nonce": q.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code = randomTestToken(t)
+	idp.mu.Lock()
+	idp.grants[code] = fakeIdPGrant{challenge: q.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	idToken, err := idp.sign(grant.claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "at", "token_type": "Bearer", "expires_in": 300, "id_token": idToken,
+	})
+}
+
+func (idp *fakeIdP) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, stdcrypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func randomTestToken(t *testing.T) string {
+	t.Helper()
+	s, err := randomURLToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func oidcTestService(issuer string) *AuthService {
+	cfg := &config.AuthConfig{SessionTTL: time.Hour, AccessTTL: 15 * time.Minute, MFARequiredRoles: []string{"admin"}}
+	client := &OIDCClient{cfg: &config.OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     testOIDCClientID,
+		RedirectURL:  "https://console.example.com/oidc/callback",
+		AllowedRoles: []string{"admin"},
+		MFAACRValues: []string{"urn:example:mfa"},
+	}}
+	return NewAuthService(repository.NewPostgresSessionStore(), cfg, nil, nil, nil, client)
+}
+
+func countIdentities(t *testing.T, userID int64) int64 {
+	t.Helper()
+	var n int64
+	if err := repository.DB.Model(&model.UserIdentity{}).Where("user_id = ?", userID).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	client, err := NewOIDCClient(&config.OIDCConfig{})
+	if err != nil || client != nil {
+		t.Fatalf("NewOIDCClient(no issuer) = %v, %v; want nil, nil", client, err)
+	}
+	svc := NewAuthService(nil, &config.AuthConfig{}, nil, nil, nil, nil)
+	if svc.OIDCConfig().Enabled {
+		t.Fatal("OIDC reported enabled")
+	}
+	if _, code, _ := svc.OIDCCallback(&OIDCCallbackRequest{Code: "c", State: "s"}, "ua", "10.0.0.1"); code != model.CodeParamError {
+		t.Fatalf("callback without OIDC: code %d, want %d", code, model.CodeParamError)
+	}
+}
+
+func TestOIDCHelpers(t *testing.T) {
+	if got := normalizeOIDCPhone("+86 138-0013-8000"); got != "13800138000" {
+		t.Errorf("normalizeOIDCPhone = %q", got)
+	}
+	if got := claimStrings([]interface{}{"a", 1, "b"}); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("claimStrings(array) = %q", got)
+	}
+	if got := claimStrings("admins"); len(got) != 1 || got[0] != "admins" {
+		t.Errorf("claimStrings(string) = %q", got)
+	}
+	if got := claimStrings(42); got != nil {
+		t.Errorf("claimStrings(number) = %q", got)
+	}
+	if hashOIDCState("a") == hashOIDCState("b") || len(hashOIDCState("a")) != 64 {
+		t.Error("hashOIDCState")
+	}
+
+	client := oidcTestService("https://idp.example.com").oidc
+	for _, tt := range []struct {
+		claims map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"amr": []interface{}{"pwd", "mfa"}}, true},
+		{map[string]interface{}{"amr": []interface{}{"otp"}}, true},
+		{map[string]interface{}{"amr": []interface{}{"pwd"}}, false},
+		{map[string]interface{}{"acr": "urn:example:mfa"}, true},
+		{map[string]interface{}{"acr": "urn:example:password"}, false},
+		{map[string]interface{}{}, false},
+	} {
+		if got := client.idpMFA(tt.claims); got != tt.want {
+			t.Errorf("idpMFA(%v) = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+}
+
+// 不依赖数据库：发现端点、PKCE 换码与 ID Token 校验能与 IdP 配合
+func TestOIDCDiscoveryAndPKCE(t *testing.T) {
+	idp := newFakeIdP(t)
+	client := oidcTestService(idp.srv.URL).oidc
+	conf, verifier, err := client.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Scopes[0] != "openid" || conf.Endpoint.TokenURL != idp.srv.URL+"/token" {
+		t.Fatalf("oauth config = %+v", conf)
+	}
+
+	ctx := client.context(context.Background())
+	pkce := oauth2.GenerateVerifier()
+	authCode, _ := idp.authorize(t, conf.AuthCodeURL("st", oauth2.S256ChallengeOption(pkce), oidc.Nonce("n1")),
+		map[string]interface{}{"sub": "s1"})
+	if _, err := conf.Exchange(ctx, authCode, oauth2.VerifierOption(oauth2.GenerateVerifier())); err == nil {
+		t.Fatal("exchange with wrong code_verifier succeeded")
+	}
+
+	authCode, _ = idp.authorize(t, conf.AuthCodeURL("st", oauth2.S256ChallengeOption(pkce), oidc.Nonce("n1")),
+		map[string]interface{}{"sub": "s1"})
+	token, err := conf.Exchange(ctx, authCode, oauth2.VerifierOption(pkce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken, err := verifier.Verify(ctx, token.Extra("id_token").(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "s1" || idToken.Nonce != "n1" || idToken.Issuer != idp.srv.URL {
+		t.Fatalf("id token = %+v", idToken)
+	}
+
+	// 其他 audience 的 ID Token 不被接受
+	forged, err := idp.sign(map[string]interface{}{"iss": idp.srv.URL, "aud": "another-client", "sub": "s1",
+		"exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(ctx, forged); err == nil {
+		t.Fatal("id token for another audience accepted")
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	testdb.Open(t)
+	idp := newFakeIdP(t)
+	svc := oidcTestService(idp.srv.URL)
+	alice := newTestUser(t, "admin", "")
+	bob := newTestUser(t, "admin", "")
+
+	login := func(t *testing.T, claims map[string]interface{}) (*LoginResponse, int, string, *OIDCCallbackRequest) {
+		t.Helper()
+		auth, code, msg := svc.OIDCAuthorize("10.0.0.1")
+		if code != 0 {
+			t.Fatalf("authorize: %d %s", code, msg)
+		}
+		authCode, state := idp.authorize(t, auth.AuthURL, claims)
+		if state != auth.State {
+			t.Fatalf("state in auth url %q, response %q", state, auth.State)
+		}
+		req := &OIDCCallbackRequest{Code: authCode, State: state}
+		resp, code, msg := svc.OIDCCallback(req, "ua", "10.0.0.1")
+		return resp, code, msg, req
+	}
+
+	// 首次登录按已验证手机号关联，之后只按 (issuer, sub) 识别
+	resp, code, msg, req := login(t, map[string]interface{}{
+		"sub": "sub-alice", "phone_number": "+86" + alice.Phone, "phone_number_verified": true, "amr": []string{"pwd", "mfa"},
+	})
+	if code != 0 || resp.UserUUID != alice.UUID.String() || resp.Token == "" {
+		t.Fatalf("first login: %d %s %+v", code, msg, resp)
+	}
+	if countIdentities(t, alice.ID) != 1 || countOperationLogs(t, "link_oidc_identity", alice.ID) != 1 {
+		t.Fatal("identity not linked")
+	}
+
+	t.Run("replayed state", func(t *testing.T) {
+		if _, code, msg := svc.OIDCCallback(req, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+			t.Fatalf("replay: %d %s, want %d", code, msg, model.CodeAuthFailed)
+		}
+		unknown := &OIDCCallbackRequest{Code: req.Code, State: "never-issued"}
+		if _, code, _ := svc.OIDCCallback(unknown, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+			t.Fatalf("unknown state: %d, want %d", code, model.CodeAuthFailed)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		_, code, _, req := login(t, map[string]interface{}{"sub": "sub-alice", "nonce": "attacker-nonce"})
+		if code != model.CodeAuthFailed {
+			t.Fatalf("code %d, want %d", code, model.CodeAuthFailed)
+		}
+		// state 已被消费，不能带着正确的 nonce 再试
+		if _, code, _ := svc.OIDCCallback(req, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+			t.Fatalf("retry after nonce mismatch: %d", code)
+		}
+	})
+
+	t.Run("unverified phone", func(t *testing.T) {
+		_, code, _, _ := login(t, map[string]interface{}{
+			"sub": "sub-bob", "phone_number": bob.Phone, "phone_number_verified": false,
+		})
+		if code != model.CodeAuthFailed {
+			t.Fatalf("code %d, want %d", code, model.CodeAuthFailed)
+		}
+		if countIdentities(t, bob.ID) != 0 {
+			t.Fatal("identity linked from unverified phone")
+		}
+		var n int64
+		repository.DB.Model(&model.LoginLog{}).
+			Where("action = ? AND result = ? AND user_id IS NULL", loginActionOIDC, loginResultNotEntitled).Count(&n)
+		if n == 0 {
+			t.Fatal("rejected sign-in not audited")
+		}
+	})
+
+	t.Run("second subject", func(t *testing.T) {
+		// IdP 中另一账号认领了 alice 的手机号：alice 已关联 sub-alice，拒绝再关联
+		_, code, _, _ := login(t, map[string]interface{}{
+			"sub": "sub-mallory", "phone_number": alice.Phone, "phone_number_verified": true,
+		})
+		if code != model.CodeAuthFailed {
+			t.Fatalf("code %d, want %d", code, model.CodeAuthFailed)
+		}
+		if countIdentities(t, alice.ID) != 1 {
+			t.Fatal("second subject linked")
+		}
+	})
+
+	t.Run("linked subject without phone", func(t *testing.T) {
+		resp, code, msg, _ := login(t, map[string]interface{}{"sub": "sub-alice", "acr": "urn:example:mfa"})
+		if code != 0 || resp.UserUUID != alice.UUID.String() || resp.Token == "" {
+			t.Fatalf("%d %s", code, msg)
+		}
+	})
+
+	t.Run("idp without mfa falls back to local totp", func(t *testing.T) {
+		resp, code, msg, _ := login(t, map[string]interface{}{"sub": "sub-alice", "amr": []string{"pwd"}})
+		if code != 0 || !resp.MFARequired || resp.MFAToken == "" || resp.Token != "" || resp.RefreshToken != "" {
+			t.Fatalf("%d %s %+v", code, msg, resp)
+		}
+		var n int64
+		repository.DB.Model(&model.LoginLog{}).
+			Where("user_id = ? AND action = ? AND result = ?", alice.ID, loginActionOIDC, loginResultMFAChallenge).Count(&n)
+		if n != 1 {
+			t.Fatalf("%d mfa_challenge audit rows, want 1", n)
+		}
+	})
+
+	t.Run("role not allowed", func(t *testing.T) {
+		carol := newTestUser(t, "user", "")
+		_, code, _, _ := login(t, map[string]interface{}{
+			"sub": "sub-carol", "phone_number": carol.Phone, "phone_number_verified": true,
+		})
+		if code != model.CodeAuthFailed {
+			t.Fatalf("code %d, want %d", code, model.CodeAuthFailed)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+
+	"promthus/internal/crypto"
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,max=128"`
+}
+
+var errPasswordReused = errors.New("password was used recently")
+
+// ChangePassword 用户自助改密：校验当前密码与密码策略，成功后清除 must_change_password，
+// 并踢掉该用户其他设备上的会话（当前会话保留）。
+func (s *AuthService) ChangePassword(userID int64, sessionFamily uuid.UUID, req *ChangePasswordRequest) (int, string) {
+	var user model.User
+	if err := repository.DB.Where("id = ? AND deleted_at IS NULL", userID).First(&user).Error; err != nil {
+		return model.CodeAccountDisabled, "account has been disabled"
+	}
+	if user.AuthSource != model.AuthSourceLocal {
+		return model.CodeParamError, "password is managed by the company directory"
+	}
+
+	ok, err := crypto.VerifyPassword(req.CurrentPassword, user.PasswordHash)
+	if err != nil || !ok {
+		logger.Info("change_password: current password mismatch", zap.Int64("user_id", userID))
+		return model.CodeAuthFailed, "current password is incorrect"
+	}
+
+	if msg := s.checkPasswordPolicy(req.NewPassword); msg != "" {
+		return model.CodeParamError, msg
+	}
+
+	hash, err := crypto.HashPassword(req.NewPassword)
+	if err != nil {
+		logger.Error("change_password: hash password failed", zap.Error(err))
+		return model.CodeInternalError, "failed to hash password"
+	}
+
+	err = repository.DB.Transaction(func(tx *gorm.DB) error {
+		var history []model.PasswordHistory
+		if err := tx.Where("user_id = ?", userID).Order("created_at DESC, id DESC").
+			Limit(s.historyDepth()).Find(&history).Error; err != nil {
+			return err
+		}
+		if passwordReused(req.NewPassword, user.PasswordHash, history) {
+			return errPasswordReused
+		}
+
+		now := time.Now()
+		result := tx.Model(&model.User{}).
+			Where("id = ? AND password_hash = ?", userID, user.PasswordHash).
+			Updates(map[string]interface{}{
+				"password_hash":        hash,
+				"must_change_password": false,
+				"password_changed_at":  now,
+				"updated_at":           now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound // 并发改密或被重置，要求重新提交
+		}
+		return recordPasswordHistory(tx, userID, hash, s.historyDepth())
+	})
+	if errors.Is(err, errPasswordReused) {
+		return model.CodeParamError, fmt.Sprintf("new password must differ from the last %d passwords", s.historyDepth())
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.CodeAuthFailed, "password was changed concurrently, please retry"
+	}
+	if err != nil {
+		logger.Error("change_password: db update failed", zap.Error(err), zap.Int64("user_id", userID))
+		return model.CodeInternalError, "failed to update password"
+	}
+
+	if err := s.sessionStore.DeleteOthers(userID, sessionFamily); err != nil {
+		logger.Error("change_password: revoke other sessions failed", zap.Error(err), zap.Int64("user_id", userID))
+	}
+	writeOperationLog(userID, "change_password", "user", userID, nil, nil)
+
+	logger.Info("change_password success", zap.Int64("user_id", userID))
+	return 0, ""
+}
+
+// checkPasswordPolicy 返回空串表示通过，否则为可直接返回给前端的原因。
+func (s *AuthService) checkPasswordPolicy(password string) string {
+	if len([]rune(password)) < s.cfg.PasswordMinLength {
+		return fmt.Sprintf("password must be at least %d characters", s.cfg.PasswordMinLength)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			return "password must not contain whitespace or control characters"
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, b := range []bool{upper, lower, digit, symbol} {
+		if b {
+			classes++
+		}
+	}
+	if classes < s.cfg.PasswordMinClasses {
+		return fmt.Sprintf("password must contain at least %d of: uppercase, lowercase, digits, symbols", s.cfg.PasswordMinClasses)
+	}
+	return ""
+}
+
+// historyDepth 历史保留条数，配置为 0 时按 1 处理（当前密码另行比较）
+func (s *AuthService) historyDepth() int {
+	if s.cfg.PasswordHistory < 1 {
+		return 1
+	}
+	return s.cfg.PasswordHistory
+}
+
+func passwordReused(password, currentHash string, history []model.PasswordHistory) bool {
+	if ok, _ := crypto.VerifyPassword(password, currentHash); ok {
+		return true
+	}
+	for _, h := range history {
+		if h.PasswordHash == currentHash {
+			continue
+		}
+		if ok, _ := crypto.VerifyPassword(password, h.PasswordHash); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recordPasswordHistory 追加一条历史并只保留最近 keep 条
+func recordPasswordHistory(tx *gorm.DB, userID int64, hash string, keep int) error {
+	if err := tx.Create(&model.PasswordHistory{UserID: userID, PasswordHash: hash}).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`DELETE FROM app.password_history WHERE user_id = ? AND id NOT IN (
+		SELECT id FROM app.password_history WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?)`,
+		userID, userID, keep).Error
+}
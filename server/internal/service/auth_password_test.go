@@ -0,0 +1,144 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"promthus/internal/config"
+	"promthus/internal/crypto"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/google/uuid"
+)
+
+// 测试用低成本参数，VerifyPassword 从哈希串中读取参数
+var testArgon2 = &crypto.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func testPasswordHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := crypto.HashPasswordWithParams(password, testArgon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func passwordTestService(store *memSessionStore) *AuthService {
+	return NewAuthService(store, &config.AuthConfig{
+		PasswordMinLength: 10, PasswordMinClasses: 3, PasswordHistory: 2,
+	}, nil, nil, nil, nil)
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	svc := passwordTestService(nil)
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"Short1!", false},
+		{"alllowercase", false},
+		{"lowerUPPER12", true},
+		{"lower-digits-12", true},
+		{"UPPER_AND_SYMBOLS", false},
+		{"Has Space 123", false},
+		{"Tab\tInside12", false},
+		{"密码Abcdef12", true}, // 汉字计为符号类
+		{"密码密码Ab1!", false},  // 按字符而非字节计长度
+	}
+	for _, tt := range tests {
+		if msg := svc.checkPasswordPolicy(tt.password); (msg == "") != tt.ok {
+			t.Errorf("checkPasswordPolicy(%q) = %q, want ok=%v", tt.password, msg, tt.ok)
+		}
+	}
+}
+
+func TestPasswordReused(t *testing.T) {
+	current := testPasswordHash(t, "Current-Pass1")
+	history := []model.PasswordHistory{
+		{PasswordHash: current},
+		{PasswordHash: testPasswordHash(t, "Older-Pass1")},
+	}
+	for _, pw := range []string{"Current-Pass1", "Older-Pass1"} {
+		if !passwordReused(pw, current, history) {
+			t.Errorf("%q not detected as reused", pw)
+		}
+	}
+	if passwordReused("Brand-New-Pass1", current, history) {
+		t.Error("new password reported as reused")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "admin", "")
+	repository.DB.Model(user).Updates(map[string]interface{}{
+		"password_hash": testPasswordHash(t, "Initial-Pass1"), "must_change_password": true,
+	})
+
+	store := newMemSessionStore()
+	current, other := uuid.New(), uuid.New()
+	for _, family := range []uuid.UUID{current, other} {
+		store.Create(&model.Session{JTI: uuid.New(), FamilyID: family, UserID: user.ID})
+	}
+	svc := passwordTestService(store)
+
+	change := func(cur, next string) int {
+		code, _ := svc.ChangePassword(user.ID, current, &ChangePasswordRequest{CurrentPassword: cur, NewPassword: next})
+		return code
+	}
+	if code := change("wrong-password", "Second-Pass1"); code != model.CodeAuthFailed {
+		t.Fatalf("wrong current: %d", code)
+	}
+	if code := change("Initial-Pass1", "weakpassword"); code != model.CodeParamError {
+		t.Fatalf("weak: %d", code)
+	}
+	if code := change("Initial-Pass1", "Initial-Pass1"); code != model.CodeParamError {
+		t.Fatalf("same as current: %d", code)
+	}
+	if code := change("Initial-Pass1", "Second-Pass1"); code != 0 {
+		t.Fatalf("change: %d", code)
+	}
+
+	var reloaded model.User
+	repository.DB.First(&reloaded, user.ID)
+	if reloaded.MustChangePassword || reloaded.PasswordChangedAt == nil {
+		t.Fatalf("must_change_password = %v, password_changed_at = %v", reloaded.MustChangePassword, reloaded.PasswordChangedAt)
+	}
+	// 其他设备被踢下线，当前会话保留
+	if _, err := store.FindByFamily(other); err == nil {
+		t.Fatal("other session survived password change")
+	}
+	if _, err := store.FindByFamily(current); err != nil {
+		t.Fatal("current session revoked")
+	}
+
+	// 管理员生成的初始密码不入历史；自行设置的密码在 PasswordHistory 次内不可复用
+	if code := change("Second-Pass1", "Third-Pass1"); code != 0 {
+		t.Fatalf("third: %d", code)
+	}
+	if code := change("Third-Pass1", "Second-Pass1"); code != model.CodeParamError {
+		t.Fatalf("reuse from history: %d", code)
+	}
+	if code := change("Third-Pass1", "Initial-Pass1"); code != 0 {
+		t.Fatalf("admin-issued password counted as history: %d", code)
+	}
+	var kept int64
+	repository.DB.Model(&model.PasswordHistory{}).Where("user_id = ?", user.ID).Count(&kept)
+	if kept != 2 {
+		t.Fatalf("password_history rows = %d, want 2", kept)
+	}
+}
+
+func TestChangePasswordDirectoryUser(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	repository.DB.Model(user).Update("auth_source", model.AuthSourceLDAP)
+
+	code, msg := passwordTestService(newMemSessionStore()).ChangePassword(user.ID, uuid.New(),
+		&ChangePasswordRequest{CurrentPassword: "x", NewPassword: "Whatever-Pass1"})
+	if code != model.CodeParamError || !strings.Contains(msg, "directory") {
+		t.Fatalf("ldap user: %d %s", code, msg)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/google/uuid"
+)
+
+func refreshTestConfig() *config.AuthConfig {
+	return &config.AuthConfig{TokenSecret: "refresh-test-secret",
+		SessionTTL: 12 * time.Hour, AccessTTL: 15 * time.Minute, IdleTimeout: 30 * time.Minute}
+}
+
+// seedRefreshSession 在内存 Store 中放一个处于 generation 代的会话，返回该代的刷新令牌
+func seedRefreshSession(t *testing.T, svc *AuthService, store *memSessionStore, generation int, lastUsed, expires time.Time) (*model.Session, string) {
+	t.Helper()
+	familyID := uuid.New()
+	token, hash, err := newRefreshToken(svc.refreshKeys()[0], familyID, generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &model.Session{
+		JTI: uuid.New(), FamilyID: familyID, UserID: 1, Role: "user",
+		RefreshTokenHash: hash, RefreshGeneration: generation,
+		ExpiresAt: expires, LastUsedAt: lastUsed,
+	}
+	if err := store.Create(session); err != nil {
+		t.Fatal(err)
+	}
+	return session, token
+}
+
+func TestRefreshTokenEncoding(t *testing.T) {
+	familyID := uuid.New()
+	key := []byte("k")
+	token, hash, err := newRefreshToken(key, familyID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotFamily, gotGen, err := parseRefreshToken(token)
+	if err != nil || gotFamily != familyID || gotGen != 7 {
+		t.Fatalf("parse = %s, %d, %v", gotFamily, gotGen, err)
+	}
+	if string(hash) != string(hashRefreshToken(token)) {
+		t.Fatal("stored hash does not match token")
+	}
+	for _, bad := range []string{"", "rt1.", "rt2." + token[4:], token[:len(token)-4]} {
+		if _, _, err := parseRefreshToken(bad); err == nil {
+			t.Errorf("parseRefreshToken(%q) accepted", bad)
+		}
+	}
+
+	// 签名密钥轮换期间，旧 kid 派生的 MAC 仍可校验；任何一把都对不上的视为伪造
+	cfg := &config.AuthConfig{TokenKeys: []config.TokenKey{{ID: "old", Secret: "s1"}, {ID: "new", Secret: "s2"}}, TokenActiveID: "new"}
+	svc := NewAuthService(nil, cfg, nil, nil, nil, nil)
+	keys := svc.refreshKeys()
+	if len(keys) != 2 || string(keys[0]) != string(refreshTokenMAC([]byte("s2"), []byte("promthus-refresh-token"))) {
+		t.Fatal("active key is not first")
+	}
+	for i, key := range keys {
+		issued, _, _ := newRefreshToken(key, familyID, 1)
+		if !svc.refreshTokenAuthentic(issued) {
+			t.Errorf("token from key %d not authentic", i)
+		}
+	}
+	if svc.refreshTokenAuthentic(token) {
+		t.Error("token from an unknown key accepted")
+	}
+}
+
+// 以下路径均在查用户之前返回，不需要数据库
+func TestRefreshRejections(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		generation  int
+		lastUsed    time.Time
+		expires     time.Time
+		token       func(key []byte, s *model.Session, current string) string
+		wantRevoked bool
+	}{
+		{
+			name: "reused older generation revokes family", generation: 2,
+			lastUsed: now, expires: now.Add(time.Hour),
+			token: func(key []byte, s *model.Session, _ string) string {
+				old, _, _ := newRefreshToken(key, s.FamilyID, 1)
+				return old
+			},
+			wantRevoked: true,
+		},
+		{
+			// family_id 可从会话列表获得，自行拼出的低代令牌不能吊销会话
+			name: "forged older generation leaves session alive", generation: 2,
+			lastUsed: now, expires: now.Add(time.Hour),
+			token: func(_ []byte, s *model.Session, _ string) string {
+				forged, _, _ := newRefreshToken([]byte("guessed"), s.FamilyID, 0)
+				return forged
+			},
+		},
+		{
+			name: "forged token of current generation", generation: 2,
+			lastUsed: now, expires: now.Add(time.Hour),
+			token: func(key []byte, s *model.Session, _ string) string {
+				forged, _, _ := newRefreshToken(key, s.FamilyID, 2)
+				return forged
+			},
+		},
+		{
+			name: "future generation", generation: 0,
+			lastUsed: now, expires: now.Add(time.Hour),
+			token: func(key []byte, s *model.Session, _ string) string {
+				next, _, _ := newRefreshToken(key, s.FamilyID, 1)
+				return next
+			},
+		},
+		{
+			name: "absolute expiry", generation: 0,
+			lastUsed: now, expires: now.Add(-time.Second),
+			token:       func(_ []byte, _ *model.Session, current string) string { return current },
+			wantRevoked: true,
+		},
+		{
+			name: "idle timeout", generation: 3,
+			lastUsed: now.Add(-time.Hour), expires: now.Add(time.Hour),
+			token:       func(_ []byte, _ *model.Session, current string) string { return current },
+			wantRevoked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemSessionStore()
+			svc := NewAuthService(store, refreshTestConfig(), nil, nil, nil, nil)
+			session, current := seedRefreshSession(t, svc, store, tt.generation, tt.lastUsed, tt.expires)
+
+			resp, code, _ := svc.Refresh(&RefreshRequest{RefreshToken: tt.token(svc.refreshKeys()[0], session, current)}, "ua", "10.0.0.1")
+			if resp != nil || code != model.CodeSessionExpired {
+				t.Fatalf("code = %d, want %d", code, model.CodeSessionExpired)
+			}
+			_, err := store.FindByFamily(session.FamilyID)
+			if revoked := err != nil; revoked != tt.wantRevoked {
+				t.Fatalf("session revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+		})
+	}
+
+	svc := NewAuthService(newMemSessionStore(), refreshTestConfig(), nil, nil, nil, nil)
+	if _, code, _ := svc.Refresh(&RefreshRequest{RefreshToken: "rt1.garbage"}, "ua", "10.0.0.1"); code != model.CodeSessionExpired {
+		t.Fatalf("malformed: code = %d", code)
+	}
+}
+
+// 登录 → 刷新轮换 → 重放旧刷新令牌吊销整个 family，新令牌随之失效
+func TestRefreshRotationAndReuse(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	store := repository.NewPostgresSessionStore()
+	svc := NewAuthService(store, refreshTestConfig(), nil, nil, nil, nil)
+
+	login, code, msg := svc.createSession(user, loginActionLogin, "ua", "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("login: %d %s", code, msg)
+	}
+	if !login.ExpiresAt.Before(login.RefreshExpiresAt) || time.Until(login.ExpiresAt) > 16*time.Minute {
+		t.Fatalf("access expires %v, session expires %v", login.ExpiresAt, login.RefreshExpiresAt)
+	}
+	first, _ := middleware.ParseToken(login.Token)
+
+	next, code, msg := svc.Refresh(&RefreshRequest{RefreshToken: login.RefreshToken}, "ua", "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("refresh: %d %s", code, msg)
+	}
+	second, _ := middleware.ParseToken(next.Token)
+	if next.RefreshToken == login.RefreshToken || second.JTI == first.JTI {
+		t.Fatal("refresh did not rotate the token pair")
+	}
+	if !next.RefreshExpiresAt.Equal(login.RefreshExpiresAt) {
+		t.Fatalf("refresh extended the absolute lifetime: %v -> %v", login.RefreshExpiresAt, next.RefreshExpiresAt)
+	}
+	// 旧 jti 随轮换作废
+	if _, err := store.FindByJTI(first.JTI); err == nil {
+		t.Fatal("old access token jti still valid")
+	}
+
+	if _, code, _ := svc.Refresh(&RefreshRequest{RefreshToken: login.RefreshToken}, "ua", "10.9.9.9"); code != model.CodeSessionExpired {
+		t.Fatalf("reuse: code = %d, want %d", code, model.CodeSessionExpired)
+	}
+	if _, code, _ := svc.Refresh(&RefreshRequest{RefreshToken: next.RefreshToken}, "ua", "10.0.0.1"); code != model.CodeSessionExpired {
+		t.Fatalf("refresh after family revoked: code = %d", code)
+	}
+	if _, err := store.FindByJTI(second.JTI); err == nil {
+		t.Fatal("session survived reuse detection")
+	}
+}
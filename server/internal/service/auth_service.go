@@ -1,7 +1,14 @@
 package service
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"promthus/internal/config"
@@ -9,6 +16,7 @@ import (
 	"promthus/internal/logger"
 	"promthus/internal/middleware"
 	"promthus/internal/model"
+	"promthus/internal/mq"
 	"promthus/internal/repository"
 
 	"github.com/google/uuid"
@@ -19,89 +27,392 @@ import (
 type AuthService struct {
 	sessionStore repository.SessionStore
 	cfg          *config.AuthConfig
+	mfaKey       []byte // 加密 TOTP 密钥（crypto.AESEncrypt）
+	publisher    *mq.Publisher
+	// 登录后端，按顺序认领登录名
+	authenticators []Authenticator
+	oidc           *OIDCClient // 为 nil 表示未启用单点登录
 }
 
-func NewAuthService(ss repository.SessionStore, cfg *config.AuthConfig) *AuthService {
-	return &AuthService{sessionStore: ss, cfg: cfg}
+func NewAuthService(ss repository.SessionStore, cfg *config.AuthConfig, mfaKey []byte, pub *mq.Publisher, authenticators []Authenticator, oidcClient *OIDCClient) *AuthService {
+	return &AuthService{sessionStore: ss, cfg: cfg, mfaKey: mfaKey, publisher: pub, authenticators: authenticators, oidc: oidcClient}
 }
 
 type LoginRequest struct {
-	Phone    string `json:"phone" binding:"required"`
+	Phone    string `json:"phone" binding:"required"` // 本地账号为手机号，目录账号为域账号
 	Password string `json:"password" binding:"required,min=8"`
 }
 
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
-	UserUUID  string    `json:"user_uuid"`
-	Role      string    `json:"role"`
-	Name      string    `json:"name"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"` // 访问 Token 过期时间
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"` // 会话绝对过期时间
+	UserUUID         string    `json:"user_uuid"`
+	Role             string    `json:"role"`
+	Name             string    `json:"name"`
+	// 角色的后台动作集合，前端据此显示菜单；以服务端逐路由校验为准
+	Permissions []string `json:"permissions,omitempty"`
+
+	// 两步登录：密码通过后若需 MFA，只返回 mfa_token，不签发 Token
+	MFARequired       bool     `json:"mfa_required,omitempty"`
+	MFAEnrollRequired bool     `json:"mfa_enroll_required,omitempty"` // 角色要求 MFA 但尚未绑定
+	MFAToken          string   `json:"mfa_token,omitempty"`
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"` // 仅在首次绑定确认时返回一次
+
+	MustChangePassword bool `json:"must_change_password,omitempty"` // 为 true 时只能先调 POST /api/auth/password
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// resolveLogin 按配置顺序找到认领该登录名的后端；某个后端不可用（如目录连不上）时继续尝试后面的，
+// 本地应急管理员账号不受影响。没有后端认领且有后端出错时返回该错误，而不是当作账号不存在。
+func (s *AuthService) resolveLogin(login string) (*Identity, Authenticator, error) {
+	var firstErr error
+	for _, auth := range s.authenticators {
+		id, err := auth.Resolve(login)
+		if err != nil {
+			logger.Warn("login: auth backend unavailable", zap.String("backend", auth.Name()), zap.Error(err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", auth.Name(), err)
+			}
+			continue
+		}
+		if id != nil {
+			return id, auth, nil
+		}
+	}
+	return nil, nil, firstErr
 }
 
 func (s *AuthService) Login(req *LoginRequest, userAgent, ipAddress string) (*LoginResponse, int, string) {
 	logger.Info("login: attempt",
 		zap.String("phone", req.Phone), zap.String("ip", ipAddress), zap.String("user_agent", userAgent))
 
-	var user model.User
-	err := repository.DB.Where("phone = ? AND deleted_at IS NULL", req.Phone).First(&user).Error
-
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	id, auth, err := s.resolveLogin(req.Phone)
+	if err != nil {
+		logger.Error("login: resolve failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if id == nil {
 		crypto.DummyVerify()
 		logger.Info("login: failed, user not found (dummy verify executed)", zap.String("phone", req.Phone))
+		s.auditLogin(nil, req.Phone, loginActionLogin, loginResultInvalidCredentials, userAgent, ipAddress, nil)
 		return nil, model.CodeAuthFailed, "invalid credentials"
 	}
-	if err != nil {
-		logger.Error("login: db query failed", zap.Error(err))
-		return nil, model.CodeInternalError, "internal error"
+	backend := map[string]interface{}{"backend": auth.Name()}
+
+	// 锁定期内不校验密码，避免继续猜测（也避免把目录账号锁死）
+	if id.User != nil {
+		if until, locked := accountLockedUntil(id.User, time.Now()); locked {
+			logger.Info("login: rejected, account locked",
+				zap.Int64("user_id", id.User.ID), zap.Time("locked_until", until), zap.String("ip", ipAddress))
+			s.auditLogin(id.User, req.Phone, loginActionLogin, loginResultAccountLocked, userAgent, ipAddress, backend)
+			return nil, model.CodeAccountLocked, lockedMessage(until)
+		}
 	}
 
-	valid, err := crypto.VerifyPassword(req.Password, user.PasswordHash)
-	if err != nil || !valid {
+	verified, err := auth.Verify(id, req.Password)
+	switch {
+	case errors.Is(err, errInvalidCredentials):
 		logger.Info("login: failed, invalid password",
-			zap.String("phone", req.Phone), zap.Int64("user_id", user.ID))
+			zap.String("phone", req.Phone), zap.String("backend", auth.Name()))
+		s.auditLogin(id.User, req.Phone, loginActionLogin, loginResultInvalidCredentials, userAgent, ipAddress, backend)
+		if id.User == nil {
+			return nil, model.CodeAuthFailed, "invalid credentials"
+		}
+		return s.loginFailed(id.User, ipAddress, "invalid credentials")
+	case errors.Is(err, errNotEntitled):
+		// 密码正确但不在任何映射组内，对外与密码错误同一提示
+		logger.Info("login: rejected, no mapped role",
+			zap.String("phone", req.Phone), zap.String("backend", auth.Name()))
+		s.auditLogin(id.User, req.Phone, loginActionLogin, loginResultNotEntitled, userAgent, ipAddress, backend)
 		return nil, model.CodeAuthFailed, "invalid credentials"
+	case err != nil:
+		logger.Error("login: verify failed", zap.Error(err), zap.String("backend", auth.Name()))
+		return nil, model.CodeInternalError, "internal error"
 	}
+	user := *verified
 
 	if user.Status == 0 {
 		logger.Info("login: rejected, account disabled",
 			zap.String("phone", req.Phone), zap.Int64("user_id", user.ID))
+		s.auditLogin(&user, req.Phone, loginActionLogin, loginResultAccountDisabled, userAgent, ipAddress, nil)
 		return nil, model.CodeAccountDisabled, "account has been disabled"
 	}
 
-	jti := uuid.New()
-	expiresAt := time.Now().Add(s.cfg.SessionTTL)
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		logger.Error("login: mfa query failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if mfaEnabled || s.mfaRequired(user.Role) {
+		s.auditLogin(&user, req.Phone, loginActionLogin, loginResultMFAChallenge, userAgent, ipAddress, nil)
+		return s.startMFAChallenge(&user, mfaEnabled, ipAddress)
+	}
+
+	return s.createSession(&user, loginActionLogin, userAgent, ipAddress)
+}
+
+// loginFailed 计入账户连续失败；本次恰好触发锁定时直接返回锁定提示。
+func (s *AuthService) loginFailed(user *model.User, ipAddress, msg string) (*LoginResponse, int, string) {
+	lockedUntil, err := s.recordLoginFailure(user, ipAddress)
+	if err != nil {
+		logger.Error("login: record failure failed", zap.Error(err), zap.Int64("user_id", user.ID))
+	}
+	if lockedUntil != nil {
+		return nil, model.CodeAccountLocked, lockedMessage(*lockedUntil)
+	}
+	return nil, model.CodeAuthFailed, msg
+}
+
+// createSession 凭据（含 MFA）全部校验通过后建立会话并签发 Token。
+func (s *AuthService) createSession(user *model.User, action, userAgent, ipAddress string) (*LoginResponse, int, string) {
+	s.detectLoginAnomaly(user, userAgent, ipAddress)
 
+	now := time.Now()
 	session := &model.Session{
-		JTI:       jti,
-		UserID:    user.ID,
-		Role:      user.Role,
-		ExpiresAt: expiresAt,
-		UserAgent: userAgent,
-		IPAddress: ipAddress,
+		JTI:        uuid.New(),
+		FamilyID:   uuid.New(),
+		UserID:     user.ID,
+		Role:       user.Role,
+		ExpiresAt:  now.Add(s.cfg.SessionTTL),
+		LastUsedAt: now,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+	}
+	refreshToken, refreshHash, err := newRefreshToken(s.refreshKeys()[0], session.FamilyID, 0)
+	if err != nil {
+		logger.Error("login: generate refresh token failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
 	}
+	session.RefreshTokenHash = refreshHash
 
 	if err := s.sessionStore.Create(session); err != nil {
 		logger.Error("login: create session failed", zap.Error(err), zap.Int64("user_id", user.ID))
 		return nil, model.CodeInternalError, "internal error"
 	}
 
-	token, err := middleware.GenerateToken(user.UUID, jti)
+	resp, err := s.issueTokens(user, session, refreshToken, now)
 	if err != nil {
 		logger.Error("login: generate token failed", zap.Error(err), zap.Int64("user_id", user.ID))
 		return nil, model.CodeInternalError, "internal error"
 	}
 
+	s.clearLoginFailures(user)
+	s.auditLogin(user, user.Phone, action, loginResultSuccess, userAgent, ipAddress, nil)
+
 	logger.Info("login: success",
 		zap.Int64("user_id", user.ID), zap.String("role", user.Role),
-		zap.String("ip", ipAddress), zap.Time("expires_at", expiresAt))
+		zap.String("ip", ipAddress), zap.Time("session_expires_at", session.ExpiresAt))
+
+	return resp, 0, ""
+}
+
+/*
+Refresh 用刷新令牌换取新的访问 Token 与刷新令牌（一次一换）：
+  - 令牌代数小于库中代数且 MAC 校验通过：旧令牌被重放，说明令牌可能泄露，吊销整个 family（删除 session）；
+    family_id 会在会话列表中展示，MAC 不对的低代令牌只按无效处理，不能借此吊销他人会话；
+  - 并发刷新时只有一方轮换成功，另一方同样按重用处理；
+  - 超过绝对有效期或空闲超时、账号被禁用时删除会话，须重新登录。
+*/
+func (s *AuthService) Refresh(req *RefreshRequest, userAgent, ipAddress string) (*LoginResponse, int, string) {
+	familyID, generation, err := parseRefreshToken(req.RefreshToken)
+	if err != nil {
+		logger.Info("refresh: rejected, malformed token", zap.String("ip", ipAddress))
+		return nil, model.CodeSessionExpired, "invalid refresh token"
+	}
+
+	session, err := s.sessionStore.FindByFamily(familyID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Info("refresh: rejected, session not found", zap.String("family_id", familyID.String()), zap.String("ip", ipAddress))
+		return nil, model.CodeSessionExpired, "session expired, please login again"
+	}
+	if err != nil {
+		logger.Error("refresh: session query failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	if generation < session.RefreshGeneration {
+		if !s.refreshTokenAuthentic(req.RefreshToken) {
+			logger.Warn("refresh: rejected, forged token of an earlier generation",
+				zap.String("family_id", familyID.String()), zap.String("ip", ipAddress))
+			return nil, model.CodeSessionExpired, "invalid refresh token"
+		}
+		s.revokeFamily(session, "refresh token reused", ipAddress, userAgent)
+		return nil, model.CodeSessionExpired, "refresh token reused, session revoked"
+	}
+	if generation != session.RefreshGeneration || !hmac.Equal(hashRefreshToken(req.RefreshToken), session.RefreshTokenHash) {
+		logger.Warn("refresh: rejected, token mismatch",
+			zap.String("family_id", familyID.String()), zap.String("ip", ipAddress))
+		return nil, model.CodeSessionExpired, "invalid refresh token"
+	}
+
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		_ = s.sessionStore.DeleteByFamily(familyID)
+		logger.Info("refresh: rejected, session expired", zap.Int64("user_id", session.UserID))
+		return nil, model.CodeSessionExpired, "session expired, please login again"
+	}
+	if s.cfg.IdleTimeout > 0 && now.Sub(session.LastUsedAt) > s.cfg.IdleTimeout {
+		_ = s.sessionStore.DeleteByFamily(familyID)
+		logger.Info("refresh: rejected, session idle timeout",
+			zap.Int64("user_id", session.UserID), zap.Time("last_used_at", session.LastUsedAt))
+		return nil, model.CodeSessionExpired, "session idle timeout, please login again"
+	}
+
+	var user model.User
+	if err := repository.DB.Where("id = ? AND deleted_at IS NULL", session.UserID).First(&user).Error; err != nil || user.Status == 0 {
+		_ = s.sessionStore.DeleteByFamily(familyID)
+		logger.Info("refresh: rejected, account unavailable", zap.Int64("user_id", session.UserID))
+		return nil, model.CodeAccountDisabled, "account has been disabled"
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken(s.refreshKeys()[0], familyID, generation+1)
+	if err != nil {
+		logger.Error("refresh: generate refresh token failed", zap.Error(err))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	newJTI := uuid.New()
+	rotated, err := s.sessionStore.Rotate(session.ID, generation, newJTI, refreshHash, user.Role, now)
+	if err != nil {
+		logger.Error("refresh: rotate session failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	if !rotated {
+		s.revokeFamily(session, "concurrent refresh with the same token", ipAddress, userAgent)
+		return nil, model.CodeSessionExpired, "refresh token reused, session revoked"
+	}
+	session.JTI, session.Role, session.RefreshGeneration = newJTI, user.Role, generation+1
+
+	resp, err := s.issueTokens(&user, session, refreshToken, now)
+	if err != nil {
+		logger.Error("refresh: generate token failed", zap.Error(err), zap.Int64("user_id", user.ID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	logger.Info("refresh: success",
+		zap.Int64("user_id", user.ID), zap.Int("generation", session.RefreshGeneration), zap.String("ip", ipAddress))
+	return resp, 0, ""
+}
 
+// issueTokens 签发访问 Token，有效期不超过会话的绝对过期时间。
+func (s *AuthService) issueTokens(user *model.User, session *model.Session, refreshToken string, now time.Time) (*LoginResponse, error) {
+	accessExpiresAt := now.Add(s.cfg.AccessTTL)
+	if session.ExpiresAt.Before(accessExpiresAt) {
+		accessExpiresAt = session.ExpiresAt
+	}
+	token, err := middleware.GenerateToken(user.UUID, session.JTI, user.Role, accessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := middleware.RolePermissions(user.Role)
+	if err != nil {
+		return nil, err
+	}
 	return &LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		UserUUID:  user.UUID.String(),
-		Role:      user.Role,
-		Name:      user.Name,
-	}, 0, ""
+		Token:            token,
+		ExpiresAt:        accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		UserUUID:         user.UUID.String(),
+		Role:             user.Role,
+		Name:             user.Name,
+		Permissions:      perms,
+
+		MustChangePassword: user.MustChangePassword,
+	}, nil
+}
+
+func (s *AuthService) revokeFamily(session *model.Session, reason, ipAddress, userAgent string) {
+	logger.Warn("refresh: token reuse detected, revoking session family",
+		zap.String("reason", reason), zap.Int64("user_id", session.UserID),
+		zap.String("family_id", session.FamilyID.String()),
+		zap.String("ip", ipAddress), zap.String("user_agent", userAgent))
+	if err := s.sessionStore.DeleteByFamily(session.FamilyID); err != nil {
+		logger.Error("refresh: revoke session family failed", zap.Error(err))
+	}
+}
+
+// 刷新令牌："rt1." + base64url(family_id(16B) || generation(4B 大端) || 随机数(32B) || MAC(32B))，库中只存 SHA-256。
+// MAC 为 HMAC-SHA256(刷新密钥, 前 52 字节)，用于在没有旧代哈希的情况下确认旧代令牌确由本服务签发。
+const refreshTokenPrefix = "rt1."
+
+const refreshTokenBodyLen = 16 + 4 + 32
+
+func newRefreshToken(key []byte, familyID uuid.UUID, generation int) (string, []byte, error) {
+	raw := make([]byte, refreshTokenBodyLen, refreshTokenBodyLen+sha256.Size)
+	copy(raw[:16], familyID[:])
+	binary.BigEndian.PutUint32(raw[16:20], uint32(generation))
+	if _, err := rand.Read(raw[20:]); err != nil {
+		return "", nil, err
+	}
+	raw = append(raw, refreshTokenMAC(key, raw)...)
+	token := refreshTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashRefreshToken(token), nil
+}
+
+func decodeRefreshToken(token string) ([]byte, error) {
+	if !strings.HasPrefix(token, refreshTokenPrefix) {
+		return nil, errors.New("bad prefix")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token[len(refreshTokenPrefix):])
+	if err != nil || len(raw) != refreshTokenBodyLen+sha256.Size {
+		return nil, errors.New("bad encoding")
+	}
+	return raw, nil
+}
+
+func parseRefreshToken(token string) (uuid.UUID, int, error) {
+	raw, err := decodeRefreshToken(token)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	familyID, err := uuid.FromBytes(raw[:16])
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	return familyID, int(binary.BigEndian.Uint32(raw[16:20])), nil
+}
+
+func refreshTokenMAC(key, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// refreshKeys 刷新令牌的 MAC 密钥，由访问 Token 签名密钥派生：第一把对应生效的 kid，用于签发；
+// 轮换期间其余各把仍用于校验。
+func (s *AuthService) refreshKeys() [][]byte {
+	var keys [][]byte
+	for _, k := range s.cfg.SigningKeys() {
+		key := refreshTokenMAC([]byte(k.Secret), []byte("promthus-refresh-token"))
+		if k.ID == s.cfg.TokenActiveID {
+			keys = append([][]byte{key}, keys...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *AuthService) refreshTokenAuthentic(token string) bool {
+	raw, err := decodeRefreshToken(token)
+	if err != nil {
+		return false
+	}
+	for _, key := range s.refreshKeys() {
+		if hmac.Equal(raw[refreshTokenBodyLen:], refreshTokenMAC(key, raw[:refreshTokenBodyLen])) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashRefreshToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
 }
 
 func (s *AuthService) Logout(jti uuid.UUID) error {
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"time"
+
+	"promthus/internal/logger"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SessionInfo 会话列表项；吊销时用 family_id（整个登录周期不变），jti 随每次刷新更换
+type SessionInfo struct {
+	JTI        string    `json:"jti"`
+	FamilyID   string    `json:"family_id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // 发起本次请求的会话
+}
+
+// listSessions 列出未过期的会话；空闲超时的会话在下次刷新时才被拒绝，仍会列出（看 last_used_at）
+func listSessions(store repository.SessionStore, userID int64, currentFamily uuid.UUID) ([]SessionInfo, error) {
+	sessions, err := store.ListByUser(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	items := make([]SessionInfo, 0, len(sessions))
+	for i := range sessions {
+		sess := &sessions[i]
+		items = append(items, SessionInfo{
+			JTI:        sess.JTI.String(),
+			FamilyID:   sess.FamilyID.String(),
+			UserAgent:  sess.UserAgent,
+			IPAddress:  sess.IPAddress,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastUsedAt,
+			ExpiresAt:  sess.ExpiresAt,
+			Current:    sess.FamilyID == currentFamily,
+		})
+	}
+	return items, nil
+}
+
+// ListSessions 当前用户的在线会话（登录设备）
+func (s *AuthService) ListSessions(userID int64, currentFamily uuid.UUID) ([]SessionInfo, int, string) {
+	items, err := listSessions(s.sessionStore, userID, currentFamily)
+	if err != nil {
+		logger.Error("list_sessions: query failed", zap.Error(err), zap.Int64("user_id", userID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	return items, 0, ""
+}
+
+// RevokeSession 吊销自己的某个会话（如丢失的手机），id 可为 jti 或 family_id；吊销当前会话等同登出
+func (s *AuthService) RevokeSession(userID int64, id string) (int, string) {
+	sessionID, err := uuid.Parse(id)
+	if err != nil {
+		return model.CodeParamError, "invalid session id"
+	}
+	found, err := s.sessionStore.DeleteForUser(userID, sessionID)
+	if err != nil {
+		logger.Error("revoke_session: delete failed", zap.Error(err), zap.Int64("user_id", userID))
+		return model.CodeInternalError, "internal error"
+	}
+	if !found {
+		return model.CodeParamError, "session not found"
+	}
+
+	writeOperationLog(userID, "revoke_session", "user", userID, map[string]interface{}{"session": id}, nil)
+	logger.Info("revoke_session: success", zap.Int64("user_id", userID), zap.String("session", id))
+	return 0, ""
+}
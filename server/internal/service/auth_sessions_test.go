@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/google/uuid"
+)
+
+func TestListSessions(t *testing.T) {
+	store := newMemSessionStore()
+	svc := NewAuthService(store, refreshTestConfig(), nil, nil, nil, nil)
+	now := time.Now()
+	current, _ := seedRefreshSession(t, svc, store, 0, now, now.Add(time.Hour))
+	older, _ := seedRefreshSession(t, svc, store, 0, now.Add(-time.Hour), now.Add(time.Hour))
+	seedRefreshSession(t, svc, store, 0, now, now.Add(-time.Second)) // 已过期
+	store.Create(&model.Session{JTI: uuid.New(), FamilyID: uuid.New(), UserID: 2, ExpiresAt: now.Add(time.Hour)})
+
+	items, code, _ := svc.ListSessions(1, current.FamilyID)
+	if code != 0 || len(items) != 2 {
+		t.Fatalf("ListSessions = %d items, code %d", len(items), code)
+	}
+	if items[0].FamilyID != current.FamilyID.String() || !items[0].Current ||
+		items[1].FamilyID != older.FamilyID.String() || items[1].Current {
+		t.Fatalf("items = %+v", items)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	testdb.Open(t)
+	alice, bob := newTestUser(t, "user", ""), newTestUser(t, "user", "")
+	store := repository.NewPostgresSessionStore()
+	now := time.Now()
+	newSession := func(userID int64) *model.Session {
+		s := &model.Session{JTI: uuid.New(), FamilyID: uuid.New(), UserID: userID, Role: "user",
+			ExpiresAt: now.Add(time.Hour), LastUsedAt: now}
+		if err := store.Create(s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	phone, laptop, bobs := newSession(alice.ID), newSession(alice.ID), newSession(bob.ID)
+	svc := NewAuthService(store, refreshTestConfig(), nil, nil, nil, nil)
+
+	if code, _ := svc.RevokeSession(alice.ID, "not-a-uuid"); code != model.CodeParamError {
+		t.Fatalf("bad id: %d", code)
+	}
+	if code, _ := svc.RevokeSession(alice.ID, bobs.FamilyID.String()); code != model.CodeParamError {
+		t.Fatalf("other user's session: %d", code)
+	}
+	if code, msg := svc.RevokeSession(alice.ID, phone.FamilyID.String()); code != 0 {
+		t.Fatalf("revoke own: %d %s", code, msg)
+	}
+	if _, err := store.FindByJTI(phone.JTI); err == nil {
+		t.Fatal("revoked session still valid")
+	}
+	if _, err := store.FindByJTI(laptop.JTI); err != nil {
+		t.Fatal("unrelated session revoked")
+	}
+
+	// 管理员远程踢下线单个会话，不影响账户状态
+	admin := newTestUser(t, "admin", "")
+	adminSvc := NewAdminService(store, nil)
+	if code, msg := adminSvc.RevokeUserSession(alice.UUID.String(), laptop.JTI.String(), admin.ID); code != 0 {
+		t.Fatalf("admin revoke: %d %s", code, msg)
+	}
+	if code, _ := adminSvc.RevokeUserSession(alice.UUID.String(), bobs.JTI.String(), admin.ID); code != model.CodeParamError {
+		t.Fatalf("admin revoke mismatched user: %d", code)
+	}
+	if n := countOperationLogs(t, "revoke_session", alice.ID); n != 2 {
+		t.Fatalf("revoke_session logs = %d", n)
+	}
+	var reloaded model.User
+	repository.DB.First(&reloaded, alice.ID)
+	if reloaded.Status != 1 {
+		t.Fatal("account disabled by session revoke")
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"promthus/internal/config"
+	"promthus/internal/crypto"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+/*
+Authenticator 登录凭据后端。Login 按 AUTH_BACKENDS 的顺序调用 Resolve，第一个认领登录名的后端负责校验密码：
+Resolve 在校验密码之前找出对应的本地用户，以便先做锁定检查；Verify 校验密码并返回最终登录的用户
+（LDAP 首次登录时即时开通，之后每次同步目录属性）。账户锁定、MFA、会话与审计仍由 Login 统一处理。
+*/
+type Authenticator interface {
+	Name() string
+	// Resolve 不归本后端管的登录名返回 (nil, nil)；Identity.User 为 nil 表示尚未开通
+	Resolve(login string) (*Identity, error)
+	// Verify 密码错误返回 errInvalidCredentials，目录中没有任何可映射角色返回 errNotEntitled
+	Verify(id *Identity, password string) (*model.User, error)
+}
+
+// Identity 后端认领的登录身份；entry 为后端私有数据（如 LDAP 条目）
+type Identity struct {
+	Login string
+	User  *model.User
+	entry interface{}
+}
+
+// roleAssignable 用户（含 LDAP 组、OIDC claim 映射）可被分配 app.roles 中除 service 以外的任意角色
+func roleAssignable(name string) bool {
+	if name == "" || name == model.RoleService {
+		return false
+	}
+	var cnt int64
+	repository.DB.Model(&model.Role{}).Where("name = ?", name).Count(&cnt)
+	return cnt > 0
+}
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errNotEntitled        = errors.New("no role mapped for this account")
+)
+
+// NewAuthenticators 按配置顺序构造登录后端，未配置时只启用本地密码
+func NewAuthenticators(cfg *config.AuthConfig) ([]Authenticator, error) {
+	names := cfg.Backends
+	if len(names) == 0 {
+		names = []string{model.AuthSourceLocal}
+	}
+	var out []Authenticator
+	for _, name := range names {
+		switch name {
+		case model.AuthSourceLocal:
+			out = append(out, localAuthenticator{})
+		case model.AuthSourceLDAP:
+			a, err := NewLDAPAuthenticator(&cfg.LDAP)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, a)
+		default:
+			return nil, fmt.Errorf("unknown auth backend %q", name)
+		}
+	}
+	return out, nil
+}
+
+// localAuthenticator 手机号 + app.users 中的 Argon2id 密码
+type localAuthenticator struct{}
+
+func (localAuthenticator) Name() string { return model.AuthSourceLocal }
+
+func (localAuthenticator) Resolve(login string) (*Identity, error) {
+	// 服务账号背后的用户只能经 API Key 访问，按不存在处理
+	var user model.User
+	err := repository.DB.Where("phone = ? AND auth_source = ? AND role <> ? AND deleted_at IS NULL",
+		login, model.AuthSourceLocal, model.RoleService).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Login: login, User: &user}, nil
+}
+
+func (localAuthenticator) Verify(id *Identity, password string) (*model.User, error) {
+	valid, err := crypto.VerifyPassword(password, id.User.PasswordHash)
+	if err != nil || !valid {
+		return nil, errInvalidCredentials
+	}
+	return id.User, nil
+}
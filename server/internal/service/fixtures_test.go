@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/kms"
+	"promthus/internal/logger"
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.L = zap.NewNop()
+	if err := middleware.SetTokenKeys([]config.TokenKey{{ID: "test", Secret: "test-token-secret"}}, ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// 以下为集成测试的数据准备，均要求已调用 testdb.Open
+
+var fixtureSeq atomic.Int64
+
+func newTestUser(t *testing.T, role, department string) *model.User {
+	t.Helper()
+	n := fixtureSeq.Add(1)
+	user := &model.User{
+		UUID:         uuid.New(),
+		Phone:        fmt.Sprintf("1380000%04d", n),
+		PasswordHash: "x",
+		Name:         fmt.Sprintf("user%d", n),
+		Role:         role,
+		Status:       1,
+		AuthSource:   model.AuthSourceLocal,
+	}
+	if department != "" {
+		user.Department.String, user.Department.Valid = department, true
+	}
+	if err := repository.DB.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// newTestDevice 返回设备及其明文密钥，密钥经当前 KMS 加密入库
+func newTestDevice(t *testing.T, deviceID, pipelineTag string) (*model.Device, []byte) {
+	t.Helper()
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := kms.Get().EncryptDeviceKey(key)
+	if err != nil {
+		t.Fatalf("encrypt device key: %v", err)
+	}
+	device := &model.Device{
+		DeviceID:     deviceID,
+		Name:         deviceID,
+		LocationText: "test",
+		KeyEncrypted: encrypted,
+		KeyVersion:   1,
+		MACAlgorithm: model.MACAlgorithmCMAC,
+		RiskLevel:    1,
+		Status:       1,
+	}
+	if pipelineTag != "" {
+		device.PipelineTag.String, device.PipelineTag.Valid = pipelineTag, true
+	}
+	if err := repository.DB.Create(device).Error; err != nil {
+		t.Fatalf("create device: %v", err)
+	}
+	return device, key
+}
+
+func grantTestPermission(t *testing.T, userID int64, deviceID string, schedule *model.PermissionSchedule) *model.Permission {
+	t.Helper()
+	perm := &model.Permission{
+		UserID:     userID,
+		DeviceType: model.DeviceTypeLock,
+		DeviceID:   deviceID,
+		GrantedBy:  userID,
+		ValidFrom:  time.Now().Add(-time.Hour),
+		Schedule:   schedule,
+		Status:     1,
+	}
+	if err := repository.DB.Create(perm).Error; err != nil {
+		t.Fatalf("create permission: %v", err)
+	}
+	return perm
+}
+
+func newTestLockService(alertCfg *config.AlertConfig) *LockService {
+	return NewLockService(
+		repository.NewPostgresDeviceFailStore(),
+		repository.NewPostgresChallengeNonceStore(),
+		repository.NewPostgresUnlockSessionStore(),
+		repository.NewPostgresRateLimitStore(),
+		repository.NewPostgresDeviceBlockStore(),
+		nil, alertCfg)
+}
+
+func countAlerts(t *testing.T, alertType, deviceID string) int64 {
+	t.Helper()
+	var n int64
+	if err := repository.DB.Model(&model.Alert{}).
+		Where("alert_type = ? AND device_id = ?", alertType, deviceID).Count(&n).Error; err != nil {
+		t.Fatalf("count alerts: %v", err)
+	}
+	return n
+}
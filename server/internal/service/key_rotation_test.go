@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func reloadDevice(t *testing.T, id int64) *model.Device {
+	t.Helper()
+	var d model.Device
+	if err := repository.DB.First(&d, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &d
+}
+
+func countOperationLogs(t *testing.T, action string, targetID int64) int64 {
+	t.Helper()
+	var n int64
+	if err := repository.DB.Model(&model.OperationLog{}).
+		Where("action = ? AND target_id = ?", action, targetID).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// 发起轮换 → 新旧版本并存 → 锁具以新版本签回执 → 新密钥转正
+func TestDeviceKeyRotation(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-ROTATE", "")
+	grantTestPermission(t, user.ID, device.DeviceID, nil)
+	adminSvc := NewAdminService(nil, nil)
+	lockSvc := newTestLockService(nil)
+
+	resp, code, msg := adminSvc.RotateDeviceKey(device.DeviceID, &RotateDeviceKeyRequest{}, admin.ID)
+	if code != 0 {
+		t.Fatalf("rotate: %d %s", code, msg)
+	}
+	if resp.KeyVersion != 1 || resp.PendingKeyVersion != 2 {
+		t.Fatalf("rotate response = %+v", resp)
+	}
+	if _, code, _ := adminSvc.RotateDeviceKey(device.DeviceID, &RotateDeviceKeyRequest{}, admin.ID); code != model.CodeParamError {
+		t.Fatalf("second rotate while pending: code = %d, want %d", code, model.CodeParamError)
+	}
+	pending := reloadDevice(t, device.ID)
+
+	// 锁具仍持旧密钥：按旧版本应答并附带换钥报文
+	oldReq := challengeReq(device.DeviceID, 1)
+	old, code, msg := lockSvc.Challenge(oldReq, user.ID, "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("challenge with old key: %d %s", code, msg)
+	}
+	if old.KeyVersion != 1 || old.KeyUpdate == "" {
+		t.Fatalf("old-key challenge: key_version=%d key_update=%q", old.KeyVersion, old.KeyUpdate)
+	}
+
+	// 锁具已换钥：按新版本应答，不再下发报文
+	v2 := int16(2)
+	newReq := challengeReq(device.DeviceID, 2)
+	newReq.KeyVersion = &v2
+	fresh, code, msg := lockSvc.Challenge(newReq, user.ID, "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("challenge with new key: %d %s", code, msg)
+	}
+	if fresh.KeyVersion != 2 || fresh.KeyUpdate != "" || fresh.Response == old.Response {
+		t.Fatalf("new-key challenge = %+v", fresh)
+	}
+
+	// 用新密钥签名的回执确认换钥
+	signer := *pending
+	signer.KeyEncrypted = pending.PendingKeyEncrypted
+	req := &ReportRequest{UnlockSessionID: fresh.UnlockSessionID, DeviceID: device.DeviceID, Result: "success",
+		OccurredAt: time.Now().Unix(), ReceiptCounter: 1, KeyVersion: 2,
+		Receipt: signReceipt(t, &signer, newReq.ChallengeC, "success", 1)}
+	if code, msg := lockSvc.Report(req, user.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("report: %d %s", code, msg)
+	}
+
+	confirmed := reloadDevice(t, device.ID)
+	if confirmed.KeyVersion != 2 || confirmed.PendingKeyVersion != nil || confirmed.PendingKeyEncrypted != nil ||
+		confirmed.KeyRotatedAt == nil || !bytes.Equal(confirmed.KeyEncrypted, pending.PendingKeyEncrypted) {
+		t.Fatalf("device after confirmation: version=%d pending=%v rotated_at=%v",
+			confirmed.KeyVersion, confirmed.PendingKeyVersion, confirmed.KeyRotatedAt)
+	}
+
+	// 旧版本已作废
+	v1 := int16(1)
+	staleReq := challengeReq(device.DeviceID, 3)
+	staleReq.KeyVersion = &v1
+	if _, code, _ := lockSvc.Challenge(staleReq, user.ID, "10.0.0.1"); code != model.CodeParamError {
+		t.Fatalf("challenge with retired key: code = %d, want %d", code, model.CodeParamError)
+	}
+
+	if n := countOperationLogs(t, "rotate_device_key", device.ID); n != 1 {
+		t.Errorf("rotate_device_key logs = %d, want 1", n)
+	}
+	if n := countOperationLogs(t, "confirm_device_key", device.ID); n != 1 {
+		t.Errorf("confirm_device_key logs = %d, want 1", n)
+	}
+}
+
+// 回执仍用旧密钥签名时不转正
+func TestDeviceKeyRotationNotConfirmedByOldKey(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-ROTATE-OLD", "")
+	grantTestPermission(t, user.ID, device.DeviceID, nil)
+	lockSvc := newTestLockService(nil)
+
+	if _, code, msg := NewAdminService(nil, nil).RotateDeviceKey(device.DeviceID, &RotateDeviceKeyRequest{}, admin.ID); code != 0 {
+		t.Fatalf("rotate: %d %s", code, msg)
+	}
+	req := challengeReq(device.DeviceID, 1)
+	resp, code, msg := lockSvc.Challenge(req, user.ID, "10.0.0.1")
+	if code != 0 {
+		t.Fatalf("challenge: %d %s", code, msg)
+	}
+	report := &ReportRequest{UnlockSessionID: resp.UnlockSessionID, DeviceID: device.DeviceID, Result: "success",
+		OccurredAt: time.Now().Unix(), ReceiptCounter: 1,
+		Receipt: signReceipt(t, device, req.ChallengeC, "success", 1)}
+	if code, msg := lockSvc.Report(report, user.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("report: %d %s", code, msg)
+	}
+	if d := reloadDevice(t, device.ID); d.KeyVersion != 1 || d.PendingKeyVersion == nil || *d.PendingKeyVersion != 2 {
+		t.Fatalf("rotation confirmed by old-key receipt: version=%d pending=%v", d.KeyVersion, d.PendingKeyVersion)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/testdb"
+)
+
+func floodConfig() *config.AlertConfig {
+	return &config.AlertConfig{
+		FloodLimit:         3,
+		FloodWindow:        time.Minute,
+		FloodBlockDuration: 5 * time.Minute,
+		FloodAlertCooldown: 10 * time.Minute,
+	}
+}
+
+func challengeReq(deviceID string, n int) *ChallengeRequest {
+	return &ChallengeRequest{DeviceID: deviceID, ChallengeC: fmt.Sprintf("%016x", n), Timestamp: time.Now().Unix()}
+}
+
+// 无权用户刷挑战不计入洪泛，不能借此封禁设备
+func TestChallengeFloodIgnoresUnauthorizedUsers(t *testing.T) {
+	testdb.Open(t)
+	owner := newTestUser(t, "user", "")
+	stranger := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-FLOOD-A", "")
+	grantTestPermission(t, owner.ID, device.DeviceID, nil)
+	svc := newTestLockService(floodConfig())
+
+	for i := 0; i < 10; i++ {
+		if _, code, _ := svc.Challenge(challengeReq(device.DeviceID, i), stranger.ID, "10.0.0.9"); code != model.CodeNoPermission {
+			t.Fatalf("stranger challenge %d: code = %d, want %d", i, code, model.CodeNoPermission)
+		}
+	}
+	if _, code, msg := svc.Challenge(challengeReq(device.DeviceID, 100), owner.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("owner challenge after stranger flood: %d %s", code, msg)
+	}
+	if n := countAlerts(t, "challenge_flood", device.DeviceID); n != 0 {
+		t.Fatalf("challenge_flood alerts = %d, want 0", n)
+	}
+}
+
+func TestChallengeFloodBlocksDevice(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-FLOOD-B", "")
+	grantTestPermission(t, user.ID, device.DeviceID, nil)
+	svc := newTestLockService(floodConfig())
+
+	for i := 0; i < 3; i++ {
+		if _, code, msg := svc.Challenge(challengeReq(device.DeviceID, i), user.ID, "10.0.0.1"); code != 0 {
+			t.Fatalf("challenge %d within limit: %d %s", i, code, msg)
+		}
+	}
+	// 超限后进入封禁期，封禁期内的挑战不再计数也不重复告警
+	for i := 3; i < 6; i++ {
+		if _, code, _ := svc.Challenge(challengeReq(device.DeviceID, i), user.ID, "10.0.0.1"); code != model.CodeTooManyRequests {
+			t.Fatalf("challenge %d over limit: code = %d, want %d", i, code, model.CodeTooManyRequests)
+		}
+	}
+	if n := countAlerts(t, "challenge_flood", device.DeviceID); n != 1 {
+		t.Fatalf("challenge_flood alerts = %d, want 1", n)
+	}
+}
+
+func TestChallengeOffHoursAttempt(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-NIGHT", "")
+	grantTestPermission(t, user.ID, device.DeviceID, nil)
+	// 全天都算非工作时段
+	svc := newTestLockService(&config.AlertConfig{
+		OffHoursStart:         "00:00",
+		OffHoursEnd:           "24:00",
+		OffHoursTimezone:      "Asia/Shanghai",
+		OffHoursAlertCooldown: 10 * time.Minute,
+	})
+
+	// 只告警不阻断，静默期内只记一条
+	for i := 0; i < 2; i++ {
+		if _, code, msg := svc.Challenge(challengeReq(device.DeviceID, i), user.ID, "10.0.0.1"); code != 0 {
+			t.Fatalf("challenge %d: %d %s", i, code, msg)
+		}
+	}
+	if n := countAlerts(t, "off_hours_attempt", device.DeviceID); n != 1 {
+		t.Fatalf("off_hours_attempt alerts = %d, want 1", n)
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestDetectOfflineDevices(t *testing.T) {
+	testdb.Open(t)
+	longAgo := time.Now().Add(-40 * 24 * time.Hour)
+	recently := time.Now().Add(-time.Hour)
+
+	idle, _ := newTestDevice(t, "LOCK-IDLE", "")
+	active, _ := newTestDevice(t, "LOCK-ACTIVE", "")
+	disabled, _ := newTestDevice(t, "LOCK-DISABLED", "")
+	neverUsed, _ := newTestDevice(t, "LOCK-NEW", "")
+	setDevice := func(d *model.Device, updates map[string]interface{}) {
+		t.Helper()
+		if err := repository.DB.Model(&model.Device{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	setDevice(idle, map[string]interface{}{"last_active_at": longAgo})
+	setDevice(active, map[string]interface{}{"last_active_at": recently})
+	setDevice(disabled, map[string]interface{}{"last_active_at": longAgo, "status": 0})
+	// 从未活跃的按登记时间算，刚登记的不算离线
+
+	svc := newTestLockService(&config.AlertConfig{OfflineThreshold: 30 * 24 * time.Hour})
+	if err := svc.DetectOfflineDevices(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{idle.DeviceID: 1, active.DeviceID: 0, disabled.DeviceID: 0, neverUsed.DeviceID: 0}
+	for deviceID, n := range want {
+		if got := countAlerts(t, "device_offline", deviceID); got != n {
+			t.Errorf("%s: device_offline alerts = %d, want %d", deviceID, got, n)
+		}
+	}
+
+	// 未处置的告警仍在时不重复生成；处置后设备依旧离线才再告警
+	if err := svc.DetectOfflineDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if got := countAlerts(t, "device_offline", idle.DeviceID); got != 1 {
+		t.Fatalf("after second run: alerts = %d, want 1", got)
+	}
+	if err := repository.DB.Model(&model.Alert{}).Where("device_id = ?", idle.DeviceID).Update("status", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DetectOfflineDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if got := countAlerts(t, "device_offline", idle.DeviceID); got != 2 {
+		t.Fatalf("after resolve: alerts = %d, want 2", got)
+	}
+}
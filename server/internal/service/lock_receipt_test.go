@@ -0,0 +1,117 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"promthus/internal/kms"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+
+	"github.com/google/uuid"
+)
+
+// signReceipt 模拟锁具固件：MAC(K_d, challenge_c || device_id || result || counter)
+func signReceipt(t *testing.T, device *model.Device, challengeC, result string, counter uint32) string {
+	t.Helper()
+	challenge, _ := hex.DecodeString(challengeC)
+	resultByte := byte(0x00)
+	if result == "success" {
+		resultByte = 0x01
+	}
+	counterBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(counterBytes, counter)
+	data := append(challenge, []byte(device.DeviceID)...)
+	data = append(data, resultByte)
+	data = append(data, counterBytes...)
+	mac, err := kms.Get().ComputeDeviceMAC(device.MACAlgorithm, device.KeyEncrypted, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(mac)
+}
+
+func TestVerifyReceiptWithoutReceipt(t *testing.T) {
+	svc := NewLockService(nil, nil, nil, nil, nil, nil, nil)
+	session := &model.UnlockSession{DeviceID: "LOCK-1", ChallengeC: "0011223344556677"}
+	if ok, reason := svc.verifyReceipt(&ReportRequest{Result: "success"}, session, 1); ok || reason != "missing_receipt" {
+		t.Fatalf("verifyReceipt = %v, %q; want false, missing_receipt", ok, reason)
+	}
+}
+
+func TestVerifyReceipt(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-RECEIPT", "")
+	svc := newTestLockService(nil)
+	session := &model.UnlockSession{ID: uuid.New(), UserID: user.ID, DeviceType: model.DeviceTypeLock,
+		DeviceID: device.DeviceID, ChallengeC: "0011223344556677"}
+
+	tests := []struct {
+		name       string
+		result     string
+		signedAs   string
+		counter    uint32
+		wantOK     bool
+		wantReason string
+	}{
+		{"valid", "success", "success", 1, true, ""},
+		{"same counter replayed", "success", "success", 1, false, "counter_replay"},
+		{"result tampered", "success", "fail", 2, false, "bad_mac"},
+		{"counter skips ahead", "fail", "fail", 7, true, ""},
+		{"older counter", "fail", "fail", 3, false, "counter_replay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ReportRequest{DeviceID: device.DeviceID, Result: tt.result, ReceiptCounter: tt.counter,
+				Receipt: signReceipt(t, device, session.ChallengeC, tt.signedAs, tt.counter)}
+			ok, reason := svc.verifyReceipt(req, session, user.ID)
+			if ok != tt.wantOK || reason != tt.wantReason {
+				t.Fatalf("verifyReceipt = %v, %q; want %v, %q", ok, reason, tt.wantOK, tt.wantReason)
+			}
+		})
+	}
+
+	var stored model.Device
+	if err := repository.DB.First(&stored, device.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReceiptCounter != 7 {
+		t.Fatalf("receipt_counter = %d, want 7", stored.ReceiptCounter)
+	}
+}
+
+// 只有回执可信的失败上报计入连续失败次数
+func TestReportCountsOnlyVerifiedFailures(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-REPORT", "")
+	failStore := newMemFailStore()
+	unlockStore := repository.NewPostgresUnlockSessionStore()
+	svc := NewLockService(failStore, nil, unlockStore, nil, nil, nil, nil)
+
+	report := func(receipt string, counter uint32) {
+		t.Helper()
+		session := &model.UnlockSession{ID: uuid.New(), UserID: user.ID, DeviceType: model.DeviceTypeLock,
+			DeviceID: device.DeviceID, ChallengeC: "8899aabbccddeeff", ExpiresAt: time.Now().Add(time.Minute)}
+		if err := unlockStore.Create(session); err != nil {
+			t.Fatal(err)
+		}
+		req := &ReportRequest{UnlockSessionID: session.ID.String(), DeviceID: device.DeviceID, Result: "fail",
+			OccurredAt: time.Now().Unix(), Receipt: receipt, ReceiptCounter: counter}
+		if code, msg := svc.Report(req, user.ID, "10.0.0.1"); code != 0 {
+			t.Fatalf("report: %d %s", code, msg)
+		}
+	}
+
+	report(signReceipt(t, device, "8899aabbccddeeff", "fail", 1), 1)
+	report("", 0)
+	report(signReceipt(t, device, "8899aabbccddeeff", "success", 2), 2) // 回执与上报结果不符
+
+	if n, _ := failStore.Get(model.DeviceTypeLock, device.DeviceID); n != 1 {
+		t.Fatalf("fail count = %d, want 1", n)
+	}
+}
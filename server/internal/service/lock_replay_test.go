@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/config"
+	"promthus/internal/model"
+	"promthus/internal/testdb"
+)
+
+func TestChallengeRejectsReplayedNonce(t *testing.T) {
+	testdb.Open(t)
+	user := newTestUser(t, "user", "")
+	device, _ := newTestDevice(t, "LOCK-REPLAY", "")
+	grantTestPermission(t, user.ID, device.DeviceID, nil)
+	svc := newTestLockService(&config.AlertConfig{ReplayAlertCooldown: time.Hour})
+
+	req := &ChallengeRequest{DeviceID: device.DeviceID, ChallengeC: "0011223344556677", Timestamp: time.Now().Unix()}
+	if _, code, msg := svc.Challenge(req, user.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("first challenge: %d %s", code, msg)
+	}
+
+	// 循环重放同一挑战：每次都拒绝，但冷却期内只产生一条告警
+	for i := 0; i < 5; i++ {
+		if _, code, _ := svc.Challenge(req, user.ID, "10.0.0.1"); code != model.CodeReplayDetected {
+			t.Fatalf("replay %d: code = %d, want %d", i, code, model.CodeReplayDetected)
+		}
+	}
+	if n := countAlerts(t, "replay_attempt", device.DeviceID); n != 1 {
+		t.Fatalf("replay_attempt alerts = %d, want 1", n)
+	}
+
+	// 大小写不同的同一 nonce 也是重放
+	upper := *req
+	upper.ChallengeC = "00112233445566AA"
+	if _, code, msg := svc.Challenge(&upper, user.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("fresh nonce: %d %s", code, msg)
+	}
+	lower := upper
+	lower.ChallengeC = "00112233445566aa"
+	if _, code, _ := svc.Challenge(&lower, user.ID, "10.0.0.1"); code != model.CodeReplayDetected {
+		t.Fatalf("case-changed replay: code = %d, want %d", code, model.CodeReplayDetected)
+	}
+}
+
+func TestChallengeRejectsStaleTimestamp(t *testing.T) {
+	svc := newTestLockService(nil)
+	req := &ChallengeRequest{DeviceID: "LOCK-X", ChallengeC: "0011223344556677",
+		Timestamp: time.Now().Add(-2 * challengeDriftSecs * time.Second).Unix()}
+	if _, code, _ := svc.Challenge(req, 1, "10.0.0.1"); code != model.CodeRequestExpired {
+		t.Fatalf("code = %d, want %d", code, model.CodeRequestExpired)
+	}
+}
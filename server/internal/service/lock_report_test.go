@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestReportRequiresMatchingUnlockSession(t *testing.T) {
+	unlockStore := newMemUnlockStore()
+	failStore := newMemFailStore()
+	svc := NewLockService(failStore, nil, unlockStore, nil, nil, nil, nil)
+
+	live := model.UnlockSession{ID: uuid.New(), UserID: 1, DeviceType: model.DeviceTypeLock,
+		DeviceID: "LOCK-1", ChallengeC: "0011223344556677", ExpiresAt: time.Now().Add(unlockSessionTTL)}
+	expired := live
+	expired.ID = uuid.New()
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	_ = unlockStore.Create(&live)
+	_ = unlockStore.Create(&expired)
+
+	tests := []struct {
+		name       string
+		sessionID  uuid.UUID
+		userID     int64
+		deviceID   string
+		wantReason string
+	}{
+		{"unknown session", uuid.New(), 1, "LOCK-1", "unknown_session"},
+		{"other user", live.ID, 2, "LOCK-1", "user_mismatch"},
+		{"other device", live.ID, 1, "LOCK-2", "device_mismatch"},
+		{"expired", expired.ID, 1, "LOCK-1", "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ReportRequest{UnlockSessionID: tt.sessionID.String(), DeviceID: tt.deviceID,
+				Result: "success", OccurredAt: time.Now().Unix()}
+			if code, _ := svc.Report(req, tt.userID, "10.0.0.1"); code != model.CodeUnlockSessionInvalid {
+				t.Fatalf("code = %d, want %d", code, model.CodeUnlockSessionInvalid)
+			}
+			if got := svc.classifyUnlockSession(tt.sessionID, tt.userID, tt.deviceID); got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+	if failStore.resets != 0 {
+		t.Fatalf("rejected reports reset the fail counter %d times", failStore.resets)
+	}
+
+	// 匹配的会话只能核销一次
+	req := &ReportRequest{UnlockSessionID: live.ID.String(), DeviceID: "LOCK-1",
+		Result: "fail", OccurredAt: time.Now().Unix()}
+	if code, msg := svc.Report(req, 1, "10.0.0.1"); code != 0 {
+		t.Fatalf("first report: %d %s", code, msg)
+	}
+	if code, _ := svc.Report(req, 1, "10.0.0.1"); code != model.CodeUnlockSessionInvalid {
+		t.Fatalf("second report: code = %d, want %d", code, model.CodeUnlockSessionInvalid)
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"promthus/internal/model"
+	"promthus/internal/testdb"
+)
+
+func TestChallengeOutsideSchedule(t *testing.T) {
+	testdb.Open(t)
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("tzdata unavailable:", err)
+	}
+	// 除今天以外的每一天全天可开，今天一律在时段外
+	today := int(time.Now().In(shanghai).Weekday())
+	if today == 0 {
+		today = 7
+	}
+	var otherDays []int
+	for d := 1; d <= 7; d++ {
+		if d != today {
+			otherDays = append(otherDays, d)
+		}
+	}
+	closed := &model.PermissionSchedule{Timezone: "Asia/Shanghai",
+		Rules: []model.ScheduleRule{{Weekdays: otherDays, Start: "00:00", End: "24:00"}}}
+
+	user := newTestUser(t, "user", "")
+	closedLock, _ := newTestDevice(t, "LOCK-CLOSED", "")
+	openLock, _ := newTestDevice(t, "LOCK-OPEN", "")
+	grantTestPermission(t, user.ID, closedLock.DeviceID, closed)
+	grantTestPermission(t, user.ID, openLock.DeviceID, nil)
+	svc := newTestLockService(nil)
+
+	req := &ChallengeRequest{DeviceID: closedLock.DeviceID, ChallengeC: "0102030405060708", Timestamp: time.Now().Unix()}
+	if _, code, _ := svc.Challenge(req, user.ID, "10.0.0.1"); code != model.CodeOutsideSchedule {
+		t.Fatalf("challenge outside schedule: code = %d, want %d", code, model.CodeOutsideSchedule)
+	}
+	req.DeviceID = openLock.DeviceID
+	if _, code, msg := svc.Challenge(req, user.ID, "10.0.0.1"); code != 0 {
+		t.Fatalf("challenge without schedule: %d %s", code, msg)
+	}
+
+	devices, err := svc.GetAuthorizedDevices(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].DeviceID != openLock.DeviceID {
+		t.Fatalf("authorized devices = %v, want only %s", devices, openLock.DeviceID)
+	}
+}
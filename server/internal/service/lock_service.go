@@ -3,45 +3,101 @@ package service
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
+	"promthus/internal/config"
 	"promthus/internal/kms"
 	"promthus/internal/logger"
 	"promthus/internal/model"
 	"promthus/internal/mq"
 	"promthus/internal/repository"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// challengeDriftSecs 客户端时间戳允许的最大偏差，nonce 在该窗口内不可重复使用
+const challengeDriftSecs = 30
+
+// unlockSessionTTL 从拿到 Response 到上报开锁结果的最长时间
+const unlockSessionTTL = 5 * time.Minute
+
 type LockService struct {
-	failStore repository.DeviceFailStore
-	publisher *mq.Publisher
+	failStore   repository.DeviceFailStore
+	nonceStore  repository.ChallengeNonceStore
+	unlockStore repository.UnlockSessionStore
+	rateStore   repository.RateLimitStore
+	blockStore  repository.DeviceBlockStore
+	publisher   *mq.Publisher
+	alertCfg    *config.AlertConfig
+	offHours    *model.PermissionSchedule // 非工作时段，nil 表示不检测
 }
 
-func NewLockService(fs repository.DeviceFailStore, pub *mq.Publisher) *LockService {
-	return &LockService{failStore: fs, publisher: pub}
+func NewLockService(fs repository.DeviceFailStore, ns repository.ChallengeNonceStore, us repository.UnlockSessionStore,
+	rs repository.RateLimitStore, bs repository.DeviceBlockStore, pub *mq.Publisher, alertCfg *config.AlertConfig) *LockService {
+	s := &LockService{
+		failStore:   fs,
+		nonceStore:  ns,
+		unlockStore: us,
+		rateStore:   rs,
+		blockStore:  bs,
+		publisher:   pub,
+		alertCfg:    alertCfg,
+	}
+	if alertCfg != nil && alertCfg.OffHoursStart != "" {
+		// 非工作时段复用权限时段规则：每天 start~end
+		offHours := &model.PermissionSchedule{
+			Timezone: alertCfg.OffHoursTimezone,
+			Rules: []model.ScheduleRule{{
+				Weekdays: []int{1, 2, 3, 4, 5, 6, 7},
+				Start:    alertCfg.OffHoursStart,
+				End:      alertCfg.OffHoursEnd,
+			}},
+		}
+		if err := offHours.Validate(); err != nil {
+			logger.Warn("off_hours detector disabled, invalid config", zap.Error(err))
+		} else {
+			s.offHours = offHours
+		}
+	}
+	return s
 }
 
 type ChallengeRequest struct {
 	DeviceID   string `json:"device_id" binding:"required,max=32"`
 	ChallengeC string `json:"challenge_c" binding:"required,len=16"`
 	Timestamp  int64  `json:"timestamp" binding:"required"`
+	// 锁具当前持有的密钥版本（与 challenge_c 一同从 NFC 读出）；为空按设备当前生效版本
+	KeyVersion *int16 `json:"key_version"`
 }
 
 type ChallengeResponse struct {
-	Response string `json:"response"`
+	Response        string    `json:"response"`
+	MACAlgorithm    string    `json:"mac_algorithm"`
+	UnlockSessionID string    `json:"unlock_session_id"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	KeyVersion      int16     `json:"key_version"`
+	// 密钥轮换中且锁具仍持旧密钥时下发的换钥报文（hex），手机随 Response 一并写入锁具
+	KeyUpdate string `json:"key_update,omitempty"`
 }
 
 type ReportRequest struct {
-	DeviceID    string `json:"device_id" binding:"required,max=32"`
-	Result      string `json:"result" binding:"required,oneof=success fail"`
-	FailReason  string `json:"fail_reason"`
-	OccurredAt  int64  `json:"occurred_at" binding:"required"`
-	DeviceModel string `json:"device_model"`
+	UnlockSessionID string `json:"unlock_session_id" binding:"required,uuid"`
+	DeviceID        string `json:"device_id" binding:"required,max=32"`
+	Result          string `json:"result" binding:"required,oneof=success fail"`
+	FailReason      string `json:"fail_reason"`
+	OccurredAt      int64  `json:"occurred_at" binding:"required"`
+	DeviceModel     string `json:"device_model"`
+	// 锁具固件对 (device_id, challenge, result, counter) 用 K_d 计算的回执 MAC（hex），手机原样转发
+	Receipt        string `json:"receipt" binding:"omitempty,len=32,hexadecimal"`
+	ReceiptCounter uint32 `json:"receipt_counter"`
+	// 计算回执所用的密钥版本；等于轮换中的新版本即视为锁具确认换钥
+	KeyVersion int16 `json:"key_version"`
 }
 
 func (s *LockService) Challenge(req *ChallengeRequest, userID int64, clientIP string) (*ChallengeResponse, int, string) {
@@ -58,7 +114,7 @@ func (s *LockService) Challenge(req *ChallengeRequest, userID int64, clientIP st
 	}
 
 	serverTime := time.Now().Unix()
-	if math.Abs(float64(serverTime-req.Timestamp)) > 30 {
+	if math.Abs(float64(serverTime-req.Timestamp)) > challengeDriftSecs {
 		logger.Info("challenge: rejected, timestamp drift",
 			zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID),
 			zap.Int64("client_ts", req.Timestamp), zap.Int64("server_ts", serverTime))
@@ -87,25 +143,68 @@ func (s *LockService) Challenge(req *ChallengeRequest, userID int64, clientIP st
 		return nil, model.CodeDeviceUnavailable, statusMsg
 	}
 
-	var permCount int64
-	repository.DB.Model(&model.Permission{}).
+	now := time.Now()
+	var perm model.Permission
+	err = repository.DB.
 		Where("user_id = ? AND device_type = ? AND device_id = ? AND status = 1 AND valid_from <= ? AND (valid_until IS NULL OR valid_until > ?)",
-			userID, model.DeviceTypeLock, device.DeviceID, time.Now(), time.Now()).
-		Count(&permCount)
-	if permCount == 0 {
+			userID, model.DeviceTypeLock, device.DeviceID, now, now).
+		First(&perm).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			logger.Error("challenge: permission query failed", zap.Error(err))
+			return nil, model.CodeInternalError, "internal error"
+		}
 		logger.Info("challenge: rejected, no permission",
 			zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID))
 		return nil, model.CodeNoPermission, "no permission for this device"
 	}
 
-	kd, err := kms.Get().DecryptDeviceKey(device.KeyEncrypted)
+	if !perm.Schedule.Allows(now) {
+		logger.Info("challenge: rejected, outside permission schedule",
+			zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID), zap.Int64("perm_id", perm.ID))
+		return nil, model.CodeOutsideSchedule, "outside permitted time window"
+	}
+
+	// 洪泛计数放在授权校验之后：无权用户刷挑战只会被 2001 拒绝，不能借此封禁别人的设备
+	if code, msg := s.checkChallengeFlood(device.DeviceID, userID, clientIP); code != 0 {
+		return nil, code, msg
+	}
+
+	// 防重放：nonce 至少保留到该时间戳不再被接受（ts+drift），再多留一个窗口作余量
+	challengeC := strings.ToLower(req.ChallengeC)
+	nonceExpires := time.Unix(req.Timestamp, 0).Add(2 * challengeDriftSecs * time.Second)
+	claimed, err := s.nonceStore.Claim(model.DeviceTypeLock, device.DeviceID, challengeC, nonceExpires)
 	if err != nil {
-		logger.Error("challenge: KMS decrypt failed", zap.Error(err), zap.String("device_id", req.DeviceID))
+		logger.Error("challenge: nonce claim failed", zap.Error(err), zap.String("device_id", req.DeviceID))
 		return nil, model.CodeInternalError, "internal error"
 	}
-	defer clearBytes(kd)
+	if !claimed {
+		logger.Warn("challenge: rejected, challenge_c replayed",
+			zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID),
+			zap.String("challenge_c", challengeC), zap.String("client_ip", clientIP))
+		s.raiseAlertWithCooldown("replay_attempt", device.DeviceID, &userID, 3, s.replayAlertCooldown(), map[string]interface{}{
+			"challenge_c": challengeC,
+			"client_ip":   clientIP,
+			"timestamp":   req.Timestamp,
+		})
+		return nil, model.CodeReplayDetected, "challenge already used"
+	}
+
+	// 密钥轮换期间新旧两个版本同时可用：锁具报告已是新版本则用新密钥，否则用旧密钥并附带换钥报文
+	keyEncrypted, keyVersion := device.KeyEncrypted, device.KeyVersion
+	needKeyUpdate := false
+	if req.KeyVersion != nil && *req.KeyVersion != device.KeyVersion {
+		if device.PendingKeyVersion == nil || *req.KeyVersion != *device.PendingKeyVersion {
+			logger.Info("challenge: rejected, unknown key version",
+				zap.String("device_id", req.DeviceID), zap.Int16("key_version", *req.KeyVersion))
+			return nil, model.CodeParamError, "unknown key_version for this device"
+		}
+		keyEncrypted, keyVersion = device.PendingKeyEncrypted, *device.PendingKeyVersion
+	} else if device.PendingKeyVersion != nil {
+		needKeyUpdate = true
+	}
 
-	challengeBytes, _ := hex.DecodeString(req.ChallengeC)
+	challengeBytes, _ := hex.DecodeString(challengeC)
 	deviceIDBytes := []byte(req.DeviceID)
 	userIDBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(userIDBytes, uint64(userID))
@@ -116,14 +215,44 @@ func (s *LockService) Challenge(req *ChallengeRequest, userID int64, clientIP st
 	data = append(data, userIDBytes...)
 	data = append(data, tsBytes...)
 
-	cmacResult, err := kms.Get().ComputeCMAC(kd, data)
+	// 按设备登记的算法计算 Response，迁移期间新老固件并存；K_d 以密文交给 KMS，HSM 后端下明文不出令牌
+	cmacResult, err := kms.Get().ComputeDeviceMAC(device.MACAlgorithm, keyEncrypted, data)
 	if err != nil {
-		logger.Error("challenge: CMAC computation failed", zap.Error(err))
+		logger.Error("challenge: MAC computation failed", zap.Error(err),
+			zap.String("device_id", req.DeviceID), zap.String("mac_algorithm", device.MACAlgorithm))
+		return nil, model.CodeInternalError, "internal error"
+	}
+
+	keyUpdate := ""
+	if needKeyUpdate {
+		payload, err := s.buildKeyUpdate(&device)
+		if err != nil {
+			// 换钥报文生成失败不影响本次开锁，下次挑战再下发
+			logger.Error("challenge: build key update failed", zap.Error(err), zap.String("device_id", req.DeviceID))
+		} else {
+			keyUpdate = hex.EncodeToString(payload)
+		}
+	}
+
+	// 签发一次性开锁会话，Report 时必须携带，防止未经挑战的结果上报
+	unlockSession := &model.UnlockSession{
+		ID:         uuid.New(),
+		UserID:     userID,
+		DeviceType: model.DeviceTypeLock,
+		DeviceID:   device.DeviceID,
+		ChallengeC: challengeC,
+		ExpiresAt:  time.Now().Add(unlockSessionTTL),
+	}
+	if err := s.unlockStore.Create(unlockSession); err != nil {
+		logger.Error("challenge: create unlock session failed", zap.Error(err), zap.String("device_id", req.DeviceID))
 		return nil, model.CodeInternalError, "internal error"
 	}
 
+	s.checkOffHours(device.DeviceID, userID, clientIP, now)
+
 	logger.Info("challenge: success, response computed",
-		zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID))
+		zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID),
+		zap.String("unlock_session_id", unlockSession.ID.String()))
 
 	if s.publisher != nil {
 		_ = s.publisher.PublishAudit(&mq.AuditMessage{
@@ -142,7 +271,12 @@ func (s *LockService) Challenge(req *ChallengeRequest, userID int64, clientIP st
 	}()
 
 	return &ChallengeResponse{
-		Response: hex.EncodeToString(cmacResult),
+		Response:        hex.EncodeToString(cmacResult),
+		MACAlgorithm:    device.MACAlgorithm,
+		UnlockSessionID: unlockSession.ID.String(),
+		ExpiresAt:       unlockSession.ExpiresAt,
+		KeyVersion:      keyVersion,
+		KeyUpdate:       keyUpdate,
 	}, 0, ""
 }
 
@@ -154,28 +288,68 @@ func (s *LockService) Report(req *ReportRequest, userID int64, clientIP string)
 		zap.String("client_ip", clientIP),
 	)
 
-	if req.Result == "fail" {
-		count, err := s.failStore.Increment(model.DeviceTypeLock, req.DeviceID)
-		if err != nil {
-			logger.Error("report: fail count increment error", zap.Error(err))
+	sessionID, _ := uuid.Parse(req.UnlockSessionID)
+	unlockSession, err := s.unlockStore.Consume(sessionID, userID, model.DeviceTypeLock, req.DeviceID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error("report: consume unlock session failed", zap.Error(err))
+			return model.CodeInternalError, "internal error"
 		}
+		reason := s.classifyUnlockSession(sessionID, userID, req.DeviceID)
+		logger.Warn("report: rejected, unlock session invalid",
+			zap.Int64("user_id", userID), zap.String("device_id", req.DeviceID),
+			zap.String("unlock_session_id", req.UnlockSessionID), zap.String("reason", reason),
+			zap.String("client_ip", clientIP))
+		if s.publisher != nil {
+			_ = s.publisher.PublishAudit(&mq.AuditMessage{
+				UserID:      userID,
+				DeviceID:    req.DeviceID,
+				DeviceType:  model.DeviceTypeLock,
+				Action:      "report_suspicious",
+				ResultCode:  2,
+				ClientIP:    clientIP,
+				DeviceModel: req.DeviceModel,
+				Extra: map[string]interface{}{
+					"reason":            reason,
+					"unlock_session_id": req.UnlockSessionID,
+					"claimed_result":    req.Result,
+					"fail_reason":       req.FailReason,
+				},
+			})
+		}
+		return model.CodeUnlockSessionInvalid, "unlock session invalid or expired"
+	}
 
-		logger.Info("report: unlock failed, fail_count incremented",
-			zap.String("device_id", req.DeviceID), zap.Int("fail_count", count),
-			zap.String("fail_reason", req.FailReason))
-
-		if count >= 3 {
-			logger.Warn("report: consecutive fail threshold reached, triggering alert",
-				zap.String("device_id", req.DeviceID), zap.Int("fail_count", count))
-			s.triggerAlertLock(req.DeviceID, userID, count)
+	// 只有锁具签名的回执校验通过，才信任上报结果并更新失败计数/活跃时间
+	verified, receiptErr := s.verifyReceipt(req, unlockSession, userID)
+	if verified {
+		if req.Result == "fail" {
+			count, err := s.failStore.Increment(model.DeviceTypeLock, req.DeviceID)
+			if err != nil {
+				logger.Error("report: fail count increment error", zap.Error(err))
+			}
+
+			logger.Info("report: unlock failed, fail_count incremented",
+				zap.String("device_id", req.DeviceID), zap.Int("fail_count", count),
+				zap.String("fail_reason", req.FailReason))
+
+			if count >= 3 {
+				logger.Warn("report: consecutive fail threshold reached, triggering alert",
+					zap.String("device_id", req.DeviceID), zap.Int("fail_count", count))
+				s.triggerAlertLock(req.DeviceID, userID, count)
+			}
+		} else {
+			_ = s.failStore.Reset(model.DeviceTypeLock, req.DeviceID)
+			repository.DB.Model(&model.Device{}).
+				Where("device_id = ? AND deleted_at IS NULL", req.DeviceID).
+				Update("last_active_at", time.Now())
+			logger.Info("report: unlock success, fail_count reset",
+				zap.String("device_id", req.DeviceID), zap.Int64("user_id", userID))
 		}
 	} else {
-		_ = s.failStore.Reset(model.DeviceTypeLock, req.DeviceID)
-		repository.DB.Model(&model.Device{}).
-			Where("device_id = ? AND deleted_at IS NULL", req.DeviceID).
-			Update("last_active_at", time.Now())
-		logger.Info("report: unlock success, fail_count reset",
-			zap.String("device_id", req.DeviceID), zap.Int64("user_id", userID))
+		logger.Warn("report: receipt unverified, result stored but not trusted",
+			zap.String("device_id", req.DeviceID), zap.Int64("user_id", userID),
+			zap.String("result", req.Result), zap.String("reason", receiptErr))
 	}
 
 	action := "unlock_success"
@@ -185,6 +359,14 @@ func (s *LockService) Report(req *ReportRequest, userID int64, clientIP string)
 		resultCode = 1
 	}
 
+	extra := map[string]interface{}{
+		"fail_reason":      req.FailReason,
+		"receipt_verified": verified,
+	}
+	if !verified {
+		extra["receipt_error"] = receiptErr
+	}
+
 	if s.publisher != nil {
 		_ = s.publisher.PublishAudit(&mq.AuditMessage{
 			UserID:      userID,
@@ -194,20 +376,144 @@ func (s *LockService) Report(req *ReportRequest, userID int64, clientIP string)
 			ResultCode:  resultCode,
 			ClientIP:    clientIP,
 			DeviceModel: req.DeviceModel,
-			Extra:       map[string]interface{}{"fail_reason": req.FailReason},
+			Extra:       extra,
 		})
 	}
 
 	return 0, ""
 }
 
+/*
+verifyReceipt 校验锁具回执：
+data = challenge_c(8B) || device_id || result(1B: 0x01 成功 / 0x00 失败) || counter(4B 大端)
+MAC 算法与该设备挑战应答一致；counter 必须严格递增，防止旧回执被重复转发。
+返回 (是否可信, 不可信原因)。
+*/
+func (s *LockService) verifyReceipt(req *ReportRequest, session *model.UnlockSession, userID int64) (bool, string) {
+	if req.Receipt == "" {
+		return false, "missing_receipt"
+	}
+	receipt, err := hex.DecodeString(req.Receipt)
+	if err != nil {
+		return false, "malformed_receipt"
+	}
+
+	var device model.Device
+	if err := repository.DB.Where("device_id = ? AND deleted_at IS NULL", session.DeviceID).First(&device).Error; err != nil {
+		logger.Error("report: device query for receipt failed", zap.Error(err), zap.String("device_id", session.DeviceID))
+		return false, "device_lookup_failed"
+	}
+
+	keyEncrypted := device.KeyEncrypted
+	confirmsRotation := false
+	if req.KeyVersion != 0 && req.KeyVersion != device.KeyVersion {
+		if device.PendingKeyVersion == nil || req.KeyVersion != *device.PendingKeyVersion {
+			return false, "unknown_key_version"
+		}
+		keyEncrypted = device.PendingKeyEncrypted
+		confirmsRotation = true
+	}
+
+	challengeBytes, _ := hex.DecodeString(session.ChallengeC)
+	resultByte := byte(0x00)
+	if req.Result == "success" {
+		resultByte = 0x01
+	}
+	counterBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(counterBytes, req.ReceiptCounter)
+
+	data := append(challengeBytes, []byte(session.DeviceID)...)
+	data = append(data, resultByte)
+	data = append(data, counterBytes...)
+
+	ok, err := kms.VerifyDeviceMAC(kms.Get(), device.MACAlgorithm, keyEncrypted, data, receipt)
+	if err != nil {
+		logger.Error("report: receipt MAC computation failed", zap.Error(err), zap.String("device_id", session.DeviceID))
+		return false, "kms_error"
+	}
+	if !ok {
+		return false, "bad_mac"
+	}
+
+	result := repository.DB.Model(&model.Device{}).
+		Where("id = ? AND receipt_counter < ?", device.ID, req.ReceiptCounter).
+		Update("receipt_counter", req.ReceiptCounter)
+	if result.Error != nil {
+		logger.Error("report: update receipt counter failed", zap.Error(result.Error), zap.String("device_id", session.DeviceID))
+		return false, "counter_update_failed"
+	}
+	if result.RowsAffected == 0 {
+		return false, "counter_replay"
+	}
+
+	if confirmsRotation {
+		s.confirmKeyRotation(&device, userID)
+	}
+
+	return true, ""
+}
+
+// buildKeyUpdate 用锁具当前密钥包装轮换中的新密钥，生成换钥报文。
+func (s *LockService) buildKeyUpdate(device *model.Device) ([]byte, error) {
+	return kms.Get().WrapDeviceKeyUpdate(device.MACAlgorithm,
+		device.KeyEncrypted, device.PendingKeyEncrypted, *device.PendingKeyVersion)
+}
+
+// confirmKeyRotation 锁具已用新密钥签名回执：新密钥转正，旧密钥作废。
+func (s *LockService) confirmKeyRotation(device *model.Device, userID int64) {
+	pendingVersion := *device.PendingKeyVersion
+	now := time.Now()
+	result := repository.DB.Model(&model.Device{}).
+		Where("id = ? AND pending_key_version = ?", device.ID, pendingVersion).
+		Updates(map[string]interface{}{
+			"key_encrypted":         gorm.Expr("pending_key_encrypted"),
+			"key_version":           pendingVersion,
+			"pending_key_encrypted": nil,
+			"pending_key_version":   nil,
+			"key_rotated_at":        now,
+			"updated_at":            now,
+		})
+	if result.Error != nil {
+		logger.Error("confirmKeyRotation: update failed", zap.Error(result.Error), zap.String("device_id", device.DeviceID))
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	logger.Info("confirmKeyRotation: new device key activated, old key retired",
+		zap.String("device_id", device.DeviceID),
+		zap.Int16("old_version", device.KeyVersion), zap.Int16("new_version", pendingVersion))
+	writeOperationLog(userID, "confirm_device_key", "device", device.ID,
+		map[string]interface{}{"device_id": device.DeviceID, "key_version": device.KeyVersion},
+		map[string]interface{}{"device_id": device.DeviceID, "key_version": pendingVersion, "retired_version": device.KeyVersion})
+}
+
 func (s *LockService) GetAuthorizedDevices(userID int64) ([]model.Device, error) {
-	var devices []model.Device
 	now := time.Now()
-	err := repository.DB.Model(&model.Device{}).
-		Joins("JOIN app.permissions ON app.permissions.device_type = ? AND app.permissions.device_id = app.devices_lock.device_id AND app.permissions.user_id = ? AND app.permissions.status = 1 AND app.permissions.valid_from <= ? AND (app.permissions.valid_until IS NULL OR app.permissions.valid_until > ?)",
-			model.DeviceTypeLock, userID, now, now).
-		Where("app.devices_lock.deleted_at IS NULL AND app.devices_lock.status != 0").
+	var perms []model.Permission
+	err := repository.DB.
+		Where("user_id = ? AND device_type = ? AND status = 1 AND valid_from <= ? AND (valid_until IS NULL OR valid_until > ?)",
+			userID, model.DeviceTypeLock, now, now).
+		Find(&perms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 时段规则在应用层判断，只返回当前时刻可开的锁
+	deviceIDs := make([]string, 0, len(perms))
+	for i := range perms {
+		if perms[i].Schedule.Allows(now) {
+			deviceIDs = append(deviceIDs, perms[i].DeviceID)
+		}
+	}
+
+	devices := []model.Device{}
+	if len(deviceIDs) == 0 {
+		return devices, nil
+	}
+	err = repository.DB.
+		Where("device_id IN ? AND deleted_at IS NULL AND status != 0", deviceIDs).
 		Find(&devices).Error
 	return devices, err
 }
@@ -258,6 +564,192 @@ func (s *LockService) triggerAlertLock(deviceID string, userID int64, failCount
 	}
 }
 
+// classifyUnlockSession 在核销失败后区分原因，仅用于审计记录。
+func (s *LockService) classifyUnlockSession(id uuid.UUID, userID int64, deviceID string) string {
+	session, err := s.unlockStore.FindByID(id)
+	if err != nil {
+		return "unknown_session"
+	}
+	switch {
+	case session.UserID != userID:
+		return "user_mismatch"
+	case session.DeviceID != deviceID:
+		return "device_mismatch"
+	default:
+		return "expired"
+	}
+}
+
+/*
+checkChallengeFlood 挑战洪泛检测（只统计已通过授权与时段校验的挑战）：
+同设备在 FloodWindow 内挑战次数超过 FloodLimit → 写 challenge_flood 告警（高）并推送，
+同时在 FloodBlockDuration 内拒绝该设备的全部挑战。已处于封禁期的直接拒绝。
+*/
+func (s *LockService) checkChallengeFlood(deviceID string, userID int64, clientIP string) (int, string) {
+	if s.alertCfg == nil || s.alertCfg.FloodLimit <= 0 {
+		return 0, ""
+	}
+
+	until, err := s.blockStore.BlockedUntil(model.DeviceTypeLock, deviceID)
+	if err != nil {
+		logger.Error("challenge: device block query failed", zap.Error(err), zap.String("device_id", deviceID))
+	} else if until != nil {
+		logger.Info("challenge: rejected, device temporarily blocked",
+			zap.String("device_id", deviceID), zap.Time("blocked_until", *until))
+		return model.CodeTooManyRequests, "too many challenges for this device, try again later"
+	}
+
+	windowSecs := int(s.alertCfg.FloodWindow.Seconds())
+	count, err := s.rateStore.Increment("challenge:"+model.DeviceTypeLock+":"+deviceID, windowSecs)
+	if err != nil {
+		logger.Error("challenge: flood counter failed", zap.Error(err), zap.String("device_id", deviceID))
+		return 0, ""
+	}
+	if count <= s.alertCfg.FloodLimit {
+		return 0, ""
+	}
+
+	blockedUntil := time.Now().Add(s.alertCfg.FloodBlockDuration)
+	if err := s.blockStore.Block(model.DeviceTypeLock, deviceID, blockedUntil, "challenge_flood"); err != nil {
+		logger.Error("challenge: block device failed", zap.Error(err), zap.String("device_id", deviceID))
+	}
+	logger.Warn("challenge: flood detected, device temporarily blocked",
+		zap.String("device_id", deviceID), zap.Int("count", count), zap.Int64("user_id", userID),
+		zap.Time("blocked_until", blockedUntil))
+
+	s.raiseAlertWithCooldown("challenge_flood", deviceID, &userID, 3, s.alertCfg.FloodAlertCooldown, map[string]interface{}{
+		"count":         count,
+		"window_secs":   windowSecs,
+		"client_ip":     clientIP,
+		"blocked_until": blockedUntil.Format(time.RFC3339),
+	})
+	return model.CodeTooManyRequests, "too many challenges for this device, try again later"
+}
+
+// checkOffHours 非工作时段开锁检测：仅记录 off_hours_attempt 告警（中）并推送，不阻断。
+func (s *LockService) checkOffHours(deviceID string, userID int64, clientIP string, now time.Time) {
+	if s.offHours == nil || !s.offHours.Allows(now) {
+		return
+	}
+	logger.Info("challenge: off-hours attempt",
+		zap.String("device_id", deviceID), zap.Int64("user_id", userID))
+	s.raiseAlertWithCooldown("off_hours_attempt", deviceID, &userID, 2, s.alertCfg.OffHoursAlertCooldown, map[string]interface{}{
+		"client_ip":  clientIP,
+		"local_time": now.Format(time.RFC3339),
+	})
+}
+
+/*
+DetectOfflineDevices 离线检测任务（由 job.Scheduler 定时调用）：
+last_active_at（从未活跃则用 created_at）早于 OfflineThreshold 的启用锁具，各生成一条 device_offline 告警（低）；
+该设备已有未处置的 device_offline 告警则跳过，本轮新增的设备汇总成一条通知推送。
+*/
+func (s *LockService) DetectOfflineDevices() error {
+	threshold := s.alertCfg.OfflineThreshold
+	cutoff := time.Now().Add(-threshold)
+
+	var created []struct {
+		DeviceID string
+	}
+	err := repository.DB.Raw(`INSERT INTO app.alerts (alert_type, device_type, device_id, severity, status, extra, created_at)
+		SELECT 'device_offline', ?, d.device_id, 1, 0,
+			jsonb_build_object('last_active_at', d.last_active_at, 'threshold_hours', ?::int),
+			NOW()
+		FROM app.devices_lock d
+		WHERE d.deleted_at IS NULL AND d.status != 0
+			AND COALESCE(d.last_active_at, d.created_at) < ?
+			AND NOT EXISTS (
+				SELECT 1 FROM app.alerts a
+				WHERE a.alert_type = 'device_offline' AND a.device_type = ?
+					AND a.device_id = d.device_id AND a.status = 0
+			)
+		RETURNING device_id`,
+		model.DeviceTypeLock, int(threshold.Hours()), cutoff, model.DeviceTypeLock).
+		Scan(&created).Error
+	if err != nil {
+		return err
+	}
+
+	if len(created) == 0 {
+		logger.Debug("device_offline: no new offline devices")
+		return nil
+	}
+
+	deviceIDs := make([]string, len(created))
+	for i, c := range created {
+		deviceIDs[i] = c.DeviceID
+	}
+	logger.Warn("device_offline: alerts created",
+		zap.Int("count", len(deviceIDs)), zap.Strings("device_ids", deviceIDs))
+
+	if s.publisher != nil {
+		_ = s.publisher.PublishNotify(&mq.NotifyMessage{
+			AlertType: "device_offline",
+			Severity:  1,
+			Extra: map[string]interface{}{
+				"count":           len(deviceIDs),
+				"device_ids":      deviceIDs,
+				"threshold_hours": int(threshold.Hours()),
+			},
+		})
+	}
+	return nil
+}
+
+func (s *LockService) replayAlertCooldown() time.Duration {
+	if s.alertCfg == nil {
+		return 0
+	}
+	return s.alertCfg.ReplayAlertCooldown
+}
+
+// raiseAlertWithCooldown 同设备同类型告警在 cooldown 内已存在则只记日志，避免告警风暴。
+func (s *LockService) raiseAlertWithCooldown(alertType, deviceID string, userID *int64, severity int16, cooldown time.Duration, extra map[string]interface{}) {
+	if cooldown > 0 {
+		var recent int64
+		err := repository.DB.Model(&model.Alert{}).
+			Where("alert_type = ? AND device_type = ? AND device_id = ? AND created_at > ?",
+				alertType, model.DeviceTypeLock, deviceID, time.Now().Add(-cooldown)).
+			Count(&recent).Error
+		if err == nil && recent > 0 {
+			logger.Info("raiseAlert: suppressed within cooldown",
+				zap.String("alert_type", alertType), zap.String("device_id", deviceID))
+			return
+		}
+	}
+	s.raiseAlert(alertType, deviceID, userID, severity, extra)
+}
+
+// raiseAlert 写入一条告警并推送通知，不改变设备状态。
+func (s *LockService) raiseAlert(alertType, deviceID string, userID *int64, severity int16, extra map[string]interface{}) {
+	alert := &model.Alert{
+		AlertType:  alertType,
+		DeviceType: model.DeviceTypeLock,
+		DeviceID:   deviceID,
+		UserID:     userID,
+		Severity:   severity,
+		Status:     0,
+		Extra:      model.JSON(extra),
+	}
+	if err := repository.DB.Create(alert).Error; err != nil {
+		logger.Error("raiseAlert: create alert failed",
+			zap.String("alert_type", alertType), zap.String("device_id", deviceID), zap.Error(err))
+		return
+	}
+
+	logger.Warn("raiseAlert: alert created",
+		zap.String("alert_type", alertType), zap.String("device_id", deviceID), zap.Int16("severity", severity))
+
+	if s.publisher != nil {
+		_ = s.publisher.PublishNotify(&mq.NotifyMessage{
+			AlertType: alertType,
+			DeviceID:  deviceID,
+			Severity:  severity,
+			Extra:     extra,
+		})
+	}
+}
+
 func clearBytes(b []byte) {
 	for i := range b {
 		b[i] = 0
@@ -265,7 +757,15 @@ func clearBytes(b []byte) {
 }
 
 func (s *LockService) GetDeviceList(page, pageSize int, status *int16, pipelineTag, search string) ([]model.Device, int64, error) {
+	return listDevices(page, pageSize, status, pipelineTag, search, nil)
+}
+
+// listDevices 锁具分页查询，LockService 与 AdminService 共用；scope 为 nil 表示不按管辖范围过滤
+func listDevices(page, pageSize int, status *int16, pipelineTag, search string, scope *model.AdminScope) ([]model.Device, int64, error) {
 	query := repository.DB.Model(&model.Device{}).Where("deleted_at IS NULL")
+	if scope != nil && len(scope.PipelineTags) > 0 {
+		query = query.Where("pipeline_tag IN ?", scope.PipelineTags)
+	}
 
 	if status != nil {
 		query = query.Where("status = ?", *status)
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"promthus/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 内存版 Store，供不依赖数据库的单元测试使用
+
+type memUnlockStore struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]model.UnlockSession
+}
+
+func newMemUnlockStore() *memUnlockStore {
+	return &memUnlockStore{sessions: map[uuid.UUID]model.UnlockSession{}}
+}
+
+func (s *memUnlockStore) Create(session *model.UnlockSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memUnlockStore) Consume(id uuid.UUID, userID int64, deviceType, deviceID string) (*model.UnlockSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.UserID != userID || session.DeviceType != deviceType ||
+		session.DeviceID != deviceID || !session.ExpiresAt.After(time.Now()) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(s.sessions, id)
+	return &session, nil
+}
+
+func (s *memUnlockStore) FindByID(id uuid.UUID) (*model.UnlockSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (s *memUnlockStore) CleanExpired() (int64, error) { return 0, nil }
+
+// memFailStore 记录每台设备的失败计数与 Reset 调用次数
+type memFailStore struct {
+	mu     sync.Mutex
+	counts map[string]int
+	resets int
+}
+
+func newMemFailStore() *memFailStore {
+	return &memFailStore{counts: map[string]int{}}
+}
+
+func (s *memFailStore) Increment(deviceType, deviceID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[deviceType+":"+deviceID]++
+	return s.counts[deviceType+":"+deviceID], nil
+}
+
+func (s *memFailStore) Reset(deviceType, deviceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resets++
+	delete(s.counts, deviceType+":"+deviceID)
+	return nil
+}
+
+func (s *memFailStore) Get(deviceType, deviceID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[deviceType+":"+deviceID], nil
+}
+
+// memSessionStore 以 family_id 为键保存会话
+type memSessionStore struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]model.Session
+	nextID   int64
+}
+
+func newMemSessionStore() *memSessionStore {
+	return &memSessionStore{sessions: map[uuid.UUID]model.Session{}}
+}
+
+func (s *memSessionStore) Create(session *model.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	session.ID = s.nextID
+	s.sessions[session.FamilyID] = *session
+	return nil
+}
+
+func (s *memSessionStore) FindByJTI(jti uuid.UUID) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		if session.JTI == jti && session.ExpiresAt.After(time.Now()) {
+			return &session, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memSessionStore) DeleteByJTI(jti uuid.UUID) error {
+	return s.deleteWhere(func(session model.Session) bool { return session.JTI == jti })
+}
+
+func (s *memSessionStore) DeleteByUserID(userID int64) error {
+	return s.deleteWhere(func(session model.Session) bool { return session.UserID == userID })
+}
+
+func (s *memSessionStore) CleanExpired() (int64, error) { return 0, nil }
+
+func (s *memSessionStore) CountActive() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.sessions)), nil
+}
+
+func (s *memSessionStore) FindByFamily(familyID uuid.UUID) (*model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[familyID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (s *memSessionStore) Rotate(id int64, generation int, jti uuid.UUID, refreshHash []byte, role string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for family, session := range s.sessions {
+		if session.ID != id || session.RefreshGeneration != generation {
+			continue
+		}
+		session.JTI, session.RefreshTokenHash, session.RefreshGeneration = jti, refreshHash, generation+1
+		session.Role, session.LastUsedAt = role, now
+		s.sessions[family] = session
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memSessionStore) DeleteByFamily(familyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, familyID)
+	return nil
+}
+
+func (s *memSessionStore) DeleteOthers(userID int64, keepFamily uuid.UUID) error {
+	return s.deleteWhere(func(session model.Session) bool {
+		return session.UserID == userID && session.FamilyID != keepFamily
+	})
+}
+
+func (s *memSessionStore) ListByUser(userID int64, now time.Time) ([]model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []model.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			out = append(out, session)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastUsedAt.After(out[j].LastUsedAt) })
+	return out, nil
+}
+
+func (s *memSessionStore) DeleteForUser(userID int64, id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for family, session := range s.sessions {
+		if session.UserID == userID && (session.JTI == id || session.FamilyID == id) {
+			delete(s.sessions, family)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memSessionStore) deleteWhere(match func(model.Session) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for family, session := range s.sessions {
+		if match(session) {
+			delete(s.sessions, family)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"promthus/internal/kms"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestCreateDeviceGeneratedKey(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	req := &CreateDeviceRequest{DeviceID: "LOCK-GEN", Name: "gen", LocationText: "test", RiskLevel: 1}
+
+	if _, code, _ := NewAdminService(nil, nil).CreateDevice(req, admin.ID); code != model.CodeParamError {
+		t.Fatalf("no transport key: code = %d, want %d", code, model.CodeParamError)
+	}
+
+	station, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, code, msg := NewAdminService(nil, station.PublicKey()).CreateDevice(req, admin.ID)
+	if code != 0 {
+		t.Fatalf("create: %d %s", code, msg)
+	}
+	bundle := resp.ProvisioningBundle
+	if bundle == nil || bundle.DeviceID != req.DeviceID || bundle.Recipient != kms.TransportKeyID(station.PublicKey()) {
+		t.Fatalf("bundle = %+v", bundle)
+	}
+
+	// 灌装包里的密钥与入库密钥一致：以 KCV 比对，不在测试里还原明文
+	stored, err := kms.Get().DecryptDeviceKey(reloadDevice(t, resp.Device.ID).KeyEncrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kcv, _ := kms.KeyCheckValue(stored); kcv != bundle.Checksum {
+		t.Fatalf("stored key KCV %s, bundle checksum %s", kcv, bundle.Checksum)
+	}
+
+	var log model.OperationLog
+	if err := repository.DB.Where("action = ? AND target_id = ?", "create_device", resp.Device.ID).First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	after := log.AfterSnapshot
+	if after["key_source"] != "generated" || after["bundle_recipient"] != bundle.Recipient || after["device_key"] != nil {
+		t.Fatalf("operation log after_snapshot = %v", after)
+	}
+}
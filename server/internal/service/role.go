@@ -0,0 +1,262 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"promthus/internal/logger"
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ==================== Roles ====================
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	DisplayName string   `json:"display_name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"max=200"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateRoleRequest struct {
+	DisplayName *string   `json:"display_name" binding:"omitempty,max=50"`
+	Description *string   `json:"description" binding:"omitempty,max=200"`
+	Permissions *[]string `json:"permissions"`
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,19}$`)
+
+// ListRoles 不含 service（只能由服务账号使用），按内置优先排序
+func (s *AdminService) ListRoles() ([]model.Role, error) {
+	var roles []model.Role
+	if err := repository.DB.Where("name <> ?", model.RoleService).
+		Order("builtin DESC, created_at").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	var rows []model.RolePermission
+	if err := repository.DB.Order("permission").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	perms := map[string][]string{}
+	for _, r := range rows {
+		perms[r.Role] = append(perms[r.Role], r.Permission)
+	}
+	for i := range roles {
+		roles[i].Permissions = perms[roles[i].Name]
+		if roles[i].Permissions == nil {
+			roles[i].Permissions = []string{}
+		}
+	}
+	return roles, nil
+}
+
+func (s *AdminService) CreateRole(req *CreateRoleRequest, operatorID int64) (*model.Role, int, string) {
+	if !roleNamePattern.MatchString(req.Name) {
+		return nil, model.CodeParamError, "role name must be 2-20 lowercase letters, digits or underscores"
+	}
+	perms, code, msg := normalizePermissions(req.Permissions)
+	if code != 0 {
+		return nil, code, msg
+	}
+	if code, msg := s.operatorCovers(operatorID, perms); code != 0 {
+		return nil, code, msg
+	}
+
+	var cnt int64
+	repository.DB.Model(&model.Role{}).Where("name = ?", req.Name).Count(&cnt)
+	if cnt > 0 {
+		return nil, model.CodeParamError, "role already exists"
+	}
+
+	role := &model.Role{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Permissions: perms,
+	}
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.Name, perms)
+	})
+	if err != nil {
+		logger.Error("create_role: db insert failed", zap.Error(err), zap.String("role", req.Name))
+		return nil, model.CodeInternalError, "failed to create role"
+	}
+
+	s.logOperation(operatorID, "create_role", "role", 0, nil, role)
+	logger.Info("create_role success",
+		zap.String("role", role.Name), zap.Strings("permissions", perms), zap.Int64("operator_id", operatorID))
+
+	return role, 0, ""
+}
+
+// UpdateRole 内置角色不可修改；动作集合变化对持有该角色的用户在下一次请求即生效
+func (s *AdminService) UpdateRole(name string, req *UpdateRoleRequest, operatorID int64) (int, string) {
+	role, code, msg := findEditableRole(name)
+	if code != 0 {
+		return code, msg
+	}
+	if code, msg := s.roleWithin(operatorID, name); code != 0 {
+		return code, msg
+	}
+
+	var perms []string
+	if req.Permissions != nil {
+		perms, code, msg = normalizePermissions(*req.Permissions)
+		if code != 0 {
+			return code, msg
+		}
+		if code, msg := s.operatorCovers(operatorID, perms); code != 0 {
+			return code, msg
+		}
+	}
+
+	before, _ := middleware.RolePermissions(name)
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if req.DisplayName != nil {
+		updates["display_name"] = *req.DisplayName
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Updates(updates).Error; err != nil {
+			return err
+		}
+		if req.Permissions == nil {
+			return nil
+		}
+		return replaceRolePermissions(tx, name, perms)
+	})
+	if err != nil {
+		logger.Error("update_role: db update failed", zap.Error(err), zap.String("role", name))
+		return model.CodeInternalError, "update failed"
+	}
+
+	// 角色以 name 为主键，target_id 记 0，name 见快照
+	s.logOperation(operatorID, "update_role", "role", 0,
+		map[string]interface{}{"name": name, "display_name": role.DisplayName, "permissions": before}, req)
+	logger.Info("update_role success", zap.String("role", name), zap.Int64("operator_id", operatorID))
+
+	return 0, ""
+}
+
+// DeleteRole 仍有用户（含已删除用户）持有该角色时拒绝
+func (s *AdminService) DeleteRole(name string, operatorID int64) (int, string) {
+	role, code, msg := findEditableRole(name)
+	if code != 0 {
+		return code, msg
+	}
+	if code, msg := s.roleWithin(operatorID, name); code != 0 {
+		return code, msg
+	}
+
+	var cnt int64
+	if err := repository.DB.Unscoped().Model(&model.User{}).Where("role = ?", name).Count(&cnt).Error; err != nil {
+		logger.Error("delete_role: count users failed", zap.Error(err), zap.String("role", name))
+		return model.CodeInternalError, "failed to delete role"
+	}
+	if cnt > 0 {
+		return model.CodeParamError, "role is still assigned to users"
+	}
+
+	if err := repository.DB.Delete(role).Error; err != nil {
+		logger.Error("delete_role: db delete failed", zap.Error(err), zap.String("role", name))
+		return model.CodeInternalError, "failed to delete role"
+	}
+
+	s.logOperation(operatorID, "delete_role", "role", 0, role, nil)
+	logger.Info("delete_role success", zap.String("role", name), zap.Int64("operator_id", operatorID))
+
+	return 0, ""
+}
+
+func findEditableRole(name string) (*model.Role, int, string) {
+	var role model.Role
+	err := repository.DB.Where("name = ?", name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, model.CodeParamError, "role not found"
+	}
+	if err != nil {
+		logger.Error("find_role: query failed", zap.Error(err), zap.String("role", name))
+		return nil, model.CodeInternalError, "failed to load role"
+	}
+	if role.Builtin {
+		return nil, model.CodeParamError, "built-in roles cannot be modified"
+	}
+	return &role, 0, ""
+}
+
+// normalizePermissions 校验并去重；自定义角色不能持有 "*"
+func normalizePermissions(perms []string) ([]string, int, string) {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, p := range perms {
+		if !middleware.IsPermission(p) {
+			return nil, model.CodeParamError, "unknown permission: " + p
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out, 0, ""
+}
+
+func replaceRolePermissions(tx *gorm.DB, role string, perms []string) error {
+	if err := tx.Where("role = ?", role).Delete(&model.RolePermission{}).Error; err != nil {
+		return err
+	}
+	if len(perms) == 0 {
+		return nil
+	}
+	rows := make([]model.RolePermission, 0, len(perms))
+	for _, p := range perms {
+		rows = append(rows, model.RolePermission{Role: role, Permission: p})
+	}
+	return tx.Create(&rows).Error
+}
+
+/*
+防止越权：操作者只能创建、修改持有不超过自己动作集合的角色，也只能把这类角色分配给用户、
+只能管理（改资料、重置密码/两步验证、解锁、踢下线）持有这类角色的用户。
+否则持有 users:write 的自定义角色就能把自己或他人提升为 admin，或接管管理员账号。
+*/
+func (s *AdminService) roleWithin(operatorID int64, role string) (int, string) {
+	perms, err := middleware.RolePermissions(role)
+	if err != nil {
+		logger.Error("role_within: load permissions failed", zap.Error(err), zap.String("role", role))
+		return model.CodeInternalError, "failed to load role"
+	}
+	return s.operatorCovers(operatorID, perms)
+}
+
+func (s *AdminService) operatorCovers(operatorID int64, perms []string) (int, string) {
+	var operator model.User
+	if err := repository.DB.Select("role").Where("id = ?", operatorID).First(&operator).Error; err != nil {
+		logger.Error("operator_covers: load operator failed", zap.Error(err), zap.Int64("operator_id", operatorID))
+		return model.CodeInternalError, "failed to load operator"
+	}
+	have, err := middleware.RolePermissions(operator.Role)
+	if err != nil {
+		logger.Error("operator_covers: load permissions failed", zap.Error(err), zap.Int64("operator_id", operatorID))
+		return model.CodeInternalError, "failed to load operator"
+	}
+	owned := map[string]bool{}
+	for _, p := range have {
+		owned[p] = true
+	}
+	for _, p := range perms {
+		if !owned[p] {
+			return model.CodeForbidden, "cannot grant or manage permission you do not hold: " + p
+		}
+	}
+	return 0, ""
+}
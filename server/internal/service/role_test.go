@@ -0,0 +1,185 @@
+package service
+
+import (
+	"testing"
+
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func newTestRole(t *testing.T, name string, perms ...string) {
+	t.Helper()
+	if err := repository.DB.Create(&model.Role{Name: name, DisplayName: name}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range perms {
+		if err := repository.DB.Create(&model.RolePermission{Role: name, Permission: p}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNormalizePermissions(t *testing.T) {
+	perms, code, _ := normalizePermissions([]string{"audit:read", "alerts:read", "audit:read"})
+	if code != 0 || len(perms) != 2 || perms[0] != "audit:read" || perms[1] != "alerts:read" {
+		t.Fatalf("dedupe = %q, %d", perms, code)
+	}
+	if perms, code, _ := normalizePermissions(nil); code != 0 || perms == nil || len(perms) != 0 {
+		t.Fatalf("empty = %#v, %d; want empty non-nil slice", perms, code)
+	}
+	// 自定义角色不能持有 "*"
+	for _, bad := range []string{middleware.PermissionAll, "users:delete", ""} {
+		if _, code, _ := normalizePermissions([]string{bad}); code != model.CodeParamError {
+			t.Errorf("normalizePermissions(%q) code %d, want %d", bad, code, model.CodeParamError)
+		}
+	}
+}
+
+func TestRoleNamePattern(t *testing.T) {
+	for _, name := range []string{"ops", "region_2", "a1"} {
+		if !roleNamePattern.MatchString(name) {
+			t.Errorf("%q rejected", name)
+		}
+	}
+	for _, name := range []string{"", "a", "Ops", "2ops", "ops-east", "abcdefghijklmnopqrstu"} {
+		if roleNamePattern.MatchString(name) {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
+
+func TestRoleCRUD(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	svc := NewAdminService(nil, nil)
+
+	role, code, msg := svc.CreateRole(&CreateRoleRequest{Name: "dispatcher", DisplayName: "调度员",
+		Permissions: []string{"devices:read", "permissions:write"}}, admin.ID)
+	if code != 0 {
+		t.Fatalf("create: %d %s", code, msg)
+	}
+	if role.Builtin {
+		t.Fatal("custom role marked builtin")
+	}
+	if ok, err := middleware.RoleHasPermission("dispatcher", "permissions:write"); err != nil || !ok {
+		t.Fatalf("dispatcher permissions:write = %v, %v", ok, err)
+	}
+
+	for _, req := range []*CreateRoleRequest{
+		{Name: "dispatcher", DisplayName: "dup"},
+		{Name: "Bad-Name", DisplayName: "x"},
+		{Name: "wildcard", DisplayName: "x", Permissions: []string{"*"}},
+		{Name: "unknown", DisplayName: "x", Permissions: []string{"locks:open"}},
+	} {
+		if _, code, _ := svc.CreateRole(req, admin.ID); code != model.CodeParamError {
+			t.Errorf("CreateRole(%q, %q) code %d, want %d", req.Name, req.Permissions, code, model.CodeParamError)
+		}
+	}
+
+	perms := []string{"devices:read"}
+	if code, msg := svc.UpdateRole("dispatcher", &UpdateRoleRequest{Permissions: &perms}, admin.ID); code != 0 {
+		t.Fatalf("update: %d %s", code, msg)
+	}
+	if ok, _ := middleware.RoleHasPermission("dispatcher", "permissions:write"); ok {
+		t.Fatal("removed permission still effective")
+	}
+
+	// 内置角色不可修改或删除
+	name := "renamed"
+	if code, _ := svc.UpdateRole("auditor", &UpdateRoleRequest{DisplayName: &name}, admin.ID); code != model.CodeParamError {
+		t.Fatalf("update builtin: code %d", code)
+	}
+	if code, _ := svc.DeleteRole("admin", admin.ID); code != model.CodeParamError {
+		t.Fatalf("delete builtin: code %d", code)
+	}
+
+	// 仍有用户持有时不可删除
+	holder := newTestUser(t, "dispatcher", "")
+	if code, _ := svc.DeleteRole("dispatcher", admin.ID); code != model.CodeParamError {
+		t.Fatalf("delete assigned: code %d", code)
+	}
+	if err := repository.DB.Model(holder).Update("role", "user").Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, msg := svc.DeleteRole("dispatcher", admin.ID); code != 0 {
+		t.Fatalf("delete: %d %s", code, msg)
+	}
+	if countOperationLogs(t, "delete_role", 0) != 1 {
+		t.Fatal("delete_role not logged")
+	}
+
+	roles, err := svc.ListRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range roles {
+		if r.Name == model.RoleService || r.Name == "dispatcher" {
+			t.Fatalf("ListRoles returned %q", r.Name)
+		}
+	}
+}
+
+// 操作者不能创建、修改或分配超出自己动作集合的角色
+func TestRoleEscalation(t *testing.T) {
+	testdb.Open(t)
+	newTestRole(t, "user_admin", "users:read", "users:write", "roles:read", "roles:write")
+	newTestRole(t, "helpdesk", "users:read")
+	operator := newTestUser(t, "user_admin", "")
+	svc := NewAdminService(nil, nil)
+
+	if _, code, _ := svc.CreateRole(&CreateRoleRequest{Name: "sneaky", DisplayName: "x",
+		Permissions: []string{"users:write", "roles:write", "audit:read"}}, operator.ID); code != model.CodeForbidden {
+		t.Fatalf("create wider role: code %d, want %d", code, model.CodeForbidden)
+	}
+	if _, code, msg := svc.CreateRole(&CreateRoleRequest{Name: "helper", DisplayName: "x",
+		Permissions: []string{"users:read"}}, operator.ID); code != 0 {
+		t.Fatalf("create narrower role: %d %s", code, msg)
+	}
+	wider := []string{"users:read", "alerts:write"}
+	if code, _ := svc.UpdateRole("helpdesk", &UpdateRoleRequest{Permissions: &wider}, operator.ID); code != model.CodeForbidden {
+		t.Fatalf("widen role: code %d, want %d", code, model.CodeForbidden)
+	}
+
+	if _, _, code, _ := svc.CreateUser(&CreateUserRequest{Phone: "13900000001", Name: "x", Role: "admin"}, operator.ID); code != model.CodeForbidden {
+		t.Fatalf("assign admin: code %d, want %d", code, model.CodeForbidden)
+	}
+	if _, _, code, _ := svc.CreateUser(&CreateUserRequest{Phone: "13900000002", Name: "x", Role: model.RoleService}, operator.ID); code != model.CodeParamError {
+		t.Fatalf("assign service: code %d, want %d", code, model.CodeParamError)
+	}
+	admin := newTestUser(t, "admin", "")
+	if _, code, _ := svc.ResetPassword(admin.UUID.String(), operator.ID); code != model.CodeForbidden {
+		t.Fatalf("reset admin password: code %d, want %d", code, model.CodeForbidden)
+	}
+}
+
+// 是否强制两步验证由角色的动作推导，自定义角色同样适用
+func TestMFARequiredFromRolePermissions(t *testing.T) {
+	testdb.Open(t)
+	newTestRole(t, "dispatcher", "devices:read", "permissions:write")
+	newTestRole(t, "viewer", "dashboard:read", "audit:read")
+	svc := mfaTestService("auditor")
+
+	tests := []struct {
+		role string
+		want bool
+	}{
+		{"admin", true},
+		{"permission_manager", true},
+		{"alert_handler", true},
+		{"auditor", true}, // 只读，由 MFARequiredRoles 额外指定
+		{"dispatcher", true},
+		{"viewer", false},
+		{"user", false},
+		{"service", false},
+	}
+	for _, tt := range tests {
+		if got := svc.mfaRequired(tt.role); got != tt.want {
+			t.Errorf("mfaRequired(%q) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+	if mfaTestService().mfaRequired("auditor") {
+		t.Error("auditor required without being listed")
+	}
+}
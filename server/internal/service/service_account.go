@@ -0,0 +1,265 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/logger"
+	"promthus/internal/middleware"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ==================== Service Accounts ====================
+
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name" binding:"required,max=50"`
+	Description string `json:"description" binding:"max=200"`
+}
+
+type UpdateServiceAccountRequest struct {
+	Description *string `json:"description" binding:"omitempty,max=200"`
+	Status      *int16  `json:"status" binding:"omitempty,oneof=0 1"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=50"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse 明文 Key 只在此处返回一次
+type CreateAPIKeyResponse struct {
+	*model.APIKey
+	Key string `json:"key"`
+}
+
+func (s *AdminService) ListServiceAccounts() ([]model.ServiceAccount, error) {
+	var accounts []model.ServiceAccount
+	err := repository.DB.Order("created_at DESC").Find(&accounts).Error
+	return accounts, err
+}
+
+// CreateServiceAccount 同时创建背后的 role=service 用户：无可用密码（"!" 不是合法哈希），登录接口也按不存在处理；
+// 该用户继承创建者的管辖范围
+func (s *AdminService) CreateServiceAccount(req *CreateServiceAccountRequest, operatorID int64) (*model.ServiceAccount, int, string) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		logger.Error("create_service_account: random failed", zap.Error(err))
+		return nil, model.CodeInternalError, "failed to create service account"
+	}
+
+	account := &model.ServiceAccount{
+		UUID:        uuid.New(),
+		Name:        req.Name,
+		Description: req.Description,
+		Status:      1,
+		CreatedBy:   operatorID,
+	}
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		user := &model.User{
+			UUID:         uuid.New(),
+			Phone:        "svc-" + hex.EncodeToString(suffix),
+			PasswordHash: "!",
+			Name:         req.Name,
+			Role:         model.RoleService,
+			Status:       1,
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		account.UserID = user.ID
+		if err := copyAdminScope(tx, operatorID, user.ID, operatorID); err != nil {
+			return err
+		}
+		return tx.Create(account).Error
+	})
+	if err != nil {
+		logger.Error("create_service_account: db insert failed", zap.Error(err), zap.String("name", req.Name))
+		return nil, model.CodeInternalError, "failed to create service account"
+	}
+
+	s.logOperation(operatorID, "create_service_account", "service_account", account.ID, nil, account)
+	logger.Info("create_service_account success",
+		zap.Int64("service_account_id", account.ID), zap.String("uuid", account.UUID.String()),
+		zap.Int64("operator_id", operatorID))
+
+	return account, 0, ""
+}
+
+// UpdateServiceAccount 停用后其全部 API Key 立即失效（鉴权时校验账号状态），重新启用即恢复
+func (s *AdminService) UpdateServiceAccount(accountUUID string, req *UpdateServiceAccountRequest, operatorID int64) (int, string) {
+	account, code, msg := findServiceAccount(accountUUID)
+	if code != 0 {
+		return code, msg
+	}
+	if code, msg := s.checkUserScopeWithin(operatorID, account.UserID); code != 0 {
+		return code, msg
+	}
+
+	before := *account
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Status != nil {
+		updates["status"] = *req.Status
+	}
+
+	err := repository.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(account).Updates(updates).Error; err != nil {
+			return err
+		}
+		if req.Status == nil {
+			return nil
+		}
+		return tx.Model(&model.User{}).Where("id = ?", account.UserID).
+			Updates(map[string]interface{}{"status": *req.Status, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		logger.Error("update_service_account: db update failed", zap.Error(err), zap.String("uuid", accountUUID))
+		return model.CodeInternalError, "update failed"
+	}
+
+	s.logOperation(operatorID, "update_service_account", "service_account", account.ID, before, updates)
+	logger.Info("update_service_account success",
+		zap.String("uuid", accountUUID), zap.Int64("operator_id", operatorID))
+
+	return 0, ""
+}
+
+func (s *AdminService) ListAPIKeys(accountUUID string) ([]model.APIKey, int, string) {
+	account, code, msg := findServiceAccount(accountUUID)
+	if code != 0 {
+		return nil, code, msg
+	}
+
+	var keys []model.APIKey
+	if err := repository.DB.Where("service_account_id = ?", account.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		logger.Error("list_api_keys: query failed", zap.Error(err), zap.String("uuid", accountUUID))
+		return nil, model.CodeInternalError, "failed to list api keys"
+	}
+	return keys, 0, ""
+}
+
+func (s *AdminService) CreateAPIKey(accountUUID string, req *CreateAPIKeyRequest, operatorID int64) (*CreateAPIKeyResponse, int, string) {
+	account, code, msg := findServiceAccount(accountUUID)
+	if code != 0 {
+		return nil, code, msg
+	}
+	// 范围比操作者宽的服务账号（如总部建的）不能由受限的操作者签发 Key
+	if code, msg := s.checkUserScopeWithin(operatorID, account.UserID); code != 0 {
+		return nil, code, msg
+	}
+	scopes, ok := normalizeScopes(req.Scopes)
+	if !ok {
+		return nil, model.CodeParamError, "invalid scopes, allowed: " + strings.Join(middleware.APIKeyScopes, ", ")
+	}
+	// 不能签出自己没有的动作，否则只持有 service_accounts:write 的角色可借 API Key 提权
+	if code, msg := s.operatorCovers(operatorID, strings.Fields(scopes)); code != 0 {
+		return nil, code, msg
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, model.CodeParamError, "expires_at must be in the future"
+	}
+
+	key, prefix, err := crypto.GenerateAPIKey()
+	if err != nil {
+		logger.Error("create_api_key: generate failed", zap.Error(err))
+		return nil, model.CodeInternalError, "failed to generate api key"
+	}
+	apiKey := &model.APIKey{
+		ServiceAccountID: account.ID,
+		Name:             req.Name,
+		KeyPrefix:        prefix,
+		KeyHash:          crypto.HashAPIKey(key),
+		Scopes:           scopes,
+		ExpiresAt:        req.ExpiresAt,
+		CreatedBy:        operatorID,
+	}
+	if err := repository.DB.Create(apiKey).Error; err != nil {
+		logger.Error("create_api_key: db insert failed", zap.Error(err), zap.String("uuid", accountUUID))
+		return nil, model.CodeInternalError, "failed to create api key"
+	}
+
+	s.logOperation(operatorID, "create_api_key", "api_key", apiKey.ID, nil, apiKey)
+	logger.Info("create_api_key success",
+		zap.Int64("api_key_id", apiKey.ID), zap.String("key_prefix", prefix),
+		zap.String("scopes", scopes), zap.Int64("operator_id", operatorID))
+
+	return &CreateAPIKeyResponse{APIKey: apiKey, Key: key}, 0, ""
+}
+
+func (s *AdminService) RevokeAPIKey(accountUUID string, keyID int64, operatorID int64) (int, string) {
+	account, code, msg := findServiceAccount(accountUUID)
+	if code != 0 {
+		return code, msg
+	}
+	if code, msg := s.checkUserScopeWithin(operatorID, account.UserID); code != 0 {
+		return code, msg
+	}
+
+	result := repository.DB.Model(&model.APIKey{}).
+		Where("id = ? AND service_account_id = ? AND revoked_at IS NULL", keyID, account.ID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_by": operatorID})
+	if result.Error != nil {
+		logger.Error("revoke_api_key: db update failed", zap.Error(result.Error), zap.Int64("api_key_id", keyID))
+		return model.CodeInternalError, "failed to revoke api key"
+	}
+	if result.RowsAffected == 0 {
+		return model.CodeParamError, "api key not found or already revoked"
+	}
+
+	s.logOperation(operatorID, "revoke_api_key", "api_key", keyID, nil, nil)
+	logger.Info("revoke_api_key success",
+		zap.Int64("api_key_id", keyID), zap.String("uuid", accountUUID), zap.Int64("operator_id", operatorID))
+
+	return 0, ""
+}
+
+func findServiceAccount(accountUUID string) (*model.ServiceAccount, int, string) {
+	if _, err := uuid.Parse(accountUUID); err != nil {
+		return nil, model.CodeParamError, "service account not found"
+	}
+	var account model.ServiceAccount
+	err := repository.DB.Where("uuid = ?", accountUUID).First(&account).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, model.CodeParamError, "service account not found"
+	}
+	if err != nil {
+		logger.Error("service account lookup failed", zap.Error(err), zap.String("uuid", accountUUID))
+		return nil, model.CodeInternalError, "internal error"
+	}
+	return &account, 0, ""
+}
+
+// normalizeScopes 校验并去重，存为空格分隔
+func normalizeScopes(scopes []string) (string, bool) {
+	seen := map[string]bool{}
+	var out []string
+	for _, sc := range scopes {
+		valid := false
+		for _, allowed := range middleware.APIKeyScopes {
+			if sc == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", false
+		}
+		if !seen[sc] {
+			seen[sc] = true
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " "), len(out) > 0
+}
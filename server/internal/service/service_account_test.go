@@ -0,0 +1,160 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"promthus/internal/crypto"
+	"promthus/internal/model"
+	"promthus/internal/repository"
+	"promthus/internal/testdb"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+		ok   bool
+	}{
+		{[]string{"audit:read"}, "audit:read", true},
+		{[]string{"audit:read", "alerts:read", "audit:read"}, "audit:read alerts:read", true},
+		{[]string{"audit:read", "users:write"}, "", false},
+		{[]string{""}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeScopes(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeScopes(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestServiceAccountLifecycle(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	svc := NewAdminService(nil, nil)
+
+	account, code, msg := svc.CreateServiceAccount(&CreateServiceAccountRequest{Name: "siem"}, admin.ID)
+	if code != 0 {
+		t.Fatalf("create account: %d %s", code, msg)
+	}
+	var user model.User
+	if err := repository.DB.First(&user, account.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != model.RoleService || !strings.HasPrefix(user.Phone, "svc-") || user.PasswordHash != "!" {
+		t.Fatalf("backing user = role %q phone %q hash %q", user.Role, user.Phone, user.PasswordHash)
+	}
+
+	// 参数校验
+	past := time.Now().Add(-time.Minute)
+	if _, code, _ := svc.CreateAPIKey(account.UUID.String(),
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"users:write"}}, admin.ID); code != model.CodeParamError {
+		t.Fatalf("unknown scope: code %d, want %d", code, model.CodeParamError)
+	}
+	if _, code, _ := svc.CreateAPIKey(account.UUID.String(),
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"audit:read"}, ExpiresAt: &past}, admin.ID); code != model.CodeParamError {
+		t.Fatalf("past expiry: code %d, want %d", code, model.CodeParamError)
+	}
+	if _, code, _ := svc.CreateAPIKey("not-a-uuid",
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"audit:read"}}, admin.ID); code != model.CodeParamError {
+		t.Fatalf("bad account uuid: code %d, want %d", code, model.CodeParamError)
+	}
+
+	resp, code, msg := svc.CreateAPIKey(account.UUID.String(),
+		&CreateAPIKeyRequest{Name: "k", Scopes: []string{"audit:read", "audit:read", "alerts:read"}}, admin.ID)
+	if code != 0 {
+		t.Fatalf("create key: %d %s", code, msg)
+	}
+	if resp.Scopes != "audit:read alerts:read" {
+		t.Fatalf("scopes = %q", resp.Scopes)
+	}
+	if prefix, ok := crypto.ParseAPIKeyPrefix(resp.Key); !ok || prefix != resp.KeyPrefix {
+		t.Fatalf("key %q does not match prefix %q", resp.Key, resp.KeyPrefix)
+	}
+	var stored model.APIKey
+	if err := repository.DB.First(&stored, resp.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.KeyHash) != string(crypto.HashAPIKey(resp.Key)) {
+		t.Fatal("stored hash does not match the returned key")
+	}
+
+	if code, _ := svc.RevokeAPIKey(account.UUID.String(), resp.ID, admin.ID); code != 0 {
+		t.Fatalf("revoke: code %d", code)
+	}
+	if code, _ := svc.RevokeAPIKey(account.UUID.String(), resp.ID, admin.ID); code != model.CodeParamError {
+		t.Fatalf("second revoke: code %d, want %d", code, model.CodeParamError)
+	}
+	if countOperationLogs(t, "revoke_api_key", resp.ID) != 1 {
+		t.Fatal("revoke_api_key not logged once")
+	}
+
+	// 停用账号同步停用背后的用户
+	disabled := int16(0)
+	if code, msg := svc.UpdateServiceAccount(account.UUID.String(), &UpdateServiceAccountRequest{Status: &disabled}, admin.ID); code != 0 {
+		t.Fatalf("disable: %d %s", code, msg)
+	}
+	if err := repository.DB.First(&user, account.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != 0 {
+		t.Fatalf("backing user status = %d, want 0", user.Status)
+	}
+}
+
+// 服务账号背后的用户即使有可用的密码哈希也无法经登录接口登录
+func TestServiceUserCannotLogin(t *testing.T) {
+	testdb.Open(t)
+	admin := newTestUser(t, "admin", "")
+	account, code, msg := NewAdminService(nil, nil).CreateServiceAccount(&CreateServiceAccountRequest{Name: "ci"}, admin.ID)
+	if code != 0 {
+		t.Fatalf("create account: %d %s", code, msg)
+	}
+	var user model.User
+	if err := repository.DB.First(&user, account.UserID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.DB.Model(&user).Update("password_hash", testPasswordHash(t, "Service-Pass1")).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := localAuthenticator{}.Resolve(user.Phone)
+	if err != nil || id != nil {
+		t.Fatalf("Resolve(service user) = %+v, %v; want nil", id, err)
+	}
+	if _, code, _ := lockoutTestService().Login(&LoginRequest{Phone: user.Phone, Password: "Service-Pass1"}, "ua", "10.0.0.1"); code != model.CodeAuthFailed {
+		t.Fatalf("login: code %d, want %d", code, model.CodeAuthFailed)
+	}
+}
+
+// 只能签发自己持有的动作，与 CreateRole、UpdateRole 的约束一致
+func TestCreateAPIKeyEscalation(t *testing.T) {
+	testdb.Open(t)
+	newTestRole(t, "integrator", "service_accounts:read", "service_accounts:write", "audit:read")
+	admin := newTestUser(t, "admin", "")
+	operator := newTestUser(t, "integrator", "")
+	svc := NewAdminService(nil, nil)
+
+	account, code, msg := svc.CreateServiceAccount(&CreateServiceAccountRequest{Name: "work-orders"}, operator.ID)
+	if code != 0 {
+		t.Fatalf("create account: %d %s", code, msg)
+	}
+	for _, scopes := range [][]string{{"permissions:write"}, {"audit:read", "alerts:write"}} {
+		if _, code, _ := svc.CreateAPIKey(account.UUID.String(), &CreateAPIKeyRequest{Name: "k", Scopes: scopes}, operator.ID); code != model.CodeForbidden {
+			t.Errorf("CreateAPIKey(%q) code %d, want %d", scopes, code, model.CodeForbidden)
+		}
+	}
+	var n int64
+	if err := repository.DB.Model(&model.APIKey{}).Where("service_account_id = ?", account.ID).Count(&n).Error; err != nil || n != 0 {
+		t.Fatalf("%d keys created, %v", n, err)
+	}
+	if _, code, msg := svc.CreateAPIKey(account.UUID.String(), &CreateAPIKeyRequest{Name: "k", Scopes: []string{"audit:read"}}, operator.ID); code != 0 {
+		t.Fatalf("scope held by operator: %d %s", code, msg)
+	}
+	if _, code, msg := svc.CreateAPIKey(account.UUID.String(), &CreateAPIKeyRequest{Name: "k", Scopes: []string{"permissions:write"}}, admin.ID); code != 0 {
+		t.Fatalf("admin: %d %s", code, msg)
+	}
+}
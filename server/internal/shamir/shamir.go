@@ -0,0 +1,197 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+Shamir 秘密分享（GF(2^8)，既约多项式 x^8+x^4+x^3+x+1）：
+秘密的每个字节各自作为一个 threshold-1 次随机多项式的常数项，第 i 份取多项式在 x_i 处的值；
+任意 threshold 份用拉格朗日插值求 x=0 处的值即可还原，少于 threshold 份得不到任何信息。
+
+分片文本格式："pms1-" + hex(threshold(1B) || x(1B) || y || SHA-256(前述字节)[:2])，
+末尾 2 字节校验只用于发现抄写错误，不提供安全性。
+*/
+
+const sharePrefix = "pms1-"
+
+var (
+	ErrInvalidShare      = errors.New("invalid share")
+	ErrThresholdMismatch = errors.New("shares have different thresholds or lengths")
+	ErrDuplicateShare    = errors.New("duplicate share")
+	ErrNotEnoughShares   = errors.New("not enough shares")
+)
+
+type Share struct {
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		x = gfMulSlow(x, 3)
+	}
+	expTable[255] = expTable[0]
+}
+
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Split 把 secret 拆为 n 份，任意 threshold 份可还原；2 <= threshold <= n <= 255。
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("require 2 <= threshold <= n <= 255, got threshold=%d n=%d", threshold, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+
+	xs, err := distinctXs(n)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Threshold: threshold, X: xs[i], Y: make([]byte, len(secret))}
+	}
+
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner 求值
+			var y byte
+			for d := threshold - 1; d >= 0; d-- {
+				y = gfMul(y, shares[i].X) ^ coeffs[d]
+			}
+			shares[i].Y[b] = y
+		}
+	}
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+	return shares, nil
+}
+
+// distinctXs 随机选取 n 个互不相同的非零横坐标
+func distinctXs(n int) ([]byte, error) {
+	perm := make([]byte, 255)
+	for i := range perm {
+		perm[i] = byte(i + 1)
+	}
+	rnd := make([]byte, 255)
+	if _, err := rand.Read(rnd); err != nil {
+		return nil, err
+	}
+	for i := 254; i > 0; i-- {
+		j := int(rnd[i]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	return perm[:n], nil
+}
+
+// Combine 用至少 threshold 份还原秘密；多于 threshold 时只取前 threshold 份。
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNotEnoughShares
+	}
+	threshold, size := shares[0].Threshold, len(shares[0].Y)
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Threshold != threshold || len(s.Y) != size {
+			return nil, ErrThresholdMismatch
+		}
+		if s.X == 0 || seen[s.X] {
+			return nil, ErrDuplicateShare
+		}
+		seen[s.X] = true
+	}
+	if len(shares) < threshold {
+		return nil, ErrNotEnoughShares
+	}
+	shares = shares[:threshold]
+
+	secret := make([]byte, size)
+	for i, si := range shares {
+		// 拉格朗日基函数在 0 处的值：prod(x_j / (x_j - x_i))，GF(2^8) 中减法即异或
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// String 编码为可抄写/打印的分片文本。
+func (s Share) String() string {
+	raw := append([]byte{byte(s.Threshold), s.X}, s.Y...)
+	sum := sha256.Sum256(raw)
+	return sharePrefix + hex.EncodeToString(append(raw, sum[:2]...))
+}
+
+// ParseShare 解析分片文本并校验抄写校验和。
+func ParseShare(text string) (Share, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, sharePrefix) {
+		return Share{}, ErrInvalidShare
+	}
+	raw, err := hex.DecodeString(text[len(sharePrefix):])
+	if err != nil || len(raw) < 5 {
+		return Share{}, ErrInvalidShare
+	}
+	body, check := raw[:len(raw)-2], raw[len(raw)-2:]
+	sum := sha256.Sum256(body)
+	if sum[0] != check[0] || sum[1] != check[1] {
+		return Share{}, fmt.Errorf("%w: checksum mismatch", ErrInvalidShare)
+	}
+	if body[0] < 2 || body[1] == 0 {
+		return Share{}, ErrInvalidShare
+	}
+	return Share{Threshold: int(body[0]), X: body[1], Y: append([]byte{}, body[2:]...)}, nil
+}
@@ -0,0 +1,108 @@
+package shamir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 任意 3 份都能还原
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				got, err := Combine([]Share{shares[i], shares[j], shares[k]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Fatalf("shares %d,%d,%d: got %x", i, j, k, got)
+				}
+			}
+		}
+	}
+	if got, err := Combine(shares); err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("all shares: %x, %v", got, err)
+	}
+	if _, err := Combine(shares[:2]); !errors.Is(err, ErrNotEnoughShares) {
+		t.Fatalf("2 of 3: err = %v", err)
+	}
+}
+
+func TestCombineRejectsBadSets(t *testing.T) {
+	a, _ := Split([]byte("secret-a"), 3, 2)
+	b, _ := Split([]byte("secret-b-longer"), 3, 2)
+	c, _ := Split([]byte("secret-c"), 4, 3)
+
+	tests := []struct {
+		name   string
+		shares []Share
+		want   error
+	}{
+		{"empty", nil, ErrNotEnoughShares},
+		{"duplicate", []Share{a[0], a[0]}, ErrDuplicateShare},
+		{"length mismatch", []Share{a[0], b[1]}, ErrThresholdMismatch},
+		{"threshold mismatch", []Share{a[0], c[1]}, ErrThresholdMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Combine(tt.shares); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitParams(t *testing.T) {
+	for _, p := range [][2]int{{3, 1}, {3, 4}, {256, 3}} {
+		if _, err := Split([]byte("x"), p[0], p[1]); err == nil {
+			t.Errorf("Split(n=%d, threshold=%d) accepted", p[0], p[1])
+		}
+	}
+	if _, err := Split(nil, 3, 2); err == nil {
+		t.Error("empty secret accepted")
+	}
+	shares, err := Split([]byte("x"), 255, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.X == 0 || seen[s.X] {
+			t.Fatalf("x = %d repeated or zero", s.X)
+		}
+		seen[s.X] = true
+	}
+}
+
+func TestShareText(t *testing.T) {
+	shares, _ := Split([]byte("0123456789abcdef"), 3, 2)
+	text := shares[0].String()
+	got, err := ParseShare("  " + text + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Threshold != 2 || got.X != shares[0].X || !bytes.Equal(got.Y, shares[0].Y) {
+		t.Fatalf("round trip = %+v, want %+v", got, shares[0])
+	}
+
+	// 抄错一个字符由校验和发现
+	typo := []byte(text)
+	last := len(typo) - 6
+	if typo[last] == '0' {
+		typo[last] = '1'
+	} else {
+		typo[last] = '0'
+	}
+	for _, bad := range []string{string(typo), text[len(sharePrefix):], "pms1-zz", "pms1-0201"} {
+		if _, err := ParseShare(bad); !errors.Is(err, ErrInvalidShare) {
+			t.Errorf("ParseShare(%q): err = %v, want ErrInvalidShare", bad, err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+-- Migration 002: 锁具 MAC 算法协商
+-- 存量锁具均为 HMAC-SHA256 固件，新增锁具默认 AES-128-CMAC（RFC 4493）。
+
+BEGIN;
+
+ALTER TABLE app.devices_lock
+    ADD COLUMN mac_algorithm VARCHAR(16) NOT NULL DEFAULT 'hmac-sha256';
+
+ALTER TABLE app.devices_lock
+    ALTER COLUMN mac_algorithm SET DEFAULT 'aes-cmac';
+
+ALTER TABLE app.devices_lock
+    ADD CONSTRAINT chk_devices_lock_mac_algorithm
+    CHECK (mac_algorithm IN ('aes-cmac', 'hmac-sha256'));
+
+COMMIT;
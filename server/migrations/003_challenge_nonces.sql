@@ -0,0 +1,17 @@
+-- Migration 003: 挑战随机数防重放
+-- 同一 (device_type, device_id, challenge_c) 在时间窗内只能使用一次。
+
+BEGIN;
+
+CREATE TABLE app.challenge_nonces (
+    device_type  VARCHAR(32) NOT NULL,
+    device_id    VARCHAR(32) NOT NULL,
+    challenge_c  VARCHAR(16) NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_type, device_id, challenge_c)
+);
+
+CREATE INDEX idx_challenge_nonces_expires ON app.challenge_nonces(expires_at);
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Migration 004: 开锁会话
+-- Challenge 签发短时效 unlock_session_id，Report 必须携带并一次性核销。
+
+BEGIN;
+
+CREATE TABLE app.unlock_sessions (
+    id           UUID PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES app.users(id),
+    device_type  VARCHAR(32) NOT NULL,
+    device_id    VARCHAR(32) NOT NULL,
+    challenge_c  VARCHAR(16) NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_unlock_sessions_expires ON app.unlock_sessions(expires_at);
+
+COMMIT;
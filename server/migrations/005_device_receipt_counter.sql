@@ -0,0 +1,9 @@
+-- Migration 005: 锁具开锁回执计数器
+-- 锁具对开锁结果签名时附带单调递增计数器，服务端记录最后接受的值以拒绝旧回执。
+
+BEGIN;
+
+ALTER TABLE app.devices_lock
+    ADD COLUMN receipt_counter BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Migration 006: 权限周期性时段规则
+-- schedule 为 NULL 表示不限时段；格式见 model.PermissionSchedule：
+-- {"timezone": "Asia/Shanghai", "rules": [{"weekdays": [1,2,3,4,5], "start": "08:00", "end": "18:00"}]}
+
+BEGIN;
+
+ALTER TABLE app.permissions
+    ADD COLUMN schedule JSONB;
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Migration 007: 设备临时封禁
+-- challenge_flood 触发后在 expires_at 之前拒绝该设备的挑战请求。
+
+BEGIN;
+
+CREATE TABLE app.device_blocks (
+    device_type  VARCHAR(32) NOT NULL,
+    device_id    VARCHAR(32) NOT NULL,
+    blocked_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    reason       VARCHAR(100),
+    PRIMARY KEY (device_type, device_id)
+);
+
+CREATE INDEX idx_device_blocks_expires ON app.device_blocks(expires_at);
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Migration 008: 设备密钥轮换
+-- 新密钥先存入 pending_key_*，与旧密钥并存；锁具以新版本签名回执后转正，旧密钥作废。
+
+BEGIN;
+
+ALTER TABLE app.devices_lock
+    ADD COLUMN pending_key_encrypted BYTEA,
+    ADD COLUMN pending_key_version   SMALLINT,
+    ADD COLUMN key_rotated_at        TIMESTAMPTZ;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Migration 009: 系统设置
+-- 目前用于记录主密钥指纹（kms.master_key_fingerprint），启动时校验主密钥是否配错。
+
+BEGIN;
+
+CREATE TABLE app.system_settings (
+    key         VARCHAR(100) PRIMARY KEY,
+    value       TEXT NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Migration 010: 刷新令牌与会话续期
+-- 每次登录是一个 token family（一行 session）：刷新令牌每用一次即轮换，refresh_generation 递增；
+-- 出现已轮换过的旧刷新令牌视为泄露，直接删除整行（吊销整个 family）。
+-- last_used_at 用于空闲超时，expires_at 仍是会话的绝对过期时间。
+
+BEGIN;
+
+ALTER TABLE app.sessions
+    ADD COLUMN family_id          UUID,
+    ADD COLUMN refresh_token_hash BYTEA,
+    ADD COLUMN refresh_generation INT NOT NULL DEFAULT 0,
+    ADD COLUMN last_used_at       TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+UPDATE app.sessions SET family_id = jti WHERE family_id IS NULL;
+
+ALTER TABLE app.sessions ALTER COLUMN family_id SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_sessions_family_id ON app.sessions(family_id);
+
+COMMIT;
@@ -0,0 +1,38 @@
+-- Migration 011: 管理员 TOTP 双因素认证
+-- user_mfa.secret_encrypted 用 crypto.AESEncrypt（AUTH_MFA_ENCRYPTION_KEY）加密；
+-- enabled = false 表示已生成密钥但尚未用验证码确认；last_used_step 防止同一验证码重复使用。
+-- mfa_challenges 为两步登录的中间态：密码校验通过后签发，5 分钟内提交验证码换取会话。
+
+BEGIN;
+
+CREATE TABLE app.user_mfa (
+    user_id          BIGINT PRIMARY KEY REFERENCES app.users(id),
+    secret_encrypted BYTEA NOT NULL,
+    enabled          BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step   BIGINT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    confirmed_at     TIMESTAMPTZ
+);
+
+CREATE TABLE app.mfa_recovery_codes (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES app.users(id),
+    code_hash  VARCHAR(64) NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_mfa_recovery_codes_hash ON app.mfa_recovery_codes(user_id, code_hash);
+
+CREATE TABLE app.mfa_challenges (
+    token      UUID PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES app.users(id),
+    attempts   SMALLINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ip_address INET
+);
+
+CREATE INDEX idx_mfa_challenges_expires ON app.mfa_challenges(expires_at);
+
+COMMIT;
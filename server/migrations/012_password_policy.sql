@@ -0,0 +1,21 @@
+-- Migration 012: 自助改密与密码策略
+-- must_change_password：管理员创建/重置生成的随机密码，首次登录后必须先改密才能使用其他接口；
+-- password_history 保存用户最近 N 次自行设置的密码哈希，改密时连同当前密码一起禁止复用；
+-- 管理员生成的随机密码不入历史。
+
+BEGIN;
+
+ALTER TABLE app.users
+    ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN password_changed_at  TIMESTAMPTZ;
+
+CREATE TABLE app.password_history (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       BIGINT NOT NULL REFERENCES app.users(id),
+    password_hash VARCHAR(100) NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_password_history_user ON app.password_history(user_id, created_at DESC);
+
+COMMIT;
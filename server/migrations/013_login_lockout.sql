@@ -0,0 +1,29 @@
+-- Migration 013: 账户锁定与登录审计
+-- 按账户累计连续登录失败（密码或两步验证码），达到阈值即锁定 locked_until；
+-- lockout_count 记录自上次成功登录以来被锁的次数，锁定时长按 2^(n-1) 递增，成功登录或管理员解锁后清零。
+-- log.login_logs 为登录审计流：经 login_audit.queue 异步写入，MQ 不可用时由服务端直接写入。
+
+BEGIN;
+
+ALTER TABLE app.users
+    ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN lockout_count      INT NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until       TIMESTAMPTZ;
+
+CREATE TABLE log.login_logs (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT,
+    phone       VARCHAR(20) NOT NULL, -- 脱敏手机号，未知账户也记录
+    action      VARCHAR(20) NOT NULL, -- login | mfa_verify
+    result      VARCHAR(30) NOT NULL, -- success | invalid_credentials | account_locked | ...
+    client_ip   INET NOT NULL,
+    user_agent  VARCHAR(200),
+    extra       JSONB,
+    occurred_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_login_logs_user_occurred ON log.login_logs(user_id, occurred_at DESC);
+CREATE INDEX idx_login_logs_occurred      ON log.login_logs(occurred_at);
+CREATE INDEX idx_login_logs_client_ip     ON log.login_logs(client_ip);
+
+COMMIT;
@@ -0,0 +1,44 @@
+-- Migration 014: 服务账号与 API Key
+-- 服务账号供工单等外部系统调用 /api/admin/*。每个服务账号背后有一行 role='service' 的 app.users，
+-- 这样 granted_by / revoked_by / handled_by 等外键与 log.operation_logs.operator_id 都能直接指向它；
+-- 该用户没有可用密码，登录接口拒绝 service 角色。
+-- API Key 明文形如 psk_<key_prefix>_<secret>，只在创建时返回一次；库中存 key_prefix（查找用）与整串的 SHA-256。
+-- scopes 以空格分隔，如 "permissions:write audit:read"。
+
+BEGIN;
+
+CREATE TABLE app.service_accounts (
+    id          BIGSERIAL PRIMARY KEY,
+    uuid        UUID NOT NULL DEFAULT gen_random_uuid(),
+    user_id     BIGINT NOT NULL REFERENCES app.users(id),
+    name        VARCHAR(50) NOT NULL,
+    description VARCHAR(200),
+    status      SMALLINT NOT NULL DEFAULT 1,
+    created_by  BIGINT NOT NULL REFERENCES app.users(id),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_service_accounts_uuid ON app.service_accounts(uuid);
+CREATE UNIQUE INDEX idx_service_accounts_user ON app.service_accounts(user_id);
+
+CREATE TABLE app.api_keys (
+    id                 BIGSERIAL PRIMARY KEY,
+    service_account_id BIGINT NOT NULL REFERENCES app.service_accounts(id),
+    name               VARCHAR(50) NOT NULL,
+    key_prefix         VARCHAR(16) NOT NULL,
+    key_hash           BYTEA NOT NULL,
+    scopes             VARCHAR(500) NOT NULL,
+    expires_at         TIMESTAMPTZ,
+    last_used_at       TIMESTAMPTZ,
+    last_used_ip       INET,
+    created_by         BIGINT NOT NULL REFERENCES app.users(id),
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at         TIMESTAMPTZ,
+    revoked_by         BIGINT REFERENCES app.users(id)
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix  ON app.api_keys(key_prefix);
+CREATE INDEX idx_api_keys_service_account ON app.api_keys(service_account_id);
+
+COMMIT;
@@ -0,0 +1,15 @@
+-- Migration 015: 外部登录后端（LDAP / AD）
+-- auth_source 标识用户由哪个登录后端管理：local 为手机号 + 本地密码；ldap 用户在首次目录登录时即时开通，
+-- 之后每次登录按目录同步姓名、部门与角色，密码只在目录中维护（本地 password_hash 为不可用的占位值）。
+-- external_id 为目录中不随改名变化的标识（AD objectGUID / OpenLDAP entryUUID），同一后端内唯一。
+
+BEGIN;
+
+ALTER TABLE app.users
+    ADD COLUMN auth_source VARCHAR(20) NOT NULL DEFAULT 'local',
+    ADD COLUMN external_id VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_users_external ON app.users(auth_source, external_id)
+    WHERE external_id IS NOT NULL AND deleted_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- Migration 016: 管理后台 OIDC 单点登录
+-- user_identities 记录外部身份 (issuer, subject) 与本地用户的关联：首次 OIDC 登录按已验证的 phone_number 找到用户后写入，
+-- 之后只按 (issuer, subject) 识别，IdP 中手机号变更不影响关联。同一用户仍可使用密码登录。
+-- oidc_states 为授权请求的中间态（state → PKCE code_verifier 与 nonce），回调时一次性消费，10 分钟过期。
+
+BEGIN;
+
+CREATE TABLE app.user_identities (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       BIGINT NOT NULL REFERENCES app.users(id),
+    issuer        VARCHAR(255) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_user_identities_subject ON app.user_identities(issuer, subject);
+CREATE INDEX idx_user_identities_user ON app.user_identities(user_id);
+
+CREATE TABLE app.oidc_states (
+    state         VARCHAR(64) PRIMARY KEY, -- SHA-256(state) 的 hex，明文只在浏览器与 IdP 之间传递
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce         VARCHAR(64) NOT NULL,
+    ip_address    VARCHAR(45),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_oidc_states_expires ON app.oidc_states(expires_at);
+
+COMMIT;
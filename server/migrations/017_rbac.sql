@@ -0,0 +1,65 @@
+-- Migration 017: 按动作鉴权的角色模型
+-- 角色 → 动作集合存 app.roles / app.role_permissions，/api/admin 下每个路由声明所需动作（如 audit:read、alerts:write）。
+-- admin 持有 "*"（全部动作，含以后新增的），存量管理员无需调整；user 与 service 没有后台动作。
+-- 内置角色不可修改或删除；自定义角色由持有 roles:write 的管理员维护。
+-- users.role 改为引用 app.roles(name)，删除角色前须先把用户改到其他角色。
+
+BEGIN;
+
+CREATE TABLE app.roles (
+    name         VARCHAR(20) PRIMARY KEY,
+    display_name VARCHAR(50) NOT NULL,
+    description  VARCHAR(200),
+    builtin      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE app.role_permissions (
+    role       VARCHAR(20) NOT NULL REFERENCES app.roles(name) ON DELETE CASCADE,
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO app.roles (name, display_name, description, builtin) VALUES
+    ('admin',              '管理员',     '全部后台操作',                               TRUE),
+    ('user',               '普通用户',   '仅使用开锁 App，无后台权限',                 TRUE),
+    ('service',            '服务账号',   '服务账号背后的用户，经 API Key 按 scope 鉴权', TRUE),
+    ('auditor',            '审计员',     '只读查看后台数据与审计、登录日志',           TRUE),
+    ('alert_handler',      '告警处理员', '查看与处理告警（含解除告警封锁）',           TRUE),
+    ('permission_manager', '权限管理员', '授予与撤销开锁权限',                         TRUE);
+
+INSERT INTO app.role_permissions (role, permission) VALUES
+    ('admin', '*'),
+
+    ('auditor', 'dashboard:read'),
+    ('auditor', 'users:read'),
+    ('auditor', 'devices:read'),
+    ('auditor', 'permissions:read'),
+    ('auditor', 'audit:read'),
+    ('auditor', 'alerts:read'),
+    ('auditor', 'service_accounts:read'),
+    ('auditor', 'roles:read'),
+
+    ('alert_handler', 'dashboard:read'),
+    ('alert_handler', 'devices:read'),
+    ('alert_handler', 'audit:read'),
+    ('alert_handler', 'alerts:read'),
+    ('alert_handler', 'alerts:write'),
+
+    ('permission_manager', 'dashboard:read'),
+    ('permission_manager', 'users:read'),
+    ('permission_manager', 'devices:read'),
+    ('permission_manager', 'permissions:read'),
+    ('permission_manager', 'permissions:write'),
+    ('permission_manager', 'roles:read');
+
+-- 手工写入过其他角色名的存量用户：补一个无动作的角色，保证外键可建
+INSERT INTO app.roles (name, display_name)
+SELECT DISTINCT role, role FROM app.users
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE app.users
+    ADD CONSTRAINT fk_users_role FOREIGN KEY (role) REFERENCES app.roles(name);
+
+COMMIT;
@@ -0,0 +1,21 @@
+-- Migration 018: 管理员管辖范围
+-- 把后台用户的管理范围限定到若干管线（devices_lock.pipeline_tag）和/或部门（users.department），用于区域主管等分级授权。
+-- 某用户在 app.admin_scopes 中没有任何行表示不受限（存量管理员行为不变）；某一维度有行时，
+-- 锁具列表、开锁授权、审计日志、告警只包含该维度命中的记录，范围外的授权、撤销与告警处置被拒绝。
+-- 范围与角色相互独立：角色决定能做哪些动作，范围决定能对哪些锁具、用户做。
+
+BEGIN;
+
+CREATE TABLE app.admin_scopes (
+    user_id    BIGINT NOT NULL REFERENCES app.users(id),
+    kind       VARCHAR(20) NOT NULL CHECK (kind IN ('pipeline_tag', 'department')),
+    value      VARCHAR(100) NOT NULL,
+    created_by BIGINT NOT NULL REFERENCES app.users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind, value)
+);
+
+-- 范围过滤以子查询按 department 取用户（pipeline_tag 已有 idx_devices_lock_pipeline）
+CREATE INDEX idx_users_department ON app.users(department);
+
+COMMIT;
@@ -1,5 +1,5 @@
 import request from '@/utils/request'
-import type { PaginatedData, User, Device, Permission, Alert, AuditLog, DashboardData, PagedData } from '@/types'
+import type { PaginatedData, User, AdminScope, Device, Permission, Alert, AuditLog, LoginLog, SessionInfo, Role, ServiceAccount, APIKey, DashboardData, PagedData } from '@/types'
 
 // Dashboard
 export function getDashboard(): Promise<DashboardData> {
@@ -23,12 +23,34 @@ export function resetPassword(uuid: string): Promise<void> {
   return request.post(`/admin/users/${uuid}/reset-pwd`)
 }
 
+export function unlockUser(uuid: string): Promise<void> {
+  return request.post(`/admin/users/${uuid}/unlock`)
+}
+
+export function setUserScope(uuid: string, data: AdminScope): Promise<void> {
+  return request.put(`/admin/users/${uuid}/scope`, data)
+}
+
+export function getUserSessions(uuid: string): Promise<{ items: SessionInfo[] }> {
+  return request.get(`/admin/users/${uuid}/sessions`)
+}
+
+export function revokeUserSession(uuid: string, id: string): Promise<void> {
+  return request.delete(`/admin/users/${uuid}/sessions/${id}`)
+}
+
+export function resetMFA(uuid: string): Promise<void> {
+  return request.delete(`/admin/users/${uuid}/mfa`)
+}
+
 // Devices
 export function getDevices(params: Record<string, any>): Promise<PaginatedData<Device>> {
   return request.get('/admin/devices', { params })
 }
 
-export function createDevice(data: Record<string, any>): Promise<Device> {
+export function createDevice(
+  data: Record<string, any>,
+): Promise<Device & { provisioning_bundle?: Record<string, any> }> {
   return request.post('/admin/devices', data)
 }
 
@@ -54,6 +76,10 @@ export function getAuditLogs(params: Record<string, any>): Promise<PagedData<Aud
   return request.get('/admin/audit-logs', { params })
 }
 
+export function getLoginLogs(params: Record<string, any>): Promise<PagedData<LoginLog>> {
+  return request.get('/admin/login-logs', { params })
+}
+
 // Alerts
 export function getAlerts(params: Record<string, any>): Promise<PaginatedData<Alert>> {
   return request.get('/admin/alerts', { params })
@@ -62,3 +88,48 @@ export function getAlerts(params: Record<string, any>): Promise<PaginatedData<Al
 export function handleAlert(id: number, data: { handle_note: string; unlock_device: boolean }): Promise<void> {
   return request.put(`/admin/alerts/${id}`, data)
 }
+
+// Roles
+export function getRoles(): Promise<{ items: Role[]; permissions: string[] }> {
+  return request.get('/admin/roles')
+}
+
+export function createRole(data: { name: string; display_name: string; description: string; permissions: string[] }): Promise<Role> {
+  return request.post('/admin/roles', data)
+}
+
+export function updateRole(name: string, data: Record<string, any>): Promise<void> {
+  return request.put(`/admin/roles/${name}`, data)
+}
+
+export function deleteRole(name: string): Promise<void> {
+  return request.delete(`/admin/roles/${name}`)
+}
+
+// Service Accounts
+export function getServiceAccounts(): Promise<{ items: ServiceAccount[] }> {
+  return request.get('/admin/service-accounts')
+}
+
+export function createServiceAccount(data: { name: string; description: string }): Promise<ServiceAccount> {
+  return request.post('/admin/service-accounts', data)
+}
+
+export function updateServiceAccount(uuid: string, data: Record<string, any>): Promise<void> {
+  return request.put(`/admin/service-accounts/${uuid}`, data)
+}
+
+export function getAPIKeys(uuid: string): Promise<{ items: APIKey[] }> {
+  return request.get(`/admin/service-accounts/${uuid}/keys`)
+}
+
+export function createAPIKey(
+  uuid: string,
+  data: { name: string; scopes: string[]; expires_at?: string },
+): Promise<APIKey & { key: string }> {
+  return request.post(`/admin/service-accounts/${uuid}/keys`, data)
+}
+
+export function revokeAPIKey(uuid: string, id: number): Promise<void> {
+  return request.delete(`/admin/service-accounts/${uuid}/keys/${id}`)
+}
@@ -1,5 +1,5 @@
 import request from '@/utils/request'
-import type { LoginForm, LoginResult } from '@/types'
+import type { LoginForm, LoginResult, MFAEnrollResult, SessionInfo } from '@/types'
 
 export function login(data: LoginForm): Promise<LoginResult> {
   return request.post('/auth/login', data)
@@ -8,3 +8,41 @@ export function login(data: LoginForm): Promise<LoginResult> {
 export function logout(): Promise<void> {
   return request.post('/auth/logout')
 }
+
+export function refresh(refreshToken: string): Promise<LoginResult> {
+  return request.post('/auth/refresh', { refresh_token: refreshToken })
+}
+
+export function enrollMFA(mfaToken: string): Promise<MFAEnrollResult> {
+  return request.post('/auth/mfa/enroll', { mfa_token: mfaToken })
+}
+
+export function verifyMFA(data: { mfa_token: string; code?: string; recovery_code?: string }): Promise<LoginResult> {
+  return request.post('/auth/mfa/verify', data)
+}
+
+export function changePassword(data: { current_password: string; new_password: string }): Promise<void> {
+  return request.post('/auth/password', data)
+}
+
+export function getSessions(): Promise<{ items: SessionInfo[] }> {
+  return request.get('/auth/sessions')
+}
+
+// 传 family_id：刷新后 jti 会变，family_id 在整个登录周期内不变
+export function revokeSession(id: string): Promise<void> {
+  return request.delete(`/auth/sessions/${id}`)
+}
+
+// OIDC 单点登录（授权码 + PKCE）
+export function getOIDCConfig(): Promise<{ enabled: boolean; display_name?: string }> {
+  return request.get('/auth/oidc/config')
+}
+
+export function authorizeOIDC(): Promise<{ auth_url: string; state: string }> {
+  return request.post('/auth/oidc/authorize')
+}
+
+export function oidcCallback(data: { code: string; state: string }): Promise<LoginResult> {
+  return request.post('/auth/oidc/callback', data)
+}
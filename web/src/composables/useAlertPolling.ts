@@ -1,11 +1,15 @@
 import { onMounted, onUnmounted } from 'vue'
 import { useAlertStore } from '@/stores/alert'
+import { useAuthStore } from '@/stores/auth'
 
 export function useAlertPolling(intervalMs = 30000) {
   const alertStore = useAlertStore()
+  const authStore = useAuthStore()
   let timer: ReturnType<typeof setInterval> | null = null
 
   onMounted(() => {
+    // 没有告警查看权限的角色（如权限管理员）不轮询，避免反复 403
+    if (!authStore.can('alerts:read')) return
     alertStore.fetchPendingAlerts()
     timer = setInterval(() => {
       alertStore.fetchPendingAlerts()
@@ -8,6 +8,18 @@ const routes: RouteRecordRaw[] = [
     component: () => import('@/views/Login.vue'),
     meta: { requiresAuth: false },
   },
+  {
+    path: '/oidc/callback',
+    name: 'OIDCCallback',
+    component: () => import('@/views/OIDCCallback.vue'),
+    meta: { requiresAuth: false },
+  },
+  {
+    path: '/change-password',
+    name: 'ChangePassword',
+    component: () => import('@/views/ChangePassword.vue'),
+    meta: { requiresAuth: true },
+  },
   {
     path: '/',
     component: () => import('@/views/Layout.vue'),
@@ -21,37 +33,61 @@ const routes: RouteRecordRaw[] = [
         path: 'dashboard',
         name: 'Dashboard',
         component: () => import('@/views/Dashboard.vue'),
-        meta: { title: '总览', roles: ['admin'] },
+        meta: { title: '总览', permission: 'dashboard:read' },
       },
       {
         path: 'users',
         name: 'Users',
         component: () => import('@/views/Users.vue'),
-        meta: { title: '用户管理', roles: ['admin'] },
+        meta: { title: '用户管理', permission: 'users:read' },
       },
       {
         path: 'devices',
         name: 'Devices',
         component: () => import('@/views/Devices.vue'),
-        meta: { title: '锁具管理', roles: ['admin'] },
+        meta: { title: '锁具管理', permission: 'devices:read' },
       },
       {
         path: 'permissions',
         name: 'Permissions',
         component: () => import('@/views/Permissions.vue'),
-        meta: { title: '权限管理', roles: ['admin'] },
+        meta: { title: '权限管理', permission: 'permissions:read' },
       },
       {
         path: 'audit-logs',
         name: 'AuditLogs',
         component: () => import('@/views/AuditLogs.vue'),
-        meta: { title: '审计日志', roles: ['admin'] },
+        meta: { title: '审计日志', permission: 'audit:read' },
+      },
+      {
+        path: 'my-sessions',
+        name: 'MySessions',
+        component: () => import('@/views/MySessions.vue'),
+        meta: { title: '登录设备' },
+      },
+      {
+        path: 'login-logs',
+        name: 'LoginLogs',
+        component: () => import('@/views/LoginLogs.vue'),
+        meta: { title: '登录日志', permission: 'audit:read' },
+      },
+      {
+        path: 'roles',
+        name: 'Roles',
+        component: () => import('@/views/Roles.vue'),
+        meta: { title: '角色管理', permission: 'roles:read' },
+      },
+      {
+        path: 'service-accounts',
+        name: 'ServiceAccounts',
+        component: () => import('@/views/ServiceAccounts.vue'),
+        meta: { title: '服务账号', permission: 'service_accounts:read' },
       },
       {
         path: 'alerts',
         name: 'Alerts',
         component: () => import('@/views/Alerts.vue'),
-        meta: { title: '告警管理', roles: ['admin'] },
+        meta: { title: '告警管理', permission: 'alerts:read' },
       },
     ],
   },
@@ -82,8 +118,12 @@ router.beforeEach((to, _from, next) => {
     return next('/login')
   }
 
-  const roles = to.meta.roles as string[] | undefined
-  if (roles && !roles.includes(authStore.role)) {
+  if (authStore.isLoggedIn && authStore.mustChangePassword && to.path !== '/change-password') {
+    return next('/change-password')
+  }
+
+  const permission = to.meta.permission as string | undefined
+  if (permission && !authStore.can(permission)) {
     return next('/403')
   }
 
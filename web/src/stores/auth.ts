@@ -6,20 +6,44 @@ export const useAuthStore = defineStore('auth', () => {
   const userUUID = ref(localStorage.getItem('user_uuid') || '')
   const role = ref(localStorage.getItem('role') || '')
   const name = ref(localStorage.getItem('user_name') || '')
+  // 角色的后台动作集合，只用于显示菜单与按钮，服务端逐路由校验
+  const permissions = ref<string[]>(JSON.parse(localStorage.getItem('permissions') || '[]'))
+  // 管理员创建/重置的密码须先修改，期间只能访问改密页
+  const mustChangePassword = ref(localStorage.getItem('must_change_password') === '1')
 
   const isLoggedIn = computed(() => !!token.value)
   const isAdmin = computed(() => role.value === 'admin')
 
-  function setAuth(data: { token: string; user_uuid: string; role: string; name: string }) {
+  function can(permission: string) {
+    return permissions.value.includes(permission)
+  }
+
+  function setAuth(data: {
+    token: string; refresh_token: string; user_uuid: string; role: string; name: string
+    permissions?: string[]; must_change_password?: boolean
+  }) {
     token.value = data.token
     userUUID.value = data.user_uuid
     role.value = data.role
     name.value = data.name
+    permissions.value = data.permissions ?? []
 
     localStorage.setItem('token', data.token)
+    localStorage.setItem('refresh_token', data.refresh_token)
     localStorage.setItem('user_uuid', data.user_uuid)
     localStorage.setItem('role', data.role)
     localStorage.setItem('user_name', data.name)
+    localStorage.setItem('permissions', JSON.stringify(permissions.value))
+    setMustChangePassword(!!data.must_change_password)
+  }
+
+  function setMustChangePassword(value: boolean) {
+    mustChangePassword.value = value
+    if (value) {
+      localStorage.setItem('must_change_password', '1')
+    } else {
+      localStorage.removeItem('must_change_password')
+    }
   }
 
   function clearAuth() {
@@ -27,12 +51,19 @@ export const useAuthStore = defineStore('auth', () => {
     userUUID.value = ''
     role.value = ''
     name.value = ''
+    permissions.value = []
 
     localStorage.removeItem('token')
+    localStorage.removeItem('refresh_token')
     localStorage.removeItem('user_uuid')
     localStorage.removeItem('role')
     localStorage.removeItem('user_name')
+    localStorage.removeItem('permissions')
+    setMustChangePassword(false)
   }
 
-  return { token, userUUID, role, name, isLoggedIn, isAdmin, setAuth, clearAuth }
+  return {
+    token, userUUID, role, name, permissions, mustChangePassword, isLoggedIn, isAdmin,
+    can, setAuth, setMustChangePassword, clearAuth,
+  }
 })
@@ -23,12 +23,23 @@ export interface User {
   name: string
   phone: string
   department: string
-  role: 'user' | 'admin'
+  role: string
   status: number
+  auth_source: 'local' | 'ldap'
+  must_change_password: boolean
+  locked_until?: string
+  // 后台管辖范围，未设置表示不受限
+  admin_scope?: AdminScope
   created_at: string
   updated_at: string
 }
 
+// 某一维度为空表示该维度不受限
+export interface AdminScope {
+  pipeline_tags: string[]
+  departments: string[]
+}
+
 export interface Device {
   id: number
   device_id: string
@@ -71,6 +82,63 @@ export interface AuditLog {
   occurred_at: string
 }
 
+export interface SessionInfo {
+  jti: string
+  family_id: string
+  user_agent: string
+  ip_address: string
+  created_at: string
+  last_used_at: string
+  expires_at: string
+  current: boolean
+}
+
+export interface LoginLog {
+  id: number
+  user_id?: number
+  phone: string
+  action: string
+  result: string
+  client_ip: string
+  user_agent: string
+  extra?: Record<string, any>
+  occurred_at: string
+}
+
+export interface Role {
+  name: string
+  display_name: string
+  description: string
+  builtin: boolean
+  permissions: string[]
+  created_at: string
+  updated_at: string
+}
+
+export interface ServiceAccount {
+  uuid: string
+  user_id: number
+  name: string
+  description: string
+  status: number
+  created_by: number
+  created_at: string
+  updated_at: string
+}
+
+export interface APIKey {
+  id: number
+  name: string
+  key_prefix: string
+  scopes: string
+  expires_at?: string
+  last_used_at?: string
+  last_used_ip?: string
+  created_by: number
+  created_at: string
+  revoked_at?: string
+}
+
 export interface Alert {
   id: number
   alert_type: string
@@ -102,7 +170,20 @@ export interface LoginForm {
 export interface LoginResult {
   token: string
   expires_at: string
+  refresh_token: string
+  refresh_expires_at: string
   user_uuid: string
   role: string
   name: string
+  permissions?: string[]
+  mfa_required?: boolean
+  mfa_enroll_required?: boolean
+  mfa_token?: string
+  recovery_codes?: string[]
+  must_change_password?: boolean
+}
+
+export interface MFAEnrollResult {
+  secret: string
+  otpauth_uri: string
 }
@@ -38,6 +38,8 @@ export const alertTypeMap: Record<string, string> = {
   challenge_flood: '挑战请求洪泛',
   off_hours_attempt: '非工作时段操作',
   device_offline: '设备离线',
+  login_anomaly: '异常登录',
+  account_locked: '账户锁定',
 }
 
 export const auditActionMap: Record<string, string> = {
@@ -48,8 +50,36 @@ export const auditActionMap: Record<string, string> = {
   auth_fail: '登录失败',
 }
 
+export const loginResultMap: Record<string, { text: string; color: string }> = {
+  success: { text: '登录成功', color: 'green' },
+  mfa_challenge: { text: '待两步验证', color: 'blue' },
+  invalid_credentials: { text: '密码错误', color: 'orange' },
+  invalid_mfa: { text: '验证码错误', color: 'orange' },
+  account_locked: { text: '账户锁定', color: 'red' },
+  account_disabled: { text: '账户禁用', color: 'red' },
+  not_entitled: { text: '未授权', color: 'red' },
+}
+
 export const riskLevelMap: Record<number, { text: string; color: string }> = {
   1: { text: '普通', color: '#1890ff' },
   2: { text: '重要', color: '#faad14' },
   3: { text: '关键', color: '#ff4d4f' },
 }
+
+// 后台动作（角色权限）
+export const permissionLabelMap: Record<string, string> = {
+  'dashboard:read': '查看总览',
+  'users:read': '查看用户',
+  'users:write': '管理用户',
+  'devices:read': '查看锁具',
+  'devices:write': '管理锁具',
+  'permissions:read': '查看开锁授权',
+  'permissions:write': '授予/撤销开锁授权',
+  'audit:read': '查看审计与登录日志',
+  'alerts:read': '查看告警',
+  'alerts:write': '处置告警',
+  'service_accounts:read': '查看服务账号',
+  'service_accounts:write': '管理服务账号',
+  'roles:read': '查看角色',
+  'roles:write': '管理角色',
+}
@@ -35,6 +35,28 @@ request.interceptors.request.use(
   (error) => Promise.reject(error)
 )
 
+// 访问 Token 过期时用刷新令牌换新并重试一次；并发请求共用同一次刷新（刷新令牌一次一换，重复使用会被服务端判为泄露）
+let refreshing: Promise<string | null> | null = null
+
+function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = localStorage.getItem('refresh_token')
+  if (!refreshToken) return Promise.resolve(null)
+  if (!refreshing) {
+    refreshing = axios
+      .post<ApiResponse>('/api/auth/refresh', { refresh_token: refreshToken })
+      .then(({ data }) => {
+        if (data.code !== 0) return null
+        useAuthStore().setAuth(data.data)
+        return data.data.token as string
+      })
+      .catch(() => null)
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
 request.interceptors.response.use(
   (response: AxiosResponse<ApiResponse>) => {
     const { data } = response
@@ -72,9 +94,27 @@ request.interceptors.response.use(
       const status = error.response.status
       const msg = error.response.data?.message
       if (status === 401) {
+        const original = error.config
+        if (original && !original._retried && !String(original.url).startsWith('/auth/')) {
+          original._retried = true
+          return refreshAccessToken().then((token) => {
+            if (!token) {
+              useAuthStore().clearAuth()
+              router.push('/login')
+              return Promise.reject(error)
+            }
+            original.headers.Authorization = `Bearer ${token}`
+            return request(original)
+          })
+        }
         const authStore = useAuthStore()
         authStore.clearAuth()
         router.push('/login')
+      } else if (status === 403 && error.response.data?.code === 1004) {
+        useAuthStore().setMustChangePassword(true)
+        router.push('/change-password')
+      } else if (status === 423) {
+        message.error(msg || '账户已锁定，请稍后再试')
       } else if (status === 400) {
         message.error(msg || '请求参数错误')
       } else if (status === 429) {